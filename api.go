@@ -0,0 +1,587 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+// MaxAPIListLimit is the max amount of Notes returned by the API list endpoint.
+const MaxAPIListLimit = 500
+
+//
+// ------------------------------------------------------------------
+// API
+// ------------------------------------------------------------------
+//
+
+// NoteJSON is the API representation of a Note.
+type NoteJSON struct {
+	ID         uint       `json:"id"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	Date       time.Time  `json:"date"`
+	Tags       []string   `json:"tags"`
+	Monospace  bool       `json:"monospace"`
+	Draft      bool       `json:"draft"`
+	Scheduled  bool       `json:"scheduled"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Words      int        `json:"words"`
+	Characters int        `json:"characters"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Seq        int64      `json:"seq"`               // of the Note's last change, see HandleAPIChanges
+	Snippet    string     `json:"snippet,omitempty"` // search results only, html with <mark>ed matches
+}
+
+// newNoteJSON converts a Note into its API representation.
+func newNoteJSON(note Note) NoteJSON {
+	tagNames := []string{}
+	for _, tag := range note.Tags {
+		tagNames = append(tagNames, tag.Name)
+	}
+
+	return NoteJSON{
+		ID:         note.ID,
+		Title:      note.DisplayTitle(),
+		Body:       string(note.Body),
+		Date:       note.Date,
+		Tags:       tagNames,
+		Monospace:  note.Monospace,
+		Draft:      note.Draft,
+		Scheduled:  note.Scheduled,
+		ExpiresAt:  note.ExpiresAt,
+		Words:      note.Words,
+		Characters: note.Characters,
+		UpdatedAt:  note.UpdatedAt,
+		Seq:        note.ChangeSeq,
+	}
+}
+
+// NoteInput is the request body for creating and updating Notes.
+// The fields are the same as the html form: the date and time are
+// ISO 8601 (or e.g. "March 4, 2021" and "10:20 AM", see
+// NoteFormDateFormats), in the time zone, which defaults to the
+// Settings' Timezone. The date can also be an ISO 8601 date and time, or
+// an RFC 3339 time with an offset, when the time is empty, or words like
+// "yesterday 3pm", see parseNaturalDate. An empty date means now, or
+// when updating, the Note's date. When updating, a missing Monospace
+// or Scheduled keeps the Note's.
+//
+// ExpiresIn deletes the Note for good after a duration like "24h", or
+// "never" removes its expiry. When updating, an empty ExpiresIn keeps it.
+//
+// When updating, UpdatedAt can be set to the `updated_at` of the Note
+// that was edited. If the Note has been changed since, the update is
+// rejected with a conflict.
+type NoteInput struct {
+	Body      string     `json:"body"`
+	Date      string     `json:"date"`
+	Time      string     `json:"time"`
+	Timezone  string     `json:"timezone,omitempty"`
+	Tags      string     `json:"tags"`
+	Monospace *bool      `json:"monospace,omitempty"`
+	Draft     bool       `json:"draft"` // when creating, see Note.Draft
+	Scheduled *bool      `json:"scheduled,omitempty"`
+	ExpiresIn string     `json:"expires_in,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// conflicts checks if the Note was changed after the input's version of it.
+func (in NoteInput) conflicts(note Note) bool {
+	return in.UpdatedAt != nil && !in.UpdatedAt.Equal(note.UpdatedAt)
+}
+
+// form converts the NoteInput into a NoteForm.
+func (in NoteInput) form() NoteForm {
+	form := NoteForm{
+		Body:      in.Body,
+		Date:      in.Date,
+		Time:      in.Time,
+		Timezone:  in.Timezone,
+		Tags:      in.Tags,
+		Monospace: in.Monospace != nil && *in.Monospace,
+		Draft:     in.Draft,
+		Scheduled: in.Scheduled != nil && *in.Scheduled,
+		Expires:   in.ExpiresIn,
+	}
+
+	if form.Date == "" {
+		now := localNow()
+		form.Date = now.Format(NotePartialDateFormat)
+		form.Time = now.Format(NotePartialTimeFormat)
+		form.Timezone = ""
+	}
+
+	return form
+}
+
+// updateForm converts the NoteInput into a valid NoteForm that updates
+// the Note, keeping the Note's date, monospace and scheduled unless
+// they are given.
+func (in NoteInput) updateForm(note Note) (NoteForm, bool) {
+	if in.Monospace == nil {
+		in.Monospace = &note.Monospace
+	}
+	if in.Scheduled == nil {
+		in.Scheduled = &note.Scheduled
+	}
+	keepDate := in.Date == ""
+	if keepDate {
+		in.Date = note.Date.Format(NotePartialDateFormat)
+		in.Time = note.Date.Format(NotePartialTimeFormat)
+		in.Timezone = ""
+	}
+
+	form := in.form()
+	if !form.IsValid() {
+		return form, false
+	}
+	// The formatted time has no seconds, keep the Note's own.
+	if keepDate {
+		form.cleanedDateTime = note.Date
+	}
+	return form, true
+}
+
+// TagJSON is the API representation of a Tag.
+type TagJSON struct {
+	Name  string `json:"name"`
+	Notes int    `json:"notes"`
+}
+
+// ChangesJSON is the response body of the changes endpoint.
+type ChangesJSON struct {
+	Notes      []NoteJSON      `json:"notes"`      // created or updated Notes
+	Deleted    []uint          `json:"deleted"`    // ids of deleted Notes
+	Tombstones []TombstoneJSON `json:"tombstones"` // the deleted Notes, with when
+	Until      time.Time       `json:"until"`      // the `since` of the next sync, as a time
+	Seq        int64           `json:"seq"`        // the `since` of the next sync, as a sequence number
+}
+
+// TombstoneJSON is a deleted Note in the changes.
+type TombstoneJSON struct {
+	ID        uint      `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Seq       int64     `json:"seq"`
+}
+
+// noteTombstone is the model for the `note_tombstones` table, which
+// keeps the Notes that were deleted for good.
+type noteTombstone struct {
+	NoteID    uint
+	DeletedAt time.Time
+	ChangeSeq int64
+}
+
+// APIError is the response body for failed API requests.
+type APIError struct {
+	Code      string   `json:"code,omitempty"`
+	Errors    []string `json:"errors"`
+	RequestID string   `json:"request_id,omitempty"` // as in the server log
+}
+
+// federatedContextKey is the request context key of requests with the
+// FederationToken.
+type federatedContextKey struct{}
+
+// federated reports if the request was sent with the FederationToken.
+// Only the shared and public Notes are listed for it, see federatedScope.
+func federated(r *http.Request) bool {
+	ok, _ := r.Context().Value(federatedContextKey{}).(bool)
+	return ok
+}
+
+// federatedScope limits the query to the Notes that other instances
+// can read: the ones with a share link, and the ones tagged tag. Drafts,
+// archived Notes and pending scheduled Notes are never read, whatever
+// the is: filters of the search.
+func federatedScope(db *gorm.DB, tag string) *gorm.DB {
+	db = notScheduled(db.Where("not notes.archived and not notes.draft"))
+	return db.Where(`
+		notes.id in (select note_id from shares where expires_at is null or expires_at > ?)
+		or notes.id in (
+			select nt.note_id from note_tag nt
+			inner join tags t on t.id = nt.tag_id
+			where t.name = ?
+		)`, time.Now(), tag)
+}
+
+// protectAPI requires a logged in user, or the APIToken as a bearer
+// token, so integrations can use the API without a login session.
+// The read-only FederationToken can only list Notes.
+func (s *Server) protectAPI(next http.Handler) http.Handler {
+	protected := s.Auth.Protect(next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(auth, "Bearer ")
+		if s.Config.FederationToken != "" && strings.HasPrefix(auth, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(s.Config.FederationToken)) == 1 {
+			if r.Method != http.MethodGet || r.URL.Path != "/api/notes" {
+				writeAPIError(w, r, ErrReadOnlyToken, nil)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), federatedContextKey{}, true)))
+			return
+		}
+		if s.Config.APIToken != "" && strings.HasPrefix(auth, "Bearer ") {
+			if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(s.Config.APIToken)) != 1 {
+				writeAPIError(w, r, ErrUnauthorized, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		protected.ServeHTTP(w, r)
+	})
+}
+
+// noteCursor is the position of a Note in a sorted list of Notes.
+// Clients get it as an opaque string, to request the page after it.
+type noteCursor struct {
+	Sort  string    `json:"s"` // e.g. date:desc
+	Value time.Time `json:"v"` // of the sort column
+	ID    uint      `json:"id"`
+}
+
+// newNoteCursor returns the cursor of the Note in the sort.
+func newNoteCursor(ns NoteSort, note Note) noteCursor {
+	cursor := noteCursor{Sort: ns.String(), Value: note.Date, ID: note.ID}
+	switch ns.Field {
+	case "created":
+		cursor.Value = note.CreatedAt
+	case "updated":
+		cursor.Value = note.UpdatedAt
+	}
+	return cursor
+}
+
+// parseNoteCursor decodes a cursor, and returns it with its sort.
+func parseNoteCursor(s string) (noteCursor, NoteSort, error) {
+	cursor := noteCursor{}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &cursor)
+	}
+	parts := strings.SplitN(cursor.Sort, ":", 2)
+	if err != nil || len(parts) != 2 {
+		return cursor, NoteSort{}, errors.New("invalid cursor")
+	}
+	ns := NoteSort{Field: parts[0], Dir: parts[1]}
+	if !ns.IsValid() {
+		return cursor, NoteSort{}, errors.New("invalid cursor")
+	}
+	return cursor, ns, nil
+}
+
+// String encodes the cursor.
+func (c noteCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Scope selects the Notes after the cursor, by the sort column and id,
+// so no OFFSET is needed. The time keeps its offset, so it is formatted
+// like the stored value, which is compared as text.
+func (c noteCursor) Scope(db *gorm.DB, ns NoteSort) *gorm.DB {
+	op := "<"
+	if ns.Dir == "asc" {
+		op = ">"
+	}
+	return db.Where(fmt.Sprintf("(%v, id) %v (?, ?)", noteSortColumns[ns.Field], op), c.Value, c.ID)
+}
+
+// HandleAPINoteList returns the most recent Notes.
+// The order is set with `?sort=date|created|updated&dir=asc|desc`,
+// and the Notes can be searched with `?q=`, and filtered by tag with
+// `?tag=`, where `?tag=none` is the untagged Notes. When a page is full, the
+// X-Next-Cursor header has the `?after=` of the next page, which keeps
+// the sort of the first page.
+func (s *Server) HandleAPINoteList(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > MaxAPIListLimit {
+		limit = 30
+	}
+
+	sq, err := ParseSearchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		writeAPIError(w, r, ErrSearch, nil, err.Error())
+		return
+	}
+	switch tag := cleanTagName(r.URL.Query().Get("tag")); tag {
+	case "":
+	case "none":
+		sq.Untagged = true
+	default:
+		sq.Tags = append(sq.Tags, tag)
+	}
+
+	db := s.ReadDB
+	if federated(r) {
+		db = federatedScope(db, s.Config.FederationTag)
+	}
+	var ns NoteSort
+	if after := r.URL.Query().Get("after"); after != "" {
+		var cursor noteCursor
+		if cursor, ns, err = parseNoteCursor(after); err != nil {
+			writeAPIError(w, r, ErrBadRequest, nil, err.Error())
+			return
+		}
+		db = cursor.Scope(db, ns)
+	} else {
+		ns = noteSort(w, r)
+	}
+
+	notes, err := searchNotes(db, sq, ns.OrderBy(), limit)
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+
+	results := []NoteJSON{}
+	for _, note := range notes {
+		result := newNoteJSON(note)
+		if sq.hasText() {
+			result.Snippet = string(sq.Snippet(result.Body))
+		}
+		results = append(results, result)
+	}
+
+	if len(notes) == limit {
+		w.Header().Set("X-Next-Cursor", newNoteCursor(ns, notes[len(notes)-1]).String())
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// HandleAPINoteDetail returns a single Note.
+func (s *Server) HandleAPINoteDetail(w http.ResponseWriter, r *http.Request) {
+	noteID := chi.URLParam(r, "noteID")
+
+	note := Note{}
+	if err := s.ReadDB.Preload("Tags").First(&note, noteID).Error; err != nil {
+		writeAPIError(w, r, ErrNoteNotFound, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newNoteJSON(note))
+}
+
+// HandleAPINoteCreate creates a Note.
+func (s *Server) HandleAPINoteCreate(w http.ResponseWriter, r *http.Request) {
+	input := NoteInput{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeAPIError(w, r, ErrBadRequest, err)
+		return
+	}
+
+	form := input.form()
+	if !form.IsValid() {
+		writeAPIError(w, r, ErrInvalidNote, nil, form.Errors...)
+		return
+	}
+
+	note, err := s.createNote(requestActor(r, "api"), &form)
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+	s.DB.Preload("Tags").First(&note, note.ID)
+	writeJSON(w, http.StatusCreated, newNoteJSON(note))
+}
+
+// HandleQuick creates a Note from plain text, dated now, for curl,
+// shell aliases and shortcuts apps, e.g.
+//
+//	curl -H "Authorization: Bearer $TOKEN" --data-binary "buy milk #errands" localhost:3000/quick
+//
+// The text is the request body when it is text/plain, or else the
+// `text` field. The #hashtags in the text are the tags.
+func (s *Server) HandleQuick(w http.ResponseWriter, r *http.Request) {
+	text := ""
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "text/plain" {
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			writeAPIError(w, r, ErrBadRequest, err)
+			return
+		}
+		text = string(b)
+	} else {
+		text = r.FormValue("text")
+	}
+
+	form := messageForm("", text)
+	if !form.IsValid() {
+		writeAPIError(w, r, ErrInvalidNote, nil, form.Errors...)
+		return
+	}
+
+	note, err := s.createNote(requestActor(r, "api"), &form)
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+	s.DB.Preload("Tags").First(&note, note.ID)
+	w.Header().Set("Location", fmt.Sprintf("/note/%v/change", note.ID))
+	writeJSON(w, http.StatusCreated, newNoteJSON(note))
+}
+
+// HandleAPINoteUpdate updates a Note.
+func (s *Server) HandleAPINoteUpdate(w http.ResponseWriter, r *http.Request) {
+	noteID := chi.URLParam(r, "noteID")
+
+	note := Note{}
+	if err := s.DB.Preload("Tags").First(&note, noteID).Error; err != nil {
+		writeAPIError(w, r, ErrNoteNotFound, err)
+		return
+	}
+
+	input := NoteInput{}
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		writeAPIError(w, r, ErrBadRequest, err)
+		return
+	}
+
+	if input.conflicts(note) {
+		writeAPIError(w, r, ErrEditConflict, nil)
+		return
+	}
+
+	form, ok := input.updateForm(note)
+	if !ok {
+		writeAPIError(w, r, ErrInvalidNote, nil, form.Errors...)
+		return
+	}
+
+	if err := s.updateNote(requestActor(r, "api"), &note, &form); err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newNoteJSON(note))
+}
+
+// HandleAPINoteDelete deletes a Note.
+func (s *Server) HandleAPINoteDelete(w http.ResponseWriter, r *http.Request) {
+	noteID := chi.URLParam(r, "noteID")
+	if err := s.deleteNote(requestActor(r, "api"), noteID); err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleAPITagList returns all tags, with their amount of Notes.
+func (s *Server) HandleAPITagList(w http.ResponseWriter, r *http.Request) {
+	tags, err := tagCounts(s.ReadDB)
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+
+	results := []TagJSON{}
+	for _, tag := range tags {
+		results = append(results, TagJSON{Name: tag.Name, Notes: tag.Notes})
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// HandleAPITagCloud returns the tags that are in use, weighted by
+// their amount of Notes, by name.
+func (s *Server) HandleAPITagCloud(w http.ResponseWriter, r *http.Request) {
+	cloud, err := tagCloud(s.ReadDB)
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cloud)
+}
+
+// HandleAPIChanges returns the Notes that were changed or deleted
+// since `?since=`, to keep a copy of the Notes in sync. Without
+// `since`, all Notes are returned.
+//
+// `since` is the `seq` of the previous changes, or an RFC 3339 time,
+// the `until` of the previous changes. The sequence numbers include
+// changes that don't update a Note's time, e.g. starring it, and the
+// Notes that were deleted for good.
+func (s *Server) HandleAPIChanges(w http.ResponseWriter, r *http.Request) {
+	// The sequence number is read first, so changes made while the
+	// feed is read are sent again in the next sync, not missed.
+	changes := ChangesJSON{Notes: []NoteJSON{}, Deleted: []uint{}, Tombstones: []TombstoneJSON{}, Until: time.Now()}
+	if err := s.ReadDB.Table("change_seq").Select("value").Scan(&changes.Seq).Error; err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+
+	notes := s.ReadDB.Preload("Tags").Order("change_seq")
+	tombstones := s.ReadDB.Table("note_tombstones")
+	param := r.URL.Query().Get("since")
+	if seq, err := strconv.ParseInt(param, 10, 64); err == nil {
+		notes = notes.Unscoped().Where("change_seq > ?", seq)
+		tombstones = tombstones.Where("change_seq > ?", seq)
+	} else if param != "" {
+		t, err := time.Parse(time.RFC3339Nano, param)
+		if err != nil {
+			writeAPIError(w, r, ErrBadRequest, err)
+			return
+		}
+		// The timestamps are stored in local time, and compared as text.
+		// Tombstones are stamped by sqlite, in UTC.
+		since := t.In(time.Local)
+		notes = notes.Unscoped().Where("updated_at > ? or deleted_at > ?", since, since)
+		tombstones = tombstones.Where("deleted_at > ?", t.UTC().Format("2006-01-02 15:04:05.000"))
+	}
+
+	found := []Note{}
+	if err := notes.Find(&found).Error; err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+	for _, note := range found {
+		if note.DeletedAt.Valid {
+			changes.Tombstones = append(changes.Tombstones, TombstoneJSON{note.ID, note.DeletedAt.Time, note.ChangeSeq})
+			continue
+		}
+		changes.Notes = append(changes.Notes, newNoteJSON(note))
+	}
+
+	// A full sync has no deleted Notes.
+	deleted := []noteTombstone{}
+	if param != "" {
+		if err := tombstones.Find(&deleted).Error; err != nil {
+			writeAPIError(w, r, ErrDatabase, err)
+			return
+		}
+	}
+	for _, t := range deleted {
+		changes.Tombstones = append(changes.Tombstones, TombstoneJSON{t.NoteID, t.DeletedAt, t.ChangeSeq})
+	}
+
+	sort.Slice(changes.Tombstones, func(i, j int) bool {
+		return changes.Tombstones[i].Seq < changes.Tombstones[j].Seq
+	})
+	for _, t := range changes.Tombstones {
+		changes.Deleted = append(changes.Deleted, t.ID)
+	}
+
+	writeJSON(w, http.StatusOK, changes)
+}
+
+// writeJSON writes the value as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi/middleware"
+	"gorm.io/gorm"
+)
+
+// ActivityPageSize is the amount of audit entries on the activity page.
+const ActivityPageSize = 100
+
+// Audit actions.
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+//
+// ------------------------------------------------------------------
+// Audit log
+// ------------------------------------------------------------------
+//
+
+// Actor is who made a change, and through which interface.
+type Actor struct {
+	User      string // empty for the password backend, which has no usernames
+	Source    string // web, api, websocket, tui, import, sync, expiry or trash
+	RequestID string
+}
+
+// requestActor returns the Actor of an http request.
+func requestActor(r *http.Request, source string) Actor {
+	return Actor{
+		User:      currentUser(r),
+		Source:    source,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+}
+
+// AuditEntry is the model for the `audit_entries` table.
+// An entry is saved with every create, update and delete, in the
+// same transaction as the change.
+type AuditEntry struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	User      string
+	Source    string
+	RequestID string
+	Action    string
+	Entity    string // note, tag or saved_search
+	EntityID  uint
+	Changes   EncryptedText // one "field: old → new" per line
+}
+
+// ChangeLines returns the changes, one per line.
+func (e AuditEntry) ChangeLines() []string {
+	if e.Changes == "" {
+		return nil
+	}
+	return strings.Split(string(e.Changes), "\n")
+}
+
+// audit saves an AuditEntry for the change.
+func audit(tx *gorm.DB, actor Actor, action, entity string, entityID uint, changes []string) error {
+	return tx.Create(&AuditEntry{
+		User:      actor.User,
+		Source:    actor.Source,
+		RequestID: actor.RequestID,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Changes:   EncryptedText(strings.Join(changes, "\n")),
+	}).Error
+}
+
+// noteChanges describes the difference between two versions of a Note.
+// Bodies are compared by length only, so the log stays readable.
+func noteChanges(before, after Note) []string {
+	changes := []string{}
+	if before.Title != after.Title {
+		changes = append(changes, fmt.Sprintf("title: %q → %q", before.Title, after.Title))
+	}
+	if !before.Date.Equal(after.Date) {
+		changes = append(changes, fmt.Sprintf("date: %v → %v", auditDate(before.Date), auditDate(after.Date)))
+	}
+	if beforeTags, afterTags := noteTagNames(before), noteTagNames(after); beforeTags != afterTags {
+		changes = append(changes, fmt.Sprintf("tags: %q → %q", beforeTags, afterTags))
+	}
+	if before.Draft != after.Draft {
+		changes = append(changes, fmt.Sprintf("draft: %v → %v", before.Draft, after.Draft))
+	}
+	if before.Scheduled != after.Scheduled {
+		changes = append(changes, fmt.Sprintf("scheduled: %v → %v", before.Scheduled, after.Scheduled))
+	}
+	if beforeExpiry, afterExpiry := auditExpiry(before.ExpiresAt), auditExpiry(after.ExpiresAt); beforeExpiry != afterExpiry {
+		changes = append(changes, fmt.Sprintf("expires: %v → %v", beforeExpiry, afterExpiry))
+	}
+	if before.Monospace != after.Monospace {
+		changes = append(changes, fmt.Sprintf("monospace: %v → %v", before.Monospace, after.Monospace))
+	}
+	if before.Body != after.Body {
+		changes = append(changes, fmt.Sprintf("body: %v → %v characters",
+			utf8.RuneCountInString(string(before.Body)), utf8.RuneCountInString(string(after.Body))))
+	}
+	return changes
+}
+
+// auditExpiry formats a Note's expiry for the audit log.
+func auditExpiry(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// auditDate formats a Note date for the audit log. The zero date is empty.
+func auditDate(d time.Time) string {
+	if d.IsZero() {
+		return ""
+	}
+	return d.Format(NoteDateFormat)
+}
+
+// ActivityContext provides context data to the activity page.
+type ActivityContext struct {
+	Entries []AuditEntry
+	Older   uint // id to show older entries from, if any
+}
+
+// HandleActivity serves the activity page, newest first.
+// Older pages are requested with `?before=<id>`.
+func (s *Server) HandleActivity(w http.ResponseWriter, r *http.Request) {
+	query := s.ReadDB.Order("id desc").Limit(ActivityPageSize + 1)
+	if before, err := strconv.ParseUint(r.URL.Query().Get("before"), 10, 64); err == nil {
+		query = query.Where("id < ?", before)
+	}
+
+	entries := []AuditEntry{}
+	if err := query.Find(&entries).Error; err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	requestContext := ActivityContext{Entries: entries}
+	if len(entries) > ActivityPageSize {
+		requestContext.Entries = entries[:ActivityPageSize]
+		requestContext.Older = entries[ActivityPageSize-1].ID
+	}
+
+	s.render(w, r, "activity", requestContext)
+}
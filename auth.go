@@ -0,0 +1,904 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tunedmystic/authsolo"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Authentication backends, selected with SIMPLENOTES_AUTH.
+const (
+	AuthBackendPassword = "password" // a single shared password
+	AuthBackendUsers    = "users"    // accounts in the users table
+	AuthBackendOIDC     = "oidc"     // an OpenID Connect provider
+	AuthBackendHeader   = "header"   // a header set by a trusted auth proxy
+)
+
+// Login session settings, for the backends that keep their own sessions.
+const (
+	SessionCookie = "session"
+	SessionMaxAge = 4 * time.Hour
+)
+
+//
+// ------------------------------------------------------------------
+// Authentication
+// ------------------------------------------------------------------
+//
+
+// Auth authenticates requests.
+type Auth interface {
+	// Protect requires a logged in user for the handler.
+	Protect(next http.Handler) http.Handler
+
+	// Handler serves the login and logout pages, and passes
+	// all other requests to h.
+	Handler(h http.Handler) http.Handler
+}
+
+// NewAuth creates the Auth backend selected in the Server's Config.
+func NewAuth(s *Server) (Auth, error) {
+	cfg := s.Config
+
+	switch cfg.Auth {
+	case AuthBackendPassword:
+		return passwordAuth{authsolo.New(cfg.Password), s, NewLoginThrottle(cfg)}, nil
+
+	case AuthBackendUsers:
+		ss, err := newSessions(cfg.SessionSecret)
+		if err != nil {
+			return nil, err
+		}
+		devices := NewRememberedDevices(s)
+		passkeys, err := NewPasskeys(s, ss, devices)
+		if err != nil {
+			return nil, err
+		}
+		return &usersAuth{ss, s, passkeys, devices, NewLoginThrottle(cfg)}, nil
+
+	case AuthBackendOIDC:
+		if cfg.OIDCIssuer == "" || cfg.OIDCClientID == "" || cfg.OIDCRedirectURL == "" {
+			return nil, errors.New("the oidc backend needs SIMPLENOTES_OIDC_ISSUER, SIMPLENOTES_OIDC_CLIENT_ID and SIMPLENOTES_OIDC_REDIRECT_URL")
+		}
+		redirect, err := url.Parse(cfg.OIDCRedirectURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIMPLENOTES_OIDC_REDIRECT_URL: %v", err)
+		}
+		ss, err := newSessions(cfg.SessionSecret)
+		if err != nil {
+			return nil, err
+		}
+		return &oidcAuth{
+			s:            s,
+			sessions:     ss,
+			issuer:       strings.TrimSuffix(cfg.OIDCIssuer, "/"),
+			clientID:     cfg.OIDCClientID,
+			clientSecret: cfg.OIDCClientSecret,
+			redirectURL:  cfg.OIDCRedirectURL,
+			callbackPath: redirect.Path,
+			allowed:      parseOIDCAllowed(cfg.OIDCAllowed),
+			client:       &http.Client{Timeout: 10 * time.Second},
+		}, nil
+
+	case AuthBackendHeader:
+		return newHeaderAuth(s)
+
+	default:
+		return nil, fmt.Errorf("invalid SIMPLENOTES_AUTH %q, use password, users, oidc or header", cfg.Auth)
+	}
+}
+
+// userContextKey is the request context key of the logged in username.
+type userContextKey struct{}
+
+// currentUser returns the logged in username. It is empty
+// for the password backend, which has no usernames.
+func currentUser(r *http.Request) string {
+	username, _ := r.Context().Value(userContextKey{}).(string)
+	return username
+}
+
+// withUser returns the request, with the logged in username.
+func withUser(r *http.Request, username string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), userContextKey{}, username))
+}
+
+// LoginContext provides context data to the login page.
+type LoginContext struct {
+	Next     string
+	Error    string
+	Form     bool // show the username and password form
+	Passkeys bool // offer passkey login
+	Remember bool // offer to remember the device
+}
+
+// loginNext returns the page to go to after logging in.
+// Only local paths are allowed, so the login can't redirect off-site.
+func loginNext(r *http.Request) string {
+	next := r.FormValue("next")
+	// Browsers follow /\evil.com like //evil.com, to another site.
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") ||
+		strings.HasPrefix(next, "/login") || next == "/logout" {
+		return "/"
+	}
+	return next
+}
+
+// redirectToLogin sends the user to the login page, and back to the
+// current page afterwards.
+func redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.Path), http.StatusFound)
+}
+
+//
+// ------------------------------------------------------------------
+// Sessions
+// ------------------------------------------------------------------
+//
+
+// sessions keeps the logged in username in a signed cookie.
+type sessions struct {
+	key []byte
+}
+
+// newSessions creates sessions signed with the secret. Without a
+// secret a random one is used, and sessions end on restart.
+func newSessions(secret string) (sessions, error) {
+	if secret == "" {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return sessions{}, err
+		}
+		return sessions{key}, nil
+	}
+	key := sha256.Sum256([]byte(secret))
+	return sessions{key[:]}, nil
+}
+
+// sign returns the signature of the session payload.
+func (ss sessions) sign(payload string) string {
+	mac := hmac.New(sha256.New, ss.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// login sets the session cookie for the user.
+func (ss sessions) login(w http.ResponseWriter, username string) {
+	expires := time.Now().Add(SessionMaxAge).Unix()
+	payload := base64.RawURLEncoding.EncodeToString([]byte(username)) + "." + strconv.FormatInt(expires, 10)
+	setCookie(w, SessionCookie, payload+"."+ss.sign(payload), SessionMaxAge)
+}
+
+// logout clears the session cookie.
+func (ss sessions) logout(w http.ResponseWriter) {
+	setCookie(w, SessionCookie, "", -1)
+}
+
+// user returns the username of the request's session.
+func (ss sessions) user(r *http.Request) (string, bool) {
+	c, err := r.Cookie(SessionCookie)
+	if err != nil {
+		return "", false
+	}
+
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(ss.sign(payload))) {
+		return "", false
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", false
+	}
+	username, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", false
+	}
+	return string(username), true
+}
+
+// Protect requires a valid session.
+func (ss sessions) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, ok := ss.user(r)
+		if !ok {
+			ss.logout(w)
+			redirectToLogin(w, r)
+			return
+		}
+		next.ServeHTTP(w, withUser(r, username))
+	})
+}
+
+// setCookie sets an http-only cookie. A negative maxAge clears it.
+func setCookie(w http.ResponseWriter, name, value string, maxAge time.Duration) {
+	age := int(maxAge.Seconds())
+	if maxAge < 0 {
+		age = -1
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   age,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+//
+// ------------------------------------------------------------------
+// Password backend
+// ------------------------------------------------------------------
+//
+
+// passwordAuth is a single shared password, with no usernames.
+type passwordAuth struct {
+	*authsolo.Auth
+	s        *Server
+	throttle *LoginThrottle
+}
+
+// Handler serves /login and /logout. The submitted password is
+// checked by authsolo, which redirects when it matches.
+func (a passwordAuth) Handler(h http.Handler) http.Handler {
+	next := a.Auth.Handler(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/login" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := a.s.clientIP(r)
+		if wait := a.throttle.Attempt(ip, ""); wait > 0 {
+			log.Printf("[auth] throttled login from %v", ip)
+			setRetryAfter(w, wait)
+			a.s.renderError(w, r, ErrLoginThrottled, nil)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == http.StatusFound {
+			log.Printf("[auth] login from %v", ip)
+			a.throttle.Succeeded(ip, "")
+		} else {
+			log.Printf("[auth] failed login from %v", ip)
+		}
+	})
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code, and writes it.
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Protect requires the password cookie.
+func (a passwordAuth) Protect(next http.Handler) http.Handler {
+	return a.SoloH(next)
+}
+
+//
+// ------------------------------------------------------------------
+// Users backend
+// ------------------------------------------------------------------
+//
+
+// usersAuth logs in with the accounts in the users table.
+// Accounts are created with `simplenotes createuser`, and
+// can log in with a password or a passkey.
+type usersAuth struct {
+	sessions
+	s        *Server
+	passkeys *Passkeys
+	devices  *RememberedDevices
+	throttle *LoginThrottle
+}
+
+// Protect requires a valid session. Without one, a remembered device
+// starts a new session.
+func (a *usersAuth) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, ok := a.user(r)
+		if !ok {
+			if username, ok = a.devices.Recall(w, r); ok {
+				a.login(w, username)
+			}
+		}
+		if !ok {
+			a.logout(w)
+			redirectToLogin(w, r)
+			return
+		}
+		next.ServeHTTP(w, withUser(r, username))
+	})
+}
+
+// Handler serves /login, /logout and the passkey pages.
+func (a *usersAuth) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			a.handleLogin(w, r)
+		case "/login/passkey/begin":
+			a.passkeys.HandleLoginBegin(w, r)
+		case "/login/passkey/finish":
+			a.passkeys.HandleLoginFinish(w, r)
+		case "/passkeys":
+			a.Protect(http.HandlerFunc(a.passkeys.HandleList)).ServeHTTP(w, r)
+		case "/passkeys/register/begin":
+			a.Protect(http.HandlerFunc(a.passkeys.HandleRegisterBegin)).ServeHTTP(w, r)
+		case "/passkeys/register/finish":
+			a.Protect(http.HandlerFunc(a.passkeys.HandleRegisterFinish)).ServeHTTP(w, r)
+		case "/passkeys/delete":
+			a.Protect(http.HandlerFunc(a.passkeys.HandleDelete)).ServeHTTP(w, r)
+		case "/devices":
+			a.Protect(http.HandlerFunc(a.devices.HandleList)).ServeHTTP(w, r)
+		case "/devices/forget":
+			a.Protect(http.HandlerFunc(a.devices.HandleForgetAll)).ServeHTTP(w, r)
+		case "/logout":
+			if err := a.devices.Forget(w, r); err != nil {
+				log.Printf("[auth] %v", err)
+			}
+			a.logout(w)
+			http.Redirect(w, r, "/login", http.StatusFound)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// handleLogin shows the login form, and checks the submitted password.
+func (a *usersAuth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	requestContext := LoginContext{Next: loginNext(r), Form: true, Passkeys: true, Remember: true}
+
+	if r.Method != http.MethodPost {
+		if _, ok := a.user(r); ok {
+			http.Redirect(w, r, requestContext.Next, http.StatusFound)
+			return
+		}
+		a.s.render(w, r, "login", requestContext)
+		return
+	}
+
+	ip := a.s.clientIP(r)
+	username := strings.ToLower(strings.TrimSpace(r.FormValue("username")))
+	if wait := a.throttle.Attempt(ip, username); wait > 0 {
+		log.Printf("[auth] throttled login for %q from %v", username, ip)
+		requestContext.Error = ErrLoginThrottled.Message
+		setRetryAfter(w, wait)
+		w.WriteHeader(ErrLoginThrottled.Status)
+		a.s.render(w, r, "login", requestContext)
+		return
+	}
+
+	user := User{}
+	err := a.s.DB.Where("username = ?", username).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		a.s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	if err != nil {
+		user.PasswordHash = dummyPasswordHash
+	}
+	if !user.CheckPassword(r.FormValue("password")) || err != nil {
+		log.Printf("[auth] failed login for %q from %v", username, ip)
+		requestContext.Error = "Invalid username or password."
+		w.WriteHeader(http.StatusUnauthorized)
+		a.s.render(w, r, "login", requestContext)
+		return
+	}
+
+	log.Printf("[auth] login for %q from %v", username, ip)
+	a.throttle.Succeeded(ip, username)
+	if r.FormValue("remember") == "true" {
+		if err := a.devices.Remember(w, r, user.Username); err != nil {
+			a.s.renderError(w, r, ErrDatabase, err)
+			return
+		}
+	}
+	a.login(w, user.Username)
+	http.Redirect(w, r, requestContext.Next, http.StatusFound)
+}
+
+//
+// ------------------------------------------------------------------
+// OpenID Connect backend
+// ------------------------------------------------------------------
+//
+
+// oidcStateCookie holds the login state while the user is at the provider.
+const oidcStateCookie = "oidc_state"
+
+// oidcAuth logs in with an OpenID Connect provider, using the
+// authorization code flow. Logins are linked to an OIDCAccount by the
+// subject of the provider, which users can't change. Without an
+// account, only the allowed verified emails can log in, and are linked
+// to an account on their first login.
+type oidcAuth struct {
+	s *Server
+	sessions
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	callbackPath string
+	allowed      map[string]bool // emails, and email domains like @example.com
+	client       *http.Client
+
+	mu       sync.Mutex
+	provider *oidcProvider // discovered on the first login
+}
+
+// oidcProvider is the provider metadata, from its discovery document.
+type oidcProvider struct {
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+// OIDCAccount is the model for the `oidc_accounts` table. It links the
+// subject of an OpenID Connect login to a username.
+type OIDCAccount struct {
+	Subject   string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Username  string
+}
+
+// oidcIdentity is the user of a login, from the provider.
+type oidcIdentity struct {
+	Subject string
+	Email   string // only set when verified by the provider
+}
+
+// parseOIDCAllowed parses the OIDCAllowed, a comma separated list of
+// emails and email domains.
+func parseOIDCAllowed(list string) map[string]bool {
+	allowed := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			allowed[name] = true
+		}
+	}
+	return allowed
+}
+
+// account returns the username of the identity, from its OIDCAccount.
+// Without one, a verified email in the OIDCAllowed is linked to a new
+// account, with the email as the username.
+func (a *oidcAuth) account(identity oidcIdentity) (string, error) {
+	account := OIDCAccount{}
+	err := a.s.DB.Where("subject = ?", identity.Subject).Take(&account).Error
+	if err == nil {
+		return account.Username, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	email := strings.ToLower(identity.Email)
+	if at := strings.LastIndex(email, "@"); at < 0 || !(a.allowed[email] || a.allowed[email[at:]]) {
+		return "", fmt.Errorf("oidc subject %q is not allowed to log in", identity.Subject)
+	}
+	account = OIDCAccount{Subject: identity.Subject, Username: email}
+	if err := a.s.DB.Create(&account).Error; err != nil {
+		return "", err
+	}
+	log.Printf("[auth] linked oidc subject %q to %v", identity.Subject, email)
+	return account.Username, nil
+}
+
+// linkOIDCAccount links the subject of an OpenID Connect login to the
+// username, replacing any earlier link of the subject.
+func linkOIDCAccount(db *gorm.DB, subject, username string) (OIDCAccount, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if !usernamePattern.MatchString(username) {
+		return OIDCAccount{}, ErrInvalidUsername
+	}
+	if subject == "" {
+		return OIDCAccount{}, errors.New("expected an oidc subject")
+	}
+
+	account := OIDCAccount{Subject: subject, Username: username}
+	err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&account).Error
+	return account, err
+}
+
+// Handler serves /login, the provider callback and /logout.
+func (a *oidcAuth) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			a.handleLogin(w, r)
+		case a.callbackPath:
+			a.handleCallback(w, r)
+		case "/logout":
+			a.logout(w)
+			http.Redirect(w, r, "/", http.StatusFound)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// discover fetches the provider metadata, once.
+func (a *oidcAuth) discover() (*oidcProvider, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.provider != nil {
+		return a.provider, nil
+	}
+
+	provider := oidcProvider{}
+	if err := a.getJSON(a.issuer+"/.well-known/openid-configuration", "", &provider); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %v", err)
+	}
+	a.provider = &provider
+	return a.provider, nil
+}
+
+// handleLogin redirects to the provider.
+func (a *oidcAuth) handleLogin(w http.ResponseWriter, r *http.Request) {
+	provider, err := a.discover()
+	if err != nil {
+		a.s.renderError(w, r, ErrLoginProvider, err)
+		return
+	}
+
+	state := make([]byte, 32)
+	if _, err := rand.Read(state); err != nil {
+		a.s.renderError(w, r, ErrInternal, err)
+		return
+	}
+	values := url.Values{
+		"state": {base64.RawURLEncoding.EncodeToString(state[:16])},
+		"nonce": {base64.RawURLEncoding.EncodeToString(state[16:])},
+		"next":  {loginNext(r)},
+	}
+	setCookie(w, oidcStateCookie, values.Encode(), 10*time.Minute)
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {a.clientID},
+		"redirect_uri":  {a.redirectURL},
+		"scope":         {"openid profile email"},
+		"state":         {values.Get("state")},
+		"nonce":         {values.Get("nonce")},
+	}
+	http.Redirect(w, r, provider.AuthorizationEndpoint+"?"+params.Encode(), http.StatusFound)
+}
+
+// handleCallback exchanges the provider's code for the username,
+// and starts the session.
+func (a *oidcAuth) handleCallback(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	setCookie(w, oidcStateCookie, "", -1)
+
+	values, _ := url.ParseQuery(c.Value)
+	query := r.URL.Query()
+	if query.Get("state") == "" || !hmac.Equal([]byte(query.Get("state")), []byte(values.Get("state"))) {
+		a.s.renderError(w, r, ErrLogin, errors.New("invalid login state"))
+		return
+	}
+	if query.Get("error") != "" {
+		a.s.renderError(w, r, ErrLogin, fmt.Errorf("login provider error: %v", query.Get("error")))
+		return
+	}
+
+	identity, err := a.exchange(query.Get("code"), values.Get("nonce"))
+	if err != nil {
+		a.s.renderError(w, r, ErrLogin, err)
+		return
+	}
+	username, err := a.account(identity)
+	if err != nil {
+		a.s.renderError(w, r, ErrLogin, err)
+		return
+	}
+
+	a.login(w, username)
+	http.Redirect(w, r, values.Get("next"), http.StatusFound)
+}
+
+// exchange trades the authorization code for an access token and an
+// ID token, and returns the identity from the userinfo endpoint. The
+// ID token must be for this client and login, see checkIDToken.
+func (a *oidcAuth) exchange(code, nonce string) (oidcIdentity, error) {
+	provider, err := a.discover()
+	if err != nil {
+		return oidcIdentity{}, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {a.redirectURL},
+	}
+	req, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return oidcIdentity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.clientSecret))
+
+	token := struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}{}
+	if err := a.doJSON(req, &token); err != nil {
+		return oidcIdentity{}, fmt.Errorf("oidc token: %v", err)
+	}
+	subject, err := a.checkIDToken(token.IDToken, nonce)
+	if err != nil {
+		return oidcIdentity{}, fmt.Errorf("oidc id token: %v", err)
+	}
+
+	claims := map[string]interface{}{}
+	if err := a.getJSON(provider.UserinfoEndpoint, token.AccessToken, &claims); err != nil {
+		return oidcIdentity{}, fmt.Errorf("oidc userinfo: %v", err)
+	}
+	if claims["sub"] != subject {
+		return oidcIdentity{}, errors.New("oidc userinfo: the subject doesn't match the id token")
+	}
+
+	identity := oidcIdentity{Subject: subject}
+	// Unverified emails can be set to anyone's address.
+	if email, ok := claims["email"].(string); ok && claims["email_verified"] == true {
+		identity.Email = email
+	}
+	return identity, nil
+}
+
+// checkIDToken checks the claims of the ID token, and returns its
+// subject. The token comes straight from the provider's token endpoint
+// over TLS, so its signature isn't checked, as allowed by the spec.
+func (a *oidcAuth) checkIDToken(idToken, nonce string) (string, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return "", errors.New("missing or malformed")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	claims := struct {
+		Issuer   string          `json:"iss"`
+		Subject  string          `json:"sub"`
+		Audience json.RawMessage `json:"aud"` // a string, or a list of strings
+		Expiry   int64           `json:"exp"`
+		Nonce    string          `json:"nonce"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", err
+	}
+
+	audience := []string{}
+	if json.Unmarshal(claims.Audience, &audience) != nil {
+		single := ""
+		json.Unmarshal(claims.Audience, &single)
+		audience = []string{single}
+	}
+	forClient := false
+	for _, aud := range audience {
+		forClient = forClient || aud == a.clientID
+	}
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != a.issuer:
+		return "", fmt.Errorf("issued by %q", claims.Issuer)
+	case !forClient:
+		return "", errors.New("issued for another client")
+	case time.Now().Unix() > claims.Expiry:
+		return "", errors.New("expired")
+	case nonce == "" || !hmac.Equal([]byte(claims.Nonce), []byte(nonce)):
+		return "", errors.New("invalid nonce")
+	case claims.Subject == "":
+		return "", errors.New("no subject")
+	}
+	return claims.Subject, nil
+}
+
+// getJSON decodes the JSON response of a GET request.
+func (a *oidcAuth) getJSON(url, accessToken string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return a.doJSON(req, v)
+}
+
+// doJSON sends the request, and decodes the JSON response.
+func (a *oidcAuth) doJSON(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v responded %v", req.URL.Host, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+//
+// ------------------------------------------------------------------
+// Header backend
+// ------------------------------------------------------------------
+//
+
+// headerAuth trusts a header with the username, set by an auth proxy
+// (e.g. Authelia or oauth2-proxy). The proxy handles login and logout.
+// The header is only trusted on requests from the proxy's address.
+type headerAuth struct {
+	s         *Server
+	headers   []string     // checked in order, the first one set is used
+	proxies   []*net.IPNet // addresses allowed to set the headers
+	provision bool         // create accounts for new usernames
+}
+
+// newHeaderAuth parses the header backend Config.
+func newHeaderAuth(s *Server) (*headerAuth, error) {
+	a := headerAuth{s: s, provision: s.Config.AuthProvision}
+
+	for _, header := range strings.Split(s.Config.AuthHeader, ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			a.headers = append(a.headers, header)
+		}
+	}
+	if len(a.headers) == 0 {
+		return nil, errors.New("the header backend needs SIMPLENOTES_AUTH_HEADER")
+	}
+
+	proxies, err := parseTrustedProxies(s.Config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	a.proxies = proxies
+	if len(a.proxies) == 0 {
+		return nil, errors.New("the header backend needs SIMPLENOTES_TRUSTED_PROXIES")
+	}
+
+	return &a, nil
+}
+
+// fromProxy reports if the request was sent by a trusted proxy.
+func (a *headerAuth) fromProxy(r *http.Request) bool {
+	return fromProxy(r, a.proxies)
+}
+
+// parseTrustedProxies parses the TrustedProxies, a comma separated
+// list of IP addresses and CIDR ranges.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	proxies := []*net.IPNet{}
+	for _, proxy := range strings.Split(list, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SIMPLENOTES_TRUSTED_PROXIES: %v", err)
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return proxies, nil
+}
+
+// fromProxy reports if the request was sent by one of the proxies.
+func fromProxy(r *http.Request, proxies []*net.IPNet) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// username returns the username from the first header that is set.
+func (a *headerAuth) username(r *http.Request) string {
+	for _, header := range a.headers {
+		if username := strings.TrimSpace(r.Header.Get(header)); username != "" {
+			return strings.ToLower(username)
+		}
+	}
+	return ""
+}
+
+// Protect requires the header to name an account in the users table.
+// With provisioning on, the account is created on the user's first request.
+func (a *headerAuth) Protect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forbidden := func(message string) {
+			w.WriteHeader(http.StatusForbidden)
+			a.s.render(w, r, "login", LoginContext{Error: message})
+		}
+
+		if !a.fromProxy(r) {
+			log.Printf("[auth] rejected request from %v, which is not a trusted proxy", r.RemoteAddr)
+			forbidden("Sign in through the login proxy to use Simple Notes.")
+			return
+		}
+
+		username := a.username(r)
+		if username == "" {
+			forbidden("Your account does not have access to Simple Notes.")
+			return
+		}
+
+		user := User{}
+		err := a.s.DB.Where("username = ?", username).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) && a.provision {
+			user, err = provisionUser(a.s.DB, username)
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) || errors.Is(err, ErrInvalidUsername) {
+			forbidden("Your account does not have access to Simple Notes.")
+			return
+		}
+		if err != nil {
+			a.s.renderError(w, r, ErrDatabase, err)
+			return
+		}
+
+		next.ServeHTTP(w, withUser(r, user.Username))
+	})
+}
+
+// Handler passes all requests to h. The proxy serves login and logout.
+func (a *headerAuth) Handler(h http.Handler) http.Handler {
+	return h
+}
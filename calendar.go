@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// CalendarEventLength is the length of a Note's event in the calendar.
+const CalendarEventLength = 30 * time.Minute
+
+//
+// ------------------------------------------------------------------
+// Calendar feed
+// ------------------------------------------------------------------
+//
+
+// HandleCalendar serves the Notes as an iCalendar feed, with an event
+// at the date of each Note, except the drafts and scheduled ones. Calendar apps can't log in, so the feed is
+// also served with `?token=` set to the CalendarToken.
+func (s *Server) HandleCalendar(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if s.Config.CalendarToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.Config.CalendarToken)) != 1 {
+		s.Auth.Protect(http.HandlerFunc(s.serveCalendar)).ServeHTTP(w, r)
+		return
+	}
+	s.serveCalendar(w, r)
+}
+
+// serveCalendar writes the iCalendar feed.
+func (s *Server) serveCalendar(w http.ResponseWriter, r *http.Request) {
+	notes := []Note{}
+	if err := s.ReadDB.Preload("Tags").Where("not draft").Scopes(notScheduled).Order("date").Find(&notes).Error; err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	host := "simplenotes"
+	if u, err := url.Parse(s.Config.baseURL()); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+
+	c := &icalWriter{}
+	c.line("BEGIN", "VCALENDAR")
+	c.line("VERSION", "2.0")
+	c.line("PRODID", "-//Simple Notes//EN")
+	c.line("X-WR-CALNAME", "Simple Notes")
+	for _, note := range notes {
+		tags := []string{}
+		for _, tag := range note.Tags {
+			tags = append(tags, icalEscape(tag.Name))
+		}
+
+		c.line("BEGIN", "VEVENT")
+		c.line("UID", fmt.Sprintf("note-%v@%v", note.ID, host))
+		c.line("DTSTAMP", note.UpdatedAt.UTC().Format("20060102T150405Z"))
+		// Note dates have no timezone, so the times are floating.
+		c.line("DTSTART", note.Date.Format("20060102T150405"))
+		c.line("DTEND", note.Date.Add(CalendarEventLength).Format("20060102T150405"))
+		c.line("SUMMARY", icalEscape(note.DisplayTitle()))
+		c.line("DESCRIPTION", icalEscape(string(note.Body)))
+		c.line("URL", fmt.Sprintf("%v/note/%v/change", s.Config.baseURL(), note.ID))
+		if len(tags) > 0 {
+			c.line("CATEGORIES", strings.Join(tags, ","))
+		}
+		c.line("END", "VEVENT")
+	}
+	c.line("END", "VCALENDAR")
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(c.String()))
+}
+
+// icalWriter builds an iCalendar document. Lines are folded at 75
+// bytes, as required by RFC 5545.
+type icalWriter struct {
+	strings.Builder
+}
+
+// line writes a content line.
+func (c *icalWriter) line(name, value string) {
+	line := name + ":" + value
+	limit := 75
+	for len(line) > limit {
+		// Don't split a multi-byte character.
+		i := limit
+		for i > 0 && line[i]&0xC0 == 0x80 {
+			i--
+		}
+		c.WriteString(line[:i] + "\r\n ")
+		line = line[i:]
+		limit = 74 // the continuation lines start with a space
+	}
+	c.WriteString(line + "\r\n")
+}
+
+// icalEscape escapes a text value.
+func icalEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
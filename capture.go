@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tunedmystic/simplenotes/client"
+)
+
+//
+// ------------------------------------------------------------------
+// Capture
+// ------------------------------------------------------------------
+//
+
+// CapturedNote is a Note written in capture mode, waiting to be sent.
+type CapturedNote struct {
+	Body  string    `json:"body"`
+	Tags  string    `json:"tags"`
+	Date  time.Time `json:"date"`
+	Error string    `json:"error,omitempty"` // why the server rejected it, see park
+}
+
+// CaptureClient sends captured notes to a simplenotes server, with
+// its API token. Notes that cannot be sent are kept in a queue file
+// and retried later. Notes that the server rejects are parked in
+// another file, so they don't hold up the queue.
+type CaptureClient struct {
+	QueuePath string
+	client    *client.Client
+}
+
+// NewCaptureClient ...
+func NewCaptureClient(baseURL, token, queuePath string) *CaptureClient {
+	return &CaptureClient{
+		QueuePath: queuePath,
+		client:    client.New(baseURL, token),
+	}
+}
+
+// RejectedPath is the file of the notes that the server rejected.
+func (c *CaptureClient) RejectedPath() string {
+	return c.QueuePath + ".rejected"
+}
+
+// send creates a single note on the server, at the date it was captured.
+func (c *CaptureClient) send(note CapturedNote) error {
+	_, err := c.client.CreateNote(context.Background(), client.NoteInput{
+		Body: note.Body,
+		Tags: note.Tags,
+		Date: note.Date.Format(time.RFC3339),
+	})
+	return err
+}
+
+// rejected reports whether the server refused the note itself, e.g.
+// because its body is too long. Sending it again fails the same way.
+// Failed logins and throttling are retried.
+func rejected(err error) bool {
+	apiErr := &client.Error{}
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Status {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return apiErr.Status >= 400 && apiErr.Status < 500
+}
+
+// Capture queues the note, and then tries to send everything in the queue.
+func (c *CaptureClient) Capture(note CapturedNote) error {
+	queue, err := c.readQueue()
+	if err != nil {
+		return err
+	}
+	if err := c.writeQueue(append(queue, note)); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// Flush sends all queued notes. Notes that fail to send stay in the
+// queue, and rejected notes are parked.
+func (c *CaptureClient) Flush() error {
+	queue, err := c.readQueue()
+	if err != nil || len(queue) == 0 {
+		return err
+	}
+
+	parked := 0
+	for i, note := range queue {
+		err := c.send(note)
+		if rejected(err) {
+			if err := c.park(note, err); err != nil {
+				c.writeQueue(queue[i:])
+				return err
+			}
+			parked++
+			continue
+		}
+		if err != nil {
+			c.writeQueue(queue[i:])
+			return err
+		}
+	}
+	if err := c.writeQueue(nil); err != nil {
+		return err
+	}
+	if parked > 0 {
+		return fmt.Errorf("%v notes rejected by the server, kept in %v", parked, c.RejectedPath())
+	}
+	return nil
+}
+
+// park adds the rejected note to the RejectedPath, with the error.
+func (c *CaptureClient) park(note CapturedNote, err error) error {
+	parked := []CapturedNote{}
+	data, readErr := ioutil.ReadFile(c.RejectedPath())
+	if readErr == nil {
+		readErr = json.Unmarshal(data, &parked)
+	}
+	if readErr != nil && !os.IsNotExist(readErr) {
+		return readErr
+	}
+
+	note.Error = err.Error()
+	data, err = json.MarshalIndent(append(parked, note), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.RejectedPath(), data, 0600)
+}
+
+// Pending returns the number of queued notes.
+func (c *CaptureClient) Pending() int {
+	queue, _ := c.readQueue()
+	return len(queue)
+}
+
+func (c *CaptureClient) readQueue() ([]CapturedNote, error) {
+	queue := []CapturedNote{}
+	data, err := ioutil.ReadFile(c.QueuePath)
+	if os.IsNotExist(err) {
+		return queue, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &queue); err != nil {
+		return nil, err
+	}
+	return queue, nil
+}
+
+func (c *CaptureClient) writeQueue(queue []CapturedNote) error {
+	if len(queue) == 0 {
+		err := os.Remove(c.QueuePath)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	data, err := json.MarshalIndent(queue, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(c.QueuePath, data, 0600)
+}
+
+// CaptureResult is the outcome of capturing a single note, for --json output.
+type CaptureResult struct {
+	Body    string `json:"body"`
+	Saved   bool   `json:"saved"`
+	Pending int    `json:"pending"`
+	Error   string `json:"error,omitempty"`
+}
+
+// runCapture runs the interactive capture prompt.
+// Each line is a note. Tags can be added after a `#`, e.g. `buy milk # errands, home`.
+// With jsonOut, the prompt is hidden and each result is written as a line of JSON.
+func runCapture(out io.Writer, jsonOut bool) {
+	home, _ := os.UserHomeDir()
+	c := NewCaptureClient(
+		getEnv("SIMPLENOTES_URL", "http://localhost:3000"),
+		getEnv("SIMPLENOTES_API_TOKEN", ""),
+		getEnv("SIMPLENOTES_QUEUE", filepath.Join(home, ".simplenotes-queue.json")),
+	)
+	enc := json.NewEncoder(out)
+
+	if !jsonOut {
+		fmt.Fprintf(out, "Capturing to %v. One note per line, Ctrl-D to quit.\n", c.client.BaseURL)
+	}
+	if n := c.Pending(); n > 0 {
+		err := c.Flush()
+		if !jsonOut {
+			fmt.Fprintf(out, "%v queued notes, sending...\n", n)
+			if err != nil {
+				fmt.Fprintf(out, "  offline: %v\n", err)
+			}
+		}
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		if !jsonOut {
+			fmt.Fprint(out, "> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		note := CapturedNote{Body: line, Date: time.Now()}
+		if i := strings.LastIndex(line, "#"); i >= 0 {
+			note.Body = strings.TrimSpace(line[:i])
+			note.Tags = line[i+1:]
+		}
+
+		err := c.Capture(note)
+		result := CaptureResult{Body: note.Body, Saved: err == nil, Pending: c.Pending()}
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		switch {
+		case jsonOut:
+			enc.Encode(result)
+		case err != nil:
+			fmt.Fprintf(out, "  queued (%v pending): %v\n", result.Pending, err)
+		default:
+			fmt.Fprintln(out, "  saved")
+		}
+	}
+	if !jsonOut {
+		fmt.Fprintln(out)
+	}
+}
@@ -0,0 +1,663 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"golang.org/x/term"
+	"gorm.io/gorm/logger"
+)
+
+//
+// ------------------------------------------------------------------
+// CLI
+// ------------------------------------------------------------------
+//
+
+// Command is a CLI subcommand.
+type Command struct {
+	Name    string
+	Summary string
+	Args    []string // allowed values for the positional argument, if any
+	Flags   *flag.FlagSet
+	Run     func(args []string) error
+}
+
+// CLI holds the subcommands, and the state they share.
+type CLI struct {
+	cfg      Config
+	out      io.Writer
+	jsonOut  bool
+	commands []*Command
+}
+
+// NewCLI ...
+func NewCLI(cfg Config, out io.Writer) *CLI {
+	c := &CLI{cfg: cfg, out: out}
+
+	serve := c.command("serve", "Run the web server (default)", c.runServe)
+	c.jsonFlag(serve)
+
+	list := c.command("list", "List notes", nil)
+	listTag := list.Flags.String("tag", "", "only list notes with this tag or its nested tags, or none for untagged notes")
+	listLimit := list.Flags.Int("limit", 30, "max number of notes to list")
+	c.jsonFlag(list)
+	list.Run = func(args []string) error {
+		return c.runList(*listTag, *listLimit)
+	}
+
+	capture := c.command("capture", "Quickly capture notes to a server, queueing while offline", c.runCapture)
+	c.jsonFlag(capture)
+
+	c.command("tui", "Browse and edit notes in the terminal", c.runTUI)
+
+	encrypt := c.command("encrypt", "Encrypt existing plaintext note bodies", c.runEncrypt)
+	c.jsonFlag(encrypt)
+
+	export := c.command("export", "Write all notes as JSON, to stdout or a file", nil)
+	exportOut := export.Flags.String("out", "", "write to this file instead of stdout")
+	export.Run = func(args []string) error {
+		return c.runExport(*exportOut)
+	}
+
+	importCmd := c.command("import", "Create notes from an export file, an Evernote .enex file, a Google Keep Takeout .zip, a Simplenote or Notion export, or - for stdin", c.runImport)
+	c.jsonFlag(importCmd)
+
+	digest := c.command("digest", "Send the digest email of new notes now", c.runDigest)
+	c.jsonFlag(digest)
+
+	createUser := c.command("createuser", "Create a user account; the password is read from stdin", c.runCreateUser)
+	c.jsonFlag(createUser)
+
+	oidcLink := c.command("oidclink", "Link the subject of an OpenID Connect login to a username", c.runOIDCLink)
+	c.jsonFlag(oidcLink)
+
+	migrate := c.command("migrate", "Apply (up) or revert (down) schema migrations", nil)
+	migrate.Args = []string{"up", "down"}
+	migrateSteps := migrate.Flags.Int("steps", 1, "number of migrations to revert with down")
+	c.jsonFlag(migrate)
+	migrate.Run = func(args []string) error {
+		return c.runMigrate(args, *migrateSteps)
+	}
+
+	completion := c.command("completion", "Print a shell completion script", c.runCompletion)
+	completion.Args = []string{"bash", "zsh", "fish"}
+
+	return c
+}
+
+// command registers a new Command.
+func (c *CLI) command(name, summary string, run func(args []string) error) *Command {
+	cmd := &Command{
+		Name:    name,
+		Summary: summary,
+		Flags:   flag.NewFlagSet(name, flag.ContinueOnError),
+		Run:     run,
+	}
+	cmd.Flags.SetOutput(c.out)
+	c.commands = append(c.commands, cmd)
+	return cmd
+}
+
+// jsonFlag adds the --json flag to the Command.
+func (c *CLI) jsonFlag(cmd *Command) {
+	cmd.Flags.BoolVar(&c.jsonOut, "json", false, "write machine-readable JSON output")
+}
+
+// Run runs the subcommand named by the first argument.
+func (c *CLI) Run(args []string) error {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	// Kept for the old `go run . server` usage.
+	if name == "server" {
+		name = "serve"
+	}
+
+	if name == "help" {
+		c.usage()
+		return nil
+	}
+
+	for _, cmd := range c.commands {
+		if cmd.Name != name {
+			continue
+		}
+		positional, err := parseFlags(cmd.Flags, args)
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return cmd.Run(positional)
+	}
+
+	c.usage()
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// parseFlags parses the flags, which may appear before or after positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// usage prints the list of commands.
+func (c *CLI) usage() {
+	fmt.Fprintf(c.out, "Usage: simplenotes <command> [flags]\n\nCommands:\n")
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	for _, cmd := range c.commands {
+		fmt.Fprintf(w, "  %v\t%v\n", cmd.Name, cmd.Summary)
+	}
+	w.Flush()
+}
+
+// printJSON writes the value as indented JSON.
+func (c *CLI) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// quiet disables the SQL log, so it doesn't mix with the command output.
+func (c *CLI) quiet() {
+	c.cfg.LogLevel = logger.Silent
+}
+
+func (c *CLI) runServe(args []string) error {
+	s, migrations, err := openServer(c.cfg)
+	if err != nil {
+		return err
+	}
+
+	if c.jsonOut {
+		names := []string{}
+		for _, m := range migrations {
+			names = append(names, m.String())
+		}
+		c.printJSON(map[string]interface{}{
+			"addr":       c.cfg.Addr,
+			"read_dsn":   c.cfg.ReadDSN,
+			"migrations": names,
+		})
+	} else {
+		for _, m := range migrations {
+			fmt.Fprintf(c.out, "Applied migration %v\n", m)
+		}
+		if c.cfg.ReadDSN != "" {
+			fmt.Fprintf(c.out, "Serving reads from %v\n", c.cfg.ReadDSN)
+		}
+		fmt.Fprintf(c.out, "Running server on %v...\n", c.cfg.Addr)
+	}
+
+	if err := s.scheduleTasks(); err != nil {
+		return err
+	}
+	s.Scheduler.Start()
+	if c.cfg.TelegramToken != "" {
+		go NewTelegramBot(s).Run()
+	}
+	if c.cfg.GitMirror != "" {
+		mirror, err := NewGitMirror(s)
+		if err != nil {
+			return err
+		}
+		go mirror.Run()
+	}
+	if c.cfg.SyncDir != "" {
+		dirSync, err := NewDirSync(s)
+		if err != nil {
+			return err
+		}
+		go dirSync.Run()
+	}
+	if c.cfg.LinkPreviews {
+		previewer, err := NewLinkPreviewer(s)
+		if err != nil {
+			return err
+		}
+		go previewer.Run()
+	}
+
+	srv := &http.Server{Addr: c.cfg.Addr, Handler: s.Routes()}
+	serveErr := make(chan error, 1)
+	go func() {
+		if c.cfg.TLSCert != "" {
+			serveErr <- srv.ListenAndServeTLS(c.cfg.TLSCert, c.cfg.TLSKey)
+			return
+		}
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	// Stop gracefully on an interrupt: finish the requests and the
+	// running Tasks, and save the last page views.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		return err
+	case <-stop:
+	}
+	log.Printf("[server] stopping")
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err := s.Scheduler.Stop(ctx); err != nil {
+		return err
+	}
+	if s.Usage != nil {
+		return s.Usage.Flush()
+	}
+	return nil
+}
+
+func (c *CLI) runList(tag string, limit int) error {
+	c.quiet()
+	s, _, err := openServer(c.cfg)
+	if err != nil {
+		return err
+	}
+
+	// The same filters as the API: nested tags, no drafts, and no
+	// pending scheduled Notes.
+	sq := SearchQuery{}
+	switch tag = cleanTagName(tag); tag {
+	case "":
+	case "none":
+		sq.Untagged = true
+	default:
+		sq.Tags = []string{tag}
+	}
+
+	notes := []Note{}
+	if err := sq.Scope(s.ReadDB.Preload("Tags")).Order("date desc").Limit(limit).Find(&notes).Error; err != nil {
+		return err
+	}
+
+	results := []NoteJSON{}
+	for _, note := range notes {
+		results = append(results, newNoteJSON(note))
+	}
+
+	if c.jsonOut {
+		return c.printJSON(results)
+	}
+
+	w := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	for _, note := range results {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", note.ID, displayDateTime(note.Date), note.Title, strings.Join(note.Tags, ", "))
+	}
+	return w.Flush()
+}
+
+func (c *CLI) runCapture(args []string) error {
+	runCapture(c.out, c.jsonOut)
+	return nil
+}
+
+func (c *CLI) runTUI(args []string) error {
+	if url := os.Getenv("SIMPLENOTES_URL"); url != "" {
+		store, err := NewRemoteStore(url, os.Getenv("SIMPLENOTES_PASSWORD"))
+		if err != nil {
+			return err
+		}
+		return runTUI(store)
+	}
+
+	c.quiet()
+	s, _, err := openServer(c.cfg)
+	if err != nil {
+		return err
+	}
+	return runTUI(LocalStore{s})
+}
+
+func (c *CLI) runEncrypt(args []string) error {
+	c.quiet()
+	s, _, err := openServer(c.cfg)
+	if err != nil {
+		return err
+	}
+
+	count, err := encryptNotes(s.DB)
+	if err != nil {
+		return err
+	}
+
+	if c.jsonOut {
+		return c.printJSON(map[string]int{"encrypted": count})
+	}
+	fmt.Fprintf(c.out, "Encrypted %v notes\n", count)
+	return nil
+}
+
+func (c *CLI) runExport(out string) error {
+	c.quiet()
+	s, _, err := openServer(c.cfg)
+	if err != nil {
+		return err
+	}
+
+	w := c.out
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	count, err := exportNotes(context.Background(), s.ReadDB, w, nil)
+	if err != nil {
+		return err
+	}
+	if out != "" {
+		fmt.Fprintf(c.out, "Exported %v notes to %v\n", count, out)
+	}
+	return nil
+}
+
+func (c *CLI) runImport(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected an export file, or - for stdin")
+	}
+
+	var r io.Reader = os.Stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	c.quiet()
+	s, _, err := openServer(c.cfg)
+	if err != nil {
+		return err
+	}
+
+	file, err := decodeImportFile(args[0], r)
+	if err != nil {
+		return err
+	}
+	count, err := s.importNotes(context.Background(), file, false, nil)
+	if err != nil {
+		return err
+	}
+
+	if c.jsonOut {
+		return c.printJSON(map[string]int{"imported": count})
+	}
+	fmt.Fprintf(c.out, "Imported %v notes\n", count)
+	return nil
+}
+
+func (c *CLI) runCreateUser(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected a username")
+	}
+
+	password, err := readPassword(c.out)
+	if err != nil {
+		return err
+	}
+
+	c.quiet()
+	s, _, err := openServer(c.cfg)
+	if err != nil {
+		return err
+	}
+
+	user, err := createUser(s.DB, args[0], password)
+	if err != nil {
+		return err
+	}
+
+	if c.jsonOut {
+		return c.printJSON(map[string]interface{}{"id": user.ID, "username": user.Username})
+	}
+	fmt.Fprintf(c.out, "Created user %v\n", user.Username)
+	return nil
+}
+
+func (c *CLI) runOIDCLink(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected an oidc subject and a username")
+	}
+
+	c.quiet()
+	s, _, err := openServer(c.cfg)
+	if err != nil {
+		return err
+	}
+
+	account, err := linkOIDCAccount(s.DB, args[0], args[1])
+	if err != nil {
+		return err
+	}
+
+	if c.jsonOut {
+		return c.printJSON(map[string]interface{}{"subject": account.Subject, "username": account.Username})
+	}
+	fmt.Fprintf(c.out, "Linked oidc subject %v to %v\n", account.Subject, account.Username)
+	return nil
+}
+
+func (c *CLI) runDigest(args []string) error {
+	// Without a schedule, the digest covers the last day.
+	if c.cfg.Digest == "" {
+		c.cfg.Digest = DigestDaily
+	}
+
+	c.quiet()
+	s, _, err := openServer(c.cfg)
+	if err != nil {
+		return err
+	}
+
+	sent, err := s.sendDigest()
+	if err != nil {
+		return err
+	}
+
+	if c.jsonOut {
+		return c.printJSON(map[string]interface{}{"notes": sent, "to": c.cfg.DigestTo})
+	}
+	if sent == 0 {
+		fmt.Fprintln(c.out, "No new notes, the digest was not sent")
+		return nil
+	}
+	fmt.Fprintf(c.out, "Sent the digest of %v notes to %v\n", sent, c.cfg.DigestTo)
+	return nil
+}
+
+// readPassword reads a password from stdin. On a terminal, the user is
+// prompted, and the input is not echoed.
+func readPassword(prompt io.Writer) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(prompt, "Password: ")
+		password, err := term.ReadPassword(fd)
+		fmt.Fprintln(prompt)
+		return string(password), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *CLI) runMigrate(args []string, steps int) error {
+	direction := "up"
+	if len(args) > 0 {
+		direction = args[0]
+	}
+
+	c.quiet()
+	db, err := openDB(c.cfg.DSN, c.cfg)
+	if err != nil {
+		return err
+	}
+
+	var migrations []Migration
+	switch direction {
+	case "up":
+		migrations, err = MigrateUp(db)
+	case "down":
+		migrations, err = MigrateDown(db, steps)
+	default:
+		return fmt.Errorf("unknown migrate direction %q, expected up or down", direction)
+	}
+
+	names := []string{}
+	for _, m := range migrations {
+		names = append(names, m.String())
+	}
+
+	if c.jsonOut {
+		result := map[string]interface{}{"direction": direction, "migrations": names}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		c.printJSON(result)
+		return err
+	}
+
+	for _, name := range names {
+		if direction == "up" {
+			fmt.Fprintf(c.out, "Applied migration %v\n", name)
+		} else {
+			fmt.Fprintf(c.out, "Reverted migration %v\n", name)
+		}
+	}
+	if len(names) == 0 && err == nil {
+		fmt.Fprintln(c.out, "Nothing to migrate")
+	}
+	return err
+}
+
+func (c *CLI) runCompletion(args []string) error {
+	if len(args) == 0 {
+		return errors.New("expected a shell: bash, zsh or fish")
+	}
+
+	switch args[0] {
+	case "bash":
+		c.completionBash()
+	case "zsh":
+		c.completionZsh()
+	case "fish":
+		c.completionFish()
+	default:
+		return fmt.Errorf("unsupported shell %q", args[0])
+	}
+	return nil
+}
+
+// flagNames returns the Command's flags, as `--name`.
+func (cmd *Command) flagNames() []string {
+	names := []string{}
+	cmd.Flags.VisitAll(func(f *flag.Flag) {
+		names = append(names, "--"+f.Name)
+	})
+	sort.Strings(names)
+	return names
+}
+
+func (c *CLI) completionBash() {
+	names := []string{}
+	for _, cmd := range c.commands {
+		names = append(names, cmd.Name)
+	}
+
+	fmt.Fprintf(c.out, "_simplenotes() {\n")
+	fmt.Fprintf(c.out, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	fmt.Fprintf(c.out, "    if [ \"$COMP_CWORD\" -eq 1 ]; then\n")
+	fmt.Fprintf(c.out, "        COMPREPLY=($(compgen -W \"%v\" -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintf(c.out, "        return\n")
+	fmt.Fprintf(c.out, "    fi\n")
+	fmt.Fprintf(c.out, "    case \"${COMP_WORDS[1]}\" in\n")
+	for _, cmd := range c.commands {
+		words := append(cmd.flagNames(), cmd.Args...)
+		fmt.Fprintf(c.out, "        %v) COMPREPLY=($(compgen -W \"%v\" -- \"$cur\")) ;;\n", cmd.Name, strings.Join(words, " "))
+	}
+	fmt.Fprintf(c.out, "    esac\n")
+	fmt.Fprintf(c.out, "}\n")
+	fmt.Fprintf(c.out, "complete -F _simplenotes simplenotes\n")
+}
+
+func (c *CLI) completionZsh() {
+	fmt.Fprintf(c.out, "#compdef simplenotes\n\n")
+	fmt.Fprintf(c.out, "_simplenotes() {\n")
+	fmt.Fprintf(c.out, "    local -a commands\n")
+	fmt.Fprintf(c.out, "    commands=(\n")
+	for _, cmd := range c.commands {
+		fmt.Fprintf(c.out, "        '%v:%v'\n", cmd.Name, cmd.Summary)
+	}
+	fmt.Fprintf(c.out, "    )\n")
+	fmt.Fprintf(c.out, "    if (( CURRENT == 2 )); then\n")
+	fmt.Fprintf(c.out, "        _describe 'command' commands\n")
+	fmt.Fprintf(c.out, "        return\n")
+	fmt.Fprintf(c.out, "    fi\n")
+	fmt.Fprintf(c.out, "    case $words[2] in\n")
+	for _, cmd := range c.commands {
+		words := append(cmd.flagNames(), cmd.Args...)
+		fmt.Fprintf(c.out, "        %v) compadd -- %v ;;\n", cmd.Name, strings.Join(words, " "))
+	}
+	fmt.Fprintf(c.out, "    esac\n")
+	fmt.Fprintf(c.out, "}\n\n")
+	fmt.Fprintf(c.out, "compdef _simplenotes simplenotes\n")
+}
+
+func (c *CLI) completionFish() {
+	fmt.Fprintf(c.out, "complete -c simplenotes -f\n")
+	for _, cmd := range c.commands {
+		fmt.Fprintf(c.out, "complete -c simplenotes -n __fish_use_subcommand -a %v -d '%v'\n", cmd.Name, cmd.Summary)
+	}
+	for _, cmd := range c.commands {
+		condition := fmt.Sprintf("'__fish_seen_subcommand_from %v'", cmd.Name)
+		cmd.Flags.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(c.out, "complete -c simplenotes -n %v -l %v -d '%v'\n", condition, f.Name, f.Usage)
+		})
+		if len(cmd.Args) > 0 {
+			fmt.Fprintf(c.out, "complete -c simplenotes -n %v -a '%v'\n", condition, strings.Join(cmd.Args, " "))
+		}
+	}
+}
+
+// RunCLI runs the command line interface with the given arguments.
+func RunCLI(cfg Config, args []string, out io.Writer) error {
+	return NewCLI(cfg, out).Run(args)
+}
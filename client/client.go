@@ -0,0 +1,405 @@
+// Package client is a Go client for the Simple Notes JSON API.
+//
+// Create a token by setting SIMPLENOTES_API_TOKEN on the server, then:
+//
+//	c := client.New("https://notes.example.com", token)
+//	note, err := c.CreateNote(ctx, client.NoteInput{Body: "Groceries", Tags: "home"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retry defaults.
+const (
+	DefaultRetries   = 3
+	DefaultRetryWait = 500 * time.Millisecond
+)
+
+//
+// ------------------------------------------------------------------
+// Types
+// ------------------------------------------------------------------
+//
+
+// Note is a note, as returned by the API.
+type Note struct {
+	ID         uint       `json:"id"`
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	Date       time.Time  `json:"date"`
+	Tags       []string   `json:"tags"`
+	Monospace  bool       `json:"monospace"`
+	Draft      bool       `json:"draft"`                // hidden from the lists until published
+	Scheduled  bool       `json:"scheduled"`            // hidden from the lists until its date
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // when the note is deleted for good
+	Words      int        `json:"words"`
+	Characters int        `json:"characters"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	Seq        int64      `json:"seq"`               // of the note's last change
+	Snippet    string     `json:"snippet,omitempty"` // search results only, html with <mark>ed matches
+}
+
+// NoteInput creates or updates a note. The date and time are ISO 8601,
+// e.g. "2021-03-04" and "10:20", in the time zone, which defaults to
+// the server's. The tags are comma separated. An empty date means now,
+// or when updating, the note's current date.
+type NoteInput struct {
+	Body      string `json:"body"`
+	Date      string `json:"date,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Timezone  string `json:"timezone,omitempty"` // e.g. Europe/Paris
+	Tags      string `json:"tags"`
+	Monospace *bool  `json:"monospace,omitempty"`  // when updating, nil keeps the note's
+	Draft     bool   `json:"draft"`                // when creating, hides the note until published
+	Scheduled *bool  `json:"scheduled,omitempty"`  // hides the note until its date; when updating, nil keeps the note's
+	ExpiresIn string `json:"expires_in,omitempty"` // e.g. "24h" deletes the note for good a day later, "never" keeps it
+
+	// UpdatedAt rejects an update with a conflict error if the note
+	// was changed after this version of it, see IsConflict.
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// Tag is a tag, with its amount of notes.
+type Tag struct {
+	Name  string `json:"name"`
+	Notes int    `json:"notes"`
+}
+
+// TagCloudEntry is a tag of the tag cloud, weighted by its amount of
+// notes.
+type TagCloudEntry struct {
+	Name   string  `json:"name"`
+	Notes  int     `json:"notes"`
+	Weight float64 `json:"weight"` // from 0 for the least used tag, to 1 for the most used
+	Color  string  `json:"color,omitempty"`
+}
+
+// Stats are the totals and averages of all notes.
+type Stats struct {
+	Notes             int64 `json:"notes"`
+	Words             int64 `json:"words"`
+	AverageWords      int   `json:"average_words"`
+	AverageCharacters int   `json:"average_characters"`
+	LongestStreak     int   `json:"longest_streak"` // in days
+}
+
+// Changes are the notes that were changed or deleted since a time,
+// or a sequence number.
+type Changes struct {
+	Notes      []Note      `json:"notes"`      // created or updated notes
+	Deleted    []uint      `json:"deleted"`    // ids of deleted notes
+	Tombstones []Tombstone `json:"tombstones"` // the deleted notes, with when
+	Until      time.Time   `json:"until"`      // the since of the next call to Changes
+	Seq        int64       `json:"seq"`        // the since of the next call to ChangesSince
+}
+
+// Tombstone is a deleted note in the Changes.
+type Tombstone struct {
+	ID        uint      `json:"id"`
+	DeletedAt time.Time `json:"deleted_at"`
+	Seq       int64     `json:"seq"`
+}
+
+// ListOptions filter and order the notes of ListNotes.
+type ListOptions struct {
+	Limit int    // up to 500, 30 when zero
+	Sort  string // date, created or updated
+	Dir   string // asc or desc
+	Query string // a search, e.g. `groceries tag:home after:2021-01-01`
+	Tag   string // the notes of a tag and its children, or "none" for untagged notes
+	After string // the Next of the previous Page, which keeps its sort
+}
+
+// Page is a page of notes, see ListPage.
+type Page struct {
+	Notes []Note
+	Next  string // the After of the next page, empty when there is none
+}
+
+// Error is a failed API request.
+type Error struct {
+	Status    int      `json:"-"`    // the HTTP status code
+	Code      string   `json:"code"` // e.g. SN-1003
+	Errors    []string `json:"errors"`
+	RequestID string   `json:"request_id"` // to find the request in the server log
+}
+
+func (e *Error) Error() string {
+	message := fmt.Sprintf("%v %v", e.Status, http.StatusText(e.Status))
+	if len(e.Errors) > 0 {
+		message = strings.Join(e.Errors, ", ")
+	}
+	if e.RequestID != "" {
+		return fmt.Sprintf("simplenotes: %v (request %v)", message, e.RequestID)
+	}
+	return "simplenotes: " + message
+}
+
+// IsNotFound reports whether the error is a missing note.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsConflict reports whether the error is an update of a note that was
+// changed since it was read.
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+func hasStatus(err error, status int) bool {
+	apiErr := &Error{}
+	return errors.As(err, &apiErr) && apiErr.Status == status
+}
+
+//
+// ------------------------------------------------------------------
+// Client
+// ------------------------------------------------------------------
+//
+
+// Client calls the API of a Simple Notes server.
+type Client struct {
+	BaseURL    string // e.g. https://notes.example.com
+	Token      string // the server's SIMPLENOTES_API_TOKEN
+	HTTPClient *http.Client
+
+	// Failed GET, PUT and DELETE requests, i.e. network errors and
+	// 429 and 5xx responses, are retried up to Retries times. The
+	// wait starts at RetryWait and doubles with each retry.
+	Retries   int
+	RetryWait time.Duration
+}
+
+// New ...
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+		Retries:    DefaultRetries,
+		RetryWait:  DefaultRetryWait,
+	}
+}
+
+// ListNotes returns the most recent notes.
+func (c *Client) ListNotes(ctx context.Context, opts ListOptions) ([]Note, error) {
+	page, err := c.ListPage(ctx, opts)
+	return page.Notes, err
+}
+
+// ListPage returns a page of notes. To read all notes, e.g. to sync
+// a large library, pass the Next of each page as the After of the
+// next call, until it is empty.
+func (c *Client) ListPage(ctx context.Context, opts ListOptions) (Page, error) {
+	query := url.Values{}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Dir != "" {
+		query.Set("dir", opts.Dir)
+	}
+	if opts.Query != "" {
+		query.Set("q", opts.Query)
+	}
+	if opts.Tag != "" {
+		query.Set("tag", opts.Tag)
+	}
+	if opts.After != "" {
+		query.Set("after", opts.After)
+	}
+
+	page := Page{Notes: []Note{}}
+	header, err := c.request(ctx, http.MethodGet, "/api/notes?"+query.Encode(), nil, &page.Notes)
+	page.Next = header.Get("X-Next-Cursor")
+	return page, err
+}
+
+// Search returns the notes that match the search, with snippets of
+// the matches. See ListOptions for the search syntax.
+func (c *Client) Search(ctx context.Context, query string, limit int) ([]Note, error) {
+	return c.ListNotes(ctx, ListOptions{Query: query, Limit: limit})
+}
+
+// GetNote returns a single note.
+func (c *Client) GetNote(ctx context.Context, id uint) (Note, error) {
+	note := Note{}
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/api/notes/%v", id), nil, &note)
+	return note, err
+}
+
+// CreateNote creates a note.
+func (c *Client) CreateNote(ctx context.Context, input NoteInput) (Note, error) {
+	note := Note{}
+	err := c.do(ctx, http.MethodPost, "/api/notes", input, &note)
+	return note, err
+}
+
+// UpdateNote updates a note.
+func (c *Client) UpdateNote(ctx context.Context, id uint, input NoteInput) (Note, error) {
+	note := Note{}
+	err := c.do(ctx, http.MethodPut, fmt.Sprintf("/api/notes/%v", id), input, &note)
+	return note, err
+}
+
+// DeleteNote deletes a note.
+func (c *Client) DeleteNote(ctx context.Context, id uint) error {
+	return c.do(ctx, http.MethodDelete, fmt.Sprintf("/api/notes/%v", id), nil, nil)
+}
+
+// Tags returns all tags.
+func (c *Client) Tags(ctx context.Context) ([]Tag, error) {
+	tags := []Tag{}
+	err := c.do(ctx, http.MethodGet, "/api/tags", nil, &tags)
+	return tags, err
+}
+
+// TagCloud returns the tags, weighted by their amount of notes.
+func (c *Client) TagCloud(ctx context.Context) ([]TagCloudEntry, error) {
+	entries := []TagCloudEntry{}
+	err := c.do(ctx, http.MethodGet, "/api/tagcloud", nil, &entries)
+	return entries, err
+}
+
+// Stats returns the totals and averages of all notes.
+func (c *Client) Stats(ctx context.Context) (Stats, error) {
+	stats := Stats{}
+	err := c.do(ctx, http.MethodGet, "/api/stats", nil, &stats)
+	return stats, err
+}
+
+// Changes returns the notes that were changed or deleted since the
+// time. The zero time returns all notes. To keep a copy of the notes
+// in sync, pass the Until of the previous Changes.
+func (c *Client) Changes(ctx context.Context, since time.Time) (Changes, error) {
+	path := "/api/changes"
+	if !since.IsZero() {
+		path += "?since=" + url.QueryEscape(since.Format(time.RFC3339Nano))
+	}
+
+	changes := Changes{}
+	err := c.do(ctx, http.MethodGet, path, nil, &changes)
+	return changes, err
+}
+
+// ChangesSince returns the notes that were changed or deleted since the
+// sequence number. Zero returns all notes. To keep a copy of the notes
+// in sync, pass the Seq of the previous Changes. Unlike Changes, it
+// also returns notes that were starred, archived, or had a tag renamed.
+func (c *Client) ChangesSince(ctx context.Context, seq int64) (Changes, error) {
+	path := "/api/changes"
+	if seq > 0 {
+		path += "?since=" + strconv.FormatInt(seq, 10)
+	}
+
+	changes := Changes{}
+	err := c.do(ctx, http.MethodGet, path, nil, &changes)
+	return changes, err
+}
+
+// do performs an API request, with retries, and decodes the JSON
+// response into out.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) error {
+	_, err := c.request(ctx, method, path, in, out)
+	return err
+}
+
+// request is do, which also returns the headers of the response.
+func (c *Client) request(ctx context.Context, method, path string, in, out interface{}) (http.Header, error) {
+	header := http.Header{}
+	var body []byte
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return header, err
+		}
+		body = b
+	}
+
+	retries := 0
+	if method != http.MethodPost {
+		retries = c.Retries
+	}
+	wait := c.RetryWait
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, body)
+		if err == nil {
+			header = resp.Header
+			err = decodeResponse(resp, out)
+		} else if ctx.Err() != nil {
+			return header, err
+		}
+		if attempt >= retries || !retryable(err, resp) {
+			return header, err
+		}
+
+		select {
+		case <-time.After(wait):
+			wait *= 2
+		case <-ctx.Done():
+			return header, ctx.Err()
+		}
+	}
+}
+
+// send sends a single request.
+func (c *Client) send(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return httpClient.Do(req)
+}
+
+// decodeResponse decodes the response into out, or into an Error.
+func decodeResponse(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := &Error{}
+		json.NewDecoder(resp.Body).Decode(apiErr)
+		apiErr.Status = resp.StatusCode
+		if apiErr.RequestID == "" {
+			apiErr.RequestID = resp.Header.Get("X-Request-Id")
+		}
+		return apiErr
+	}
+	if out == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// retryable reports whether the request may succeed when sent again,
+// i.e. after a network error, or a 429 or 5xx response.
+func retryable(err error, resp *http.Response) bool {
+	return err != nil && (resp == nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500)
+}
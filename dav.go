@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"golang.org/x/net/webdav"
+	"gorm.io/gorm"
+)
+
+// DAVPrefix is the path the WebDAV interface is served at.
+const DAVPrefix = "/dav"
+
+func init() {
+	// Let chi route the WebDAV methods.
+	for _, method := range []string{"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK"} {
+		chi.RegisterMethod(method)
+	}
+}
+
+// davFileID matches the Note id in a file name, e.g. "… (12).md".
+var davFileID = regexp.MustCompile(`\((\d+)\)\.md$`)
+
+//
+// ------------------------------------------------------------------
+// WebDAV
+// ------------------------------------------------------------------
+//
+
+// HandleDAV serves the Notes as Markdown files over WebDAV, e.g. to
+// mount them as a network drive. WebDAV clients can't use the login
+// page, so requests use basic auth with the WebDAVPassword.
+func (s *Server) HandleDAV() http.Handler {
+	dav := &webdav.Handler{
+		Prefix:     DAVPrefix,
+		FileSystem: davFS{s},
+		LockSystem: webdav.NewMemLS(),
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(s.Config.WebDAVPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Simple Notes"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+	})
+}
+
+// davFS is a flat directory with a file per Note. The files are named
+// by date and title, with the id, e.g. "2021-03-04 Groceries (12).md".
+//
+// Writing a file updates the Note's body, a new .md file creates a
+// Note and deleting a file deletes its Note. The date and tags of a
+// Note are kept; renames and directories are not supported.
+type davFS struct {
+	s *Server
+}
+
+// davFileName returns the file name of the Note.
+func davFileName(note Note) string {
+	title := strings.NewReplacer("/", "-", "\\", "-", ":", "-").Replace(note.DisplayTitle())
+	return fmt.Sprintf("%v %v (%v).md", note.Date.Format(NoteDayFormat), title, note.ID)
+}
+
+// note returns the Note of the file name.
+func (fs davFS) note(name string) (Note, error) {
+	note := Note{}
+	match := davFileID.FindStringSubmatch(name)
+	if match == nil {
+		return note, os.ErrNotExist
+	}
+	err := fs.s.DB.Preload("Tags").First(&note, match[1]).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return note, os.ErrNotExist
+	}
+	return note, err
+}
+
+// actor returns the Actor of the WebDAV request.
+func (fs davFS) actor(ctx context.Context) Actor {
+	return Actor{Source: "webdav", RequestID: middleware.GetReqID(ctx)}
+}
+
+// Mkdir implements webdav.FileSystem.
+func (fs davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return os.ErrPermission
+}
+
+// Rename implements webdav.FileSystem.
+func (fs davFS) Rename(ctx context.Context, oldName, newName string) error {
+	return os.ErrPermission
+}
+
+// RemoveAll implements webdav.FileSystem.
+func (fs davFS) RemoveAll(ctx context.Context, name string) error {
+	note, err := fs.note(name)
+	if err != nil {
+		return err
+	}
+	return fs.s.deleteNote(fs.actor(ctx), fmt.Sprint(note.ID))
+}
+
+// Stat implements webdav.FileSystem.
+func (fs davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if strings.Trim(name, "/") == "" {
+		return davFileInfo{name: "/", dir: true}, nil
+	}
+	note, err := fs.note(name)
+	if err != nil {
+		return nil, err
+	}
+	return newDAVFileInfo(note), nil
+}
+
+// OpenFile implements webdav.FileSystem.
+func (fs davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if strings.Trim(name, "/") == "" {
+		return &davFile{fs: fs, info: davFileInfo{name: "/", dir: true}}, nil
+	}
+
+	note, err := fs.note(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0 && strings.HasSuffix(name, ".md"):
+		// A new Note, created on close.
+		return &davFile{fs: fs, ctx: ctx, info: davFileInfo{name: path.Base(name)}, writes: &bytes.Buffer{}}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	f := &davFile{fs: fs, ctx: ctx, note: &note, info: newDAVFileInfo(note), reader: strings.NewReader(string(note.Body))}
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		f.writes = &bytes.Buffer{}
+	}
+	return f, nil
+}
+
+// davFile is an open Note file, or the directory.
+// Writes are saved when the file is closed.
+type davFile struct {
+	fs     davFS
+	ctx    context.Context
+	info   davFileInfo
+	reader *strings.Reader // nil for new files and the directory
+	note   *Note           // nil for new files and the directory
+	writes *bytes.Buffer   // nil unless opened for writing
+}
+
+// Write implements webdav.File.
+func (f *davFile) Write(p []byte) (int, error) {
+	if f.writes == nil {
+		return 0, os.ErrPermission
+	}
+	return f.writes.Write(p)
+}
+
+// Read implements webdav.File.
+func (f *davFile) Read(p []byte) (int, error) {
+	if f.reader == nil {
+		return 0, io.EOF
+	}
+	return f.reader.Read(p)
+}
+
+// Seek implements webdav.File.
+func (f *davFile) Seek(offset int64, whence int) (int64, error) {
+	if f.reader == nil {
+		return 0, nil
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+// Stat implements webdav.File.
+func (f *davFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// Readdir implements webdav.File. It lists all Notes, newest first.
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.dir {
+		return nil, os.ErrInvalid
+	}
+	notes := []Note{}
+	if err := f.fs.s.ReadDB.Order(DefaultNoteSort.OrderBy()).Find(&notes).Error; err != nil {
+		return nil, err
+	}
+	infos := []os.FileInfo{}
+	for _, note := range notes {
+		infos = append(infos, newDAVFileInfo(note))
+	}
+	return infos, nil
+}
+
+// Close implements webdav.File. The written body is saved.
+func (f *davFile) Close() error {
+	if f.writes == nil {
+		return nil
+	}
+	// Editors end files with a newline, which isn't part of the body.
+	body := strings.TrimRight(f.writes.String(), "\r\n")
+	actor := f.fs.actor(f.ctx)
+
+	if f.note == nil {
+		form := messageForm("", body)
+		if !form.IsValid() {
+			return errors.New(strings.Join(form.Errors, ", "))
+		}
+		_, err := f.fs.s.createNote(actor, &form)
+		return err
+	}
+
+	if body == string(f.note.Body) {
+		return nil
+	}
+	form := NoteForm{
+		Body:      body,
+		Date:      f.note.Date.Format(NotePartialDateFormat),
+		Time:      f.note.Date.Format(NotePartialTimeFormat),
+		Tags:      noteTagNames(*f.note),
+		Monospace: f.note.Monospace,
+		Scheduled: f.note.Scheduled,
+	}
+	if !form.IsValid() {
+		return errors.New(strings.Join(form.Errors, ", "))
+	}
+	return f.fs.s.updateNote(actor, f.note, &form)
+}
+
+// davFileInfo implements os.FileInfo for Notes and the directory.
+type davFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func newDAVFileInfo(note Note) davFileInfo {
+	return davFileInfo{name: davFileName(note), size: int64(len(note.Body)), modTime: note.UpdatedAt}
+}
+
+func (i davFileInfo) Name() string       { return i.name }
+func (i davFileInfo) Size() int64        { return i.size }
+func (i davFileInfo) ModTime() time.Time { return i.modTime }
+func (i davFileInfo) IsDir() bool        { return i.dir }
+func (i davFileInfo) Sys() interface{}   { return nil }
+
+func (i davFileInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
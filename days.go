@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+//
+// ------------------------------------------------------------------
+// Days
+// ------------------------------------------------------------------
+//
+
+// DayContext provides context data to the day page.
+type DayContext struct {
+	IndexContext
+	Day  string // YYYY-MM-DD
+	Date string // the day, for display
+	Prev string // the closest earlier day with Notes, if any
+	Next string // the closest later day with Notes, if any
+}
+
+// HandleDay serves the Notes of a single day, with links
+// to the previous and next days that have Notes. Drafts and pending
+// scheduled Notes are left out, as on the index.
+func (s *Server) HandleDay(w http.ResponseWriter, r *http.Request) {
+	day, err := time.Parse(NoteDayFormat, chi.URLParam(r, "day"))
+	if err != nil {
+		s.renderError(w, r, ErrBadRequest, err)
+		return
+	}
+
+	requestContext := DayContext{
+		Day:  day.Format(NoteDayFormat),
+		Date: displayDay(day),
+	}
+	requestContext.ReviewBefore = s.Config.reviewBefore()
+
+	err = notScheduled(s.ReadDB.Preload("Tags")).
+		Where("date(date) = ?", requestContext.Day).
+		Where("not draft").
+		Order("date").
+		Find(&requestContext.Notes).Error
+	if err == nil {
+		requestContext.AutoTags, err = autoTags(s.ReadDB, requestContext.Notes)
+	}
+	if err == nil && s.Config.LinkPreviews {
+		requestContext.Previews, err = linkPreviews(s.ReadDB, requestContext.Notes)
+	}
+	if err == nil {
+		requestContext.Prev, requestContext.Next, err = adjacentDays(s.ReadDB, requestContext.Day)
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	s.render(w, r, "day", requestContext)
+}
+
+// HandleDayJump redirects to the day page of the date picked with
+// `?date=YYYY-MM-DD`.
+func (s *Server) HandleDayJump(w http.ResponseWriter, r *http.Request) {
+	day, err := time.Parse(NoteDayFormat, r.URL.Query().Get("date"))
+	if err != nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/day/"+day.Format(NoteDayFormat), http.StatusFound)
+}
+
+// OnThisDayContext provides context data to the "on this day" page.
+type OnThisDayContext struct {
+	IndexContext
+	Day  string // YYYY-MM-DD
+	Date string // the month and day, for display
+}
+
+// HandleOnThisDay serves the Notes written on the same calendar day
+// in previous years, newest first, except the drafts. The day is today, or the one picked
+// with `?date=YYYY-MM-DD`.
+func (s *Server) HandleOnThisDay(w http.ResponseWriter, r *http.Request) {
+	day := time.Now()
+	if date := r.URL.Query().Get("date"); date != "" {
+		var err error
+		if day, err = time.Parse(NoteDayFormat, date); err != nil {
+			s.renderError(w, r, ErrBadRequest, err)
+			return
+		}
+	}
+
+	requestContext := OnThisDayContext{
+		Day:  day.Format(NoteDayFormat),
+		Date: formatDate(day, "January 2"),
+	}
+	requestContext.ReviewBefore = s.Config.reviewBefore()
+
+	// The notes of February 29 show on February 28 of other years.
+	monthDays := []string{day.Format("01-02")}
+	if day.Month() == time.February && day.Day() == 28 && day.AddDate(0, 0, 1).Day() == 1 {
+		monthDays = append(monthDays, "02-29")
+	}
+
+	// Uses the idx_notes_month_day index.
+	err := s.ReadDB.Preload("Tags").
+		Where("strftime('%m-%d', date) in ?", monthDays).
+		Where("strftime('%Y', date) < ?", day.Format("2006")).
+		Where("not draft").
+		Order("date desc").
+		Find(&requestContext.Notes).Error
+	if err == nil {
+		requestContext.AutoTags, err = autoTags(s.ReadDB, requestContext.Notes)
+	}
+	if err == nil && s.Config.LinkPreviews {
+		requestContext.Previews, err = linkPreviews(s.ReadDB, requestContext.Notes)
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	s.render(w, r, "onthisday", requestContext)
+}
+
+// adjacentDays returns the closest days before and after the day
+// that have Notes. Days without Notes are skipped, as are days with
+// only drafts or pending scheduled Notes.
+func adjacentDays(db *gorm.DB, day string) (prev, next string, err error) {
+	shown := func() *gorm.DB {
+		return notScheduled(db.Model(&Note{})).Where("not draft")
+	}
+	err = shown().
+		Select("coalesce(max(date(date)), '')").
+		Where("date(date) < ?", day).
+		Scan(&prev).Error
+	if err != nil {
+		return "", "", err
+	}
+	err = shown().
+		Select("coalesce(min(date(date)), '')").
+		Where("date(date) > ?", day).
+		Scan(&next).Error
+	return prev, next, err
+}
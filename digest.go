@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Digest periods.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+//
+// ------------------------------------------------------------------
+// Digest
+// ------------------------------------------------------------------
+//
+
+// digestPeriod returns how far back the digest of the period looks.
+func digestPeriod(period string) (time.Duration, error) {
+	switch period {
+	case DigestDaily:
+		return 24 * time.Hour, nil
+	case DigestWeekly:
+		return 7 * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid SIMPLENOTES_DIGEST %q, use daily or weekly", period)
+	}
+}
+
+// digestSchedule returns the schedule of the digest: every day at
+// DigestHour, or for weekly digests, Mondays at DigestHour.
+func digestSchedule(period string, hour int) string {
+	if period == DigestWeekly {
+		return fmt.Sprintf("0 %v * * 1", hour)
+	}
+	return fmt.Sprintf("0 %v * * *", hour)
+}
+
+// runDigest is the digest Task. Failed digests are not retried; the
+// next one is sent as usual.
+func (s *Server) runDigest(ctx context.Context) error {
+	sent, err := s.sendDigest()
+	if err != nil {
+		return err
+	}
+	log.Printf("[digest] sent %v notes to %v", sent, s.Config.DigestTo)
+	return nil
+}
+
+// sendDigest emails a summary of the Notes created in the last period,
+// except the drafts and scheduled ones, and of the Notes tagged "remember" that are due,
+// with a link to each.
+// No email is sent when there are no such Notes.
+// It returns the number of Notes in the digest.
+func (s *Server) sendDigest() (int, error) {
+	period, err := digestPeriod(s.Config.Digest)
+	if err != nil {
+		return 0, err
+	}
+
+	notes := []Note{}
+	err = s.ReadDB.Preload("Tags").
+		Where("created_at >= ? and not draft", time.Now().Add(-period)).
+		Scopes(notScheduled).
+		Order("date").
+		Find(&notes).Error
+	if err != nil {
+		return 0, err
+	}
+	recalls, err := dueRecalls(s.ReadDB, time.Now(), RecallShown)
+	if err != nil || len(notes)+len(recalls) == 0 {
+		return 0, err
+	}
+
+	subject := fmt.Sprintf("Your %v notes: %v new", s.Config.Digest, len(notes))
+	body := digestBody(notes, s.Config.baseURL())
+	if len(recalls) > 0 {
+		subject += fmt.Sprintf(", %v to remember", len(recalls))
+		body += "To remember\n\n" + digestBody(recalls, s.Config.baseURL())
+	}
+	return len(notes) + len(recalls), s.sendMail(subject, body)
+}
+
+// digestBody formats the Notes as a plain text email.
+func digestBody(notes []Note, baseURL string) string {
+	var b strings.Builder
+	for _, note := range notes {
+		fmt.Fprintf(&b, "%v\n", note.DisplayTitle())
+		fmt.Fprintf(&b, "%v", displayDateTime(note.Date))
+		if tags := noteTagNames(note); tags != "" {
+			fmt.Fprintf(&b, " · %v", tags)
+		}
+		fmt.Fprintf(&b, "\n%v/note/%v/change\n\n", baseURL, note.ID)
+	}
+	return b.String()
+}
+
+// sendMail sends a plain text email to the DigestTo address.
+func (s *Server) sendMail(subject, body string) error {
+	cfg := s.Config
+	if cfg.SMTPHost == "" || cfg.DigestTo == "" {
+		return fmt.Errorf("SIMPLENOTES_SMTP_HOST and SIMPLENOTES_DIGEST_TO must be set")
+	}
+	from := cfg.SMTPFrom
+	if from == "" {
+		from = cfg.DigestTo
+	}
+
+	headers := []string{
+		"From: " + from,
+		"To: " + cfg.DigestTo,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+	msg := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.ReplaceAll(body, "\n", "\r\n")
+
+	var auth smtp.Auth
+	if cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPHost)
+	}
+	addr := net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort))
+	return smtp.SendMail(addr, auth, from, []string{cfg.DigestTo}, []byte(msg))
+}
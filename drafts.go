@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+// Draft autosave settings.
+const (
+	DraftCookie = "draft"
+	DraftMaxAge = 7 * 24 * time.Hour
+)
+
+//
+// ------------------------------------------------------------------
+// Drafts
+// ------------------------------------------------------------------
+//
+
+// Draft is the model for the `drafts` table. It is the content of the
+// create form while a Note is written, which the form posts every few
+// seconds, so a crashed browser or an expired session doesn't lose it.
+//
+// A draft belongs to the browser's draft cookie, which holds a random
+// key, of which only the hash is saved, and to the logged in user.
+// It is removed when the Note is saved, or discarded on the form.
+type Draft struct {
+	ID        uint `gorm:"primarykey"`
+	UpdatedAt time.Time
+	KeyHash   string
+	Username  string
+	Date      string
+	Time      string
+	Timezone  string
+	Body      EncryptedText
+	Tags      string
+	Monospace bool
+}
+
+// SavedAt returns when the draft was last saved, in the Notes' time zone.
+func (d Draft) SavedAt() time.Time {
+	return d.UpdatedAt.In(noteLocation())
+}
+
+// draftKeyHash returns the hash of the request's draft key, or false
+// when the request has no draft cookie.
+func draftKeyHash(r *http.Request) (string, bool) {
+	c, err := r.Cookie(DraftCookie)
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+	return hashRememberToken(c.Value), true
+}
+
+// loadDraft returns the draft of the request, if any.
+func (s *Server) loadDraft(r *http.Request) (Draft, bool, error) {
+	draft := Draft{}
+	hash, ok := draftKeyHash(r)
+	if !ok {
+		return draft, false, nil
+	}
+	err := s.DB.Where("key_hash = ? and username = ? and updated_at > ?", hash, currentUser(r), time.Now().Add(-DraftMaxAge)).
+		First(&draft).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return draft, false, nil
+	}
+	return draft, err == nil, err
+}
+
+// discardDraft removes the draft of the request, e.g. once its Note is
+// saved.
+func (s *Server) discardDraft(r *http.Request) error {
+	hash, ok := draftKeyHash(r)
+	if !ok {
+		return nil
+	}
+	return s.DB.Where("key_hash = ?", hash).Delete(&Draft{}).Error
+}
+
+// HandleNoteDraft saves the posted create form as the request's draft.
+// The draft cookie is set on the first save. An empty form removes the
+// draft.
+func (s *Server) HandleNoteDraft(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, ErrBadRequest, err)
+		return
+	}
+	draft := Draft{
+		Username:  currentUser(r),
+		Date:      r.Form.Get("date"),
+		Time:      r.Form.Get("time"),
+		Timezone:  r.Form.Get("timezone"),
+		Body:      EncryptedText(r.Form.Get("body")),
+		Tags:      r.Form.Get("tags"),
+		Monospace: r.Form.Get("monospace") != "",
+	}
+	if utf8.RuneCountInString(string(draft.Body)) > MaxBodyLength {
+		s.renderError(w, r, ErrInvalidNote, errors.New("body is too large"))
+		return
+	}
+
+	if draft.Body == "" && draft.Tags == "" {
+		if err := s.discardDraft(r); err != nil {
+			s.renderError(w, r, ErrDatabase, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	hash, ok := draftKeyHash(r)
+	if !ok {
+		key, keyHash, err := newRememberToken()
+		if err != nil {
+			s.renderError(w, r, ErrInternal, err)
+			return
+		}
+		setCookie(w, DraftCookie, key, DraftMaxAge)
+		hash = keyHash
+	}
+	draft.KeyHash = hash
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		// Forget the drafts that were left too long ago.
+		if err := tx.Where("updated_at < ?", time.Now().Add(-DraftMaxAge)).Delete(&Draft{}).Error; err != nil {
+			return err
+		}
+		saved := Draft{}
+		err := tx.Where("key_hash = ?", hash).First(&saved).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		draft.ID = saved.ID
+		return tx.Save(&draft).Error
+	})
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleNoteDraftDiscard removes the request's draft, and shows an
+// empty create form.
+func (s *Server) HandleNoteDraftDiscard(w http.ResponseWriter, r *http.Request) {
+	if err := s.discardDraft(r); err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	http.Redirect(w, r, "/note/new", http.StatusFound)
+}
+
+//
+// ------------------------------------------------------------------
+// Draft Notes
+// ------------------------------------------------------------------
+//
+
+// HandleNotePublish publishes a draft Note, which shows it in the note
+// list, searches and feeds. The Note keeps its date.
+func (s *Server) HandleNotePublish(w http.ResponseWriter, r *http.Request) {
+	note := Note{}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Tags").First(&note, chi.URLParam(r, "noteID")).Error; err != nil {
+			return err
+		}
+		if !note.Draft {
+			return nil
+		}
+		if err := tx.Model(&note).UpdateColumn("draft", false).Error; err != nil {
+			return err
+		}
+		published := note
+		published.Draft = false
+		changes := noteChanges(note, published)
+		note = published
+		return audit(tx, requestActor(r, "web"), AuditUpdate, "note", note.ID, changes)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrNoteNotFound, err)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	s.Counts.Clear()
+	s.Events.Publish(newNoteEvent(NoteUpdated, note))
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/yuin/goldmark-emoji/definition"
+)
+
+//
+// ------------------------------------------------------------------
+// Emoji
+// ------------------------------------------------------------------
+//
+
+// emojis are the GitHub emoji shortcodes, e.g. :rocket:.
+var emojis = definition.Github()
+
+// emojiShortcode matches the :shortcodes: in text.
+var emojiShortcode = regexp.MustCompile(`:[a-z0-9_+\-]+:`)
+
+// expandShortcodes replaces the :shortcodes: in the text with their
+// emoji, e.g. :rocket: with 🚀, as in Slack and GitHub. Unknown
+// shortcodes, and GitHub's custom emoji, are kept as text.
+func expandShortcodes(text string) string {
+	return emojiShortcode.ReplaceAllStringFunc(text, func(shortcode string) string {
+		emoji, ok := emojis.Get(shortcode[1 : len(shortcode)-1])
+		if !ok || !emoji.IsUnicode() {
+			return shortcode
+		}
+		return string(emoji.Unicode)
+	})
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+//
+// ------------------------------------------------------------------
+// Error codes
+// ------------------------------------------------------------------
+//
+
+// ErrorCode is a user-facing error. The short code is shown to the user,
+// and written to the log next to the underlying error, so a bug report
+// can be matched to what went wrong.
+type ErrorCode struct {
+	Code    string
+	Status  int
+	Message string
+}
+
+// Error codes.
+var (
+	ErrBadRequest     = ErrorCode{"SN-1001", http.StatusBadRequest, "The request could not be read."}
+	ErrInvalidNote    = ErrorCode{"SN-1002", http.StatusBadRequest, "The note is not valid."}
+	ErrNoteNotFound   = ErrorCode{"SN-1003", http.StatusNotFound, "That note does not exist."}
+	ErrEditConflict   = ErrorCode{"SN-1004", http.StatusConflict, "The note was changed since it was read."}
+	ErrSearch         = ErrorCode{"SN-1005", http.StatusBadRequest, "The search is not valid."}
+	ErrInbound        = ErrorCode{"SN-1006", http.StatusNotAcceptable, "The message was not accepted."}
+	ErrUnauthorized   = ErrorCode{"SN-1007", http.StatusUnauthorized, "The API token is not valid."}
+	ErrJobNotFound    = ErrorCode{"SN-1008", http.StatusNotFound, "That job does not exist, or the server was restarted since."}
+	ErrImport         = ErrorCode{"SN-1009", http.StatusBadRequest, "The export file is not valid."}
+	ErrShareNotFound  = ErrorCode{"SN-1010", http.StatusNotFound, "This link does not exist, or was revoked."}
+	ErrShareExpired   = ErrorCode{"SN-1011", http.StatusGone, "This link has expired."}
+	ErrShareRaw       = ErrorCode{"SN-1012", http.StatusForbidden, "The raw text of this note is not shared."}
+	ErrPresetNotFound = ErrorCode{"SN-1013", http.StatusNotFound, "That preset does not exist."}
+	ErrRemote         = ErrorCode{"SN-1014", http.StatusBadGateway, "Another instance could not be read."}
+	ErrTagNotFound    = ErrorCode{"SN-1015", http.StatusNotFound, "That tag does not exist."}
+	ErrSearchNotFound = ErrorCode{"SN-1016", http.StatusNotFound, "That saved search does not exist."}
+	ErrPageNotFound   = ErrorCode{"SN-1017", http.StatusNotFound, "That page does not exist."}
+	ErrMethod         = ErrorCode{"SN-1018", http.StatusMethodNotAllowed, "That page does not support this request."}
+	ErrLogin          = ErrorCode{"SN-1019", http.StatusUnauthorized, "The login failed, please try again."}
+	ErrLoginProvider  = ErrorCode{"SN-1020", http.StatusBadGateway, "The login provider is not available."}
+	ErrLoginThrottled = ErrorCode{"SN-1021", http.StatusTooManyRequests, "Too many failed logins, please try again later."}
+	ErrReadOnlyToken  = ErrorCode{"SN-1022", http.StatusForbidden, "The federation token can only list the shared and public notes."}
+	ErrDatabase       = ErrorCode{"SN-2001", http.StatusInternalServerError, "Something went wrong while saving."}
+	ErrInternal       = ErrorCode{"SN-2002", http.StatusInternalServerError, "Something went wrong."}
+	ErrTemplate       = ErrorCode{"SN-2003", http.StatusInternalServerError, "Something went wrong while showing this page."}
+)
+
+// RequestIDHeader is the response header with the ID of the request,
+// which is also in the log and on error pages.
+const RequestIDHeader = "X-Request-Id"
+
+// exposeRequestID is a middleware that sets the RequestIDHeader, so a
+// failed request can be matched to its log lines from any response.
+func exposeRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := middleware.GetReqID(r.Context()); id != "" {
+			w.Header().Set(RequestIDHeader, id)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ErrorContext provides context data to the error page.
+type ErrorContext struct {
+	Code      string
+	Message   string
+	RequestID string
+}
+
+// logError writes the error to the log, tagged with its code and request ID.
+func logError(r *http.Request, code ErrorCode, err error) {
+	if err != nil {
+		log.Printf("[%v] [%v] %v %v: %v", code.Code, middleware.GetReqID(r.Context()), r.Method, r.URL.Path, err)
+	}
+}
+
+// renderError logs the error, and serves the error page. If the error
+// page fails too, the error is served as text.
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, code ErrorCode, err error) {
+	logError(r, code, err)
+	requestContext := ErrorContext{
+		Code:      code.Code,
+		Message:   code.Message,
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	b := &bytes.Buffer{}
+	if err := s.Templates.ExecuteTemplate(b, "error", requestContext); err != nil {
+		logError(r, ErrTemplate, err)
+		http.Error(w, fmt.Sprintf("%v Error code: %v", code.Message, code.Code), code.Status)
+		return
+	}
+	w.WriteHeader(code.Status)
+	b.WriteTo(w)
+}
+
+// render serves the template. It is executed before anything is
+// written, so a failed template serves the error page instead of half
+// a page.
+func (s *Server) render(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	b := &bytes.Buffer{}
+	if err := s.Templates.ExecuteTemplate(b, name, data); err != nil {
+		s.renderError(w, r, ErrTemplate, fmt.Errorf("template %v: %v", name, err))
+		return
+	}
+	b.WriteTo(w)
+}
+
+// HandleNotFound serves the error page of unknown pages, or the JSON
+// error of unknown API paths.
+func (s *Server) HandleNotFound(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeAPIError(w, r, ErrPageNotFound, nil)
+		return
+	}
+	s.renderError(w, r, ErrPageNotFound, nil)
+}
+
+// HandleMethodNotAllowed serves the error page of requests with a
+// method the page doesn't support, e.g. a GET of a form action.
+func (s *Server) HandleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeAPIError(w, r, ErrMethod, nil)
+		return
+	}
+	s.renderError(w, r, ErrMethod, nil)
+}
+
+//
+// ------------------------------------------------------------------
+// Panic recovery
+// ------------------------------------------------------------------
+//
+
+// PanicCount is the number of handler and job panics that were recovered.
+// It is published on `/debug/vars`.
+var PanicCount = expvar.NewInt("panics")
+
+// Recoverer is a middleware that recovers from handler panics.
+// The panic is logged with a stack trace, and the error page is served
+// instead of dropping the connection.
+func (s *Server) Recoverer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rvr := recover()
+			if rvr == nil {
+				return
+			}
+			if rvr == http.ErrAbortHandler {
+				// Let the server abort the response.
+				panic(rvr)
+			}
+
+			PanicCount.Add(1)
+			logError(r, ErrInternal, fmt.Errorf("panic: %v\n%s", rvr, debug.Stack()))
+
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeAPIError(w, r, ErrInternal, nil)
+				return
+			}
+			s.renderError(w, r, ErrInternal, nil)
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeAPIError logs the error, and writes it as a JSON response.
+// The messages default to the code's message.
+func writeAPIError(w http.ResponseWriter, r *http.Request, code ErrorCode, err error, messages ...string) {
+	logError(r, code, err)
+	if len(messages) == 0 {
+		messages = []string{code.Message}
+	}
+	writeJSON(w, code.Status, APIError{Code: code.Code, Errors: messages, RequestID: middleware.GetReqID(r.Context())})
+}
+
+//
+// ------------------------------------------------------------------
+// Logs
+// ------------------------------------------------------------------
+//
+
+// LogBuffer keeps the most recent log lines in memory.
+type LogBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	size  int
+}
+
+// NewLogBuffer ...
+func NewLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{size: size}
+}
+
+// Write implements io.Writer.
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		b.lines = append(b.lines, line)
+	}
+	if len(b.lines) > b.size {
+		b.lines = b.lines[len(b.lines)-b.size:]
+	}
+	return len(p), nil
+}
+
+// Lines returns a copy of the buffered lines.
+func (b *LogBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]string{}, b.lines...)
+}
+
+// RecentLogs holds the last lines written to the log.
+var RecentLogs = NewLogBuffer(1000)
+
+// LogOutput is where all logs are written: stdout, and the RecentLogs.
+var LogOutput io.Writer = io.MultiWriter(os.Stdout, RecentLogs)
+
+// SQLLogOutput is where the SQL log is written. It has the Note bodies
+// and the password and token hashes of the statements, so it is kept
+// out of the RecentLogs of the support bundle.
+var SQLLogOutput io.Writer = os.Stdout
+
+//
+// ------------------------------------------------------------------
+// Support bundle
+// ------------------------------------------------------------------
+//
+
+// redacted replaces secret config values.
+const redacted = "[redacted]"
+
+// urlCredentials matches the user and password of the urls in a text.
+var urlCredentials = regexp.MustCompile(`://[^/\s@]+@`)
+
+// secretParam matches the names of url parameters with credentials,
+// e.g. the _auth_pass of a sqlite DSN.
+var secretParam = regexp.MustCompile(`(?i)pass|secret|token|key|auth`)
+
+// redactURL hides the user, password and secret parameters of a url
+// or DSN. Values that can't be parsed are hidden entirely.
+func redactURL(value string) string {
+	if value == "" {
+		return ""
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return redacted
+	}
+	if u.RawQuery == "" && strings.Contains(u.Path, "=") {
+		// A DSN of key=value pairs, e.g. host=db password=...
+		return redactParams(value, " ")
+	}
+	u.RawQuery = redactParams(u.RawQuery, "&")
+	if u.User == nil {
+		return u.String()
+	}
+	u.User = nil
+	return strings.Replace(u.String(), "://", "://"+redacted+"@", 1)
+}
+
+// redactParams hides the secret values of the name=value pairs of the
+// text, split by the separator.
+func redactParams(text, separator string) string {
+	params := strings.Split(text, separator)
+	for i, param := range params {
+		if name := strings.SplitN(param, "=", 2)[0]; secretParam.MatchString(name) {
+			params[i] = name + "=" + redacted
+		}
+	}
+	return strings.Join(params, separator)
+}
+
+// redactCredentials hides the user and password of the urls in a text,
+// e.g. the output of git push.
+func redactCredentials(text string) string {
+	return urlCredentials.ReplaceAllString(text, "://"+redacted+"@")
+}
+
+// Redacted returns a copy of the Config that is safe to share.
+func (cfg Config) Redacted() Config {
+	if cfg.EncryptionSecret != "" {
+		cfg.EncryptionSecret = redacted
+	}
+	if cfg.Password != "" {
+		cfg.Password = redacted
+	}
+	if cfg.SessionSecret != "" {
+		cfg.SessionSecret = redacted
+	}
+	if cfg.OIDCClientSecret != "" {
+		cfg.OIDCClientSecret = redacted
+	}
+	if cfg.SMTPPassword != "" {
+		cfg.SMTPPassword = redacted
+	}
+	if cfg.MailgunSigningKey != "" {
+		cfg.MailgunSigningKey = redacted
+	}
+	if cfg.TelegramToken != "" {
+		cfg.TelegramToken = redacted
+	}
+	if cfg.SlackSigningSecret != "" {
+		cfg.SlackSigningSecret = redacted
+	}
+	if cfg.WebDAVPassword != "" {
+		cfg.WebDAVPassword = redacted
+	}
+	if cfg.CalendarToken != "" {
+		cfg.CalendarToken = redacted
+	}
+	if cfg.APIToken != "" {
+		cfg.APIToken = redacted
+	}
+	if cfg.FederationToken != "" {
+		cfg.FederationToken = redacted
+	}
+	cfg.Remotes = redactRemotes(cfg.Remotes)
+	cfg.GitRemote = redactURL(cfg.GitRemote)
+	cfg.DSN = redactURL(cfg.DSN)
+	cfg.ReadDSN = redactURL(cfg.ReadDSN)
+	return cfg
+}
+
+// HandleSupportBundle serves a zip file to attach to bug reports.
+// It has the recent logs, the redacted config and the schema version.
+func (s *Server) HandleSupportBundle(w http.ResponseWriter, r *http.Request) {
+	applied := []SchemaMigration{}
+	s.DB.Order("version").Find(&applied)
+
+	available := []string{}
+	if migrations, err := loadMigrations(); err == nil {
+		for _, m := range migrations {
+			available = append(available, m.String())
+		}
+	}
+
+	schema := map[string]interface{}{
+		"applied":   applied,
+		"available": available,
+	}
+
+	system := map[string]string{
+		"go_version": runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"created_at": time.Now().UTC().Format(time.RFC3339),
+	}
+
+	filename := fmt.Sprintf("simplenotes-support-%v.zip", time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	z := zip.NewWriter(w)
+	defer z.Close()
+
+	if f, err := z.Create("logs.txt"); err == nil {
+		io.WriteString(f, strings.Join(RecentLogs.Lines(), "\n")+"\n")
+	}
+	writeZipJSON(z, "config.json", s.Config.Redacted())
+	writeZipJSON(z, "schema.json", schema)
+	writeZipJSON(z, "system.json", system)
+}
+
+// writeZipJSON adds a JSON file to the zip.
+func writeZipJSON(z *zip.Writer, name string, v interface{}) {
+	f, err := z.Create(name)
+	if err != nil {
+		return
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
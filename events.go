@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Types of NoteEvent.
+const (
+	NoteCreated = "created"
+	NoteUpdated = "updated"
+	NoteDeleted = "deleted"
+)
+
+//
+// ------------------------------------------------------------------
+// Events
+// ------------------------------------------------------------------
+//
+
+// NoteEvent describes a change to a Note.
+type NoteEvent struct {
+	Type   string    `json:"type"`
+	NoteID uint      `json:"note_id"`
+	Title  string    `json:"title,omitempty"`
+	Body   string    `json:"body,omitempty"`
+	Date   time.Time `json:"date"`
+	Tags   []string  `json:"tags,omitempty"`
+}
+
+// newNoteEvent creates a NoteEvent of the given type from the Note.
+func newNoteEvent(eventType string, note Note) NoteEvent {
+	n := newNoteJSON(note)
+
+	return NoteEvent{
+		Type:   eventType,
+		NoteID: n.ID,
+		Title:  n.Title,
+		Body:   n.Body,
+		Date:   n.Date,
+		Tags:   n.Tags,
+	}
+}
+
+// EventHub fans out NoteEvents to all subscribers.
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[chan NoteEvent]struct{}
+}
+
+// NewEventHub ...
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: make(map[chan NoteEvent]struct{}),
+	}
+}
+
+// Subscribe returns a channel that receives all published events.
+func (h *EventHub) Subscribe() chan NoteEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan NoteEvent, 16)
+	h.subscribers[ch] = struct{}{}
+	return ch
+}
+
+// Unsubscribe stops sending events to the channel.
+func (h *EventHub) Unsubscribe(ch chan NoteEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers, ch)
+}
+
+// Publish sends the event to all subscribers.
+// Subscribers that are too slow to keep up will miss the event.
+func (h *EventHub) Publish(event NoteEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+//
+// ------------------------------------------------------------------
+// WebSocket
+// ------------------------------------------------------------------
+//
+
+// wsPingInterval is how often the server pings idle WebSocket clients.
+const wsPingInterval = 30 * time.Second
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// wsRequest is a message sent by a WebSocket client.
+// The only supported type is "create", which quick-creates a Note dated now.
+type wsRequest struct {
+	Type string `json:"type"`
+	Body string `json:"body"`
+	Tags string `json:"tags"`
+}
+
+// wsResponse is the reply to a wsRequest.
+type wsResponse struct {
+	Type   string   `json:"type"` // "ok" or "error"
+	NoteID uint     `json:"note_id,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// HandleWebSocket streams NoteEvents to the client, and accepts quick-create messages.
+// The endpoint sits behind the same authentication as the API, so clients
+// can connect with the APIToken as well as a login session.
+func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with an error.
+		return
+	}
+	defer conn.Close()
+
+	actor := requestActor(r, "websocket")
+	events := s.Events.Subscribe()
+	defer s.Events.Unsubscribe(events)
+
+	responses := make(chan wsResponse)
+	done := make(chan struct{})
+	quit := make(chan struct{})
+	defer close(quit)
+
+	// Read client messages until the connection is closed.
+	go func() {
+		defer close(done)
+		for {
+			req := wsRequest{}
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			select {
+			case responses <- s.handleWSRequest(actor, req):
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	// All writes happen here, since the connection supports only one writer.
+	for {
+		select {
+		case <-done:
+			return
+		case event := <-events:
+			err = conn.WriteJSON(event)
+		case resp := <-responses:
+			err = conn.WriteJSON(resp)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleWSRequest performs the action requested by a WebSocket client.
+func (s *Server) handleWSRequest(actor Actor, req wsRequest) wsResponse {
+	if req.Type != "create" {
+		return wsResponse{Type: "error", Errors: []string{"Unknown message type"}}
+	}
+
+	now := localNow()
+	form := NoteForm{
+		Body: req.Body,
+		Date: now.Format(NotePartialDateFormat),
+		Time: now.Format(NotePartialTimeFormat),
+		Tags: req.Tags,
+	}
+
+	if !form.IsValid() {
+		return wsResponse{Type: "error", Errors: form.Errors}
+	}
+
+	note, err := s.createNote(actor, &form)
+	if err != nil {
+		return wsResponse{Type: "error", Errors: []string{err.Error()}}
+	}
+	return wsResponse{Type: "ok", NoteID: note.ID}
+}
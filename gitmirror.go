@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+//
+// ------------------------------------------------------------------
+// Git mirror
+// ------------------------------------------------------------------
+//
+
+// GitMirror keeps a git repository with a Markdown file per Note, and
+// commits every change, for history and offsite backups.
+//
+// The whole mirror is synced on every change, so changes that were
+// missed, e.g. while the server was stopped, are committed too.
+type GitMirror struct {
+	s   *Server
+	dir string
+}
+
+// NewGitMirror opens the repository in the GitMirror directory.
+// It is created when it doesn't exist.
+func NewGitMirror(s *Server) (*GitMirror, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("SIMPLENOTES_GIT_MIRROR needs git: %v", err)
+	}
+
+	m := &GitMirror{s: s, dir: s.Config.GitMirror}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(m.dir, ".git")); os.IsNotExist(err) {
+		if _, err := m.git("init"); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Run commits the changes of the Notes until the server stops.
+func (m *GitMirror) Run() {
+	events := m.s.Events.Subscribe()
+	defer m.s.Events.Unsubscribe(events)
+
+	if err := m.Sync("Sync notes"); err != nil {
+		log.Printf("[git] %v", err)
+	}
+	for event := range events {
+		message := fmt.Sprintf("Note %v %v", event.NoteID, event.Type)
+		if event.Title != "" {
+			message += ": " + event.Title
+		}
+		if err := m.Sync(message); err != nil {
+			log.Printf("[git] %v", err)
+		}
+	}
+}
+
+// Sync writes the files of all Notes, removes the files of deleted
+// Notes, and commits the changes, if any. The commit is pushed to the
+// GitRemote, when set. Notes that expire are left out, so they don't
+// stay in the history once they are purged. A Note that is given an
+// expiry later was already committed, and its earlier versions stay in
+// the history; only its file is removed.
+func (m *GitMirror) Sync(message string) error {
+	notes := []Note{}
+	if err := m.s.DB.Preload("Tags").Where("expires_at is null").Order("id").Find(&notes).Error; err != nil {
+		return err
+	}
+
+	keep := map[string]bool{}
+	for _, note := range notes {
+		name := fmt.Sprintf("%v.md", note.ID)
+		keep[name] = true
+		if err := ioutil.WriteFile(filepath.Join(m.dir, name), gitMirrorFile(note), 0644); err != nil {
+			return err
+		}
+	}
+	files, err := filepath.Glob(filepath.Join(m.dir, "*.md"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !keep[filepath.Base(file)] {
+			if err := os.Remove(file); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := m.git("add", "-A"); err != nil {
+		return err
+	}
+	status, err := m.git("status", "--porcelain")
+	if err != nil || len(status) == 0 {
+		return err
+	}
+	if _, err := m.git("commit", "-m", message); err != nil {
+		return err
+	}
+
+	if m.s.Config.GitRemote != "" {
+		_, err = m.git("push", m.s.Config.GitRemote, "HEAD")
+	}
+	return err
+}
+
+// git runs a git command in the mirror, and returns its output. The
+// credentials of the GitRemote are hidden in the errors, which are
+// logged.
+func (m *GitMirror) git(args ...string) ([]byte, error) {
+	args = append([]string{"-c", "user.name=Simple Notes", "-c", "user.email=simplenotes@localhost"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = m.dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("git %v: %v: %s", args[4], err, redactCredentials(string(bytes.TrimSpace(out))))
+	}
+	return out, nil
+}
+
+// gitMirrorFile returns the Markdown file of the Note, with its
+// date, tags and flags in the front matter.
+func gitMirrorFile(note Note) []byte {
+	b := &bytes.Buffer{}
+	fmt.Fprintln(b, "---")
+	fmt.Fprintf(b, "date: %v\n", note.Date.Format(time.RFC3339))
+	if tags := noteTagNames(note); tags != "" {
+		fmt.Fprintf(b, "tags: [%v]\n", tags)
+	}
+	if note.Monospace {
+		fmt.Fprintln(b, "monospace: true")
+	}
+	if note.Scheduled {
+		fmt.Fprintln(b, "scheduled: true")
+	}
+	fmt.Fprintln(b, "---")
+	fmt.Fprintln(b)
+	fmt.Fprintln(b, string(note.Body))
+	return b.Bytes()
+}
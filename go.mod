@@ -1,11 +1,23 @@
 module github.com/tunedmystic/simplenotes
 
-go 1.15
+go 1.16
 
 require (
+	github.com/alecthomas/chroma v0.10.0
+	github.com/charmbracelet/bubbletea v0.20.0
+	github.com/duo-labs/webauthn v0.0.0-20210727191636-9f1b88ef44cc
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/go-chi/chi v1.5.1
-	github.com/mattn/go-sqlite3 v1.14.6 // indirect
+	github.com/gorilla/websocket v1.4.2
+	github.com/mattn/go-sqlite3 v1.14.15 // indirect
+	github.com/microcosm-cc/bluemonday v1.0.16
 	github.com/tunedmystic/authsolo v0.0.1
+	github.com/yuin/goldmark v1.4.0
+	github.com/yuin/goldmark-emoji v1.0.2
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	golang.org/x/net v0.0.0-20210614182718-04defd469f4e
+	golang.org/x/term v0.0.0-20210422114643-f5beecf764ed
+	golang.org/x/text v0.3.7
 	gorm.io/driver/sqlite v1.1.4
 	gorm.io/gorm v1.20.9
 )
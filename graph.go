@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+// noteLinkPattern matches links to other Notes in a body, e.g.
+// http://localhost:3000/note/12/change or /note/12.
+var noteLinkPattern = regexp.MustCompile(`/note/(\d+)\b`)
+
+//
+// ------------------------------------------------------------------
+// Graph export
+// ------------------------------------------------------------------
+//
+
+// Graph is a network of Notes or Tags, for analysis in tools like
+// Gephi or Graphviz.
+type Graph struct {
+	Name     string
+	Directed bool
+	Nodes    []GraphNode
+	Edges    []GraphEdge
+}
+
+// GraphNode is a Note or a Tag.
+type GraphNode struct {
+	ID     string
+	Label  string
+	Weight int // the amount of Notes of a Tag, 1 for Notes
+}
+
+// GraphEdge is a link between Notes, or Tags used on the same Notes.
+type GraphEdge struct {
+	Source string
+	Target string
+	Weight int // the amount of links, or of Notes with both Tags
+}
+
+// HandleGraphExport serves a graph as a download, e.g. /graph/tags.dot.
+// The graphs are "links" and "tags", the formats "dot" and "graphml".
+func (s *Server) HandleGraphExport(w http.ResponseWriter, r *http.Request) {
+	name, format := chi.URLParam(r, "graph"), chi.URLParam(r, "format")
+
+	var graph Graph
+	var err error
+	switch name {
+	case "links":
+		graph, err = noteLinkGraph(s.ReadDB)
+	case "tags":
+		graph, err = tagGraph(s.ReadDB)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	switch format {
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+	case "graphml":
+		w.Header().Set("Content-Type", "application/graphml+xml; charset=utf-8")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="simplenotes-%v.%v"`, name, format))
+
+	if format == "dot" {
+		err = graph.WriteDOT(w)
+	} else {
+		err = graph.WriteGraphML(w)
+	}
+	if err != nil {
+		logError(r, ErrInternal, err)
+	}
+}
+
+// noteLinkGraph returns the graph of links between Notes. A Note links
+// to another when its body has the url of the other Note.
+func noteLinkGraph(db *gorm.DB) (Graph, error) {
+	graph := Graph{Name: "links", Directed: true}
+
+	notes := []Note{}
+	if err := db.Select("id", "title", "body").Order("id").Find(&notes).Error; err != nil {
+		return graph, err
+	}
+
+	exists := map[string]bool{}
+	for _, note := range notes {
+		id := strconv.FormatUint(uint64(note.ID), 10)
+		exists[id] = true
+		graph.Nodes = append(graph.Nodes, GraphNode{ID: id, Label: note.DisplayTitle(), Weight: 1})
+	}
+
+	for _, note := range notes {
+		source := strconv.FormatUint(uint64(note.ID), 10)
+		links := map[string]int{}
+		targets := []string{}
+		for _, match := range noteLinkPattern.FindAllStringSubmatch(string(note.Body), -1) {
+			target := match[1]
+			if target == source || !exists[target] {
+				continue
+			}
+			if links[target] == 0 {
+				targets = append(targets, target)
+			}
+			links[target]++
+		}
+		for _, target := range targets {
+			graph.Edges = append(graph.Edges, GraphEdge{source, target, links[target]})
+		}
+	}
+	return graph, nil
+}
+
+// tagGraph returns the co-occurrence graph of Tags: Tags are linked
+// when they are used on the same Notes.
+func tagGraph(db *gorm.DB) (Graph, error) {
+	graph := Graph{Name: "tags"}
+
+	tags := []TagCount{}
+	err := db.Raw(`
+		select t.name, count(distinct n.id) as notes
+		from tags t
+		join note_tag nt on nt.tag_id = t.id
+		join notes n on n.id = nt.note_id and n.deleted_at is null
+		where t.deleted_at is null
+		group by t.name
+		order by t.name;
+	`).Scan(&tags).Error
+	if err != nil {
+		return graph, err
+	}
+	for _, tag := range tags {
+		graph.Nodes = append(graph.Nodes, GraphNode{ID: tag.Name, Label: tag.Name, Weight: tag.Notes})
+	}
+
+	err = db.Raw(`
+		select a.name as source, b.name as target, count(distinct n.id) as weight
+		from note_tag nta
+		join note_tag ntb on ntb.note_id = nta.note_id
+		join tags a on a.id = nta.tag_id and a.deleted_at is null
+		join tags b on b.id = ntb.tag_id and b.deleted_at is null
+		join notes n on n.id = nta.note_id and n.deleted_at is null
+		where a.name < b.name
+		group by a.name, b.name
+		order by a.name, b.name;
+	`).Scan(&graph.Edges).Error
+	return graph, err
+}
+
+// WriteDOT writes the graph in the Graphviz DOT format.
+func (g Graph) WriteDOT(w io.Writer) error {
+	kind, arrow := "graph", "--"
+	if g.Directed {
+		kind, arrow = "digraph", "->"
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%v %v {\n", kind, dotQuote(g.Name))
+	for _, n := range g.Nodes {
+		fmt.Fprintf(b, "  %v [label=%v, weight=%v];\n", dotQuote(n.ID), dotQuote(n.Label), n.Weight)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(b, "  %v %v %v [weight=%v];\n", dotQuote(e.Source), arrow, dotQuote(e.Target), e.Weight)
+	}
+	fmt.Fprintln(b, "}")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotQuote returns the DOT string literal of s.
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+	return `"` + s + `"`
+}
+
+// WriteGraphML writes the graph in the GraphML format, with the label
+// and weight as data keys.
+func (g Graph) WriteGraphML(w io.Writer) error {
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	type node struct {
+		ID   string `xml:"id,attr"`
+		Data []data `xml:"data"`
+	}
+	type edge struct {
+		Source string `xml:"source,attr"`
+		Target string `xml:"target,attr"`
+		Data   []data `xml:"data"`
+	}
+	type key struct {
+		ID   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+	type graphml struct {
+		XMLName xml.Name `xml:"graphml"`
+		XMLNS   string   `xml:"xmlns,attr"`
+		Keys    []key    `xml:"key"`
+		Graph   struct {
+			ID          string `xml:"id,attr"`
+			EdgeDefault string `xml:"edgedefault,attr"`
+			Nodes       []node `xml:"node"`
+			Edges       []edge `xml:"edge"`
+		} `xml:"graph"`
+	}
+
+	doc := graphml{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []key{
+			{"label", "node", "label", "string"},
+			{"weight", "node", "weight", "int"},
+			{"edge_weight", "edge", "weight", "int"},
+		},
+	}
+	doc.Graph.ID = g.Name
+	doc.Graph.EdgeDefault = "undirected"
+	if g.Directed {
+		doc.Graph.EdgeDefault = "directed"
+	}
+	for _, n := range g.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, node{n.ID, []data{
+			{"label", n.Label},
+			{"weight", strconv.Itoa(n.Weight)},
+		}})
+	}
+	for _, e := range g.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, edge{e.Source, e.Target, []data{
+			{"edge_weight", strconv.Itoa(e.Weight)},
+		}})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Security header defaults. The pages have inline scripts and styles,
+// and show the images of link previews from other sites.
+const (
+	DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; " +
+		"img-src 'self' data: https:; connect-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'"
+	DefaultFrameOptions   = "DENY"
+	DefaultReferrerPolicy = "same-origin"
+	DefaultHSTSMaxAge     = 180 * 24 * 60 * 60 // seconds
+)
+
+//
+// ------------------------------------------------------------------
+// Security headers
+// ------------------------------------------------------------------
+//
+
+// securityHeaders is a middleware that sets the security headers of
+// the Config on every response. Strict-Transport-Security is only set
+// when TLS is on: the server has a TLS certificate, or its BaseURL is
+// https, e.g. behind a proxy.
+func (s *Server) securityHeaders(next http.Handler) http.Handler {
+	headers := map[string]string{
+		"Content-Security-Policy": s.Config.ContentSecurityPolicy,
+		"X-Content-Type-Options":  "nosniff",
+		"X-Frame-Options":         s.Config.FrameOptions,
+		"Referrer-Policy":         s.Config.ReferrerPolicy,
+	}
+	tls := s.Config.TLSCert != "" || strings.HasPrefix(s.Config.BaseURL, "https://")
+	if tls && s.Config.HSTSMaxAge > 0 {
+		headers["Strict-Transport-Security"] = fmt.Sprintf("max-age=%v", s.Config.HSTSMaxAge)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range headers {
+			if value != "" {
+				w.Header().Set(name, value)
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/alecthomas/chroma"
+	"github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// DefaultCodeTheme is the theme of highlighted code blocks.
+const DefaultCodeTheme = "github"
+
+//
+// ------------------------------------------------------------------
+// Code highlighting
+// ------------------------------------------------------------------
+//
+
+// codeHighlighter renders the fenced code blocks of Markdown with
+// syntax highlighting, for the language of the block, e.g. ```go.
+// Blocks in an unknown language are rendered as plain text.
+type codeHighlighter struct {
+	style     *chroma.Style
+	formatter *html.Formatter
+}
+
+// newCodeHighlighter returns a highlighter with the theme, one of
+// the chroma styles, e.g. "github" or "monokai".
+func newCodeHighlighter(theme string) (*codeHighlighter, error) {
+	style, ok := styles.Registry[theme]
+	if !ok {
+		return nil, fmt.Errorf("invalid SIMPLENOTES_CODE_THEME %q, use a chroma style, e.g. github or monokai", theme)
+	}
+	// Inline styles, so the theme needs no stylesheet.
+	return &codeHighlighter{style: style, formatter: html.New(html.WithClasses(false))}, nil
+}
+
+// RegisterFuncs implements renderer.NodeRenderer.
+func (h *codeHighlighter) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, h.renderFencedCodeBlock)
+}
+
+func (h *codeHighlighter) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	block := node.(*ast.FencedCodeBlock)
+
+	var code bytes.Buffer
+	lines := block.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		code.Write(line.Value(source))
+	}
+
+	lexer := lexers.Get(string(block.Language(source)))
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		return ast.WalkStop, err
+	}
+	if err := h.formatter.Format(w, h.style, tokens); err != nil {
+		return ast.WalkStop, err
+	}
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AsOfDateFormat is the date format for the `?asof=` param.
+const AsOfDateFormat = "2006-01-02"
+
+//
+// ------------------------------------------------------------------
+// History
+// ------------------------------------------------------------------
+//
+
+// NoteRevision is the model for the `note_revisions` table.
+// A revision is saved every time a Note is created or updated.
+type NoteRevision struct {
+	ID        uint `gorm:"primarykey"`
+	NoteID    uint
+	Body      EncryptedText
+	Date      time.Time
+	Tags      string // comma separated tag names
+	CreatedAt time.Time
+}
+
+// saveRevision records the version of the Note saved from the NoteForm.
+func saveRevision(tx *gorm.DB, noteID uint, form *NoteForm) error {
+	return tx.Create(&NoteRevision{
+		NoteID: noteID,
+		Body:   form.cleanedBody,
+		Date:   form.cleanedDateTime,
+		Tags:   noteTagNames(Note{Tags: form.cleanedTags}),
+	}).Error
+}
+
+// parseAsOf parses the `?asof=` date. Notes are shown as they were at
+// the end of that day.
+func parseAsOf(value string) (time.Time, error) {
+	d, err := time.ParseInLocation(AsOfDateFormat, value, localNow().Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date for asof: %q, use YYYY-MM-DD", value)
+	}
+	return d.AddDate(0, 0, 1), nil
+}
+
+// asOfScope selects the Notes that existed at the given time,
+// including the ones that have been deleted since.
+func asOfScope(db *gorm.DB, asof time.Time) *gorm.DB {
+	// Timestamps are stored in local time.
+	asof = asof.Local()
+	return db.Unscoped().
+		Where("created_at < ?", asof).
+		Where("deleted_at is null or deleted_at >= ?", asof)
+}
+
+// notesAsOf returns up to `limit` Notes as they were at the given time.
+func notesAsOf(db *gorm.DB, asof time.Time, order string, limit int) ([]Note, error) {
+	notes := []Note{}
+	if err := asOfScope(db, asof).Preload("Tags").Order(order).Limit(limit).Find(&notes).Error; err != nil {
+		return notes, err
+	}
+	return notes, applyRevisions(db, notes, asof)
+}
+
+// noteAsOf returns the Note as it was at the given time.
+func noteAsOf(db *gorm.DB, noteID string, asof time.Time) (Note, error) {
+	note := Note{}
+	if err := asOfScope(db, asof).Preload("Tags").First(&note, noteID).Error; err != nil {
+		return note, err
+	}
+	notes := []Note{note}
+	err := applyRevisions(db, notes, asof)
+	return notes[0], err
+}
+
+// applyRevisions replaces the Notes' content with their latest revision
+// before the given time. Notes without one keep their current content.
+func applyRevisions(db *gorm.DB, notes []Note, asof time.Time) error {
+	if len(notes) == 0 {
+		return nil
+	}
+
+	ids := []uint{}
+	for _, note := range notes {
+		ids = append(ids, note.ID)
+	}
+
+	revisions := []NoteRevision{}
+	err := db.Where("note_id in ? and created_at < ?", ids, asof.Local()).Order("created_at, id").Find(&revisions).Error
+	if err != nil {
+		return err
+	}
+
+	latest := map[uint]NoteRevision{}
+	names := []string{}
+	for _, rev := range revisions {
+		latest[rev.NoteID] = rev
+		for _, name := range strings.Split(rev.Tags, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+
+	// The revisions only have the names, the chips show the existing
+	// Tags with their color.
+	existing := []Tag{}
+	if err := db.Where("name in ?", names).Find(&existing).Error; err != nil {
+		return err
+	}
+	byName := map[string]Tag{}
+	for _, tag := range existing {
+		byName[tag.Name] = tag
+	}
+
+	for i, note := range notes {
+		rev, ok := latest[note.ID]
+		if !ok {
+			continue
+		}
+		notes[i].Title = "" // inferred from the revision's body
+		notes[i].Body = rev.Body
+		notes[i].Date = rev.Date
+		notes[i].Tags = []Tag{}
+		for _, name := range strings.Split(rev.Tags, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if tag, ok := byName[name]; ok {
+				notes[i].Tags = append(notes[i].Tags, tag)
+			} else {
+				notes[i].Tags = append(notes[i].Tags, Tag{Name: name})
+			}
+		}
+	}
+
+	return nil
+}
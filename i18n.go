@@ -0,0 +1,382 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LocaleDateFormat is the name of the DateFormat of the Settings'
+// Locale, the way dates are usually written in the language.
+const LocaleDateFormat = "locale"
+
+//
+// ------------------------------------------------------------------
+// Internationalization
+// ------------------------------------------------------------------
+//
+
+// Locale is a language of the pages and the dates. Pages are written
+// in English, and translated with the `t` template function. Text
+// without a translation is shown in English.
+type Locale struct {
+	Name       string // e.g. "de", as in the lang attribute
+	Label      string // in the language itself, e.g. "Deutsch"
+	DateFormat DateFormat
+
+	// words translates the month and day names of formatted dates.
+	words map[string]string
+
+	// messages translates the text of the pages, by its English text.
+	messages map[string]string
+}
+
+// Locales are the languages offered in the settings.
+var Locales = []Locale{
+	{
+		Name:       "en",
+		Label:      "English",
+		DateFormat: DateFormat{LocaleDateFormat, NotePartialDateFormat, NotePartialTimeFormat, NoteDateFormat},
+	},
+	{
+		Name:       "de",
+		Label:      "Deutsch",
+		DateFormat: DateFormat{LocaleDateFormat, "_2. January 2006", "15:04", "_2. Jan 2006 15:04"},
+		words: dateWords(
+			"Januar Februar März April Mai Juni Juli August September Oktober November Dezember",
+			"Jan Feb Mär Apr Mai Jun Jul Aug Sep Okt Nov Dez",
+			"Sonntag Montag Dienstag Mittwoch Donnerstag Freitag Samstag",
+			"So Mo Di Mi Do Fr Sa",
+		),
+		messages: map[string]string{
+			"write notes and stuff":             "Notizen und so",
+			"New Note":                          "Neue Notiz",
+			"Tags":                              "Tags",
+			"Starred":                           "Favoriten",
+			"Drafts":                            "Entwürfe",
+			"Random":                            "Zufall",
+			"On this day":                       "An diesem Tag",
+			"Stats":                             "Statistik",
+			"Review":                            "Durchsicht",
+			"Timeline":                          "Zeitleiste",
+			"Activity":                          "Aktivität",
+			"Settings":                          "Einstellungen",
+			"Admin":                             "Verwaltung",
+			"Notes":                             "Notizen",
+			"Jump to":                           "Springe zu",
+			"Go":                                "Los",
+			"View as of":                        "Stand vom",
+			"Sort by:":                          "Sortieren nach:",
+			"date":                              "Datum",
+			"created":                           "erstellt",
+			"updated":                           "geändert",
+			"newest first":                      "neueste zuerst",
+			"oldest first":                      "älteste zuerst",
+			"Remember":                          "Erinnerung",
+			"Got it":                            "Verstanden",
+			"Show me later":                     "Später zeigen",
+			"Show it again after a longer wait": "Nach längerer Zeit wieder zeigen",
+			"Show it again tomorrow":            "Morgen wieder zeigen",
+			"Undo":                              "Rückgängig",
+			"Pin this search":                   "Suche anheften",
+			"Name":                              "Name",
+			`Search, e.g. tag:home after:2021-01-01 "exact phrase"`: `Suchen, z.B. tag:home after:2021-01-01 "genauer Wortlaut"`,
+			"Star":                          "Favorisieren",
+			"Unstar":                        "Nicht mehr favorisieren",
+			"needs review":                  "durchsehen",
+			"Not changed since":             "Nicht geändert seit",
+			"draft":                         "Entwurf",
+			"scheduled":                     "geplant",
+			"expires":                       "läuft ab",
+			"Deleted for good at this time": "Wird zu dieser Zeit endgültig gelöscht",
+			"archived":                      "archiviert",
+			"No notes found.":               "Keine Notizen gefunden.",
+			"No preset":                     "Keine Vorlage",
+			"Use preset":                    "Vorlage verwenden",
+			"Manage presets":                "Vorlagen verwalten",
+			"Date and time":                 "Datum und Uhrzeit",
+			"Date":                          "Datum",
+			"Time":                          "Uhrzeit",
+			"Body":                          "Text",
+			"Monospace (keep spacing, for tables and code)": "Festbreitenschrift (behält Leerzeichen, für Tabellen und Code)",
+			"Scheduled (hidden until its date and time)":    "Geplant (verborgen bis zu Datum und Uhrzeit)",
+			"Delete the note":       "Notiz löschen",
+			"Never":                 "Nie",
+			"In an hour":            "In einer Stunde",
+			"In a day":              "In einem Tag",
+			"In a week":             "In einer Woche",
+			"In 30 days":            "In 30 Tagen",
+			"Back":                  "Zurück",
+			"Cancel":                "Abbrechen",
+			"Save as draft":         "Als Entwurf speichern",
+			"Save":                  "Speichern",
+			"Log in":                "Anmelden",
+			"Username":              "Benutzername",
+			"Password":              "Passwort",
+			"Remember me":           "Angemeldet bleiben",
+			"Log in with a passkey": "Mit Passkey anmelden",
+			"Error code:":           "Fehlercode:",
+			"Request ID:":           "Anfrage-ID:",
+			"Include this code when reporting the problem.": "Gib diesen Code an, wenn du das Problem meldest.",
+			"Back to notes":           "Zurück zu den Notizen",
+			"The settings are saved.": "Die Einstellungen sind gespeichert.",
+			"Language":                "Sprache",
+			"Time zone":               "Zeitzone",
+			"New notes are dated in this time zone. The dates of existing notes don't change.": "Neue Notizen werden in dieser Zeitzone datiert. Die Daten bestehender Notizen ändern sich nicht.",
+			"Notes per page":           "Notizen pro Seite",
+			"Order of notes":           "Reihenfolge der Notizen",
+			"Theme":                    "Farbschema",
+			"Same as the device":       "Wie das Gerät",
+			"Light":                    "Hell",
+			"Dark":                     "Dunkel",
+			"Date format":              "Datumsformat",
+			"as usual in the language": "wie in der Sprache üblich",
+			"Save settings":            "Einstellungen speichern",
+		},
+	},
+	{
+		Name:       "fr",
+		Label:      "Français",
+		DateFormat: DateFormat{LocaleDateFormat, "_2 January 2006", "15:04", "_2 Jan 2006 15:04"},
+		words: dateWords(
+			"janvier février mars avril mai juin juillet août septembre octobre novembre décembre",
+			"janv. févr. mars avr. mai juin juil. août sept. oct. nov. déc.",
+			"dimanche lundi mardi mercredi jeudi vendredi samedi",
+			"dim. lun. mar. mer. jeu. ven. sam.",
+		),
+		messages: map[string]string{
+			"write notes and stuff":             "des notes et tout ça",
+			"New Note":                          "Nouvelle note",
+			"Tags":                              "Étiquettes",
+			"Starred":                           "Favoris",
+			"Drafts":                            "Brouillons",
+			"Random":                            "Au hasard",
+			"On this day":                       "Ce jour-là",
+			"Stats":                             "Statistiques",
+			"Review":                            "Revue",
+			"Timeline":                          "Fil",
+			"Activity":                          "Activité",
+			"Settings":                          "Réglages",
+			"Admin":                             "Administration",
+			"Notes":                             "Notes",
+			"Jump to":                           "Aller au",
+			"Go":                                "OK",
+			"View as of":                        "Voir au",
+			"Sort by:":                          "Trier par :",
+			"date":                              "date",
+			"created":                           "création",
+			"updated":                           "modification",
+			"newest first":                      "plus récentes d'abord",
+			"oldest first":                      "plus anciennes d'abord",
+			"Remember":                          "Souvenir",
+			"Got it":                            "Compris",
+			"Show me later":                     "Plus tard",
+			"Show it again after a longer wait": "La remontrer dans longtemps",
+			"Show it again tomorrow":            "La remontrer demain",
+			"Undo":                              "Annuler",
+			"Pin this search":                   "Épingler cette recherche",
+			"Name":                              "Nom",
+			`Search, e.g. tag:home after:2021-01-01 "exact phrase"`: `Rechercher, p. ex. tag:home after:2021-01-01 "phrase exacte"`,
+			"Star":                          "Ajouter aux favoris",
+			"Unstar":                        "Retirer des favoris",
+			"needs review":                  "à revoir",
+			"Not changed since":             "Pas modifiée depuis le",
+			"draft":                         "brouillon",
+			"scheduled":                     "programmée",
+			"expires":                       "expire le",
+			"Deleted for good at this time": "Supprimée définitivement à cette heure",
+			"archived":                      "archivée",
+			"No notes found.":               "Aucune note trouvée.",
+			"No preset":                     "Aucun modèle",
+			"Use preset":                    "Utiliser le modèle",
+			"Manage presets":                "Gérer les modèles",
+			"Date and time":                 "Date et heure",
+			"Date":                          "Date",
+			"Time":                          "Heure",
+			"Body":                          "Texte",
+			"Monospace (keep spacing, for tables and code)": "Chasse fixe (garde les espaces, pour les tableaux et le code)",
+			"Scheduled (hidden until its date and time)":    "Programmée (masquée jusqu'à sa date et son heure)",
+			"Delete the note":       "Supprimer la note",
+			"Never":                 "Jamais",
+			"In an hour":            "Dans une heure",
+			"In a day":              "Dans un jour",
+			"In a week":             "Dans une semaine",
+			"In 30 days":            "Dans 30 jours",
+			"Back":                  "Retour",
+			"Cancel":                "Annuler",
+			"Save as draft":         "Enregistrer comme brouillon",
+			"Save":                  "Enregistrer",
+			"Log in":                "Se connecter",
+			"Username":              "Nom d'utilisateur",
+			"Password":              "Mot de passe",
+			"Remember me":           "Rester connecté",
+			"Log in with a passkey": "Se connecter avec une clé d'accès",
+			"Error code:":           "Code d'erreur :",
+			"Request ID:":           "ID de la requête :",
+			"Include this code when reporting the problem.": "Indiquez ce code en signalant le problème.",
+			"Back to notes":           "Retour aux notes",
+			"The settings are saved.": "Les réglages sont enregistrés.",
+			"Language":                "Langue",
+			"Time zone":               "Fuseau horaire",
+			"New notes are dated in this time zone. The dates of existing notes don't change.": "Les nouvelles notes sont datées dans ce fuseau horaire. Les dates des notes existantes ne changent pas.",
+			"Notes per page":           "Notes par page",
+			"Order of notes":           "Ordre des notes",
+			"Theme":                    "Thème",
+			"Same as the device":       "Comme l'appareil",
+			"Light":                    "Clair",
+			"Dark":                     "Sombre",
+			"Date format":              "Format de date",
+			"as usual in the language": "habituel dans la langue",
+			"Save settings":            "Enregistrer les réglages",
+		},
+	},
+	{
+		Name:       "es",
+		Label:      "Español",
+		DateFormat: DateFormat{LocaleDateFormat, "_2 de January de 2006", "15:04", "_2 Jan 2006 15:04"},
+		words: dateWords(
+			"enero febrero marzo abril mayo junio julio agosto septiembre octubre noviembre diciembre",
+			"ene feb mar abr may jun jul ago sept oct nov dic",
+			"domingo lunes martes miércoles jueves viernes sábado",
+			"dom lun mar mié jue vie sáb",
+		),
+		messages: map[string]string{
+			"write notes and stuff":             "notas y esas cosas",
+			"New Note":                          "Nueva nota",
+			"Tags":                              "Etiquetas",
+			"Starred":                           "Destacadas",
+			"Drafts":                            "Borradores",
+			"Random":                            "Al azar",
+			"On this day":                       "Un día como hoy",
+			"Stats":                             "Estadísticas",
+			"Review":                            "Revisión",
+			"Timeline":                          "Cronología",
+			"Activity":                          "Actividad",
+			"Settings":                          "Ajustes",
+			"Admin":                             "Administración",
+			"Notes":                             "Notas",
+			"Jump to":                           "Ir al",
+			"Go":                                "Ir",
+			"View as of":                        "Ver al",
+			"Sort by:":                          "Ordenar por:",
+			"date":                              "fecha",
+			"created":                           "creación",
+			"updated":                           "modificación",
+			"newest first":                      "más recientes primero",
+			"oldest first":                      "más antiguas primero",
+			"Remember":                          "Recuerda",
+			"Got it":                            "Entendido",
+			"Show me later":                     "Más tarde",
+			"Show it again after a longer wait": "Volver a mostrar dentro de más tiempo",
+			"Show it again tomorrow":            "Volver a mostrar mañana",
+			"Undo":                              "Deshacer",
+			"Pin this search":                   "Fijar esta búsqueda",
+			"Name":                              "Nombre",
+			`Search, e.g. tag:home after:2021-01-01 "exact phrase"`: `Buscar, p. ej. tag:home after:2021-01-01 "frase exacta"`,
+			"Star":                          "Destacar",
+			"Unstar":                        "Quitar de destacadas",
+			"needs review":                  "por revisar",
+			"Not changed since":             "Sin cambios desde el",
+			"draft":                         "borrador",
+			"scheduled":                     "programada",
+			"expires":                       "caduca",
+			"Deleted for good at this time": "Se elimina definitivamente a esta hora",
+			"archived":                      "archivada",
+			"No notes found.":               "No se encontraron notas.",
+			"No preset":                     "Sin plantilla",
+			"Use preset":                    "Usar plantilla",
+			"Manage presets":                "Gestionar plantillas",
+			"Date and time":                 "Fecha y hora",
+			"Date":                          "Fecha",
+			"Time":                          "Hora",
+			"Body":                          "Texto",
+			"Monospace (keep spacing, for tables and code)": "Monoespaciado (conserva los espacios, para tablas y código)",
+			"Scheduled (hidden until its date and time)":    "Programada (oculta hasta su fecha y hora)",
+			"Delete the note":       "Eliminar la nota",
+			"Never":                 "Nunca",
+			"In an hour":            "En una hora",
+			"In a day":              "En un día",
+			"In a week":             "En una semana",
+			"In 30 days":            "En 30 días",
+			"Back":                  "Volver",
+			"Cancel":                "Cancelar",
+			"Save as draft":         "Guardar como borrador",
+			"Save":                  "Guardar",
+			"Log in":                "Iniciar sesión",
+			"Username":              "Usuario",
+			"Password":              "Contraseña",
+			"Remember me":           "Recordarme",
+			"Log in with a passkey": "Iniciar sesión con una llave de acceso",
+			"Error code:":           "Código de error:",
+			"Request ID:":           "ID de la solicitud:",
+			"Include this code when reporting the problem.": "Incluye este código al informar del problema.",
+			"Back to notes":           "Volver a las notas",
+			"The settings are saved.": "Los ajustes están guardados.",
+			"Language":                "Idioma",
+			"Time zone":               "Zona horaria",
+			"New notes are dated in this time zone. The dates of existing notes don't change.": "Las notas nuevas se fechan en esta zona horaria. Las fechas de las notas existentes no cambian.",
+			"Notes per page":           "Notas por página",
+			"Order of notes":           "Orden de las notas",
+			"Theme":                    "Tema",
+			"Same as the device":       "Como el dispositivo",
+			"Light":                    "Claro",
+			"Dark":                     "Oscuro",
+			"Date format":              "Formato de fecha",
+			"as usual in the language": "el habitual en el idioma",
+			"Save settings":            "Guardar ajustes",
+		},
+	},
+}
+
+// dateWords maps the English month and day names of formatted dates to
+// the lists of the language, in the order of time.Month and
+// time.Weekday.
+func dateWords(months, shortMonths, days, shortDays string) map[string]string {
+	words := map[string]string{}
+	add := func(names string, english func(i int) string) {
+		for i, name := range strings.Fields(names) {
+			words[english(i)] = name
+		}
+	}
+	// May is its own short name, so the short names come first, and
+	// the month's name wins.
+	add(shortMonths, func(i int) string { return time.Month(i + 1).String()[:3] })
+	add(months, func(i int) string { return time.Month(i + 1).String() })
+	add(shortDays, func(i int) string { return time.Weekday(i).String()[:3] })
+	add(days, func(i int) string { return time.Weekday(i).String() })
+	return words
+}
+
+// findLocale returns the Locale of the name, or English.
+func findLocale(name string) Locale {
+	for _, l := range Locales {
+		if l.Name == name {
+			return l
+		}
+	}
+	return Locales[0]
+}
+
+// translate returns the text in the Settings' Locale. It is the `t`
+// template function.
+func translate(text string) string {
+	if message, ok := currentSettings().Language().messages[text]; ok {
+		return message
+	}
+	return text
+}
+
+// dateWord matches the words of a formatted date.
+var dateWord = regexp.MustCompile(`[A-Za-z]+`)
+
+// formatDate formats the time with the layout, with the month and day
+// names of the Settings' Locale.
+func formatDate(t time.Time, layout string) string {
+	words := currentSettings().Language().words
+	return dateWord.ReplaceAllStringFunc(t.Format(layout), func(word string) string {
+		if w, ok := words[word]; ok {
+			return w
+		}
+		return word
+	})
+}
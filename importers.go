@@ -0,0 +1,887 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Date formats of other apps' exports.
+const (
+	ENEXTimeFormat = "20060102T150405Z"        // Evernote
+	KeepTimeFormat = "Jan 2, 2006, 3:04:05 PM" // the html of Google Keep notes
+)
+
+// Limits of the zip files of Notion exports, so a small upload can't
+// unpack into more than the memory, e.g. a zip bomb.
+const (
+	MaxNotionExportSize = 256 << 20 // uncompressed bytes of the pages, databases and zip files
+	MaxNotionZipDepth   = 2         // of zip files in zip files
+)
+
+//
+// ------------------------------------------------------------------
+// Importers
+// ------------------------------------------------------------------
+//
+
+// decodeImportFile reads the Notes of a file to import, by the format
+// of its extension: .enex for Evernote, .zip for a Google Takeout of
+// Keep or a Simplenote export, or else an export file or the notes.json
+// of a Simplenote export.
+func decodeImportFile(name string, r io.Reader) (ExportFile, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".enex":
+		return decodeENEX(r)
+	case ".zip":
+		return decodeZip(r)
+	default:
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return ExportFile{}, err
+		}
+		if isSimplenoteExport(b) {
+			return decodeSimplenote(bytes.NewReader(b))
+		}
+		return decodeExportFile(bytes.NewReader(b))
+	}
+}
+
+// decodeZip reads the Notes of a zip file, by its contents: a
+// Simplenote export has a source/notes.json file, a Notion export has
+// pages named by their id, and a Google Takeout has a Keep folder.
+func decodeZip(r io.Reader) (ExportFile, error) {
+	archive, size, err := readerAt(r)
+	if err != nil {
+		return ExportFile{}, err
+	}
+	zr, err := zip.NewReader(archive, size)
+	if err != nil {
+		return ExportFile{}, fmt.Errorf("invalid zip file: %v", err)
+	}
+
+	for _, f := range zr.File {
+		if f.Name == SimplenoteFile || strings.HasSuffix(f.Name, "/"+SimplenoteFile) {
+			rc, err := f.Open()
+			if err != nil {
+				return ExportFile{}, err
+			}
+			defer rc.Close()
+			return decodeSimplenote(rc)
+		}
+	}
+	for _, f := range zr.File {
+		if notionPage.MatchString(path.Base(f.Name)) || path.Ext(f.Name) == ".zip" {
+			return decodeNotion(zr)
+		}
+	}
+	return decodeKeepTakeout(zr)
+}
+
+// readerAt returns the reader as an io.ReaderAt, as zip files are read.
+// Files and uploads are read in place, other readers in memory.
+func readerAt(r io.Reader) (io.ReaderAt, int64, error) {
+	if f, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		size, err := f.Seek(0, io.SeekEnd)
+		return f, size, err
+	}
+	b, err := ioutil.ReadAll(r)
+	return bytes.NewReader(b), int64(len(b)), err
+}
+
+// noteWallTime returns the time as a Note date, i.e. the wall clock
+// time in the Settings' Timezone, see NoteForm.Validate.
+func noteWallTime(t time.Time) time.Time {
+	wall := t.In(noteLocation())
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, time.UTC)
+}
+
+// importTags returns the tag names of another app. They may have
+// commas, which separate tags here.
+func importTags(names []string) []string {
+	tags := []string{}
+	for _, name := range names {
+		if name = strings.Join(strings.Fields(strings.ReplaceAll(name, ",", " ")), " "); name != "" {
+			tags = append(tags, name)
+		}
+	}
+	return tags
+}
+
+// enexNote is a note in an Evernote export. The content is ENML, a
+// subset of XHTML.
+type enexNote struct {
+	Title   string   `xml:"title"`
+	Content string   `xml:"content"`
+	Created string   `xml:"created"`
+	Updated string   `xml:"updated"`
+	Tags    []string `xml:"tag"`
+}
+
+// decodeENEX reads the notes of an Evernote export. The title is the
+// first line of the body, and the content is converted to Markdown.
+// Attachments are left out.
+func decodeENEX(r io.Reader) (ExportFile, error) {
+	file := ExportFile{Version: ExportVersion, ExportedAt: time.Now().UTC(), Notes: []ExportNote{}}
+
+	dec := xml.NewDecoder(r)
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return file, fmt.Errorf("invalid Evernote export: %v", err)
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "note" {
+			continue
+		}
+
+		en := enexNote{}
+		if err := dec.DecodeElement(&en, &start); err != nil {
+			return file, fmt.Errorf("invalid Evernote export: %v", err)
+		}
+		note, err := en.exportNote()
+		if err != nil {
+			return file, fmt.Errorf("invalid Evernote note %q: %v", en.Title, err)
+		}
+		file.Notes = append(file.Notes, note)
+	}
+
+	if len(file.Notes) == 0 {
+		return file, fmt.Errorf("invalid Evernote export: no notes found")
+	}
+	return file, nil
+}
+
+// exportNote converts the Evernote note.
+func (en enexNote) exportNote() (ExportNote, error) {
+	created, err := time.Parse(ENEXTimeFormat, strings.TrimSpace(en.Created))
+	if err != nil {
+		return ExportNote{}, err
+	}
+	updated := created
+	if en.Updated != "" {
+		if updated, err = time.Parse(ENEXTimeFormat, strings.TrimSpace(en.Updated)); err != nil {
+			return ExportNote{}, err
+		}
+	}
+
+	body, err := enmlMarkdown(en.Content)
+	if err != nil {
+		return ExportNote{}, err
+	}
+	title := strings.TrimSpace(en.Title)
+	if title != "" && title != "Untitled" && !strings.HasPrefix(body, title) {
+		body = strings.TrimSpace(title + "\n\n" + body)
+	}
+
+	return ExportNote{
+		Body:      body,
+		Date:      noteWallTime(created),
+		Tags:      importTags(en.Tags),
+		CreatedAt: created,
+		UpdatedAt: updated,
+	}, nil
+}
+
+// Whitespace of html text.
+var (
+	whitespace = regexp.MustCompile(`\s+`)
+	blankLines = regexp.MustCompile(`\n[ \t]*\n(?:[ \t]*\n)+`)
+)
+
+// enmlMarkdown converts the ENML content of an Evernote note to
+// Markdown text.
+func enmlMarkdown(enml string) (string, error) {
+	doc, err := html.Parse(strings.NewReader(enml))
+	if err != nil {
+		return "", err
+	}
+
+	m := &markdownWriter{}
+	m.walk(doc)
+
+	lines := strings.Split(m.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	text := blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(text), nil
+}
+
+// markdownWriter writes html as Markdown text.
+type markdownWriter struct {
+	strings.Builder
+	lists []string // the bullets of the open lists, "-" or "1."
+	pre   bool
+}
+
+// newline ends the current line, unless it is empty.
+func (m *markdownWriter) newline() {
+	s := m.String()
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		m.WriteString("\n")
+	}
+}
+
+// paragraph starts a new paragraph, after an empty line.
+func (m *markdownWriter) paragraph() {
+	m.newline()
+	if s := m.String(); s != "" && !strings.HasSuffix(s, "\n\n") {
+		m.WriteString("\n")
+	}
+}
+
+func (m *markdownWriter) children(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		m.walk(c)
+	}
+}
+
+// wrap writes the children between the mark, e.g. ** for bold text.
+func (m *markdownWriter) wrap(n *html.Node, mark string) {
+	m.WriteString(mark)
+	m.children(n)
+	m.WriteString(mark)
+}
+
+func (m *markdownWriter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		if m.pre {
+			m.WriteString(n.Data)
+			return
+		}
+		// Runs of whitespace are a single space, as in html.
+		text := whitespace.ReplaceAllString(n.Data, " ")
+		if out := m.String(); out == "" || strings.HasSuffix(out, "\n") || strings.HasSuffix(out, " ") {
+			text = strings.TrimLeft(text, " ")
+		}
+		m.WriteString(text)
+		return
+	case html.DocumentNode:
+		m.children(n)
+		return
+	case html.ElementNode:
+	default:
+		return
+	}
+
+	switch n.Data {
+	case "head", "script", "style", "en-crypt":
+		// Encrypted text is not imported.
+	case "en-media":
+		// Attachments are not imported. The html parser doesn't close
+		// the empty ENML elements, so the text after them are children.
+		m.children(n)
+	case "br":
+		m.WriteString("\n")
+	case "div":
+		m.newline()
+		m.children(n)
+		m.newline()
+	case "p", "blockquote", "table":
+		m.paragraph()
+		m.children(n)
+		m.paragraph()
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		m.paragraph()
+		m.WriteString(strings.Repeat("#", int(n.Data[1]-'0')) + " ")
+		m.children(n)
+		m.paragraph()
+	case "ul", "ol":
+		bullet := "-"
+		if n.Data == "ol" {
+			bullet = "1."
+		}
+		if len(m.lists) == 0 {
+			m.paragraph()
+		}
+		m.lists = append(m.lists, bullet)
+		m.children(n)
+		m.lists = m.lists[:len(m.lists)-1]
+		if len(m.lists) == 0 {
+			m.paragraph()
+		}
+	case "li":
+		m.newline()
+		bullet := "-"
+		if len(m.lists) > 0 {
+			bullet = m.lists[len(m.lists)-1]
+			m.WriteString(strings.Repeat("  ", len(m.lists)-1))
+		}
+		m.WriteString(bullet + " ")
+		m.children(n)
+		m.newline()
+	case "tr":
+		m.newline()
+		m.children(n)
+		m.newline()
+	case "td", "th":
+		if !strings.HasSuffix(m.String(), "\n") {
+			m.WriteString(" | ")
+		}
+		m.children(n)
+	case "hr":
+		m.paragraph()
+		m.WriteString("---")
+		m.paragraph()
+	case "pre":
+		m.paragraph()
+		m.WriteString("```\n")
+		m.pre = true
+		m.children(n)
+		m.pre = false
+		m.newline()
+		m.WriteString("```")
+		m.paragraph()
+	case "b", "strong":
+		m.wrap(n, "**")
+	case "i", "em":
+		m.wrap(n, "_")
+	case "s", "strike", "del":
+		m.wrap(n, "~~")
+	case "code":
+		m.wrap(n, "`")
+	case "en-todo":
+		checked := false
+		for _, attr := range n.Attr {
+			checked = checked || (attr.Key == "checked" && attr.Val == "true")
+		}
+		if checked {
+			m.WriteString("[x] ")
+		} else {
+			m.WriteString("[ ] ")
+		}
+		m.children(n)
+	case "a":
+		href := ""
+		for _, attr := range n.Attr {
+			if attr.Key == "href" {
+				href = attr.Val
+			}
+		}
+		text := &markdownWriter{}
+		text.children(n)
+		label := strings.TrimSpace(text.String())
+		switch {
+		case label == "":
+			m.WriteString(href)
+		case href == "" || href == label:
+			m.WriteString(label)
+		default:
+			m.WriteString("[" + label + "](" + href + ")")
+		}
+	default:
+		m.children(n)
+	}
+}
+
+// keepNote is a note in a Google Takeout of Keep, which has a .json
+// and an .html file per note. Older Takeouts only have the .html.
+type keepNote struct {
+	Title       string         `json:"title"`
+	TextContent string         `json:"textContent"`
+	ListContent []keepListItem `json:"listContent"`
+	Labels      []keepLabel    `json:"labels"`
+	Annotations []struct {
+		URL string `json:"url"`
+	} `json:"annotations"`
+	IsPinned   bool  `json:"isPinned"`
+	IsArchived bool  `json:"isArchived"`
+	IsTrashed  bool  `json:"isTrashed"`
+	Created    int64 `json:"createdTimestampUsec"`
+	Edited     int64 `json:"userEditedTimestampUsec"`
+}
+
+type keepListItem struct {
+	Text      string `json:"text"`
+	IsChecked bool   `json:"isChecked"`
+}
+
+type keepLabel struct {
+	Name string `json:"name"`
+}
+
+// decodeKeepTakeout reads the Keep notes of a Google Takeout zip file.
+// Labels are tags, pinned notes are starred, and archived notes stay
+// archived. Trashed notes and attachments are left out.
+func decodeKeepTakeout(zr *zip.Reader) (ExportFile, error) {
+	file := ExportFile{Version: ExportVersion, ExportedAt: time.Now().UTC(), Notes: []ExportNote{}}
+
+	files := map[string]*zip.File{}
+	names := []string{}
+	for _, f := range zr.File {
+		ext := path.Ext(f.Name)
+		if path.Base(path.Dir(f.Name)) != "Keep" || (ext != ".json" && ext != ".html") {
+			continue
+		}
+		files[f.Name] = f
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		// The .json of a note is preferred over its .html.
+		ext := path.Ext(name)
+		if ext == ".html" && files[strings.TrimSuffix(name, ext)+".json"] != nil {
+			continue
+		}
+		kn, err := readKeepNote(files[name])
+		if err != nil {
+			return file, fmt.Errorf("invalid Keep note %v: %v", path.Base(name), err)
+		}
+		if !kn.IsTrashed {
+			file.Notes = append(file.Notes, kn.exportNote())
+		}
+	}
+
+	if len(file.Notes) == 0 {
+		return file, fmt.Errorf("invalid zip file: no Keep notes or Simplenote export found")
+	}
+	return file, nil
+}
+
+// readKeepNote reads a Keep note from its .json or .html file.
+func readKeepNote(f *zip.File) (keepNote, error) {
+	kn := keepNote{}
+	rc, err := f.Open()
+	if err != nil {
+		return kn, err
+	}
+	defer rc.Close()
+
+	if path.Ext(f.Name) == ".html" {
+		return parseKeepHTML(rc)
+	}
+	err = json.NewDecoder(rc).Decode(&kn)
+	return kn, err
+}
+
+// exportNote converts the Keep note. The title is the first line of
+// the body, and list items are Markdown task list items.
+func (kn keepNote) exportNote() ExportNote {
+	parts := []string{}
+	if title := strings.TrimSpace(kn.Title); title != "" {
+		parts = append(parts, title)
+	}
+	if text := strings.TrimSpace(kn.TextContent); text != "" {
+		parts = append(parts, text)
+	}
+	if len(kn.ListContent) > 0 {
+		items := []string{}
+		for _, item := range kn.ListContent {
+			check := " "
+			if item.IsChecked {
+				check = "x"
+			}
+			items = append(items, fmt.Sprintf("- [%v] %v", check, strings.TrimSpace(item.Text)))
+		}
+		parts = append(parts, strings.Join(items, "\n"))
+	}
+	for _, annotation := range kn.Annotations {
+		if annotation.URL != "" && !strings.Contains(strings.Join(parts, "\n"), annotation.URL) {
+			parts = append(parts, annotation.URL)
+		}
+	}
+
+	labels := []string{}
+	for _, label := range kn.Labels {
+		labels = append(labels, label.Name)
+	}
+
+	updated := time.Unix(0, kn.Edited*int64(time.Microsecond)).UTC()
+	created := updated
+	if kn.Created > 0 {
+		created = time.Unix(0, kn.Created*int64(time.Microsecond)).UTC()
+	}
+
+	return ExportNote{
+		Body:      strings.Join(parts, "\n\n"),
+		Date:      noteWallTime(created),
+		Tags:      importTags(labels),
+		Archived:  kn.IsArchived,
+		Starred:   kn.IsPinned,
+		CreatedAt: created,
+		UpdatedAt: updated,
+	}
+}
+
+// parseKeepHTML reads a Keep note from its .html file. The date in the
+// heading has no time zone, it is taken to be in the Settings' Timezone.
+func parseKeepHTML(r io.Reader) (keepNote, error) {
+	kn := keepNote{}
+	doc, err := html.Parse(r)
+	if err != nil {
+		return kn, err
+	}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch htmlClass(n) {
+		case "heading":
+			if t, err := time.ParseInLocation(KeepTimeFormat, strings.TrimSpace(htmlText(n)), noteLocation()); err == nil {
+				kn.Created = t.UnixNano() / int64(time.Microsecond)
+				kn.Edited = kn.Created
+			}
+			return
+		case "archived":
+			kn.IsArchived = true
+		case "pinned":
+			kn.IsPinned = true
+		case "title":
+			kn.Title = strings.TrimSpace(htmlText(n))
+			return
+		case "listitem":
+			text := strings.TrimSpace(htmlText(n))
+			kn.ListContent = append(kn.ListContent, keepListItem{
+				Text:      strings.TrimSpace(strings.TrimLeft(text, "☐☑")),
+				IsChecked: strings.HasPrefix(text, "☑"),
+			})
+			return
+		case "content":
+			m := &markdownWriter{}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				if c.Type == html.ElementNode && c.Data == "ul" {
+					walk(c)
+				} else {
+					m.walk(c)
+				}
+			}
+			kn.TextContent = m.String()
+			return
+		case "label-name":
+			kn.Labels = append(kn.Labels, keepLabel{Name: strings.TrimSpace(htmlText(n))})
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if kn.Created == 0 {
+		return kn, fmt.Errorf("no date found")
+	}
+	return kn, nil
+}
+
+// htmlClass returns the first class of the html element, e.g.
+// "listitem" for class="listitem checked".
+func htmlClass(n *html.Node) string {
+	if n.Type != html.ElementNode {
+		return ""
+	}
+	for _, attr := range n.Attr {
+		if classes := strings.Fields(attr.Val); attr.Key == "class" && len(classes) > 0 {
+			return classes[0]
+		}
+	}
+	return ""
+}
+
+// htmlText returns the text of the html node and its children.
+func htmlText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	text := ""
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		text += htmlText(c)
+	}
+	return text
+}
+
+// SimplenoteFile is the notes file in a Simplenote export.
+const SimplenoteFile = "source/notes.json"
+
+// simplenoteExport is the notes.json of a Simplenote export.
+type simplenoteExport struct {
+	ActiveNotes  []simplenoteNote `json:"activeNotes"`
+	TrashedNotes []simplenoteNote `json:"trashedNotes"`
+}
+
+// simplenoteNote is a note of a Simplenote export. Its first line is
+// the title.
+type simplenoteNote struct {
+	Content      string    `json:"content"`
+	CreationDate time.Time `json:"creationDate"`
+	LastModified time.Time `json:"lastModified"`
+	Tags         []string  `json:"tags"`
+	Pinned       bool      `json:"pinned"`
+}
+
+// isSimplenoteExport reports whether the json is the notes.json of a
+// Simplenote export, rather than an export file.
+func isSimplenoteExport(b []byte) bool {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return false
+	}
+	_, ok := keys["activeNotes"]
+	return ok
+}
+
+// decodeSimplenote reads the notes.json of a Simplenote export.
+// Pinned notes are starred, and trashed notes are left out.
+func decodeSimplenote(r io.Reader) (ExportFile, error) {
+	file := ExportFile{Version: ExportVersion, ExportedAt: time.Now().UTC(), Notes: []ExportNote{}}
+
+	export := simplenoteExport{}
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return file, fmt.Errorf("invalid Simplenote export: %v", err)
+	}
+
+	for _, sn := range export.ActiveNotes {
+		created, updated := sn.CreationDate.UTC(), sn.LastModified.UTC()
+		if created.IsZero() {
+			created = updated
+		}
+		if updated.IsZero() {
+			updated = created
+		}
+		file.Notes = append(file.Notes, ExportNote{
+			Body:      strings.TrimSpace(sn.Content),
+			Date:      noteWallTime(created),
+			Tags:      importTags(sn.Tags),
+			Starred:   sn.Pinned,
+			CreatedAt: created,
+			UpdatedAt: updated,
+		})
+	}
+	return file, nil
+}
+
+// Notion exports.
+var (
+	notionPage     = regexp.MustCompile(`^(.*) ([0-9a-f]{32})\.md$`)
+	notionProperty = regexp.MustCompile(`^([A-Za-z][\w ]*): (.*)$`)
+)
+
+// Notion property names, lowercased, of the created date and the tags.
+var (
+	notionCreated = []string{"created", "created time", "created at", "date created", "date"}
+	notionTags    = []string{"tags", "tag", "labels", "label"}
+)
+
+// notionDateFormats are the formats of Notion dates, in Markdown and CSV.
+var notionDateFormats = []string{
+	"January 2, 2006 3:04 PM",
+	"January 2, 2006 15:04",
+	"January 2, 2006",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"2006/01/02 15:04",
+	"2006-01-02",
+}
+
+// notionFile is a page or database file of a Notion export.
+type notionFile struct {
+	name     string
+	content  []byte
+	modified time.Time
+}
+
+// decodeNotion reads the pages of a Notion export. A page is a Markdown
+// file, and the rows of a database are pages, with their properties in
+// a CSV file. The Created and Tags properties of a page are its date
+// and tags; pages without a Created date are dated when exported.
+// Large exports are split in zip files, which are read in turn.
+func decodeNotion(zr *zip.Reader) (ExportFile, error) {
+	file := ExportFile{Version: ExportVersion, ExportedAt: time.Now().UTC(), Notes: []ExportNote{}}
+
+	budget := int64(MaxNotionExportSize)
+	files, err := notionFiles(zr, &budget, 0)
+	if err != nil {
+		return file, err
+	}
+
+	// The properties of the database rows, by the folder of the row
+	// pages and the row's title.
+	rows := map[string]map[string]string{}
+	for _, f := range files {
+		if path.Ext(f.name) != ".csv" {
+			continue
+		}
+		dir := strings.TrimSuffix(strings.TrimSuffix(f.name, ".csv"), "_all")
+		records, err := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(f.content, []byte("\ufeff")))).ReadAll()
+		if err != nil {
+			return file, fmt.Errorf("invalid Notion database %v: %v", path.Base(f.name), err)
+		}
+		if len(records) == 0 {
+			continue
+		}
+		header := records[0]
+		for _, record := range records[1:] {
+			props := map[string]string{}
+			for i, value := range record {
+				if i < len(header) {
+					props[strings.ToLower(strings.TrimSpace(header[i]))] = strings.TrimSpace(value)
+				}
+			}
+			if len(record) > 0 {
+				rows[dir+"/"+strings.TrimSpace(record[0])] = props
+			}
+		}
+	}
+
+	for _, f := range files {
+		match := notionPage.FindStringSubmatch(path.Base(f.name))
+		if match == nil {
+			continue
+		}
+		title := match[1]
+		file.Notes = append(file.Notes, notionNote(title, string(f.content), rows[path.Dir(f.name)+"/"+title], f.modified))
+	}
+
+	if len(file.Notes) == 0 {
+		return file, fmt.Errorf("invalid Notion export: no pages found")
+	}
+	return file, nil
+}
+
+// notionFiles returns the pages and databases of the Notion export,
+// and of the zip files in it, by name. Each file that is read is taken
+// off the budget of uncompressed bytes, and reading more than the
+// budget fails.
+func notionFiles(zr *zip.Reader, budget *int64, depth int) ([]notionFile, error) {
+	files := []notionFile{}
+	for _, f := range zr.File {
+		ext := path.Ext(f.Name)
+		if ext != ".md" && ext != ".csv" && ext != ".zip" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := ioutil.ReadAll(io.LimitReader(rc, *budget+1))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if *budget -= int64(len(content)); *budget < 0 {
+			return nil, fmt.Errorf("invalid Notion export: larger than %v MB uncompressed", MaxNotionExportSize>>20)
+		}
+
+		if ext == ".zip" {
+			if depth >= MaxNotionZipDepth {
+				return nil, fmt.Errorf("invalid Notion export: zip files nested too deep in %v", path.Base(f.Name))
+			}
+			part, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+			if err != nil {
+				return nil, fmt.Errorf("invalid zip file %v: %v", path.Base(f.Name), err)
+			}
+			partFiles, err := notionFiles(part, budget, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, partFiles...)
+			continue
+		}
+		files = append(files, notionFile{name: f.Name, content: content, modified: f.Modified})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, nil
+}
+
+// notionNote converts a Notion page. Its properties are the lines
+// after the title, which are also in the CSV of its database, if any.
+// The Created and Tags properties are removed from the body.
+func notionNote(title, content string, row map[string]string, exported time.Time) ExportNote {
+	lines := strings.Split(strings.ReplaceAll(strings.TrimPrefix(content, "\ufeff"), "\r\n", "\n"), "\n")
+
+	// The title, as a heading.
+	body := []string{}
+	i := 0
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+	if i < len(lines) && strings.HasPrefix(lines[i], "# ") {
+		body = append(body, lines[i], "")
+		i++
+	} else {
+		body = append(body, "# "+title, "")
+	}
+	for i < len(lines) && strings.TrimSpace(lines[i]) == "" {
+		i++
+	}
+
+	// The properties, up to an empty line.
+	props := map[string]string{}
+	for key, value := range row {
+		props[key] = value
+	}
+	end := i
+	for end < len(lines) && notionProperty.MatchString(lines[end]) {
+		end++
+	}
+	if end > i && (end == len(lines) || strings.TrimSpace(lines[end]) == "") {
+		for _, line := range lines[i:end] {
+			match := notionProperty.FindStringSubmatch(line)
+			key := strings.ToLower(match[1])
+			props[key] = strings.TrimSpace(match[2])
+			if !notionKnownProperty(key) {
+				body = append(body, line)
+			}
+		}
+		i = end
+	}
+	body = append(body, lines[i:]...)
+
+	date := noteWallTime(exported)
+	for _, key := range notionCreated {
+		// A date range starts with its first date.
+		value := strings.TrimPrefix(strings.Split(props[key], " → ")[0], "@")
+		if t, err := parseTimeFormats(value, notionDateFormats...); value != "" && err == nil {
+			date = t
+			break
+		}
+	}
+	created := time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), 0, noteLocation()).UTC()
+
+	tags := []string{}
+	for _, key := range notionTags {
+		if props[key] != "" {
+			tags = importTags(strings.Split(props[key], ","))
+			break
+		}
+	}
+
+	return ExportNote{
+		Body:      strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(body, "\n"), "\n\n")),
+		Date:      date,
+		Tags:      tags,
+		CreatedAt: created,
+		UpdatedAt: created,
+	}
+}
+
+// notionKnownProperty reports whether the property is the created date
+// or the tags of a page.
+func notionKnownProperty(key string) bool {
+	for _, known := range append(notionCreated, notionTags...) {
+		if key == known {
+			return true
+		}
+	}
+	return false
+}
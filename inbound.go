@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InboundMaxAge is how old the signature of an inbound email can be,
+// so a captured request can't be replayed later.
+const InboundMaxAge = 5 * time.Minute
+
+// hashtagPattern matches the #hashtags of an email, which become tags.
+var hashtagPattern = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_/-]+)`)
+
+//
+// ------------------------------------------------------------------
+// Notes by email
+// ------------------------------------------------------------------
+//
+
+// HandleInboundMailgun creates a Note from an email, forwarded by a
+// Mailgun route. The subject is the first line of the Note, and the
+// #hashtags in the text are its tags.
+//
+// Mailgun retries failed requests, except ones rejected with 406,
+// which is used for emails that will never be accepted.
+func (s *Server) HandleInboundMailgun(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		writeAPIError(w, r, ErrBadRequest, err)
+		return
+	}
+
+	if err := s.verifyMailgun(r.FormValue("timestamp"), r.FormValue("token"), r.FormValue("signature")); err != nil {
+		writeAPIError(w, r, ErrInbound, err)
+		return
+	}
+	if !s.inboundSender(r.FormValue("sender")) {
+		writeAPIError(w, r, ErrInbound, fmt.Errorf("sender %q is not allowed", r.FormValue("sender")))
+		return
+	}
+
+	form := messageForm(r.FormValue("subject"), r.FormValue("stripped-text"))
+	if !form.IsValid() {
+		writeAPIError(w, r, ErrInbound, errors.New(strings.Join(form.Errors, ", ")), form.Errors...)
+		return
+	}
+
+	note, err := s.createNote(requestActor(r, "email"), &form)
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]uint{"id": note.ID})
+}
+
+// verifyMailgun checks the signature of a Mailgun webhook request.
+func (s *Server) verifyMailgun(timestamp, token, signature string) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > InboundMaxAge || age < -InboundMaxAge {
+		return errors.New("signature expired")
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.Config.MailgunSigningKey))
+	mac.Write([]byte(timestamp + token))
+	if !hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil)))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// inboundSender reports whether Notes can be created by emails from
+// the address. Any address is allowed when InboundSenders is empty.
+func (s *Server) inboundSender(address string) bool {
+	if s.Config.InboundSenders == "" {
+		return true
+	}
+	for _, allowed := range strings.Split(s.Config.InboundSenders, ",") {
+		if strings.EqualFold(strings.TrimSpace(allowed), address) {
+			return true
+		}
+	}
+	return false
+}
+
+// messageForm converts an email or chat message into a NoteForm, dated
+// now. The #hashtags in the text are the tags.
+func messageForm(subject, text string) NoteForm {
+	body := strings.TrimSpace(subject)
+	if text = strings.TrimSpace(text); text != "" {
+		body += "\n\n" + text
+	}
+
+	tags := []string{}
+	for _, match := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+		tags = append(tags, match[1])
+	}
+
+	now := localNow()
+	return NoteForm{
+		Body: strings.TrimSpace(body),
+		Date: now.Format(NotePartialDateFormat),
+		Time: now.Format(NotePartialTimeFormat),
+		Tags: strings.Join(tags, ","),
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+// Kinds of Job.
+const (
+	JobExport = "export"
+	JobImport = "import"
+)
+
+// States of a Job.
+const (
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+// JobHistory is the amount of finished Jobs that are kept.
+const JobHistory = 10
+
+//
+// ------------------------------------------------------------------
+// Background jobs
+// ------------------------------------------------------------------
+//
+
+// Job is an export or import that runs in the background, so large
+// ones don't time out the request that started them. Jobs are kept in
+// memory, they are lost when the server stops.
+type Job struct {
+	ID      int
+	Kind    string
+	Started time.Time
+
+	mu       sync.Mutex
+	state    string
+	done     int
+	total    int
+	errors   []string // records that were skipped
+	err      error    // why the job failed
+	finished time.Time
+	file     string // the export file, done exports only
+	cancel   context.CancelFunc
+}
+
+// progress implements Progress.
+func (j *Job) progress(done, total int, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.done, j.total = done, total
+	if err != nil {
+		j.errors = append(j.errors, err.Error())
+	}
+}
+
+// Cancel stops the Job, if it is running.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+// JobStatus is a snapshot of a Job, for the jobs pages.
+type JobStatus struct {
+	ID       int
+	Kind     string
+	State    string
+	Done     int
+	Total    int
+	Errors   []string
+	Error    string
+	Started  time.Time
+	Elapsed  time.Duration
+	ETA      time.Duration // zero when unknown
+	Download bool
+}
+
+// Percent returns how much of the Job is done, from 0 to 100.
+func (s JobStatus) Percent() int {
+	if s.Total == 0 {
+		return 0
+	}
+	return s.Done * 100 / s.Total
+}
+
+// Status returns a snapshot of the Job.
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := JobStatus{
+		ID:       j.ID,
+		Kind:     j.Kind,
+		State:    j.state,
+		Done:     j.done,
+		Total:    j.total,
+		Errors:   append([]string{}, j.errors...),
+		Started:  j.Started,
+		Download: j.file != "",
+	}
+	if j.err != nil {
+		status.Error = j.err.Error()
+	}
+
+	end := j.finished
+	if j.state == JobRunning {
+		end = time.Now()
+		// Assume the remaining records take as long as the done ones.
+		if j.done > 0 && j.total > j.done {
+			perRecord := end.Sub(j.Started) / time.Duration(j.done)
+			status.ETA = (perRecord * time.Duration(j.total-j.done)).Round(time.Second)
+		}
+	}
+	status.Elapsed = end.Sub(j.Started).Round(time.Second)
+	return status
+}
+
+// Jobs runs and keeps track of the background Jobs.
+type Jobs struct {
+	mu     sync.Mutex
+	jobs   []*Job // oldest first
+	nextID int
+}
+
+// NewJobs ...
+func NewJobs() *Jobs {
+	return &Jobs{nextID: 1}
+}
+
+// Start runs the function in the background as a new Job.
+// The function returns the path of the export file, if any.
+func (js *Jobs) Start(kind string, run func(ctx context.Context, job *Job) (string, error)) *Job {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	js.mu.Lock()
+	job := &Job{ID: js.nextID, Kind: kind, Started: time.Now(), state: JobRunning, cancel: cancel}
+	js.nextID++
+	js.jobs = append(js.jobs, job)
+	js.trim()
+	js.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		file, err := runJob(ctx, job, run)
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		job.finished = time.Now()
+		switch {
+		case errors.Is(err, context.Canceled):
+			job.state = JobCanceled
+		case err != nil:
+			job.state = JobFailed
+			job.err = err
+			log.Printf("[jobs] %v %v: %v", job.Kind, job.ID, err)
+		default:
+			job.state = JobDone
+			job.file = file
+		}
+	}()
+	return job
+}
+
+// runJob runs the function of the Job. A panic fails the Job, rather
+// than stopping the server, and is logged with its stack trace.
+func runJob(ctx context.Context, job *Job, run func(ctx context.Context, job *Job) (string, error)) (file string, err error) {
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			PanicCount.Add(1)
+			log.Printf("[jobs] %v %v: panic: %v\n%s", job.Kind, job.ID, rvr, debug.Stack())
+			err = fmt.Errorf("panic: %v", rvr)
+		}
+	}()
+	return run(ctx, job)
+}
+
+// trim forgets the oldest finished Jobs, and removes their files,
+// when there are more than JobHistory.
+func (js *Jobs) trim() {
+	finished := []*Job{}
+	for _, job := range js.jobs {
+		if job.Status().State != JobRunning {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= JobHistory {
+		return
+	}
+
+	forget := map[*Job]bool{}
+	for _, job := range finished[:len(finished)-JobHistory] {
+		forget[job] = true
+		job.mu.Lock()
+		if job.file != "" {
+			os.Remove(job.file)
+		}
+		job.mu.Unlock()
+	}
+
+	jobs := []*Job{}
+	for _, job := range js.jobs {
+		if !forget[job] {
+			jobs = append(jobs, job)
+		}
+	}
+	js.jobs = jobs
+}
+
+// Get returns the Job with the id, or nil.
+func (js *Jobs) Get(id string) *Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	for _, job := range js.jobs {
+		if strconv.Itoa(job.ID) == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// List returns the status of all Jobs, newest first.
+func (js *Jobs) List() []JobStatus {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	statuses := []JobStatus{}
+	for i := len(js.jobs) - 1; i >= 0; i-- {
+		statuses = append(statuses, js.jobs[i].Status())
+	}
+	return statuses
+}
+
+// JobsContext provides context data to the jobs page.
+type JobsContext struct {
+	Jobs  []JobStatus
+	Tasks []TaskStatus
+}
+
+// HandleJobList serves the jobs page, where exports and imports
+// are started, with the scheduled Tasks.
+func (s *Server) HandleJobList(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "jobs", JobsContext{Jobs: s.Jobs.List(), Tasks: s.Scheduler.Tasks()})
+}
+
+// HandleJobExport starts an export of all Notes.
+func (s *Server) HandleJobExport(w http.ResponseWriter, r *http.Request) {
+	job := s.Jobs.Start(JobExport, func(ctx context.Context, job *Job) (string, error) {
+		f, err := ioutil.TempFile("", "simplenotes-export-*.json")
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+
+		if _, err := exportNotes(ctx, s.ReadDB, f, job.progress); err != nil {
+			os.Remove(f.Name())
+			return "", err
+		}
+		return f.Name(), f.Close()
+	})
+
+	http.Redirect(w, r, fmt.Sprintf("/jobs/%v", job.ID), http.StatusFound)
+}
+
+// HandleJobImport starts an import of the uploaded export file, or
+// of an export of another app, see decodeImportFile.
+// Invalid Notes are skipped, and listed on the job's page.
+func (s *Server) HandleJobImport(w http.ResponseWriter, r *http.Request) {
+	upload, header, err := r.FormFile("file")
+	if err != nil {
+		s.renderError(w, r, ErrBadRequest, err)
+		return
+	}
+	defer upload.Close()
+
+	file, err := decodeImportFile(header.Filename, upload)
+	if err != nil {
+		s.renderError(w, r, ErrImport, err)
+		return
+	}
+
+	job := s.Jobs.Start(JobImport, func(ctx context.Context, job *Job) (string, error) {
+		job.progress(0, len(file.Notes), nil)
+		_, err := s.importNotes(ctx, file, true, job.progress)
+		return "", err
+	})
+
+	http.Redirect(w, r, fmt.Sprintf("/jobs/%v", job.ID), http.StatusFound)
+}
+
+// HandleJob serves the progress page of a Job.
+func (s *Server) HandleJob(w http.ResponseWriter, r *http.Request) {
+	job := s.Jobs.Get(chi.URLParam(r, "jobID"))
+	if job == nil {
+		s.renderError(w, r, ErrJobNotFound, nil)
+		return
+	}
+	s.render(w, r, "job", job.Status())
+}
+
+// HandleJobCancel stops a running Job. A canceled import
+// creates no Notes.
+func (s *Server) HandleJobCancel(w http.ResponseWriter, r *http.Request) {
+	job := s.Jobs.Get(chi.URLParam(r, "jobID"))
+	if job == nil {
+		s.renderError(w, r, ErrJobNotFound, nil)
+		return
+	}
+	job.Cancel()
+
+	http.Redirect(w, r, fmt.Sprintf("/jobs/%v", job.ID), http.StatusFound)
+}
+
+// HandleJobDownload serves the file of a done export.
+func (s *Server) HandleJobDownload(w http.ResponseWriter, r *http.Request) {
+	job := s.Jobs.Get(chi.URLParam(r, "jobID"))
+	if job == nil {
+		s.renderError(w, r, ErrJobNotFound, nil)
+		return
+	}
+	job.mu.Lock()
+	file := job.file
+	job.mu.Unlock()
+	if file == "" {
+		s.renderError(w, r, ErrJobNotFound, nil)
+		return
+	}
+
+	name := fmt.Sprintf("simplenotes-export-%v.json", job.Started.Format(NoteDayFormat))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, file)
+}
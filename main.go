@@ -1,16 +1,30 @@
 package main
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
 	"embed"
+	"encoding/base64"
+	"errors"
+	"expvar"
 	"fmt"
 	"html/template"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
-	"github.com/tunedmystic/authsolo"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/text/unicode/norm"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -21,11 +35,67 @@ const (
 	NoteDateFormat        = "Jan _2, 2006 3:04 PM"
 	NotePartialDateFormat = "January _2, 2006"
 	NotePartialTimeFormat = "3:04 PM"
+	NoteDayFormat         = "2006-01-02" // day permalinks
+	NoteFormDateFormat    = "2006-01-02" // ISO 8601, posted by the date input
+	NoteFormTimeFormat    = "15:04"      // ISO 8601, posted by the time input
 )
 
+// NoteForm dates and times can also be typed in these formats, e.g. by
+// API clients, or in browsers without date and time inputs.
+var (
+	NoteFormDateFormats = []string{
+		NoteFormDateFormat, NotePartialDateFormat, "Jan _2, 2006", "January _2 2006", "Jan _2 2006",
+		"_2 January 2006", "_2 Jan 2006", "2006/01/02",
+	}
+	NoteFormTimeFormats = []string{
+		NoteFormTimeFormat, "15:04:05", NotePartialTimeFormat, "3:04PM", "3:04:05 PM", "3 PM", "3PM",
+	}
+	// A date with a time, in the date field, when there is no time.
+	NoteFormDateTimeFormats = []string{
+		"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04", NoteDateFormat,
+	}
+)
+
+// Timezones are the time zones offered on the Note form and in the
+// settings.
+var Timezones = []string{
+	"UTC",
+	"America/Anchorage",
+	"America/Los_Angeles",
+	"America/Denver",
+	"America/Chicago",
+	"America/New_York",
+	"America/Halifax",
+	"America/Sao_Paulo",
+	"Atlantic/Reykjavik",
+	"Europe/London",
+	"Europe/Paris",
+	"Europe/Berlin",
+	"Europe/Helsinki",
+	"Europe/Moscow",
+	"Africa/Lagos",
+	"Africa/Johannesburg",
+	"Asia/Dubai",
+	"Asia/Kolkata",
+	"Asia/Bangkok",
+	"Asia/Shanghai",
+	"Asia/Singapore",
+	"Asia/Tokyo",
+	"Australia/Perth",
+	"Australia/Sydney",
+	"Pacific/Auckland",
+	"Pacific/Honolulu",
+}
+
 // MaxBodyLength is the max amount of characters the Note Body can have.
 const MaxBodyLength = 500
 
+// MaxTitleLength is the max amount of characters an inferred title can have.
+const MaxTitleLength = 80
+
+// NoteSortCookie remembers the preferred order of the Note list.
+const NoteSortCookie = "note_sort"
+
 //
 // ------------------------------------------------------------------
 // Models
@@ -35,26 +105,311 @@ const MaxBodyLength = 500
 // Note is the model for the `notes` table.
 type Note struct {
 	gorm.Model
-	Body string
-	Date time.Time
+	Title EncryptedText // inferred from the body, see inferTitle
+	Body  EncryptedText
+	Date  time.Time
+
+	// Monospace shows the body in a monospace font, with its whitespace
+	// preserved, e.g. for ASCII tables and code.
+	Monospace bool
+
+	// Words and Characters count the body. They are stored on save,
+	// so the stats don't need to read every body.
+	Words      int
+	Characters int
+
+	// Archived Notes are hidden from the note list and searches.
+	// ReviewedAt is when the Note was last kept in the review queue.
+	Archived   bool
+	ReviewedAt *time.Time
+
+	// Starred Notes are collected at /starred.
+	Starred bool
+
+	// Draft Notes are hidden from the note list, searches and feeds,
+	// until they are published, see HandleNotePublish.
+	Draft bool
+
+	// Scheduled Notes are hidden like drafts until their date has
+	// passed, see notScheduled.
+	Scheduled bool
+
+	// ExpiresAt is when the Note is deleted for good, see runPurges.
+	ExpiresAt *time.Time
+
+	// ChangeSeq numbers the changes of all Notes, for the changes feed.
+	// It is set by the database, see the notes_change_seq migration.
+	ChangeSeq int64 `gorm:"->"`
 
 	Tags []Tag `gorm:"many2many:note_tag"`
 }
 
-// DisplayDate formats the date as a string.
+// DisplayDate formats the date as a string, in the DateFormat of the
+// Settings.
 func (n *Note) DisplayDate() string {
-	return n.Date.Format(NotePartialDateFormat)
+	return displayDay(n.Date)
+}
+
+// Day returns the day of the date, as used in day permalinks.
+func (n *Note) Day() string {
+	return n.Date.Format(NoteDayFormat)
 }
 
 // DisplayTime formats the date's time as a string.
 func (n *Note) DisplayTime() string {
-	return n.Date.Format(NotePartialTimeFormat)
+	return formatDate(n.Date, currentSettings().Format().Time)
+}
+
+// DisplayTitle returns the Note title. Notes saved without one
+// infer it from the body.
+func (n *Note) DisplayTitle() string {
+	if n.Title != "" {
+		return string(n.Title)
+	}
+	return inferTitle(string(n.Body))
 }
 
 // Tag is the model for the `tags` table.
 type Tag struct {
 	gorm.Model
-	Name string
+	Name  string
+	Color string // e.g. #2563eb, or empty for the default gray
+
+	// Description documents what the Tag is for, on its about page.
+	Description string
+}
+
+//
+// ------------------------------------------------------------------
+// Encryption
+// ------------------------------------------------------------------
+//
+
+// Prefixes of the stored values of EncryptedText. Values that start
+// with "enc:" are never stored as-is, so plaintext that does is marked
+// with plainPrefix, and isn't mistaken for an encrypted value.
+const (
+	encryptedPrefix       = "enc:v2:" // encrypted with the key of the stored salt
+	legacyEncryptedPrefix = "enc:v1:" // encrypted with the unsalted sha256 key, read only
+	plainPrefix           = "enc:none:"
+)
+
+// bodyCipher encrypts Note bodies at rest. It is nil when encryption is disabled.
+var bodyCipher cipher.AEAD
+
+// legacyBodyCipher decrypts the values of legacyEncryptedPrefix, until
+// they are encrypted again with `encrypt`.
+var legacyBodyCipher cipher.AEAD
+
+// EncryptionSalt is the model for the `encryption_salt` table. It has
+// the random salt of the key of the EncryptionSecret.
+type EncryptionSalt struct {
+	ID   uint
+	Salt []byte
+}
+
+// TableName overrides the table name used by EncryptionSalt.
+func (EncryptionSalt) TableName() string {
+	return "encryption_salt"
+}
+
+// EnableEncryption derives an AES-256 key from the secret with argon2id,
+// and turns on at-rest encryption for EncryptedText values. The salt
+// is created on first use, and stored in the database.
+func EnableEncryption(db *gorm.DB, secret string) error {
+	salt := EncryptionSalt{ID: 1}
+	if err := db.Take(&salt).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+		salt.Salt = make([]byte, 16)
+		if _, err := rand.Read(salt.Salt); err != nil {
+			return err
+		}
+		if err := db.Create(&salt).Error; err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	aead, err := newBodyCipher(argon2.IDKey([]byte(secret), salt.Salt, 1, 64*1024, 4, 32))
+	if err != nil {
+		return err
+	}
+	legacyKey := sha256.Sum256([]byte("simplenotes:body:" + secret))
+	legacy, err := newBodyCipher(legacyKey[:])
+	if err != nil {
+		return err
+	}
+	bodyCipher, legacyBodyCipher = aead, legacy
+	return nil
+}
+
+// newBodyCipher returns the AES-GCM cipher of the key.
+func newBodyCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptedText is a string that is encrypted before it is written to the
+// database, and decrypted when it is read. Plaintext values are read as-is,
+// so existing data keeps working until it is migrated with `encrypt`.
+type EncryptedText string
+
+// Value implements driver.Valuer.
+func (t EncryptedText) Value() (driver.Value, error) {
+	if bodyCipher == nil {
+		if strings.HasPrefix(string(t), "enc:") {
+			return plainPrefix + string(t), nil
+		}
+		return string(t), nil
+	}
+	nonce := make([]byte, bodyCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := bodyCipher.Seal(nonce, nonce, []byte(t), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Scan implements sql.Scanner.
+func (t *EncryptedText) Scan(value interface{}) error {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+		s = ""
+	default:
+		return fmt.Errorf("cannot scan %T into EncryptedText", value)
+	}
+
+	raw, aead := s, bodyCipher
+	switch {
+	case strings.HasPrefix(s, plainPrefix):
+		*t = EncryptedText(strings.TrimPrefix(s, plainPrefix))
+		return nil
+	case strings.HasPrefix(s, encryptedPrefix):
+		s = strings.TrimPrefix(s, encryptedPrefix)
+	case strings.HasPrefix(s, legacyEncryptedPrefix):
+		s = strings.TrimPrefix(s, legacyEncryptedPrefix)
+		aead = legacyBodyCipher
+	default:
+		*t = EncryptedText(s)
+		return nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		// Plaintext saved before it was marked, e.g. "enc:v1: my notes".
+		*t = EncryptedText(raw)
+		return nil
+	}
+	if aead == nil {
+		return errors.New("encrypted value found, but no encryption secret is configured")
+	}
+	nonceSize := aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return errors.New("encrypted value is too short")
+	}
+	plain, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return err
+	}
+	*t = EncryptedText(plain)
+	return nil
+}
+
+// encryptedColumns are the table columns of EncryptedText values.
+// Note bodies are last, their count is the one encryptNotes returns.
+var encryptedColumns = [][2]string{
+	{"audit_entries", "changes"},
+	{"drafts", "body"},
+	{"note_revisions", "body"},
+	{"notes", "title"},
+	{"notes", "body"},
+}
+
+// encryptNotes re-saves every EncryptedText value, see encryptedColumns,
+// so that plaintext ones, and the ones encrypted with the legacy key,
+// are encrypted. It returns the number of Notes that were encrypted.
+func encryptNotes(db *gorm.DB) (int, error) {
+	if bodyCipher == nil {
+		return 0, errors.New("SIMPLENOTES_ENCRYPTION_SECRET is not set")
+	}
+
+	count := 0
+	for _, c := range encryptedColumns {
+		var err error
+		if count, err = encryptColumn(db, c[0], c[1]); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+// encryptColumn encrypts the values of the column in the given table
+// that aren't encrypted with the current key.
+// It returns the number of rows that were encrypted.
+func encryptColumn(db *gorm.DB, table, column string) (int, error) {
+	query := fmt.Sprintf("select id, %v from %v where %v not like ?", column, table, column)
+	rows, err := db.Raw(query, encryptedPrefix+"%").Rows()
+	if err != nil {
+		return 0, err
+	}
+
+	type plainValue struct {
+		ID    uint
+		Value EncryptedText
+	}
+	values := []plainValue{}
+	for rows.Next() {
+		v := plainValue{}
+		if err := rows.Scan(&v.ID, &v.Value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		values = append(values, v)
+	}
+	rows.Close()
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, v := range values {
+			if err := tx.Table(table).Where("id = ?", v.ID).UpdateColumn(column, v.Value).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(values), nil
+}
+
+// countNoteBodies stores the word and character counts of Notes that
+// don't have them yet, including deleted Notes.
+func countNoteBodies(db *gorm.DB) error {
+	notes := []Note{}
+	err := db.Unscoped().Select("id", "body").Where("words is null").Find(&notes).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, note := range notes {
+			words, characters := countBody(string(note.Body))
+			err := tx.Model(&Note{}).Unscoped().Where("id = ?", note.ID).
+				UpdateColumns(map[string]interface{}{"words": words, "characters": characters}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 //
@@ -67,51 +422,269 @@ type Tag struct {
 type Server struct {
 	Templates     *template.Template
 	StaticHandler http.Handler
-	DB            *gorm.DB
+	DB            *gorm.DB // primary connection, used for all writes
+	ReadDB        *gorm.DB // replica connection, used for read-only pages
+	Events        *EventHub
+	Counts        *SearchCounts
+	Config        Config
+	Auth          Auth
+	Usage         *UsageCounter // nil unless analytics are enabled
+	Jobs          *Jobs
+	Scheduler     *Scheduler // periodic Tasks, see scheduleTasks
+	Remotes       []*Remote  // other instances in the timeline
 }
 
+// TemplatesHTML holds all the html templates.
+//
+//go:embed templates/*
+var TemplatesHTML embed.FS
+
+// Assets holds all the static assets.
+//
+//go:embed static/*
+var Assets embed.FS
+
 // NewServer ...
 func NewServer(db *gorm.DB) Server {
-	// TemplatesHTML holds all the html templates.
-	//go:embed templates/*
-	var TemplatesHTML embed.FS
-
-	// Assets holds all the static assets.
-	//go:embed static/*
-	var Assets embed.FS
+	counts := NewSearchCounts(db)
+	funcs := template.FuncMap{
+		"pinnedSearches": counts.Pinned,
+		"tagNotes":       counts.TagNotes,
+		"pageTitle":      counts.Title,
+		"timezones":      func() []string { return Timezones },
+		"noteTimezone":   noteTimezone,
+		"theme":          func() string { return currentSettings().Theme },
+		"formatDate":     displayDateTime,
+		"formatDay":      displayDay,
+		"locale":         func() string { return currentSettings().Language().Name },
+		"t":              translate,
+		"noteBody":       renderNoteBody,
+	}
 
 	return Server{
-		Templates:     template.Must(template.ParseFS(TemplatesHTML, "templates/*.html")),
+		Templates:     template.Must(template.New("").Funcs(funcs).ParseFS(TemplatesHTML, "templates/*.html")),
 		StaticHandler: http.FileServer(http.FS(Assets)),
 		DB:            db,
+		ReadDB:        db,
+		Events:        NewEventHub(),
+		Counts:        counts,
+		Jobs:          NewJobs(),
+		Scheduler:     NewScheduler(),
 	}
 }
 
 // Routes ...
 func (s *Server) Routes() http.Handler {
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(middleware.RequestID)
+	r.Use(exposeRequestID)
+	r.Use(middleware.RequestLogger(&middleware.DefaultLogFormatter{
+		Logger:  log.New(LogOutput, "", log.LstdFlags),
+		NoColor: true,
+	}))
+	r.Use(s.Recoverer)
+	if s.Usage != nil {
+		r.Use(s.Usage.Count)
+	}
+	r.NotFound(s.HandleNotFound)
+	r.MethodNotAllowed(s.HandleMethodNotAllowed)
+
+	// Static assets are public, so the login page can be styled, and
+	// so is the service worker, which caches them.
+	r.Get("/static/*", s.HandleStatic)
+	r.Get("/sw.js", s.HandleServiceWorker)
+
+	// Inbound messages are verified by their signature, not a login.
+	if s.Config.MailgunSigningKey != "" {
+		r.Post("/inbound/mailgun", s.HandleInboundMailgun)
+	}
+	if s.Config.SlackSigningSecret != "" {
+		r.Post("/integrations/slack", s.HandleSlackCommand)
+	}
+
+	// WebDAV clients use basic auth, see HandleDAV.
+	if s.Config.WebDAVPassword != "" {
+		dav := s.HandleDAV()
+		r.Handle(DAVPrefix, dav)
+		r.Handle(DAVPrefix+"/*", dav)
+	}
+
+	// Share links are public, see HandleShare.
+	r.Get("/s/{token}", s.HandleShare)
+	r.Get("/s/{token}/raw", s.HandleShareRaw)
+
+	// The calendar feed checks its token, or else the login.
+	r.Get("/calendar.ics", s.HandleCalendar)
+
+	// The API checks its token, or else the login.
+	r.Route("/api", s.apiRoutes)
+	r.With(s.protectAPI).Post("/quick", s.HandleQuick)
+	r.With(s.protectAPI).Get("/ws", s.HandleWebSocket) // note events and quick-create
+
+	// Add authentication middleware to all other routes.
+	r.Group(func(r chi.Router) {
+		r.Use(s.Auth.Protect)
+		s.protectedRoutes(r)
+	})
 
-	// Add authentication middleware to all routes.
-	auth := authsolo.New("super-secret")
-	r.Use(auth.SoloH)
+	// The security headers are set on the login pages too.
+	return s.securityHeaders(s.Auth.Handler(r))
+}
 
+// protectedRoutes adds the routes that need a logged in user.
+func (s *Server) protectedRoutes(r chi.Router) {
 	r.Get("/", s.HandleIndex)
-	r.Get("/static/*", s.HandleStatic)
-	r.Get("/note/new", s.HandleNoteCreateForm)             // note create form
-	r.Post("/note/new", s.HandleNoteCreate)                // note create action
-	r.Get("/note/{noteID}/change", s.HandleNoteUpdateForm) // note update form
-	r.Post("/note/{noteID}/change", s.HandleNoteUpdate)    // note update action
-	r.Post("/note/{noteID}/delete", s.HandleNoteDelete)    // note delete action
+	r.Get("/note/new", s.HandleNoteCreateForm)                       // note create form
+	r.Post("/note/new", s.HandleNoteCreate)                          // note create action
+	r.Post("/note/draft", s.HandleNoteDraft)                         // create form autosave
+	r.Post("/note/draft/discard", s.HandleNoteDraftDiscard)          // create form draft discard action
+	r.Get("/note/{noteID}/change", s.HandleNoteUpdateForm)           // note update form
+	r.Post("/note/{noteID}/change", s.HandleNoteUpdate)              // note update action
+	r.Post("/note/{noteID}/delete", s.HandleNoteDelete)              // note delete action
+	r.Post("/note/{noteID}/undo", s.HandleNoteUndo)                  // note undo delete action
+	r.Post("/note/{noteID}/star", s.HandleNoteStar)                  // note star and unstar action
+	r.Get("/starred", s.HandleStarred)                               // starred notes
+	r.Get("/untagged", s.HandleUntagged)                             // notes without tags
+	r.Get("/random", s.HandleRandom)                                 // redirect to a random note
+	r.Get("/onthisday", s.HandleOnThisDay)                           // notes of this day in previous years
+	r.Post("/note/{noteID}/archive", s.HandleNoteArchive)            // note archive action
+	r.Post("/note/{noteID}/unarchive", s.HandleNoteUnarchive)        // note unarchive action
+	r.Post("/note/{noteID}/publish", s.HandleNotePublish)            // draft note publish action
+	r.Post("/note/{noteID}/remember/got-it", s.HandleRecallGotIt)    // resurface a remembered note later
+	r.Post("/note/{noteID}/remember/later", s.HandleRecallLater)     // resurface a note again tomorrow
+	r.Get("/review", s.HandleReview)                                 // review queue of untouched notes
+	r.Post("/review/{noteID}/keep", s.HandleReviewKeep)              // keep a note, it leaves the queue
+	r.Post("/review/{noteID}/delete", s.HandleReviewDelete)          // delete a note from the queue
+	r.Get("/tags", s.HandleTagList)                                  // tags page
+	r.Get("/tags/cloud", s.HandleTagCloud)                           // tag cloud page
+	r.Post("/tags/{tagID}/delete", s.HandleTagDelete)                // stale tag delete action
+	r.Post("/tags/{tagID}/color", s.HandleTagColor)                  // tag color action
+	r.Get("/tag/{name}/about", s.HandleTagAbout)                     // tag about page
+	r.Post("/tag/{name}/about", s.HandleTagAboutUpdate)              // tag description update action
+	r.Post("/tags/bulk", s.HandleTagBulk)                            // bulk rename action
+	r.Get("/presets", s.HandlePresetList)                            // capture presets page
+	r.Post("/presets", s.HandlePresetCreate)                         // capture preset create or update action
+	r.Post("/presets/{presetID}/delete", s.HandlePresetDelete)       // capture preset delete action
+	r.Post("/tags/rules", s.HandleTagRuleCreate)                     // auto-tagging rule create action
+	r.Post("/tags/rules/{ruleID}/delete", s.HandleTagRuleDelete)     // auto-tagging rule delete action
+	r.Post("/note/{noteID}/untag/{tagID}", s.HandleNoteUntag)        // remove a tag, e.g. an auto tag
+	r.Post("/note/{noteID}/share", s.HandleShareCreate)              // share link create action
+	r.Post("/shares/{shareID}/delete", s.HandleShareDelete)          // share link revoke action
+	r.Get("/day", s.HandleDayJump)                                   // jump to a date
+	r.Get("/day/{day}", s.HandleDay)                                 // notes of a single day
+	r.Get("/timeline", s.HandleTimeline)                             // local and remote notes in one list
+	r.Get("/stats", s.HandleStats)                                   // stats page
+	r.Get("/graph/{graph}.{format}", s.HandleGraphExport)            // link and tag graphs, as DOT or GraphML
+	r.Get("/activity", s.HandleActivity)                             // audit log
+	r.Get("/saved/{searchID}", s.HandleSavedSearch)                  // run a saved search
+	r.Post("/searches", s.HandleSavedSearchCreate)                   // pin a search
+	r.Post("/searches/{searchID}/delete", s.HandleSavedSearchDelete) // unpin a search
+	r.Get("/settings", s.HandleSettings)                             // settings page
+	r.Post("/settings", s.HandleSettingsUpdate)                      // settings update action
+	r.Get("/admin", s.HandleAdmin)                                   // admin page, with usage analytics
+	r.Get("/jobs", s.HandleJobList)                                  // export and import jobs
+	r.Get("/export.csv", s.HandleExportCSV)                          // notes as csv
+	r.Get("/export.org", s.HandleExportOrg)                          // notes as an org file
+	r.Post("/jobs/export", s.HandleJobExport)                        // start an export
+	r.Post("/jobs/import", s.HandleJobImport)                        // start an import of an export file
+	r.Get("/jobs/{jobID}", s.HandleJob)                              // job progress page
+	r.Post("/jobs/{jobID}/cancel", s.HandleJobCancel)                // job cancel action
+	r.Get("/jobs/{jobID}/download", s.HandleJobDownload)             // exported file
+	r.Get("/admin/support-bundle", s.HandleSupportBundle)            // logs, config and schema for bug reports
+	r.Get("/debug/vars", expvar.Handler().ServeHTTP)                 // metrics
+}
 
-	return auth.Handler(r)
+// apiRoutes adds the routes of the JSON API.
+func (s *Server) apiRoutes(r chi.Router) {
+	r.Use(s.protectAPI)
+	r.Get("/openapi.json", s.HandleAPISpec)
+	r.Get("/docs", s.HandleAPIDocs)
+	r.Get("/notes", s.HandleAPINoteList)
+	r.Post("/notes", s.HandleAPINoteCreate)
+	r.Get("/notes/{noteID}", s.HandleAPINoteDetail)
+	r.Put("/notes/{noteID}", s.HandleAPINoteUpdate)
+	r.Delete("/notes/{noteID}", s.HandleAPINoteDelete)
+	r.Get("/tags", s.HandleAPITagList)
+	r.Get("/tagcloud", s.HandleAPITagCloud)
+	r.Get("/changes", s.HandleAPIChanges)
+	r.Get("/stats", s.HandleAPIStats)
 }
 
 // HandleIndex serves the home page.
 func (s *Server) HandleIndex(w http.ResponseWriter, r *http.Request) {
-	notes := make([]Note, 30)
-	s.DB.Preload("Tags").Limit(30).Order("date desc").Find(&notes)
-	s.Templates.ExecuteTemplate(w, "index", notes)
+	sort := noteSort(w, r)
+	requestContext := IndexContext{
+		Sort:  sort,
+		Query: r.URL.Query().Get("q"),
+		Flash: popFlash(w, r),
+	}
+
+	sq, err := ParseSearchQuery(requestContext.Query)
+	if err != nil {
+		requestContext.SearchError = err.Error()
+		sq = SearchQuery{}
+	}
+
+	if asofParam := r.URL.Query().Get("asof"); asofParam != "" {
+		asof, err := parseAsOf(asofParam)
+		if err == nil {
+			requestContext.AsOf = asofParam
+			requestContext.Notes, _ = notesAsOf(s.ReadDB, asof, sort.OrderBy(), currentSettings().PageSize)
+			s.render(w, r, "index", requestContext)
+			return
+		}
+		requestContext.AsOfError = err.Error()
+	}
+
+	welcome, err := welcomeHTML(s.ReadDB, s.Config)
+	if err != nil {
+		logError(r, ErrDatabase, err)
+	}
+	requestContext.Welcome = welcome
+	requestContext.ReviewBefore = s.Config.reviewBefore()
+	requestContext.Timeline = len(s.Remotes) > 0
+
+	requestContext.Notes, _ = searchNotes(s.ReadDB, sq, sort.OrderBy(), currentSettings().PageSize)
+	if requestContext.Query == "" {
+		if requestContext.Recalls, err = dueRecalls(s.ReadDB, time.Now(), RecallShown); err != nil {
+			logError(r, ErrDatabase, err)
+		}
+	}
+	requestContext.AutoTags, err = autoTags(s.ReadDB, requestContext.Notes)
+	if err != nil {
+		logError(r, ErrDatabase, err)
+	}
+	if s.Config.LinkPreviews {
+		if requestContext.Previews, err = linkPreviews(s.ReadDB, requestContext.Notes); err != nil {
+			logError(r, ErrDatabase, err)
+		}
+	}
+	if sq.hasText() {
+		requestContext.Snippets = map[uint]template.HTML{}
+		for _, note := range requestContext.Notes {
+			requestContext.Snippets[note.ID] = sq.Snippet(string(note.Body))
+		}
+	}
+	s.render(w, r, "index", requestContext)
+}
+
+// HandleRandom redirects to a random Note, to resurface old ones.
+// Archived, draft and scheduled Notes are skipped.
+func (s *Server) HandleRandom(w http.ResponseWriter, r *http.Request) {
+	note := Note{}
+	err := s.ReadDB.Where("not archived and not draft").Scopes(notScheduled).Order("random()").Limit(1).Find(&note).Error
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	if note.ID == 0 {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	// Not cached, so every visit picks another Note.
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, fmt.Sprintf("/note/%v/change", note.ID), http.StatusFound)
 }
 
 // HandleStatic serves static assets.
@@ -121,57 +694,99 @@ func (s *Server) HandleStatic(w http.ResponseWriter, r *http.Request) {
 
 // HandleNoteCreateForm serves the Note create form.
 func (s *Server) HandleNoteCreateForm(w http.ResponseWriter, r *http.Request) {
-	var loc *time.Location
-	loc, err := time.LoadLocation("America/New_York")
+	now := localNow()
+
+	form := NoteForm{
+		Date:     now.Format(NoteFormDateFormat),
+		Time:     now.Format(NoteFormTimeFormat),
+		Timezone: noteTimezone(),
+	}
 
+	presets, err := capturePresets(s.ReadDB, r)
 	if err != nil {
-		loc = time.UTC
+		s.renderError(w, r, ErrDatabase, err)
+		return
 	}
-	now := time.Now().In(loc)
 
-	form := NoteForm{
-		Date: now.Format(NotePartialDateFormat),
-		Time: now.Format(NotePartialTimeFormat),
+	// Fill in the tags and body of the `?preset=<name>`.
+	preset := r.URL.Query().Get("preset")
+	if preset != "" {
+		found := false
+		for _, p := range presets {
+			if p.Name == preset {
+				form.Tags, form.Body, found = p.Tags, p.Body, true
+			}
+		}
+		if !found {
+			s.renderError(w, r, ErrPresetNotFound, nil)
+			return
+		}
+	}
+
+	// Fill in `?body=` and `?tags=`, e.g. from the bookmarklet.
+	if body := r.URL.Query().Get("body"); body != "" {
+		form.Body = body
+	}
+	if tags := r.URL.Query().Get("tags"); tags != "" {
+		form.Tags = tags
+	}
+
+	// Restore the draft of an unsaved Note, unless the form is filled in.
+	var restored *Draft
+	if preset == "" && form.Body == "" && form.Tags == "" {
+		draft, ok, err := s.loadDraft(r)
+		if err != nil {
+			s.renderError(w, r, ErrDatabase, err)
+			return
+		}
+		if ok {
+			form.Date, form.Time, form.Timezone = draft.Date, draft.Time, draft.Timezone
+			form.Body, form.Tags, form.Monospace = string(draft.Body), draft.Tags, draft.Monospace
+			restored = &draft
+		}
 	}
 
 	requestContext := NoteFormContext{
-		Form:   form,
-		URL:    r.URL.Path,
-		Action: "create",
+		Form:    form,
+		URL:     r.URL.Path,
+		Action:  "create",
+		Presets: presets,
+		Preset:  preset,
+		Draft:   restored,
+		Tags:    s.formTags(r, form.Tags),
 	}
 
-	s.Templates.ExecuteTemplate(w, "note-form", requestContext)
+	s.render(w, r, "note-form", requestContext)
 }
 
 // HandleNoteCreate performs the Note creation.
 func (s *Server) HandleNoteCreate(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Something went wrong: %v", err.Error()), http.StatusInternalServerError)
+		s.renderError(w, r, ErrBadRequest, err)
 		return
 	}
 
 	form := NoteForm{
-		Body: r.Form.Get("body"),
-		Date: r.Form.Get("date"),
-		Time: r.Form.Get("time"),
-		Tags: r.Form.Get("tags"),
+		Body:      r.Form.Get("body"),
+		Date:      r.Form.Get("date"),
+		Time:      r.Form.Get("time"),
+		Timezone:  r.Form.Get("timezone"),
+		Tags:      r.Form.Get("tags"),
+		Monospace: r.Form.Get("monospace") != "",
+		Draft:     r.Form.Get("draft") != "",
+		Scheduled: r.Form.Get("scheduled") != "",
+		Expires:   r.Form.Get("expires"),
 	}
 
 	if form.IsValid() {
-		note := Note{
-			Body: form.cleanedBody,
-			Date: form.cleanedDateTime,
+		if _, err := s.createNote(requestActor(r, "web"), &form); err != nil {
+			s.renderError(w, r, ErrDatabase, err)
+			return
 		}
-
-		// Create Note.
-		s.DB.Create(&note)
-
-		// Create Note tags.
-		if len(form.cleanedTags) > 0 {
-			s.DB.Model(&note).Association("Tags").Append(form.cleanedTags)
+		if err := s.discardDraft(r); err != nil {
+			log.Printf("[drafts] %v", err)
 		}
-
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
@@ -180,32 +795,46 @@ func (s *Server) HandleNoteCreate(w http.ResponseWriter, r *http.Request) {
 		Form:   form,
 		URL:    r.URL.Path,
 		Action: "create",
+		Tags:   s.formTags(r, form.Tags),
 	}
 
-	s.Templates.ExecuteTemplate(w, "note-form", requestContext)
+	s.render(w, r, "note-form", requestContext)
 }
 
-// HandleNoteUpdateForm serves the Note update form.
+// HandleNoteUpdateForm serves the Note update form. The Note is read
+// from the primary, like in the conflict check of HandleNoteUpdate, so
+// a lagging replica doesn't show a stale version.
+// With `?asof=<date>`, the Note is shown read-only, as it was on that date.
 func (s *Server) HandleNoteUpdateForm(w http.ResponseWriter, r *http.Request) {
 	noteID := chi.URLParam(r, "noteID")
+	asofParam := r.URL.Query().Get("asof")
 
 	note := Note{}
-	if err := s.DB.Preload("Tags").First(&note, noteID).Error; err != nil {
-		http.Error(w, fmt.Sprintf("note %v not found", noteID), http.StatusNotFound)
+	if asofParam != "" {
+		asof, err := parseAsOf(asofParam)
+		if err != nil {
+			s.renderError(w, r, ErrBadRequest, err)
+			return
+		}
+		if note, err = noteAsOf(s.ReadDB, noteID, asof); err != nil {
+			s.renderError(w, r, ErrNoteNotFound, err)
+			return
+		}
+	} else if err := s.DB.Preload("Tags").First(&note, noteID).Error; err != nil {
+		s.renderError(w, r, ErrNoteNotFound, err)
 		return
 	}
 
-	// Convert slice of tags into a list of comma-separated names.
-	tagNames := []string{}
-	for _, tag := range note.Tags {
-		tagNames = append(tagNames, tag.Name)
-	}
-
 	form := NoteForm{
-		Body: note.Body,
-		Date: note.Date.Format(NotePartialDateFormat),
-		Time: note.Date.Format(NotePartialTimeFormat),
-		Tags: strings.Join(tagNames, ", "),
+		Body:      string(note.Body),
+		Date:      note.Date.Format(NoteFormDateFormat),
+		Time:      note.Date.Format(NoteFormTimeFormat),
+		Timezone:  noteTimezone(),
+		Tags:      noteTagNames(note),
+		Monospace: note.Monospace,
+		Scheduled: note.Scheduled,
+		Expires:   NoteExpiresKeep,
+		UpdatedAt: noteVersion(note),
 	}
 
 	requestContext := NoteFormContext{
@@ -213,9 +842,20 @@ func (s *Server) HandleNoteUpdateForm(w http.ResponseWriter, r *http.Request) {
 		URL:    r.URL.Path,
 		Action: "update",
 		NoteID: note.ID,
+		Title:  note.DisplayTitle(),
+		AsOf:   asofParam,
+
+		Archived:    note.Archived,
+		Starred:     note.Starred,
+		Unpublished: note.Draft,
+		ExpiresAt:   note.Expiry(),
+		Tags:        s.formTags(r, form.Tags),
+	}
+	if err := s.ReadDB.Where("note_id = ?", note.ID).Order("id").Find(&requestContext.Shares).Error; err != nil {
+		logError(r, ErrDatabase, err)
 	}
 
-	s.Templates.ExecuteTemplate(w, "note-form", requestContext)
+	s.render(w, r, "note-form", requestContext)
 }
 
 // HandleNoteUpdate performs the Note update.
@@ -224,28 +864,53 @@ func (s *Server) HandleNoteUpdate(w http.ResponseWriter, r *http.Request) {
 
 	note := Note{}
 	if err := s.DB.Preload("Tags").First(&note, noteID).Error; err != nil {
-		http.Error(w, fmt.Sprintf("note %v not found", noteID), http.StatusNotFound)
+		s.renderError(w, r, ErrNoteNotFound, err)
 		return
 	}
 
 	err := r.ParseForm()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Something went wrong: %v", err.Error()), http.StatusInternalServerError)
+		s.renderError(w, r, ErrBadRequest, err)
+		return
 	}
 
 	form := NoteForm{
-		Body: r.Form.Get("body"),
-		Date: r.Form.Get("date"),
-		Time: r.Form.Get("time"),
-		Tags: r.Form.Get("tags"),
+		Body:      r.Form.Get("body"),
+		Date:      r.Form.Get("date"),
+		Time:      r.Form.Get("time"),
+		Timezone:  r.Form.Get("timezone"),
+		Tags:      r.Form.Get("tags"),
+		Monospace: r.Form.Get("monospace") != "",
+		Scheduled: r.Form.Get("scheduled") != "",
+		Expires:   r.Form.Get("expires"),
+		UpdatedAt: r.Form.Get("updated_at"),
 	}
 
-	if form.IsValid() {
-		s.DB.Model(&note).Updates(&Note{Body: form.cleanedBody, Date: form.cleanedDateTime})
-		s.DB.Model(&note).Association("Tags").Replace(form.cleanedTags)
-		removeStaleTags(s.DB)
+	// The Note was saved somewhere else since this form was opened.
+	// Show both versions, and let the user pick one.
+	if form.UpdatedAt != "" && form.UpdatedAt != noteVersion(note) {
+		form.UpdatedAt = noteVersion(note)
+		requestContext := NoteConflictContext{
+			Form:     form,
+			Note:     note,
+			URL:      r.URL.Path,
+			NoteID:   note.ID,
+			NoteTags: noteTagNames(note),
+			NoteDate: note.DisplayDate(),
+			NoteTime: note.DisplayTime(),
+		}
+		w.WriteHeader(http.StatusConflict)
+		s.render(w, r, "note-conflict", requestContext)
+		return
+	}
 
+	if form.IsValid() {
+		if err := s.updateNote(requestActor(r, "web"), &note, &form); err != nil {
+			s.renderError(w, r, ErrDatabase, err)
+			return
+		}
 		http.Redirect(w, r, "/", http.StatusFound)
+		return
 	}
 
 	requestContext := NoteFormContext{
@@ -253,43 +918,290 @@ func (s *Server) HandleNoteUpdate(w http.ResponseWriter, r *http.Request) {
 		URL:    r.URL.Path,
 		Action: "update",
 		NoteID: note.ID,
+		Tags:   s.formTags(r, form.Tags),
 	}
 
-	s.Templates.ExecuteTemplate(w, "note-form", requestContext)
+	s.render(w, r, "note-form", requestContext)
 }
 
 // HandleNoteDelete performs the Note deletion.
 func (s *Server) HandleNoteDelete(w http.ResponseWriter, r *http.Request) {
 	noteID := chi.URLParam(r, "noteID")
-	s.DB.Unscoped().Delete(&Note{}, noteID)
-	removeStaleTags(s.DB)
+	if err := s.deleteNote(requestActor(r, "web"), noteID); err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	if id, err := strconv.ParseUint(noteID, 10, 64); err == nil {
+		setFlash(w, Flash{Message: "Note deleted.", UndoNoteID: uint(id)})
+	}
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+// createNote creates a Note and its Tags from a valid NoteForm.
+// Everything is saved in a single transaction, with the audit entry.
+func (s *Server) createNote(actor Actor, form *NoteForm) (Note, error) {
+	note := Note{
+		Title:     EncryptedText(inferTitle(string(form.cleanedBody))),
+		Body:      form.cleanedBody,
+		Date:      form.cleanedDateTime,
+		Monospace: form.Monospace,
+		Draft:     form.Draft,
+		Scheduled: form.Scheduled,
+		ExpiresAt: form.cleanedExpires,
+	}
+	note.Words, note.Characters = countBody(string(form.cleanedBody))
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		// Create Note.
+		if err := tx.Create(&note).Error; err != nil {
+			return err
+		}
+
+		// Create Note tags, with the tags of matching rules.
+		auto, err := applyTagRules(tx, form, "")
+		if err != nil {
+			return err
+		}
+		if form.cleanedTags, err = findOrCreateTags(tx, form.cleanedTags); err != nil {
+			return err
+		}
+		if len(form.cleanedTags) > 0 {
+			if err := tx.Model(&note).Association("Tags").Append(form.cleanedTags); err != nil {
+				return err
+			}
+		}
+		if err := markAutoTags(tx, note.ID, form.cleanedTags, auto); err != nil {
+			return err
+		}
+		if err := saveRevision(tx, note.ID, form); err != nil {
+			return err
+		}
+		created := note
+		created.Tags = form.cleanedTags
+		return audit(tx, actor, AuditCreate, "note", note.ID, noteChanges(Note{}, created))
+	})
+	if err != nil {
+		return Note{}, err
+	}
+
+	s.Counts.Clear()
+	s.Events.Publish(newNoteEvent(NoteCreated, note))
+	return note, nil
+}
+
+// updateNote updates the Note and its Tags from a valid NoteForm.
+// Everything is saved in a single transaction, with the audit entry.
+func (s *Server) updateNote(actor Actor, note *Note, form *NoteForm) error {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		before := *note
+		updates := &Note{
+			Title:     EncryptedText(inferTitle(string(form.cleanedBody))),
+			Body:      form.cleanedBody,
+			Date:      form.cleanedDateTime,
+			Monospace: form.Monospace,
+			Draft:     before.Draft,
+			Scheduled: form.Scheduled,
+			ExpiresAt: before.ExpiresAt,
+		}
+		if form.expiryChanged() {
+			updates.ExpiresAt = form.cleanedExpires
+		}
+		updates.Words, updates.Characters = countBody(string(form.cleanedBody))
+		if err := tx.Model(note).Updates(updates).Error; err != nil {
+			return err
+		}
+		// Updates skips zero values, so the flags are set on their own.
+		flags := map[string]interface{}{"monospace": form.Monospace, "scheduled": form.Scheduled, "expires_at": updates.ExpiresAt}
+		if err := tx.Model(note).Updates(flags).Error; err != nil {
+			return err
+		}
+		auto, err := applyTagRules(tx, form, string(before.Body))
+		if err != nil {
+			return err
+		}
+		if form.cleanedTags, err = findOrCreateTags(tx, form.cleanedTags); err != nil {
+			return err
+		}
+		if err := tx.Model(note).Association("Tags").Replace(form.cleanedTags); err != nil {
+			return err
+		}
+		if err := markAutoTags(tx, note.ID, form.cleanedTags, auto); err != nil {
+			return err
+		}
+		if err := saveRevision(tx, note.ID, form); err != nil {
+			return err
+		}
+		after := *updates
+		after.Tags = form.cleanedTags
+		if err := audit(tx, actor, AuditUpdate, "note", note.ID, noteChanges(before, after)); err != nil {
+			return err
+		}
+		return s.cleanupTags(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.DB.Preload("Tags").First(note, note.ID)
+	s.Counts.Clear()
+	s.Events.Publish(newNoteEvent(NoteUpdated, *note))
+	return nil
+}
+
+// deleteNote deletes the Note, and any Tags that are no longer used.
+// Everything is deleted in a single transaction. The Note is soft deleted,
+// so that it still shows in the "as of" view of earlier dates.
+func (s *Server) deleteNote(actor Actor, noteID string) error {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		note := Note{}
+		if err := tx.Preload("Tags").First(&note, noteID).Error; err != nil {
+			// Already deleted.
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		if err := tx.Exec("delete from note_tag where note_id = ?", noteID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&Note{}, noteID).Error; err != nil {
+			return err
+		}
+		if err := audit(tx, actor, AuditDelete, "note", note.ID, noteChanges(note, Note{})); err != nil {
+			return err
+		}
+		return s.cleanupTags(tx)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.Counts.Clear()
+	id, _ := strconv.ParseUint(noteID, 10, 64)
+	s.Events.Publish(NoteEvent{Type: NoteDeleted, NoteID: uint(id)})
+	return nil
+}
+
 //
 // ------------------------------------------------------------------
 // Helper structs
 // ------------------------------------------------------------------
 //
 
+// IndexContext provides context data to the home page.
+type IndexContext struct {
+	Notes        []Note
+	Sort         NoteSort
+	Query        string
+	SearchError  string
+	AsOf         string // date of the historical snapshot, if any
+	AsOfError    string
+	Snippets     map[uint]template.HTML // search result snippets, by Note id
+	Flash        *Flash
+	Welcome      template.HTML          // shown above the note list
+	AutoTags     map[uint]map[uint]bool // ids of tags added by rules, by Note id
+	Previews     map[uint][]LinkPreview // link cards, by Note id
+	ReviewBefore time.Time              // Notes untouched since then are marked for review
+	Recalls      []Note                 // Notes tagged "remember" that are due to resurface
+	Timeline     bool                   // remote instances are set, link to the timeline
+}
+
+// NoteSort is the order of a Note list.
+type NoteSort struct {
+	Field string // date, created or updated
+	Dir   string // asc or desc
+}
+
+// noteSortColumns maps the NoteSort fields to their columns.
+var noteSortColumns = map[string]string{
+	"date":    "date",
+	"created": "created_at",
+	"updated": "updated_at",
+}
+
+// DefaultNoteSort is the order of Note lists. The Settings can pick
+// another for the index page.
+var DefaultNoteSort = NoteSort{Field: "date", Dir: "desc"}
+
+// IsValid checks if the field and direction are known.
+func (ns NoteSort) IsValid() bool {
+	_, ok := noteSortColumns[ns.Field]
+	return ok && (ns.Dir == "asc" || ns.Dir == "desc")
+}
+
+// OrderBy returns the sql order clause. The id breaks ties.
+func (ns NoteSort) OrderBy() string {
+	return fmt.Sprintf("%v %v, id %v", noteSortColumns[ns.Field], ns.Dir, ns.Dir)
+}
+
+// String returns the NoteSort as `<field>:<dir>`, e.g. `date:desc`.
+func (ns NoteSort) String() string {
+	return ns.Field + ":" + ns.Dir
+}
+
+// Toggle returns the sort by the given field. If it is the current
+// field, the direction is flipped.
+func (ns NoteSort) Toggle(field string) NoteSort {
+	if ns.Field != field {
+		return NoteSort{Field: field, Dir: "desc"}
+	}
+	if ns.Dir == "desc" {
+		return NoteSort{Field: field, Dir: "asc"}
+	}
+	return NoteSort{Field: field, Dir: "desc"}
+}
+
 // NoteFormContext provides context data to html templates.
 type NoteFormContext struct {
 	Form   NoteForm
 	URL    string
 	Action string
 	NoteID uint
+	Title  string
+	AsOf   string // date of the historical snapshot; the form is read-only
+	Shares []Share
+
+	Archived    bool
+	Starred     bool
+	Unpublished bool      // a draft Note
+	ExpiresAt   time.Time // the Note's expiry, if any, kept unless the form changes it
+
+	Presets []CapturePreset // create form only
+	Preset  string          // name of the selected preset
+	Draft   *Draft          // the restored draft of the create form
+
+	Tags []Tag // the existing tags of the form, shown as chips
+}
+
+// NoteConflictContext provides context data to the edit conflict page.
+// Form holds the user's version, and Note holds the saved version.
+type NoteConflictContext struct {
+	Form     NoteForm
+	Note     Note
+	URL      string
+	NoteID   uint
+	NoteTags string
+	NoteDate string
+	NoteTime string
 }
 
 // NoteForm validates and cleans data for Notes.
 type NoteForm struct {
-	Date            string
-	Time            string
+	Date            string // ISO 8601, or e.g. "March 4, 2021"
+	Time            string // ISO 8601, or e.g. "10:20 AM"
+	Timezone        string // of the date and time, empty means the Settings' Timezone
 	Body            string
 	Tags            string
+	Monospace       bool
+	Draft           bool   // save a new Note as a draft
+	Scheduled       bool   // hide the Note until its date
+	Expires         string // "never", a duration like "24h", or empty to keep the expiry
+	UpdatedAt       string // version of the Note when the form was opened
 	Errors          []string
 	cleanedDateTime time.Time
-	cleanedBody     string
+	cleanedBody     EncryptedText
 	cleanedTags     []Tag
+	cleanedExpires  *time.Time
 }
 
 // IsValid checks if the form is valid.
@@ -301,34 +1213,64 @@ func (form *NoteForm) IsValid() bool {
 // Validate performs the form validation.
 // Errors are collected and are available via the `.Errors` list.
 func (form *NoteForm) Validate() {
-	if form.Body == "" {
+	body, ok := normalizeText(form.Body)
+	if !ok {
+		form.Errors = append(form.Errors, "Body is not valid UTF-8")
+	}
+
+	if body == "" {
 		form.Errors = append(form.Errors, "Body cannot be blank")
 	}
 
-	if len(form.Body) > MaxBodyLength {
+	if utf8.RuneCountInString(body) > MaxBodyLength {
 		form.Errors = append(form.Errors, "Body is too large")
 	}
 
-	form.cleanedBody = strings.Trim(form.Body, " ")
-
-	d, err := time.Parse(NotePartialDateFormat, form.Date)
-	if err != nil {
-		form.Errors = append(form.Errors, "Invalid Date")
+	form.cleanedBody = EncryptedText(strings.Trim(body, " "))
+
+	switch form.Expires {
+	case "", NoteExpiresKeep, NoteExpiresNever:
+	default:
+		d, err := time.ParseDuration(form.Expires)
+		if err != nil || d <= 0 {
+			form.Errors = append(form.Errors, "Invalid Expiry")
+		} else {
+			expires := time.Now().Add(d)
+			form.cleanedExpires = &expires
+		}
 	}
 
-	timeStr := form.Time
-	if timeStr == "" {
-		timeStr = "12:00 AM"
-	}
-	t, err := time.Parse(NotePartialTimeFormat, timeStr)
-	if err != nil {
-		form.Errors = append(form.Errors, "Invalid Time")
+	d, t, zoned, errs := parseNoteDateTime(form.Date, form.Time)
+	form.Errors = append(form.Errors, errs...)
+	if len(errs) == 0 {
+		// The form shows the values in the formats of the inputs.
+		form.Date = d.Format(NoteFormDateFormat)
+		form.Time = t.Format(NoteFormTimeFormat)
+		if t.Second() != 0 {
+			form.Time = t.Format("15:04:05")
+		}
 	}
 
 	form.cleanedDateTime = time.Date(d.Year(), d.Month(), d.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
 
-	for _, tagName := range strings.Split(form.Tags, ",") {
-		cleanedName := strings.ToLower(strings.Trim(tagName, " "))
+	// Convert a time from another zone to the Notes' wall clock time.
+	if !zoned && form.Timezone != "" && form.Timezone != noteTimezone() {
+		loc, err := time.LoadLocation(form.Timezone)
+		if err != nil {
+			form.Errors = append(form.Errors, "Invalid Timezone")
+		} else {
+			wall := time.Date(d.Year(), d.Month(), d.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc).In(noteLocation())
+			form.cleanedDateTime = time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), time.UTC)
+		}
+	}
+
+	tags, ok := normalizeText(form.Tags)
+	if !ok {
+		form.Errors = append(form.Errors, "Tags are not valid UTF-8")
+	}
+
+	for _, tagName := range strings.Split(tags, ",") {
+		cleanedName := cleanTagName(tagName)
 		if cleanedName != "" {
 			form.cleanedTags = append(form.cleanedTags, Tag{Name: cleanedName})
 		}
@@ -341,21 +1283,538 @@ func (form *NoteForm) Validate() {
 // ------------------------------------------------------------------
 //
 
-// removeStaleTags deletes Tags that are not linked to Notes.
-func removeStaleTags(db *gorm.DB) {
+// normalizeText converts the text to NFC form, with `\n` line endings,
+// so the same characters are always stored the same way.
+// It reports false if the text is not valid UTF-8.
+func normalizeText(text string) (string, bool) {
+	if !utf8.ValidString(text) {
+		return strings.ToValidUTF8(text, "\uFFFD"), false
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return norm.NFC.String(text), true
+}
+
+// noteSort returns the NoteSort for the request.
+// The `sort` and `dir` query params take precedence, and are remembered
+// in a cookie. Otherwise, the remembered preference is used, or else
+// the sort of the Settings.
+func noteSort(w http.ResponseWriter, r *http.Request) NoteSort {
+	query := r.URL.Query()
+	preferred := currentSettings().Sort()
+	if query.Get("sort") != "" || query.Get("dir") != "" {
+		ns := NoteSort{Field: query.Get("sort"), Dir: query.Get("dir")}
+		if ns.Field == "" {
+			ns.Field = preferred.Field
+		}
+		if ns.Dir == "" {
+			ns.Dir = preferred.Dir
+		}
+		if ns.IsValid() {
+			http.SetCookie(w, &http.Cookie{
+				Name:     NoteSortCookie,
+				Value:    ns.String(),
+				Path:     "/",
+				MaxAge:   365 * 24 * 60 * 60,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			return ns
+		}
+	}
+
+	if cookie, err := r.Cookie(NoteSortCookie); err == nil {
+		parts := strings.SplitN(cookie.Value, ":", 2)
+		if len(parts) == 2 {
+			ns := NoteSort{Field: parts[0], Dir: parts[1]}
+			if ns.IsValid() {
+				return ns
+			}
+		}
+	}
+
+	return preferred
+}
+
+// removeStaleTags deletes Tags that are not linked to Notes. Tags with
+// a color or a description are kept, so they aren't lost when the last
+// Note is untagged.
+func removeStaleTags(db *gorm.DB) error {
 	staleTagIds := []int{}
-	db.Raw(`
+	err := db.Raw(`
 		select id
 		from tags
-		where id not in (
+		where color = '' and description = '' and id not in (
 			select distinct t.id
 			from tags t
 			inner join note_tag nt on nt.tag_id = t.id
 		);
-	`).Scan(&staleTagIds)
-	fmt.Printf("Stale Tag ids: %v\n", staleTagIds)
+	`).Scan(&staleTagIds).Error
+	if err != nil {
+		return err
+	}
 	if len(staleTagIds) > 0 {
-		db.Unscoped().Delete(&Tag{}, staleTagIds)
+		return db.Unscoped().Delete(&Tag{}, staleTagIds).Error
+	}
+	return nil
+}
+
+// noteVersion identifies the saved version of a Note.
+// It changes every time the Note is updated.
+func noteVersion(note Note) string {
+	return strconv.FormatInt(note.UpdatedAt.UnixNano(), 10)
+}
+
+// noteTagNames returns the Note's tags as a list of comma-separated names.
+func noteTagNames(note Note) string {
+	tagNames := []string{}
+	for _, tag := range note.Tags {
+		tagNames = append(tagNames, tag.Name)
+	}
+	return strings.Join(tagNames, ", ")
+}
+
+// inferTitle returns a title from the first line of the body, up to the
+// end of its first sentence. Long titles are shortened.
+func inferTitle(body string) string {
+	line := ""
+	for _, l := range strings.Split(body, "\n") {
+		if l = strings.TrimSpace(strings.TrimLeft(l, "#*->[] \t")); l != "" {
+			line = l
+			break
+		}
+	}
+
+	for _, end := range []string{". ", "! ", "? "} {
+		if i := strings.Index(line, end); i > 0 {
+			line = line[:i+1]
+		}
+	}
+
+	runes := []rune(line)
+	if len(runes) > MaxTitleLength {
+		return strings.TrimSpace(string(runes[:MaxTitleLength-1])) + "…"
+	}
+	return line
+}
+
+// countBody returns the amount of words and characters in the body.
+func countBody(body string) (words, characters int) {
+	return len(strings.Fields(body)), utf8.RuneCountInString(body)
+}
+
+// localNow returns the current time in the app's timezone.
+func localNow() time.Time {
+	return time.Now().In(noteLocation())
+}
+
+// noteLocation returns the location of the Settings' Timezone.
+func noteLocation() *time.Location {
+	loc, err := time.LoadLocation(noteTimezone())
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// parseNoteDateTime parses the date and time fields of a NoteForm, in
+// any of the NoteForm formats, or in words, see parseNaturalDate. The
+// date can have the time, when the time is blank, or be an RFC 3339 time
+// with an offset, which is converted to the Notes' time zone and reported
+// as zoned. A blank time is midnight.
+func parseNoteDateTime(date, clock string) (d, t time.Time, zoned bool, errs []string) {
+	date = strings.TrimSpace(date)
+	clock = strings.TrimSpace(clock)
+
+	if clock == "" {
+		if dt, err := time.Parse(time.RFC3339, date); err == nil {
+			dt = dt.In(noteLocation())
+			return dt, dt, true, nil
+		}
+		if dt, err := parseTimeFormats(date, NoteFormDateTimeFormats...); err == nil {
+			return dt, dt, false, nil
+		}
+		if dt, hasTime, ok := parseNaturalDate(date, localNow()); ok && hasTime {
+			return dt, dt, false, nil
+		}
+		clock = "00:00"
+	}
+
+	var err error
+	if d, err = parseTimeFormats(date, NoteFormDateFormats...); err != nil {
+		var ok bool
+		if d, _, ok = parseNaturalDate(date, localNow()); !ok {
+			errs = append(errs, "Invalid Date")
+		}
+	}
+	var ok bool
+	if t, ok = parseNaturalTime(clock); !ok {
+		errs = append(errs, "Invalid Time")
+	}
+	return d, t, false, errs
+}
+
+// parseTimeFormats parses the value with the first format that fits.
+func parseTimeFormats(value string, formats ...string) (time.Time, error) {
+	var err error
+	for _, format := range formats {
+		var t time.Time
+		if t, err = time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// openDB opens a sqlite database with the given DSN.
+// The connection pragmas from the Config are added to the DSN.
+func openDB(dsn string, cfg Config) (*gorm.DB, error) {
+	return gorm.Open(sqlite.Open(sqliteDSN(dsn, cfg)), &gorm.Config{
+		Logger: logger.New(log.New(SQLLogOutput, "\r\n", log.LstdFlags), logger.Config{
+			SlowThreshold: 200 * time.Millisecond,
+			LogLevel:      cfg.LogLevel,
+			Colorful:      false,
+		}),
+	})
+}
+
+// sqliteDSN adds the journal mode, busy timeout and foreign key pragmas to the DSN.
+func sqliteDSN(dsn string, cfg Config) string {
+	params := url.Values{}
+	if cfg.JournalMode != "" {
+		params.Set("_journal_mode", cfg.JournalMode)
+	}
+	if cfg.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.Itoa(cfg.BusyTimeout))
+	}
+	if cfg.ForeignKeys {
+		params.Set("_foreign_keys", "on")
+	}
+
+	if len(params) == 0 {
+		return dsn
+	}
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + params.Encode()
+	}
+	return dsn + "?" + params.Encode()
+}
+
+// getEnv returns the value of the environment variable, or the fallback if it is not set.
+func getEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt is like getEnv, for integer values.
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvBool is like getEnv, for boolean values.
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+//
+// ------------------------------------------------------------------
+// Config
+// ------------------------------------------------------------------
+//
+
+// Config holds the application settings, read from the environment.
+type Config struct {
+	Addr string
+
+	// DSN is the primary database. All writes go here.
+	DSN string
+
+	// ReadDSN is an optional read replica (e.g. a LiteFS or Litestream
+	// replica of the primary). When set, read-only pages are served from it.
+	ReadDSN string
+
+	// EncryptionSecret enables at-rest encryption of Note bodies when set.
+	EncryptionSecret string
+
+	// Sqlite connection pragmas. WAL mode and a busy timeout let
+	// concurrent requests wait for the write lock instead of failing
+	// with "database is locked".
+	JournalMode string
+	BusyTimeout int // milliseconds
+	ForeignKeys bool
+
+	// Welcome is Markdown shown above the note list, e.g. an intro for a
+	// shared instance. WelcomeNoteID shows the body of that Note instead.
+	Welcome       string
+	WelcomeNoteID int
+
+	// CodeTheme is the syntax highlighting theme of code blocks in
+	// Markdown, one of the chroma styles, e.g. "github" or "monokai".
+	CodeTheme string
+
+	// ReviewMonths is how long a Note can go untouched before it shows
+	// up in the review queue at /review. Zero turns the queue off.
+	ReviewMonths int
+
+	// TrashDays is how long deleted Notes are kept, for the history and
+	// the changes feed, before they are purged for good. Zero keeps them.
+	TrashDays int
+
+	// StaleTags is the policy for tags that no Note uses anymore:
+	// "delete", "keep" or "review".
+	StaleTags string
+
+	// HTMLPolicy sanitizes the html of Note bodies and Markdown:
+	// "ugc", "strict" or "off".
+	HTMLPolicy string
+
+	// Auth is the authentication backend: "password", "users",
+	// "oidc" or "header".
+	Auth string
+
+	// Password is the shared password of the "password" backend.
+	Password string
+
+	// SessionSecret signs the login sessions of the "users" and "oidc"
+	// backends. When empty, sessions end when the server restarts.
+	SessionSecret string
+
+	// The OpenID Connect provider and client of the "oidc" backend.
+	// OIDCAllowed lists the verified emails and email domains (e.g.
+	// @example.com) that can log in, besides the subjects linked to a
+	// username with the `oidclink` command.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCAllowed      string
+
+	// PasskeyOrigin is the public URL of the server, which passkeys are
+	// registered for. Defaults to http://Addr.
+	PasskeyOrigin string
+
+	// AuthHeader lists the username headers of the "header" backend,
+	// which are only trusted on requests from the TrustedProxies.
+	// AuthProvision creates accounts for new usernames.
+	AuthHeader     string
+	TrustedProxies string
+	AuthProvision  bool
+
+	// LoginAttempts is how many failed logins, per client IP and per
+	// username, lock the login for LoginLockout seconds. Each failure
+	// before that delays the next login, see LoginThrottle. Zero turns
+	// the throttling off.
+	LoginAttempts int
+	LoginLockout  int
+
+	// BaseURL is the public URL of the server, for links in emails.
+	// Defaults to http://Addr, or https://Addr with a TLSCert.
+	BaseURL string
+
+	// TLSCert and TLSKey are the files of the certificate to serve
+	// https with, instead of http.
+	TLSCert string
+	TLSKey  string
+
+	// The security headers of every response. An empty value omits
+	// the header. HSTSMaxAge is in seconds, and only used when TLS is
+	// on, see securityHeaders.
+	ContentSecurityPolicy string
+	FrameOptions          string
+	ReferrerPolicy        string
+	HSTSMaxAge            int
+
+	// Digest emails a summary of new Notes "daily" or "weekly" (on
+	// Mondays) at DigestHour, to DigestTo. Empty disables it.
+	Digest     string
+	DigestHour int
+	DigestTo   string
+
+	// The SMTP server for sending emails.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// MailgunSigningKey enables creating Notes by email, through a
+	// Mailgun route to /inbound/mailgun. InboundSenders optionally
+	// lists the addresses that are allowed to create Notes.
+	MailgunSigningKey string
+	InboundSenders    string
+
+	// TelegramToken enables the Telegram bot, which only answers
+	// the chat ids listed in TelegramChats.
+	TelegramToken string
+	TelegramChats string
+
+	// SlackSigningSecret enables a Slack slash command that creates
+	// Notes, with /integrations/slack as its request URL.
+	SlackSigningSecret string
+
+	// GitMirror is a directory where every change is committed to a git
+	// repository, with a Markdown file per Note. Commits are pushed to
+	// GitRemote, when set. The files are not encrypted. Notes created
+	// with an expiry are left out, but a Note given an expiry after it
+	// was created stays in the history, also once it is purged.
+	GitMirror string
+	GitRemote string
+
+	// SyncDir is a directory with a Markdown file per Note, that is
+	// synced both ways: files edited in other editors update their
+	// Notes. The files are not encrypted.
+	SyncDir string
+
+	// WebDAVPassword enables the WebDAV interface at /dav, which serves
+	// the Notes as Markdown files. Clients log in with this password.
+	WebDAVPassword string
+
+	// CalendarToken lets calendar apps subscribe to /calendar.ics?token=...
+	// without a login. Empty means the feed needs a login.
+	CalendarToken string
+
+	// APIToken lets integrations use the API with an
+	// `Authorization: Bearer ...` header, instead of a login session.
+	APIToken string
+
+	// Remotes lists other instances, whose Notes are shown next to the
+	// local ones at /timeline, see parseRemotes, with their
+	// FederationToken.
+	Remotes string
+
+	// FederationToken lets other instances list the shared Notes, and
+	// the ones tagged FederationTag, for their timeline. It is read-only,
+	// and can't read the other Notes.
+	FederationToken string
+	FederationTag   string
+
+	// LinkPreviews fetches the title, description and image of the links
+	// in Notes, shown as cards below them. Off by default, since the
+	// linked sites see the server's requests.
+	LinkPreviews bool
+
+	// Analytics counts the page views per route, shown on the admin
+	// page. The counts are only stored in the database.
+	Analytics bool
+
+	// LogLevel is the level of the SQL query log.
+	LogLevel logger.LogLevel
+}
+
+// baseURL returns the public URL of the server, without a trailing slash.
+func (cfg Config) baseURL() string {
+	if cfg.BaseURL != "" {
+		return strings.TrimSuffix(cfg.BaseURL, "/")
+	}
+	if cfg.TLSCert != "" {
+		return "https://" + cfg.Addr
+	}
+	return "http://" + cfg.Addr
+}
+
+// NewConfig reads the Config from environment variables.
+func NewConfig() Config {
+	return Config{
+		Addr:    getEnv("SIMPLENOTES_ADDR", "localhost:3000"),
+		DSN:     getEnv("SIMPLENOTES_DSN", "simplenotes.sqlite"),
+		ReadDSN: getEnv("SIMPLENOTES_READ_DSN", ""),
+
+		EncryptionSecret: getEnv("SIMPLENOTES_ENCRYPTION_SECRET", ""),
+
+		JournalMode: getEnv("SIMPLENOTES_JOURNAL_MODE", "WAL"),
+		BusyTimeout: getEnvInt("SIMPLENOTES_BUSY_TIMEOUT", 5000),
+		ForeignKeys: getEnvBool("SIMPLENOTES_FOREIGN_KEYS", true),
+
+		Welcome:       getEnv("SIMPLENOTES_WELCOME", ""),
+		WelcomeNoteID: getEnvInt("SIMPLENOTES_WELCOME_NOTE", 0),
+
+		CodeTheme: getEnv("SIMPLENOTES_CODE_THEME", DefaultCodeTheme),
+
+		ReviewMonths: getEnvInt("SIMPLENOTES_REVIEW_MONTHS", 6),
+
+		TrashDays: getEnvInt("SIMPLENOTES_TRASH_DAYS", 30),
+
+		StaleTags: getEnv("SIMPLENOTES_STALE_TAGS", StaleTagsDelete),
+
+		HTMLPolicy: getEnv("SIMPLENOTES_HTML_POLICY", HTMLPolicyUGC),
+
+		Auth:          getEnv("SIMPLENOTES_AUTH", AuthBackendPassword),
+		Password:      getEnv("SIMPLENOTES_PASSWORD", "super-secret"),
+		SessionSecret: getEnv("SIMPLENOTES_SESSION_SECRET", ""),
+
+		OIDCIssuer:       getEnv("SIMPLENOTES_OIDC_ISSUER", ""),
+		OIDCClientID:     getEnv("SIMPLENOTES_OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getEnv("SIMPLENOTES_OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:  getEnv("SIMPLENOTES_OIDC_REDIRECT_URL", ""),
+		OIDCAllowed:      getEnv("SIMPLENOTES_OIDC_ALLOWED", ""),
+
+		PasskeyOrigin: getEnv("SIMPLENOTES_PASSKEY_ORIGIN", ""),
+
+		AuthHeader:     getEnv("SIMPLENOTES_AUTH_HEADER", "Remote-User,X-Auth-Request-User"),
+		TrustedProxies: getEnv("SIMPLENOTES_TRUSTED_PROXIES", "127.0.0.1,::1"),
+		AuthProvision:  getEnvBool("SIMPLENOTES_AUTH_PROVISION", true),
+
+		LoginAttempts: getEnvInt("SIMPLENOTES_LOGIN_ATTEMPTS", DefaultLoginAttempts),
+		LoginLockout:  getEnvInt("SIMPLENOTES_LOGIN_LOCKOUT", DefaultLoginLockout),
+
+		BaseURL: getEnv("SIMPLENOTES_BASE_URL", ""),
+
+		TLSCert: getEnv("SIMPLENOTES_TLS_CERT", ""),
+		TLSKey:  getEnv("SIMPLENOTES_TLS_KEY", ""),
+
+		ContentSecurityPolicy: getEnv("SIMPLENOTES_CSP", DefaultContentSecurityPolicy),
+		FrameOptions:          getEnv("SIMPLENOTES_FRAME_OPTIONS", DefaultFrameOptions),
+		ReferrerPolicy:        getEnv("SIMPLENOTES_REFERRER_POLICY", DefaultReferrerPolicy),
+		HSTSMaxAge:            getEnvInt("SIMPLENOTES_HSTS_MAX_AGE", DefaultHSTSMaxAge),
+
+		Digest:     getEnv("SIMPLENOTES_DIGEST", ""),
+		DigestHour: getEnvInt("SIMPLENOTES_DIGEST_HOUR", 7),
+		DigestTo:   getEnv("SIMPLENOTES_DIGEST_TO", ""),
+
+		SMTPHost:     getEnv("SIMPLENOTES_SMTP_HOST", ""),
+		SMTPPort:     getEnvInt("SIMPLENOTES_SMTP_PORT", 587),
+		SMTPUsername: getEnv("SIMPLENOTES_SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SIMPLENOTES_SMTP_PASSWORD", ""),
+		SMTPFrom:     getEnv("SIMPLENOTES_SMTP_FROM", ""),
+
+		MailgunSigningKey: getEnv("SIMPLENOTES_MAILGUN_SIGNING_KEY", ""),
+		InboundSenders:    getEnv("SIMPLENOTES_INBOUND_SENDERS", ""),
+
+		TelegramToken: getEnv("SIMPLENOTES_TELEGRAM_TOKEN", ""),
+		TelegramChats: getEnv("SIMPLENOTES_TELEGRAM_CHATS", ""),
+
+		SlackSigningSecret: getEnv("SIMPLENOTES_SLACK_SIGNING_SECRET", ""),
+
+		GitMirror: getEnv("SIMPLENOTES_GIT_MIRROR", ""),
+		GitRemote: getEnv("SIMPLENOTES_GIT_REMOTE", ""),
+
+		SyncDir: getEnv("SIMPLENOTES_SYNC_DIR", ""),
+
+		WebDAVPassword: getEnv("SIMPLENOTES_WEBDAV_PASSWORD", ""),
+
+		CalendarToken: getEnv("SIMPLENOTES_CALENDAR_TOKEN", ""),
+
+		APIToken: getEnv("SIMPLENOTES_API_TOKEN", ""),
+
+		Remotes: getEnv("SIMPLENOTES_REMOTES", ""),
+
+		FederationToken: getEnv("SIMPLENOTES_FEDERATION_TOKEN", ""),
+		FederationTag:   getEnv("SIMPLENOTES_FEDERATION_TAG", "public"),
+
+		LinkPreviews: getEnvBool("SIMPLENOTES_LINK_PREVIEWS", false),
+
+		Analytics: getEnvBool("SIMPLENOTES_ANALYTICS", false),
+
+		LogLevel: logger.Info,
 	}
 }
 
@@ -366,25 +1825,98 @@ func removeStaleTags(db *gorm.DB) {
 //
 
 func main() {
-	// Init database.
-	db, err := gorm.Open(sqlite.Open("simplenotes.sqlite"), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-	})
+	log.SetOutput(LogOutput)
+	cfg := NewConfig()
 
+	if err := RunCLI(cfg, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "simplenotes: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// openServer opens the database(s) and creates the Server.
+// Pending migrations are applied first, and returned.
+func openServer(cfg Config) (*Server, []Migration, error) {
+	switch cfg.StaleTags {
+	case StaleTagsDelete, StaleTagsKeep, StaleTagsReview:
+	default:
+		return nil, nil, fmt.Errorf("invalid SIMPLENOTES_STALE_TAGS %q, use delete, keep or review", cfg.StaleTags)
+	}
+	policy, err := parseHTMLPolicy(cfg.HTMLPolicy)
+	if err != nil {
+		return nil, nil, err
+	}
+	htmlPolicy = policy
+	if _, err := newCodeHighlighter(cfg.CodeTheme); err != nil {
+		return nil, nil, err
+	}
+	if cfg.Digest != "" {
+		if _, err := digestPeriod(cfg.Digest); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Init database.
+	db, err := openDB(cfg.DSN, cfg)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
 	// Migrate the schema.
-	db.AutoMigrate(&Note{}, &Tag{})
+	migrations, err := MigrateUp(db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Init encryption, with the salt stored by the migrations.
+	if cfg.EncryptionSecret != "" {
+		if err := EnableEncryption(db, cfg.EncryptionSecret); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Count the Notes saved before the counts were stored.
+	if err := countNoteBodies(db); err != nil {
+		return nil, nil, err
+	}
+
+	// Load the settings, saved at /settings.
+	if err := loadSettings(db); err != nil {
+		return nil, nil, err
+	}
 
 	// Init server.
 	s := NewServer(db)
+	s.Config = cfg
 
-	// Start server.
-	addr := "localhost:3000"
-	fmt.Printf("Running server on %v...\n", addr)
-	http.ListenAndServe(addr, s.Routes())
+	// Init authentication.
+	auth, err := NewAuth(&s)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.Auth = auth
+
+	// Init usage analytics.
+	if cfg.Analytics {
+		s.Usage = NewUsageCounter(db)
+	}
+
+	// Init remote instances.
+	if s.Remotes, err = parseRemotes(cfg.Remotes); err != nil {
+		return nil, nil, err
+	}
+
+	// Init read replica. The replica is never migrated, it
+	// receives the schema from the primary.
+	if cfg.ReadDSN != "" {
+		readDB, err := openDB(cfg.ReadDSN, cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		s.ReadDB = readDB
+	}
+
+	return &s, migrations, nil
 }
 
 /*
@@ -393,12 +1925,163 @@ func main() {
 
 
 	* Run the server:
-		> go1.16beta1 run main.go
+		> go1.16beta1 run .
+
+	* Run the server with a read replica (e.g. a LiteFS mount):
+		> SIMPLENOTES_READ_DSN=/litefs/simplenotes.sqlite go1.16beta1 run .
+
+	* Keep unused tags, and flag them for review on the tags page:
+		> SIMPLENOTES_STALE_TAGS=review go1.16beta1 run .
+
+	* Show note bodies as plain text, without any html formatting:
+		> SIMPLENOTES_HTML_POLICY=strict go1.16beta1 run .
+
+	* List commands:
+		> go1.16beta1 run . help
+
+	* List notes, as JSON:
+		> go1.16beta1 run . list --tag idea --json | jq
+
+	* Encrypt note bodies at rest (and migrate existing plaintext notes):
+		> SIMPLENOTES_ENCRYPTION_SECRET=... go1.16beta1 run . encrypt
+
+	* Quickly capture notes from the terminal (queued while the server is offline):
+		> SIMPLENOTES_URL=http://localhost:3000 SIMPLENOTES_API_TOKEN=... go1.16beta1 run . capture
+
+	* Browse and edit notes in the terminal (locally, or against a remote instance):
+		> go1.16beta1 run . tui
+		> SIMPLENOTES_URL=https://notes.example.com SIMPLENOTES_PASSWORD=... go1.16beta1 run . tui
+
+	* Back up notes, and restore them into another database:
+		> go1.16beta1 run . export --out notes.json
+		> SIMPLENOTES_DSN=other.sqlite go1.16beta1 run . import notes.json
+
+	* Import an Evernote export (also on the Export and import page):
+		> go1.16beta1 run . import Notebook.enex
+
+	* Import Google Keep notes, from a Google Takeout archive:
+		> go1.16beta1 run . import takeout-20210304T102000Z-001.zip
+
+	* Import a Simplenote export, the zip file or its notes.json:
+		> go1.16beta1 run . import notes.zip
+
+	* Import a Notion workspace, exported as "Markdown & CSV":
+		> go1.16beta1 run . import Export-0123abcd.zip
+
+	* Create a user account:
+		> go1.16beta1 run . createuser alice
+
+	* Show an intro above the note list, from Markdown or from note 1:
+		> SIMPLENOTES_WELCOME="**Team notes.** Tag notes with your name." go1.16beta1 run .
+		> SIMPLENOTES_WELCOME_NOTE=1 go1.16beta1 run .
+
+	* Review notes at /review once they are untouched for a year, instead of 6 months:
+		> SIMPLENOTES_REVIEW_MONTHS=12 go1.16beta1 run .
+
+	* Show the notes of another instance next to these ones, at /timeline:
+		> SIMPLENOTES_REMOTES="work=https://TOKEN@notes.work.example.com" go1.16beta1 run .
+
+	* Let other instances show the shared notes, and the ones tagged public, with a read-only token:
+		> SIMPLENOTES_FEDERATION_TOKEN=... go1.16beta1 run .
+
+	* Highlight the code blocks in Markdown with another theme:
+		> SIMPLENOTES_CODE_THEME=monokai go1.16beta1 run .
+
+	* Log in with user accounts instead of the shared password:
+		> go1.16beta1 run . createuser alice
+		> SIMPLENOTES_AUTH=users SIMPLENOTES_SESSION_SECRET=change-me go1.16beta1 run .
+
+	* Also log in with passkeys, registered at /passkeys after logging in:
+		> SIMPLENOTES_AUTH=users SIMPLENOTES_PASSKEY_ORIGIN=https://notes.example.com go1.16beta1 run .
+
+	* Stay logged in for 30 days with "Remember me", and forget the remembered devices at /devices:
+		> SIMPLENOTES_AUTH=users SIMPLENOTES_SESSION_SECRET=change-me go1.16beta1 run .
+
+	* Log in with an OpenID Connect provider:
+		> SIMPLENOTES_AUTH=oidc \
+		  SIMPLENOTES_OIDC_ISSUER=https://accounts.example.com \
+		  SIMPLENOTES_OIDC_CLIENT_ID=simplenotes \
+		  SIMPLENOTES_OIDC_CLIENT_SECRET=... \
+		  SIMPLENOTES_OIDC_REDIRECT_URL=http://localhost:3000/login/callback \
+		  SIMPLENOTES_OIDC_ALLOWED=me@example.com,@example.org \
+		  go1.16beta1 run .
+
+	* Log in as alice with the OpenID Connect subject 248289761001:
+		> go1.16beta1 run . oidclink 248289761001 alice
+
+	* Trust the Remote-User header of an auth proxy (e.g. Authelia) on 10.0.0.5:
+		> SIMPLENOTES_AUTH=header SIMPLENOTES_TRUSTED_PROXIES=10.0.0.5 go1.16beta1 run .
+
+	* Email a daily digest of new notes at 7 AM:
+		> SIMPLENOTES_DIGEST=daily \
+		  SIMPLENOTES_DIGEST_TO=me@example.com \
+		  SIMPLENOTES_SMTP_HOST=smtp.example.com \
+		  SIMPLENOTES_SMTP_USERNAME=me@example.com \
+		  SIMPLENOTES_SMTP_PASSWORD=... \
+		  SIMPLENOTES_BASE_URL=https://notes.example.com \
+		  go1.16beta1 run .
+
+	* Send the digest now, to try the SMTP settings:
+		> go1.16beta1 run . digest
+
+	* Create notes by email, with a Mailgun route that forwards to /inbound/mailgun:
+		> SIMPLENOTES_MAILGUN_SIGNING_KEY=... SIMPLENOTES_INBOUND_SENDERS=me@example.com go1.16beta1 run .
+
+	* Save notes by messaging a Telegram bot (the bot replies with the chat id to allow):
+		> SIMPLENOTES_TELEGRAM_TOKEN=123456:ABC... SIMPLENOTES_TELEGRAM_CHATS=987654321 go1.16beta1 run .
+
+	* Create notes with a Slack slash command, e.g. /note "remember to ...":
+		> SIMPLENOTES_SLACK_SIGNING_SECRET=... SIMPLENOTES_BASE_URL=https://notes.example.com go1.16beta1 run .
+
+	* Count page views per route, shown on the admin page (stored locally only):
+		> SIMPLENOTES_ANALYTICS=true go1.16beta1 run .
+
+	* Commit every change to a git repository, and push it to a remote:
+		> SIMPLENOTES_GIT_MIRROR=./notes-git SIMPLENOTES_GIT_REMOTE=origin go1.16beta1 run .
+
+	* Sync the notes with a directory of Markdown files, to edit them in any editor:
+		> SIMPLENOTES_SYNC_DIR=~/notes go1.16beta1 run .
+
+	* Serve https, with a certificate, which also sends the HSTS header:
+		> SIMPLENOTES_TLS_CERT=cert.pem SIMPLENOTES_TLS_KEY=key.pem go1.16beta1 run .
+
+	* Allow images from any site and framing by the same site, or omit a header:
+		> SIMPLENOTES_CSP="default-src 'self' 'unsafe-inline'; img-src *" SIMPLENOTES_FRAME_OPTIONS=SAMEORIGIN go1.16beta1 run .
+		> SIMPLENOTES_REFERRER_POLICY= go1.16beta1 run .
+
+	* Lock the login for an hour after 3 failed logins, from an address or for a username:
+		> SIMPLENOTES_LOGIN_ATTEMPTS=3 SIMPLENOTES_LOGIN_LOCKOUT=3600 go1.16beta1 run .
+
+	* Mount the notes as Markdown files over WebDAV, at http://localhost:3000/dav/:
+		> SIMPLENOTES_WEBDAV_PASSWORD=... go1.16beta1 run .
+
+	* Subscribe to the notes in a calendar app, at http://localhost:3000/calendar.ics?token=...
+		> SIMPLENOTES_CALENDAR_TOKEN=$(openssl rand -hex 16) go1.16beta1 run .
+
+	* Show link cards below notes, with the title, description and image of the links:
+		> SIMPLENOTES_LINK_PREVIEWS=true go1.16beta1 run .
+
+	* Use the API with a token, e.g. from the Go client in ./client:
+		> SIMPLENOTES_API_TOKEN=$(openssl rand -hex 16) go1.16beta1 run .
+		> curl -H "Authorization: Bearer $SIMPLENOTES_API_TOKEN" http://localhost:3000/api/changes
+		> curl -H "Authorization: Bearer $SIMPLENOTES_API_TOKEN" http://localhost:3000/api/changes?since=42
+
+	* Save a note from the shell, #hashtags become tags:
+		> curl -H "Authorization: Bearer $SIMPLENOTES_API_TOKEN" -H "Content-Type: text/plain" \
+		  --data-binary "buy milk #errands" http://localhost:3000/quick
+
+	* Revert the latest schema migration:
+		> go1.16beta1 run . migrate down
+
+	* Install shell completions:
+		> simplenotes completion bash > /etc/bash_completion.d/simplenotes
+		> simplenotes completion zsh > "${fpath[1]}/_simplenotes"
+		> simplenotes completion fish > ~/.config/fish/completions/simplenotes.fish
 
 	* Build the application:
 		> go1.16beta1 build -ldflags="-s -w"
 
 	* Run the server and reload on file changes (requires entr):
-		> bash -c "find . -type f \( -name '*.go' -o -name '*.html' \) | grep -v 'misc' | entr -r go1.16beta1 run main.go server"
+		> bash -c "find . -type f \( -name '*.go' -o -name '*.html' \) | grep -v 'misc' | entr -r go1.16beta1 run . server"
 
 */
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MathMaxDepth is how deeply math may be nested, e.g. fractions in
+// fractions, before it is shown as text.
+const MathMaxDepth = 32
+
+//
+// ------------------------------------------------------------------
+// Math
+// ------------------------------------------------------------------
+//
+
+// renderNoteBody returns the html of a Note body, with its $...$ and
+// $$...$$ math rendered as MathML, which browsers display natively,
+// and its emoji :shortcodes: expanded.
+//
+// As in Pandoc, an opening $ can't be followed by a space, and a
+// closing $ can't follow a space or be followed by a digit, so prices
+// like "$5 and $10" stay text. A \$ is a literal dollar sign. Math that
+// can't be converted is shown as it was written.
+func renderNoteBody(body EncryptedText) template.HTML {
+	text := string(body)
+	b := &strings.Builder{}
+
+	for i := 0; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], `\$`):
+			b.WriteString("$")
+			i += 2
+			continue
+		case strings.HasPrefix(text[i:], "$$"):
+			if end := strings.Index(text[i+2:], "$$"); end > 0 {
+				source := text[i+2 : i+2+end]
+				if mathML, err := texToMathML(source, true); err == nil {
+					b.WriteString(mathML)
+					i += end + 4
+					continue
+				}
+			}
+			b.WriteString("$$")
+			i += 2
+			continue
+		case text[i] == '$':
+			if end := inlineMathEnd(text[i+1:]); end > 0 {
+				source := text[i+1 : i+1+end]
+				if mathML, err := texToMathML(source, false); err == nil {
+					b.WriteString(mathML)
+					i += end + 2
+					continue
+				}
+			}
+		}
+
+		// Copy the text up to the next dollar sign.
+		next := strings.IndexByte(text[i+1:], '$')
+		if next < 0 {
+			next = len(text)
+		} else {
+			next += i + 1
+			if text[next-1] == '\\' {
+				next--
+			}
+		}
+		b.WriteString(template.HTMLEscapeString(expandShortcodes(text[i:next])))
+		i = next
+	}
+	return sanitizeHTML(b.String())
+}
+
+// inlineMathEnd returns the index of the $ that closes the inline math
+// at the start of the text, or -1.
+func inlineMathEnd(text string) int {
+	if text == "" || text[0] == ' ' || text[0] == '\t' || text[0] == '\n' || text[0] == '$' {
+		return -1
+	}
+	for i := 1; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			return -1
+		case '\\':
+			i++
+		case '$':
+			prev := text[i-1]
+			if prev == ' ' || prev == '\t' {
+				continue
+			}
+			if i+1 < len(text) && text[i+1] >= '0' && text[i+1] <= '9' {
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// texToMathML converts TeX math to a MathML element. The TeX source
+// is kept as an annotation, so it is copied along with the math.
+func texToMathML(source string, display bool) (string, error) {
+	p := &texParser{src: source, display: display}
+	inner, err := p.parseRow("")
+	if err != nil {
+		return "", err
+	}
+	if p.pos < len(p.src) {
+		return "", p.errorf("unexpected }")
+	}
+
+	open := "<math>"
+	if display {
+		open = `<math display="block">`
+	}
+	return fmt.Sprintf(`%v<semantics><mrow>%v</mrow><annotation encoding="application/x-tex">%v</annotation></semantics></math>`,
+		open, inner, html.EscapeString(source)), nil
+}
+
+// texParser converts a subset of TeX math, about what notes need:
+// scripts, fractions, roots, accents, fonts, delimiters, Greek letters,
+// common symbols and functions, and matrix and cases environments.
+type texParser struct {
+	src     string
+	pos     int
+	depth   int
+	display bool // limits are only set above and below in display math
+}
+
+func (p *texParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("math at %v: %v", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips the spaces, which TeX ignores in math.
+func (p *texParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// peekCommand returns the command at the position, e.g. `\frac`, without
+// reading it, or "".
+func (p *texParser) peekCommand() string {
+	if p.pos >= len(p.src) || p.src[p.pos] != '\\' {
+		return ""
+	}
+	end := p.pos + 1
+	for end < len(p.src) && isASCIILetter(p.src[end]) {
+		end++
+	}
+	if end == p.pos+1 && end < len(p.src) {
+		end++ // a single symbol, e.g. `\{` or `\,`
+	}
+	return p.src[p.pos:end]
+}
+
+// parseRow parses atoms up to the end, a closing brace, or the stop
+// command, e.g. `\right` or `\end`, which is not read.
+func (p *texParser) parseRow(stop string) (string, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > MathMaxDepth {
+		return "", p.errorf("nested too deeply")
+	}
+
+	b := &strings.Builder{}
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) || p.src[p.pos] == '}' {
+			return b.String(), nil
+		}
+		if stop != "" && (p.peekCommand() == stop || p.peekCommand() == `\\` || p.src[p.pos] == '&') {
+			return b.String(), nil
+		}
+
+		atom, limits, err := p.parseAtom()
+		if err != nil {
+			return "", err
+		}
+		atom, err = p.parseScripts(atom, limits && p.display)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(atom)
+	}
+}
+
+// parseScripts adds the ^ and _ scripts that follow the atom. Limits
+// are placed above and below the atom instead, e.g. for \sum.
+func (p *texParser) parseScripts(base string, limits bool) (string, error) {
+	var sup, sub string
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.src) || (p.src[p.pos] != '^' && p.src[p.pos] != '_') {
+			break
+		}
+		mark := p.src[p.pos]
+		p.pos++
+		script, err := p.parseArgument()
+		if err != nil {
+			return "", err
+		}
+		if mark == '^' && sup == "" {
+			sup = script
+		} else if mark == '_' && sub == "" {
+			sub = script
+		} else {
+			return "", p.errorf("double %c", mark)
+		}
+	}
+
+	under, over, both := "msub", "msup", "msubsup"
+	if limits {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sup != "" && sub != "":
+		return fmt.Sprintf("<%v>%v%v%v</%v>", both, base, sub, sup, both), nil
+	case sup != "":
+		return fmt.Sprintf("<%v>%v%v</%v>", over, base, sup, over), nil
+	case sub != "":
+		return fmt.Sprintf("<%v>%v%v</%v>", under, base, sub, under), nil
+	}
+	return base, nil
+}
+
+// parseArgument parses a braced group or a single atom, e.g. the
+// arguments of \frac, or a script.
+func (p *texParser) parseArgument() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return "", p.errorf("missing argument")
+	}
+	if p.src[p.pos] == '{' {
+		return p.parseGroup()
+	}
+	atom, _, err := p.parseAtom()
+	return atom, err
+}
+
+// parseGroup parses a braced group into a single <mrow>.
+func (p *texParser) parseGroup() (string, error) {
+	p.pos++ // {
+	inner, err := p.parseRow("")
+	if err != nil {
+		return "", err
+	}
+	if p.pos >= len(p.src) {
+		return "", p.errorf("missing }")
+	}
+	p.pos++ // }
+	return "<mrow>" + inner + "</mrow>", nil
+}
+
+// parseTextArgument reads a braced group as text, e.g. of \text.
+func (p *texParser) parseTextArgument() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) || p.src[p.pos] != '{' {
+		return "", p.errorf("missing {")
+	}
+	end := strings.IndexByte(p.src[p.pos:], '}')
+	if end < 0 {
+		return "", p.errorf("missing }")
+	}
+	text := p.src[p.pos+1 : p.pos+end]
+	p.pos += end + 1
+	return text, nil
+}
+
+// parseAtom parses a single number, letter, symbol, group or command.
+// It reports whether the atom takes its scripts as limits.
+func (p *texParser) parseAtom() (string, bool, error) {
+	c := p.src[p.pos]
+	switch {
+	case c == '{':
+		group, err := p.parseGroup()
+		return group, false, err
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		return "<mn>" + p.src[start:p.pos] + "</mn>", false, nil
+	case isASCIILetter(c):
+		p.pos++
+		return "<mi>" + string(c) + "</mi>", false, nil
+	case c == '\\':
+		return p.parseCommand()
+	case c == '^' || c == '_':
+		return "", false, p.errorf("missing base of %c", c)
+	case c == '\'':
+		p.pos++
+		return "<mo>′</mo>", false, nil
+	case c == '#' || c == '%' || c == '&' || c == '~':
+		return "", false, p.errorf("unexpected %c", c)
+	}
+
+	r, size := utf8.DecodeRuneInString(p.src[p.pos:])
+	p.pos += size
+	if c < 0x80 && strings.ContainsRune("+-=<>()[]|/*,;:!?", r) {
+		if r == '-' {
+			r = '−'
+		}
+		return "<mo>" + html.EscapeString(string(r)) + "</mo>", false, nil
+	}
+	if unicode.IsLetter(r) {
+		return "<mi>" + html.EscapeString(string(r)) + "</mi>", false, nil
+	}
+	return "<mo>" + html.EscapeString(string(r)) + "</mo>", false, nil
+}
+
+// parseCommand parses a command and its arguments.
+func (p *texParser) parseCommand() (string, bool, error) {
+	name := p.peekCommand()
+	p.pos += len(name)
+	name = name[1:]
+
+	if s, ok := texIdentifiers[name]; ok {
+		return "<mi>" + s + "</mi>", false, nil
+	}
+	if s, ok := texOperators[name]; ok {
+		return "<mo>" + s + "</mo>", false, nil
+	}
+	if s, ok := texLargeOperators[name]; ok {
+		return "<mo>" + s + "</mo>", name != "int" && name != "iint" && name != "oint", nil
+	}
+	if texFunctions[name] {
+		return "<mi>" + name + "</mi>", name == "lim" || name == "max" || name == "min" || name == "sup" || name == "inf", nil
+	}
+	if width, ok := texSpaces[name]; ok {
+		return fmt.Sprintf(`<mspace width="%v"/>`, width), false, nil
+	}
+
+	switch name {
+	case "frac", "dfrac", "tfrac":
+		num, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return "<mfrac>" + num + den + "</mfrac>", false, nil
+
+	case "binom":
+		top, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		bottom, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return `<mrow><mo>(</mo><mfrac linethickness="0">` + top + bottom + `</mfrac><mo>)</mo></mrow>`, false, nil
+
+	case "sqrt":
+		p.skipSpace()
+		index := ""
+		if p.pos < len(p.src) && p.src[p.pos] == '[' {
+			end := strings.IndexByte(p.src[p.pos:], ']')
+			if end < 0 {
+				return "", false, p.errorf("missing ]")
+			}
+			indexParser := &texParser{src: p.src[p.pos+1 : p.pos+end], depth: p.depth}
+			inner, err := indexParser.parseRow("")
+			if err != nil || indexParser.pos < len(indexParser.src) {
+				return "", false, p.errorf("invalid root index")
+			}
+			index = "<mrow>" + inner + "</mrow>"
+			p.pos += end + 1
+		}
+		radicand, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		if index != "" {
+			return "<mroot>" + radicand + index + "</mroot>", false, nil
+		}
+		return "<msqrt>" + radicand + "</msqrt>", false, nil
+
+	case "text", "textrm", "mbox", "operatorname":
+		text, err := p.parseTextArgument()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "operatorname" {
+			return "<mi>" + html.EscapeString(text) + "</mi>", false, nil
+		}
+		return "<mtext>" + html.EscapeString(text) + "</mtext>", false, nil
+
+	case "left":
+		return p.parseDelimited()
+
+	case "begin":
+		return p.parseEnvironment()
+	}
+
+	if accent, ok := texAccents[name]; ok {
+		base, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		if name == "underline" {
+			return `<munder accentunder="true">` + base + "<mo>" + accent + "</mo></munder>", false, nil
+		}
+		return `<mover accent="true">` + base + "<mo>" + accent + "</mo></mover>", false, nil
+	}
+	if variant, ok := texFonts[name]; ok {
+		arg, err := p.parseArgument()
+		if err != nil {
+			return "", false, err
+		}
+		return fmt.Sprintf(`<mstyle mathvariant="%v">%v</mstyle>`, variant, arg), false, nil
+	}
+
+	return "", false, p.errorf(`unknown command \%v`, name)
+}
+
+// parseDelimited parses `\left( ... \right)`, with stretchy delimiters.
+func (p *texParser) parseDelimited() (string, bool, error) {
+	open, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	inner, err := p.parseRow(`\right`)
+	if err != nil {
+		return "", false, err
+	}
+	if p.peekCommand() != `\right` {
+		return "", false, p.errorf(`missing \right`)
+	}
+	p.pos += len(`\right`)
+	closing, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	return "<mrow>" + open + inner + closing + "</mrow>", false, nil
+}
+
+// parseDelimiter parses the delimiter of \left or \right, where a dot
+// is no delimiter.
+func (p *texParser) parseDelimiter() (string, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return "", p.errorf("missing delimiter")
+	}
+	if command := p.peekCommand(); command != "" {
+		p.pos += len(command)
+		if s, ok := texDelimiters[command[1:]]; ok {
+			return `<mo stretchy="true">` + s + "</mo>", nil
+		}
+		return "", p.errorf("invalid delimiter %v", command)
+	}
+
+	c := p.src[p.pos]
+	p.pos++
+	switch c {
+	case '.':
+		return "", nil
+	case '(', ')', '[', ']', '|', '/':
+		return `<mo stretchy="true">` + string(c) + "</mo>", nil
+	}
+	return "", p.errorf("invalid delimiter %c", c)
+}
+
+// parseEnvironment parses the cells of a matrix or cases environment,
+// separated by & and \\, into a table.
+func (p *texParser) parseEnvironment() (string, bool, error) {
+	name, err := p.parseTextArgument()
+	if err != nil {
+		return "", false, err
+	}
+	fences, ok := texEnvironments[name]
+	if !ok {
+		return "", false, p.errorf("unknown environment %v", name)
+	}
+
+	rows := []string{}
+	cells := []string{}
+	for {
+		cell, err := p.parseRow(`\end`)
+		if err != nil {
+			return "", false, err
+		}
+		cells = append(cells, "<mtd>"+cell+"</mtd>")
+
+		if p.pos >= len(p.src) || p.src[p.pos] == '}' {
+			return "", false, p.errorf(`missing \end{%v}`, name)
+		}
+		if p.src[p.pos] == '&' {
+			p.pos++
+			continue
+		}
+		rows = append(rows, "<mtr>"+strings.Join(cells, "")+"</mtr>")
+		cells = nil
+		if p.peekCommand() == `\\` {
+			p.pos += 2
+			continue
+		}
+
+		p.pos += len(`\end`)
+		end, err := p.parseTextArgument()
+		if err != nil {
+			return "", false, err
+		}
+		if end != name {
+			return "", false, p.errorf(`\begin{%v} ended by \end{%v}`, name, end)
+		}
+		break
+	}
+
+	table := "<mtable>" + strings.Join(rows, "") + "</mtable>"
+	if name == "cases" || name == "aligned" {
+		table = `<mtable columnalign="left">` + strings.Join(rows, "") + "</mtable>"
+	}
+	return "<mrow>" + fences[0] + table + fences[1] + "</mrow>", false, nil
+}
+
+func isASCIILetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// texIdentifiers are the commands of letters and constants.
+var texIdentifiers = map[string]string{
+	"alpha": "α", "beta": "β", "gamma": "γ", "delta": "δ", "epsilon": "ϵ", "varepsilon": "ε",
+	"zeta": "ζ", "eta": "η", "theta": "θ", "vartheta": "ϑ", "iota": "ι", "kappa": "κ",
+	"lambda": "λ", "mu": "μ", "nu": "ν", "xi": "ξ", "pi": "π", "varpi": "ϖ", "rho": "ρ",
+	"varrho": "ϱ", "sigma": "σ", "varsigma": "ς", "tau": "τ", "upsilon": "υ", "phi": "ϕ",
+	"varphi": "φ", "chi": "χ", "psi": "ψ", "omega": "ω",
+	"Gamma": "Γ", "Delta": "Δ", "Theta": "Θ", "Lambda": "Λ", "Xi": "Ξ", "Pi": "Π",
+	"Sigma": "Σ", "Upsilon": "Υ", "Phi": "Φ", "Psi": "Ψ", "Omega": "Ω",
+	"infty": "∞", "partial": "∂", "nabla": "∇", "hbar": "ℏ", "ell": "ℓ", "emptyset": "∅",
+	"aleph": "ℵ", "Re": "ℜ", "Im": "ℑ",
+}
+
+// texOperators are the commands of operators, relations and arrows.
+var texOperators = map[string]string{
+	"pm": "±", "mp": "∓", "times": "×", "div": "÷", "cdot": "⋅", "ast": "∗", "star": "⋆",
+	"circ": "∘", "bullet": "∙", "oplus": "⊕", "otimes": "⊗",
+	"leq": "≤", "le": "≤", "geq": "≥", "ge": "≥", "neq": "≠", "ne": "≠", "approx": "≈",
+	"equiv": "≡", "sim": "∼", "simeq": "≃", "cong": "≅", "propto": "∝", "ll": "≪", "gg": "≫",
+	"in": "∈", "notin": "∉", "ni": "∋", "subset": "⊂", "subseteq": "⊆", "supset": "⊃",
+	"supseteq": "⊇", "cup": "∪", "cap": "∩", "setminus": "∖", "wedge": "∧", "land": "∧",
+	"vee": "∨", "lor": "∨", "neg": "¬", "lnot": "¬", "forall": "∀", "exists": "∃",
+	"to": "→", "rightarrow": "→", "leftarrow": "←", "leftrightarrow": "↔", "gets": "←",
+	"Rightarrow": "⇒", "Leftarrow": "⇐", "Leftrightarrow": "⇔", "implies": "⟹", "iff": "⟺",
+	"mapsto": "↦", "uparrow": "↑", "downarrow": "↓",
+	"ldots": "…", "cdots": "⋯", "vdots": "⋮", "ddots": "⋱", "dots": "…",
+	"mid": "∣", "parallel": "∥", "perp": "⊥", "angle": "∠", "prime": "′", "degree": "°",
+	"{": "{", "}": "}", "|": "‖", "lbrace": "{", "rbrace": "}", "langle": "⟨", "rangle": "⟩",
+	"lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉", "%": "%", "#": "#",
+	"&": "&amp;", "_": "_", "$": "$",
+}
+
+// texLargeOperators are the operators whose scripts are limits,
+// except for integrals.
+var texLargeOperators = map[string]string{
+	"sum": "∑", "prod": "∏", "coprod": "∐", "int": "∫", "iint": "∬", "oint": "∮",
+	"bigcup": "⋃", "bigcap": "⋂", "bigoplus": "⨁", "bigotimes": "⨂",
+}
+
+// texFunctions are the named functions, set upright.
+var texFunctions = map[string]bool{
+	"sin": true, "cos": true, "tan": true, "cot": true, "sec": true, "csc": true,
+	"arcsin": true, "arccos": true, "arctan": true, "sinh": true, "cosh": true, "tanh": true,
+	"log": true, "ln": true, "lg": true, "exp": true, "lim": true, "max": true, "min": true,
+	"sup": true, "inf": true, "det": true, "dim": true, "ker": true, "deg": true, "gcd": true,
+	"arg": true, "mod": true, "Pr": true,
+}
+
+// texSpaces are the spacing commands, by width.
+var texSpaces = map[string]string{
+	",": "0.167em", ":": "0.222em", ">": "0.222em", ";": "0.278em", " ": "0.333em",
+	"quad": "1em", "qquad": "2em", "!": "-0.167em",
+}
+
+// texAccents are the accents, set over the argument, or under it for
+// \underline.
+var texAccents = map[string]string{
+	"hat": "^", "widehat": "^", "bar": "¯", "overline": "¯", "underline": "_", "vec": "→",
+	"dot": "˙", "ddot": "¨", "tilde": "~", "widetilde": "~",
+}
+
+// texFonts are the font commands, by mathvariant.
+var texFonts = map[string]string{
+	"mathbb": "double-struck", "mathbf": "bold", "boldsymbol": "bold-italic", "mathit": "italic",
+	"mathrm": "normal", "mathcal": "script", "mathfrak": "fraktur", "mathsf": "sans-serif",
+	"mathtt": "monospace",
+}
+
+// texDelimiters are the delimiter commands of \left and \right.
+var texDelimiters = map[string]string{
+	"{": "{", "}": "}", "lbrace": "{", "rbrace": "}", "langle": "⟨", "rangle": "⟩",
+	"|": "‖", "vert": "|", "Vert": "‖", "lfloor": "⌊", "rfloor": "⌋", "lceil": "⌈", "rceil": "⌉",
+}
+
+// texEnvironments are the table environments, with their fences.
+var texEnvironments = map[string][2]string{
+	"matrix":  {"", ""},
+	"pmatrix": {"<mo>(</mo>", "<mo>)</mo>"},
+	"bmatrix": {"<mo>[</mo>", "<mo>]</mo>"},
+	"Bmatrix": {"<mo>{</mo>", "<mo>}</mo>"},
+	"vmatrix": {"<mo>|</mo>", "<mo>|</mo>"},
+	"Vmatrix": {"<mo>‖</mo>", "<mo>‖</mo>"},
+	"cases":   {"<mo>{</mo>", ""},
+	"aligned": {"", ""},
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MigrationFiles holds the versioned schema migrations.
+// Files are named `<version>_<name>.up.sql` and `<version>_<name>.down.sql`.
+//
+//go:embed migrations/*.sql
+var MigrationFiles embed.FS
+
+//
+// ------------------------------------------------------------------
+// Migrations
+// ------------------------------------------------------------------
+//
+
+// Migration is a single versioned schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaMigration is the model for the `schema_migrations` table.
+// It records which Migrations have been applied.
+type SchemaMigration struct {
+	Version   int `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
+
+// loadMigrations reads the embedded migration files, sorted by version.
+func loadMigrations() ([]Migration, error) {
+	files, err := MigrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		filename := file.Name()
+
+		parts := strings.SplitN(filename, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration filename: %v", filename)
+		}
+
+		content, err := MigrationFiles.ReadFile(path.Join("migrations", filename))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+
+		switch {
+		case strings.HasSuffix(filename, ".up.sql"):
+			m.Name = strings.TrimSuffix(parts[1], ".up.sql")
+			m.Up = string(content)
+		case strings.HasSuffix(filename, ".down.sql"):
+			m.Down = string(content)
+		default:
+			return nil, fmt.Errorf("invalid migration filename: %v", filename)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %v has no up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// appliedVersions returns the set of applied migration versions.
+func appliedVersions(db *gorm.DB) (map[int]bool, error) {
+	err := db.Exec(`
+		create table if not exists schema_migrations (
+			version integer primary key,
+			applied_at datetime
+		);
+	`).Error
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []SchemaMigration{}
+	if err := db.Find(&applied).Error; err != nil {
+		return nil, err
+	}
+
+	versions := map[int]bool{}
+	for _, m := range applied {
+		versions[m.Version] = true
+	}
+	return versions, nil
+}
+
+// String returns the migration's filename prefix, e.g. `0001_initial`.
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%v", m.Version, m.Name)
+}
+
+// MigrateUp applies all pending migrations, in order, and returns them.
+// Each migration runs in its own transaction.
+func MigrateUp(db *gorm.DB) ([]Migration, error) {
+	done := []Migration{}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return done, err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return done, err
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(m.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %v: %v", m, err)
+		}
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// MigrateDown reverts the latest `steps` applied migrations, and returns them.
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	done := []Migration{}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return done, err
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return done, err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if !applied[m.Version] {
+			continue
+		}
+		if m.Down == "" {
+			return done, fmt.Errorf("migration %v cannot be reverted", m)
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(m.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %v: %v", m, err)
+		}
+		done = append(done, m)
+		steps--
+	}
+
+	return done, nil
+}
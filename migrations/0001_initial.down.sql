@@ -0,0 +1,3 @@
+drop table if exists `note_tag`;
+drop table if exists `tags`;
+drop table if exists `notes`;
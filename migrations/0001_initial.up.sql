@@ -0,0 +1,31 @@
+-- The initial schema, as created by the gorm models.
+-- Uses `if not exists` so databases created by AutoMigrate are adopted as-is.
+
+create table if not exists `notes` (
+    `id` integer,
+    `created_at` datetime,
+    `updated_at` datetime,
+    `deleted_at` datetime,
+    `body` text,
+    `date` datetime,
+    primary key (`id`)
+);
+create index if not exists `idx_notes_deleted_at` on `notes`(`deleted_at`);
+
+create table if not exists `tags` (
+    `id` integer,
+    `created_at` datetime,
+    `updated_at` datetime,
+    `deleted_at` datetime,
+    `name` text,
+    primary key (`id`)
+);
+create index if not exists `idx_tags_deleted_at` on `tags`(`deleted_at`);
+
+create table if not exists `note_tag` (
+    `note_id` integer,
+    `tag_id` integer,
+    primary key (`note_id`, `tag_id`),
+    constraint `fk_note_tag_note` foreign key (`note_id`) references `notes`(`id`),
+    constraint `fk_note_tag_tag` foreign key (`tag_id`) references `tags`(`id`)
+);
@@ -0,0 +1,2 @@
+-- The home page lists notes by date.
+create index if not exists `idx_notes_date` on `notes`(`date`);
@@ -0,0 +1,27 @@
+-- Every version of a note, for the "as of" view.
+create table if not exists `note_revisions` (
+    `id` integer,
+    `note_id` integer,
+    `body` text,
+    `date` datetime,
+    `tags` text,
+    `created_at` datetime,
+    primary key (`id`)
+);
+create index if not exists `idx_note_revisions_note_id` on `note_revisions`(`note_id`, `created_at`);
+
+-- Existing notes start with their current version.
+insert into `note_revisions` (`note_id`, `body`, `date`, `tags`, `created_at`)
+select
+    n.`id`,
+    n.`body`,
+    n.`date`,
+    coalesce((
+        select group_concat(t.`name`, ', ')
+        from `note_tag` nt
+        inner join `tags` t on t.`id` = nt.`tag_id`
+        where nt.`note_id` = n.`id`
+    ), ''),
+    n.`updated_at`
+from `notes` n
+where n.`deleted_at` is null;
@@ -0,0 +1,8 @@
+-- Searches pinned to the nav.
+create table if not exists `saved_searches` (
+    `id` integer,
+    `name` text,
+    `query` text,
+    `created_at` datetime,
+    primary key (`id`)
+);
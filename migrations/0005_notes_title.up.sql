@@ -0,0 +1,3 @@
+-- The display title, inferred from the body when a note is saved.
+-- Notes saved before this have no title, and infer it when displayed.
+alter table `notes` add column `title` text;
@@ -0,0 +1,12 @@
+-- User accounts, created with the `createuser` command.
+create table if not exists `users` (
+    `id` integer,
+    `created_at` datetime,
+    `updated_at` datetime,
+    `deleted_at` datetime,
+    `username` text not null,
+    `password_hash` text not null,
+    primary key (`id`)
+);
+create unique index if not exists `idx_users_username` on `users`(`username`);
+create index if not exists `idx_users_deleted_at` on `users`(`deleted_at`);
@@ -0,0 +1,14 @@
+-- The audit log of every change, for the activity page.
+create table if not exists `audit_entries` (
+    `id` integer,
+    `created_at` datetime,
+    `user` text,
+    `source` text,
+    `request_id` text,
+    `action` text,
+    `entity` text,
+    `entity_id` integer,
+    `changes` text,
+    primary key (`id`)
+);
+create index if not exists `idx_audit_entries_entity` on `audit_entries`(`entity`, `entity_id`);
@@ -0,0 +1,17 @@
+-- Passkeys (WebAuthn credentials) of user accounts.
+create table if not exists `passkey_credentials` (
+    `id` integer,
+    `created_at` datetime,
+    `user_id` integer not null,
+    `name` text,
+    `credential_id` blob not null,
+    `public_key` blob not null,
+    `attestation_type` text,
+    `aaguid` blob,
+    `sign_count` integer,
+    `last_used_at` datetime,
+    primary key (`id`),
+    constraint `fk_passkey_credentials_user` foreign key (`user_id`) references `users`(`id`) on delete cascade
+);
+create unique index if not exists `idx_passkey_credentials_credential_id` on `passkey_credentials`(`credential_id`);
+create index if not exists `idx_passkey_credentials_user_id` on `passkey_credentials`(`user_id`);
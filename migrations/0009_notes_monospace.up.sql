@@ -0,0 +1,2 @@
+-- Show the note in a monospace font, with its whitespace preserved.
+alter table `notes` add column `monospace` numeric not null default false;
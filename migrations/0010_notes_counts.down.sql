@@ -0,0 +1,2 @@
+alter table `notes` drop column `words`;
+alter table `notes` drop column `characters`;
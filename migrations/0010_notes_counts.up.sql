@@ -0,0 +1,4 @@
+-- Word and character counts of the body, stored when a note is saved.
+-- Existing notes are counted on startup, they start as null.
+alter table `notes` add column `words` integer;
+alter table `notes` add column `characters` integer;
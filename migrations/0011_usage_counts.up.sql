@@ -0,0 +1,7 @@
+-- Page views per route and day, for the opt-in usage analytics.
+create table if not exists `usage_counts` (
+    `day` text,
+    `route` text,
+    `count` integer not null default 0,
+    primary key (`day`, `route`)
+);
@@ -0,0 +1,3 @@
+drop table if exists `tag_rules`;
+
+alter table `note_tag` drop column `auto`;
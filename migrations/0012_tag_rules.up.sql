@@ -0,0 +1,11 @@
+-- Rules that tag Notes whose body matches a pattern, e.g. gym|run -> fitness.
+create table if not exists `tag_rules` (
+    `id` integer,
+    `created_at` datetime,
+    `pattern` text,
+    `tag` text,
+    primary key (`id`)
+);
+
+-- Tags added by a rule, shown with a badge until they are removed or edited.
+alter table `note_tag` add column `auto` numeric not null default false;
@@ -0,0 +1,12 @@
+-- Public links to a single Note, optionally expiring and watermarked.
+create table if not exists `shares` (
+    `id` integer,
+    `created_at` datetime,
+    `note_id` integer,
+    `token` text not null,
+    `expires_at` datetime,
+    `watermark` numeric not null default false,
+    primary key (`id`),
+    constraint `fk_shares_note` foreign key (`note_id`) references `notes`(`id`) on delete cascade
+);
+create unique index if not exists `idx_shares_token` on `shares`(`token`);
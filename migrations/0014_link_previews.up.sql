@@ -0,0 +1,10 @@
+-- Title, description and image of the URLs in Note bodies, shown as link cards.
+create table if not exists `link_previews` (
+    `url` text,
+    `title` text,
+    `description` text,
+    `image` text,
+    `fetched_at` datetime,
+    `error` text,
+    primary key (`url`)
+);
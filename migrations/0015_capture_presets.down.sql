@@ -0,0 +1 @@
+drop table if exists `capture_presets`;
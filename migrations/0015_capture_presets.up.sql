@@ -0,0 +1,11 @@
+-- Templates for new Notes, e.g. a "meeting" preset with its tags and an agenda.
+create table if not exists `capture_presets` (
+    `id` integer,
+    `created_at` datetime,
+    `username` text not null default '',
+    `name` text not null,
+    `tags` text not null default '',
+    `body` text not null default '',
+    primary key (`id`)
+);
+create unique index if not exists `idx_capture_presets_name` on `capture_presets`(`username`, `name`);
@@ -0,0 +1,2 @@
+alter table `notes` drop column `archived`;
+alter table `notes` drop column `reviewed_at`;
@@ -0,0 +1,4 @@
+-- Archived notes are hidden from the note list and searches, unless
+-- searching is:archived. Reviewed notes leave the review queue.
+alter table `notes` add column `archived` numeric not null default false;
+alter table `notes` add column `reviewed_at` datetime;
@@ -0,0 +1,9 @@
+-- When Notes tagged "remember" are resurfaced next, and at which interval.
+create table if not exists `recalls` (
+    `note_id` integer,
+    `step` integer not null default 0,
+    `due_at` datetime not null,
+    primary key (`note_id`),
+    constraint `fk_recalls_note` foreign key (`note_id`) references `notes`(`id`) on delete cascade
+);
+create index if not exists `idx_recalls_due_at` on `recalls`(`due_at`);
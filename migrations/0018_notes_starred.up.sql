@@ -0,0 +1,2 @@
+-- Starred notes are collected at /starred, e.g. reference notes.
+alter table `notes` add column `starred` numeric not null default false;
@@ -0,0 +1,2 @@
+-- The "on this day" page finds notes by the month and day of their date.
+create index if not exists `idx_notes_month_day` on `notes`(strftime('%m-%d', `date`));
@@ -0,0 +1,2 @@
+-- Tags can have a color, e.g. #2563eb, for their chips.
+alter table `tags` add column `color` text not null default '';
@@ -0,0 +1 @@
+alter table `tags` drop column `description`;
@@ -0,0 +1,2 @@
+-- Tags can describe what they are for, on their about page.
+alter table `tags` add column `description` text not null default '';
@@ -0,0 +1 @@
+drop table if exists `remembered_devices`;
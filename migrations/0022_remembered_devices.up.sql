@@ -0,0 +1,13 @@
+-- Remembered devices of user accounts, which stay logged in.
+create table if not exists `remembered_devices` (
+    `id` integer,
+    `created_at` datetime,
+    `user_id` integer not null,
+    `name` text,
+    `token_hash` text not null,
+    `last_used_at` datetime,
+    `expires_at` datetime,
+    primary key (`id`),
+    constraint `fk_remembered_devices_user` foreign key (`user_id`) references `users`(`id`) on delete cascade
+);
+create index if not exists `idx_remembered_devices_user_id` on `remembered_devices`(`user_id`);
@@ -0,0 +1,11 @@
+drop trigger if exists `notes_change_seq_insert`;
+drop trigger if exists `notes_change_seq_update`;
+drop trigger if exists `notes_change_seq_delete`;
+drop trigger if exists `note_tag_change_seq_insert`;
+drop trigger if exists `note_tag_change_seq_delete`;
+drop trigger if exists `tags_change_seq_rename`;
+drop table if exists `note_tombstones`;
+drop table if exists `change_seq`;
+
+drop index if exists `idx_notes_change_seq`;
+alter table `notes` drop column `change_seq`;
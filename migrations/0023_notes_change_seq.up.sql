@@ -0,0 +1,64 @@
+-- Every change to a Note gets the next change sequence number, for the
+-- changes feed of the sync API. The triggers number all changes, also
+-- those that don't touch `updated_at`, e.g. starring, archiving, tag
+-- renames and imports. Soft deleted Notes keep their number, and hard
+-- deleted Notes leave a tombstone with theirs.
+create table if not exists `change_seq` (
+    `value` integer not null
+);
+insert into `change_seq` (`value`) select coalesce(max(`id`), 0) from `notes`;
+
+alter table `notes` add column `change_seq` integer not null default 0;
+update `notes` set `change_seq` = `id`;
+create index if not exists `idx_notes_change_seq` on `notes`(`change_seq`);
+
+create table if not exists `note_tombstones` (
+    `note_id` integer,
+    `deleted_at` datetime,
+    `change_seq` integer not null,
+    primary key (`note_id`)
+);
+create index if not exists `idx_note_tombstones_change_seq` on `note_tombstones`(`change_seq`);
+
+create trigger if not exists `notes_change_seq_insert` after insert on `notes`
+begin
+    update `change_seq` set `value` = `value` + 1;
+    update `notes` set `change_seq` = (select `value` from `change_seq`) where `id` = new.`id`;
+    delete from `note_tombstones` where `note_id` = new.`id`;
+end;
+
+create trigger if not exists `notes_change_seq_update` after update on `notes`
+when new.`change_seq` = old.`change_seq`
+begin
+    update `change_seq` set `value` = `value` + 1;
+    update `notes` set `change_seq` = (select `value` from `change_seq`) where `id` = new.`id`;
+end;
+
+create trigger if not exists `notes_change_seq_delete` after delete on `notes`
+begin
+    update `change_seq` set `value` = `value` + 1;
+    insert or replace into `note_tombstones` (`note_id`, `deleted_at`, `change_seq`)
+    values (old.`id`, strftime('%Y-%m-%d %H:%M:%f', 'now'), (select `value` from `change_seq`));
+end;
+
+-- Tags are part of the Note, so adding, removing and renaming them
+-- changes it too.
+create trigger if not exists `note_tag_change_seq_insert` after insert on `note_tag`
+begin
+    update `change_seq` set `value` = `value` + 1;
+    update `notes` set `change_seq` = (select `value` from `change_seq`) where `id` = new.`note_id`;
+end;
+
+create trigger if not exists `note_tag_change_seq_delete` after delete on `note_tag`
+begin
+    update `change_seq` set `value` = `value` + 1;
+    update `notes` set `change_seq` = (select `value` from `change_seq`) where `id` = old.`note_id`;
+end;
+
+create trigger if not exists `tags_change_seq_rename` after update of `name` on `tags`
+when new.`name` is not old.`name`
+begin
+    update `change_seq` set `value` = `value` + 1;
+    update `notes` set `change_seq` = (select `value` from `change_seq`)
+    where `id` in (select `note_id` from `note_tag` where `tag_id` = new.`id`);
+end;
@@ -0,0 +1,12 @@
+-- The settings, changed at /settings. The table has a single row, once
+-- they are saved, until then the defaults are used.
+create table if not exists `settings` (
+    `id` integer,
+    `updated_at` datetime,
+    `timezone` text not null,
+    `page_size` integer not null,
+    `note_sort` text not null,
+    `theme` text not null,
+    `date_format` text not null,
+    primary key (`id`)
+);
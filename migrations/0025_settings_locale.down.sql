@@ -0,0 +1 @@
+alter table `settings` drop column `locale`;
@@ -0,0 +1,2 @@
+-- The language of the pages and the dates, see Locales.
+alter table `settings` add column `locale` text not null default 'en';
@@ -0,0 +1,15 @@
+-- Drafts of the create form, autosaved while a note is written.
+create table if not exists `drafts` (
+    `id` integer,
+    `updated_at` datetime,
+    `key_hash` text not null,
+    `username` text not null default '',
+    `date` text,
+    `time` text,
+    `timezone` text,
+    `body` text,
+    `tags` text,
+    `monospace` numeric not null default false,
+    primary key (`id`)
+);
+create unique index if not exists `idx_drafts_key_hash` on `drafts`(`key_hash`);
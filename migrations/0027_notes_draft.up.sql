@@ -0,0 +1,3 @@
+-- Draft notes are hidden from the note list, searches and feeds, unless
+-- searching is:draft, until they are published.
+alter table `notes` add column `draft` numeric not null default false;
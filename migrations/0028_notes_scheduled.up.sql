@@ -0,0 +1,3 @@
+-- Scheduled notes are hidden from the note list, searches and feeds,
+-- unless searching is:scheduled, until their date has passed.
+alter table `notes` add column `scheduled` numeric not null default false;
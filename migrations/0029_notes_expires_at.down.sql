@@ -0,0 +1,2 @@
+drop index if exists `idx_notes_expires_at`;
+alter table `notes` drop column `expires_at`;
@@ -0,0 +1,4 @@
+-- Notes that expire are deleted for good once they expire, with their
+-- tags, revisions, shares and recalls.
+alter table `notes` add column `expires_at` datetime;
+create index if not exists `idx_notes_expires_at` on `notes`(`expires_at`);
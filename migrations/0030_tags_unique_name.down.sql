@@ -0,0 +1,2 @@
+-- The merged tag rows are not split again.
+drop index if exists `idx_tags_name`;
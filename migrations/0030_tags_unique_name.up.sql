@@ -0,0 +1,32 @@
+-- Tags were created once per Note, so the same name had a row for
+-- every Note. The rows of a name are merged into the oldest one, which
+-- keeps the first color and description that was set.
+create temp table `tag_merges` as
+select t.`id` as `from_id`, k.`id` as `to_id`
+from `tags` t
+inner join (select `name`, min(`id`) as `id` from `tags` group by `name`) k on k.`name` = t.`name`
+where t.`id` != k.`id`;
+
+update `tags` set
+    `color` = coalesce((
+        select c.`color` from `tags` c
+        where c.`name` = `tags`.`name` and c.`color` != ''
+        order by c.`id` limit 1
+    ), ''),
+    `description` = coalesce((
+        select d.`description` from `tags` d
+        where d.`name` = `tags`.`name` and d.`description` != ''
+        order by d.`id` limit 1
+    ), '')
+where `id` in (select `to_id` from `tag_merges`);
+
+insert or ignore into `note_tag` (`note_id`, `tag_id`, `auto`)
+select nt.`note_id`, m.`to_id`, nt.`auto`
+from `note_tag` nt
+inner join `tag_merges` m on m.`from_id` = nt.`tag_id`;
+
+delete from `note_tag` where `tag_id` in (select `from_id` from `tag_merges`);
+delete from `tags` where `id` in (select `from_id` from `tag_merges`);
+drop table `tag_merges`;
+
+create unique index if not exists `idx_tags_name` on `tags`(`name`);
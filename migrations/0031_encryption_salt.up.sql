@@ -0,0 +1,7 @@
+-- The random salt of the key that encrypts Note bodies at rest. It is
+-- created on first use, see EnableEncryption.
+create table if not exists `encryption_salt` (
+    `id` integer,
+    `salt` blob not null,
+    primary key (`id`)
+);
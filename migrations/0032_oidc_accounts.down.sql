@@ -0,0 +1 @@
+drop table if exists `oidc_accounts`;
@@ -0,0 +1,8 @@
+-- The accounts of OpenID Connect logins, by the provider's subject.
+-- They are linked on the first login, or with the `oidclink` command.
+create table if not exists `oidc_accounts` (
+    `subject` text not null,
+    `created_at` datetime,
+    `username` text not null,
+    primary key (`subject`)
+);
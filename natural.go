@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// ------------------------------------------------------------------
+// Natural language dates
+// ------------------------------------------------------------------
+//
+
+// parseNaturalDate parses a date relative to now, for quick capture,
+// e.g. "yesterday 3pm", "last friday", "2 days ago at 9:30" or "noon".
+// It returns the date, with the time of day if the text has one, and
+// false if the text isn't understood.
+//
+// The dates understood are "now", "today", "yesterday", "tomorrow",
+// weekdays (the latest one, or "last" and "next" ones), and amounts of
+// days, weeks, months or years "ago" or "in" the future. A bare time is
+// today.
+func parseNaturalDate(text string, now time.Time) (date time.Time, hasTime bool, ok bool) {
+	words := strings.Fields(strings.ToLower(strings.ReplaceAll(text, ",", " ")))
+
+	// The time is at the end, e.g. "3pm", "3:30 pm" or "at noon".
+	var clock time.Time
+	for n := 2; n > 0 && !hasTime; n-- {
+		if len(words) >= n {
+			if clock, hasTime = parseNaturalTime(strings.Join(words[len(words)-n:], " ")); hasTime {
+				words = words[:len(words)-n]
+			}
+		}
+	}
+	if hasTime && len(words) > 0 && words[len(words)-1] == "at" {
+		words = words[:len(words)-1]
+	}
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch {
+	case len(words) == 0 && hasTime:
+		date = today
+	case len(words) == 1 && words[0] == "now" && !hasTime:
+		return now.Truncate(time.Second), true, true
+	case len(words) == 1 && words[0] == "today":
+		date = today
+	case len(words) == 1 && words[0] == "yesterday":
+		date = today.AddDate(0, 0, -1)
+	case len(words) == 1 && words[0] == "tomorrow":
+		date = today.AddDate(0, 0, 1)
+	default:
+		if date, ok = parseNaturalWeekday(words, today); !ok {
+			if date, ok = parseNaturalAmount(words, today); !ok {
+				return time.Time{}, false, false
+			}
+		}
+	}
+
+	if hasTime {
+		date = time.Date(date.Year(), date.Month(), date.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, date.Location())
+	}
+	return date, hasTime, true
+}
+
+// parseNaturalTime parses a time of day in any of the NoteForm formats,
+// in any case, or "noon" or "midnight".
+func parseNaturalTime(text string) (time.Time, bool) {
+	switch strings.ToLower(text) {
+	case "noon":
+		return time.Date(0, 1, 1, 12, 0, 0, 0, time.UTC), true
+	case "midnight":
+		return time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC), true
+	}
+	t, err := parseTimeFormats(strings.ToUpper(text), NoteFormTimeFormats...)
+	return t, err == nil
+}
+
+// parseNaturalWeekday parses e.g. "friday", which is the latest one
+// including today, or "last friday" and "next friday", which are before
+// and after today.
+func parseNaturalWeekday(words []string, today time.Time) (time.Time, bool) {
+	which := ""
+	if len(words) == 2 {
+		which, words = words[0], words[1:]
+	}
+	if len(words) != 1 {
+		return time.Time{}, false
+	}
+	day := -1
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if words[0] == name || words[0] == name[:3] {
+			day = int(d)
+		}
+	}
+	if day < 0 {
+		return time.Time{}, false
+	}
+
+	back := (int(today.Weekday()) - day + 7) % 7 // days since the latest one
+	switch which {
+	case "", "this":
+		return today.AddDate(0, 0, -back), true
+	case "last":
+		if back == 0 {
+			back = 7
+		}
+		return today.AddDate(0, 0, -back), true
+	case "next":
+		return today.AddDate(0, 0, 7-back), true
+	}
+	return time.Time{}, false
+}
+
+// parseNaturalAmount parses e.g. "3 days ago", "a week ago", "in 2 weeks",
+// "last month" or "next year".
+func parseNaturalAmount(words []string, today time.Time) (time.Time, bool) {
+	sign := 0
+	switch {
+	case len(words) == 2 && words[0] == "last":
+		sign, words = -1, []string{"1", words[1]}
+	case len(words) == 2 && words[0] == "next":
+		sign, words = 1, []string{"1", words[1]}
+	case len(words) == 3 && words[2] == "ago":
+		sign, words = -1, words[:2]
+	case len(words) == 3 && words[0] == "in":
+		sign, words = 1, words[1:]
+	default:
+		return time.Time{}, false
+	}
+
+	n, err := strconv.Atoi(words[0])
+	if words[0] == "a" || words[0] == "an" {
+		n, err = 1, nil
+	}
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	n *= sign
+	switch strings.TrimSuffix(words[1], "s") {
+	case "day":
+		return today.AddDate(0, 0, n), true
+	case "week":
+		return today.AddDate(0, 0, 7*n), true
+	case "month":
+		return today.AddDate(0, n, 0), true
+	case "year":
+		return today.AddDate(n, 0, 0), true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// APIVersion is the version of the JSON API, as shown in the OpenAPI spec.
+const APIVersion = "1.0.0"
+
+//
+// ------------------------------------------------------------------
+// OpenAPI
+// ------------------------------------------------------------------
+//
+
+// object is a JSON object in the OpenAPI spec.
+type object = map[string]interface{}
+
+// HandleAPISpec serves the OpenAPI spec of the JSON API.
+func (s *Server) HandleAPISpec(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPISpec(s.Config.Auth))
+}
+
+// HandleAPIDocs serves the Swagger UI page for exploring the JSON API.
+func (s *Server) HandleAPIDocs(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "api-docs", nil)
+}
+
+// openAPISpec returns the OpenAPI 3 document for the JSON API.
+// The schemas are generated from the request and response types,
+// so they stay in sync with the handlers.
+func openAPISpec(auth string) object {
+	noteID := object{
+		"name":        "noteID",
+		"in":          "path",
+		"required":    true,
+		"description": "The note id.",
+		"schema":      object{"type": "integer"},
+	}
+
+	// The password backend keeps its login in the authsolo cookie.
+	cookie := SessionCookie
+	if auth == AuthBackendPassword {
+		cookie = "user"
+	}
+
+	sortFields := []string{}
+	for field := range noteSortColumns {
+		sortFields = append(sortFields, field)
+	}
+	sort.Strings(sortFields)
+
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":       "Simple Notes API",
+			"version":     APIVersion,
+			"description": "Read and write notes. Log in at /login first; the session cookie authenticates API requests. Integrations can send the SIMPLENOTES_API_TOKEN as a bearer token instead, and other instances the read-only SIMPLENOTES_FEDERATION_TOKEN, which only lists the shared and public notes.",
+		},
+		"servers":  []object{{"url": "/api"}},
+		"security": []object{{"session": []string{}}, {"token": []string{}}},
+		"paths": object{
+			"/notes": object{
+				"get": object{
+					"summary": "List notes",
+					"parameters": []object{
+						queryParam("limit", "Max number of notes, up to 500.", object{"type": "integer", "default": 30}),
+						queryParam("sort", "The field to order by. Remembered for later requests.", object{"type": "string", "enum": sortFields}),
+						queryParam("dir", "The order direction. Remembered for later requests.", object{"type": "string", "enum": []string{"asc", "desc"}}),
+						queryParam("q", `Search, e.g. groceries tag:home after:2021-01-01 "oat milk"`, object{"type": "string"}),
+						queryParam("tag", "Only notes with the tag, or its nested tags. none selects the notes without tags.", object{"type": "string"}),
+						queryParam("after", "The X-Next-Cursor of the previous page. The sort of the first page is kept.", object{"type": "string"}),
+					},
+					"responses": object{
+						"200": withHeader(
+							jsonResponse("The notes.", object{"type": "array", "items": schemaRef("Note")}),
+							"X-Next-Cursor", "The `after` of the next page. Missing when the page is not full.",
+						),
+						"400": errorResponse("The search or cursor is not valid."),
+					},
+				},
+				"post": object{
+					"summary":     "Create a note",
+					"requestBody": jsonRequest(schemaRef("NoteInput")),
+					"responses": object{
+						"201": jsonResponse("The created note.", schemaRef("Note")),
+						"400": errorResponse("The note is not valid."),
+					},
+				},
+			},
+			"/notes/{noteID}": object{
+				"parameters": []object{noteID},
+				"get": object{
+					"summary": "Get a note",
+					"responses": object{
+						"200": jsonResponse("The note.", schemaRef("Note")),
+						"404": errorResponse("The note does not exist."),
+					},
+				},
+				"put": object{
+					"summary":     "Update a note",
+					"description": "Set `updated_at` to the version of the note that was edited, to reject the update if the note was changed since.",
+					"requestBody": jsonRequest(schemaRef("NoteInput")),
+					"responses": object{
+						"200": jsonResponse("The updated note.", schemaRef("Note")),
+						"400": errorResponse("The note is not valid."),
+						"404": errorResponse("The note does not exist."),
+						"409": errorResponse("The note was changed since it was read."),
+					},
+				},
+				"delete": object{
+					"summary": "Delete a note",
+					"responses": object{
+						"204": object{"description": "The note was deleted."},
+					},
+				},
+			},
+			"/tags": object{
+				"get": object{
+					"summary": "List tags",
+					"responses": object{
+						"200": jsonResponse("The tags, with their amount of notes.", object{"type": "array", "items": schemaRef("Tag")}),
+					},
+				},
+			},
+			"/tagcloud": object{
+				"get": object{
+					"summary": "Get the tag cloud",
+					"responses": object{
+						"200": jsonResponse("The tags in use, weighted by their amount of notes.", object{"type": "array", "items": schemaRef("TagCloudEntry")}),
+					},
+				},
+			},
+			"/changes": object{
+				"get": object{
+					"summary":     "List changed notes",
+					"description": "Returns the notes that were changed or deleted since a sequence number or a time, to keep a copy of the notes in sync. Pass `seq` (or `until`) as `since` in the next request. Sequence numbers also include notes that were starred, archived or had a tag renamed.",
+					"parameters": []object{
+						queryParam("since", "A sequence number, or an RFC 3339 time. Empty returns all notes.", object{"type": "string"}),
+					},
+					"responses": object{
+						"200": jsonResponse("The changes.", schemaRef("Changes")),
+						"400": errorResponse("The sequence number or time is not valid."),
+					},
+				},
+			},
+			"/stats": object{
+				"get": object{
+					"summary": "Get writing stats",
+					"responses": object{
+						"200": jsonResponse("Totals and averages of the notes.", schemaRef("Stats")),
+					},
+				},
+			},
+		},
+		"components": object{
+			"schemas": object{
+				"Note":          jsonSchema(reflect.TypeOf(NoteJSON{})),
+				"NoteInput":     jsonSchema(reflect.TypeOf(NoteInput{})),
+				"Tag":           jsonSchema(reflect.TypeOf(TagJSON{})),
+				"TagCloudEntry": jsonSchema(reflect.TypeOf(TagCloudEntry{})),
+				"Changes":       jsonSchema(reflect.TypeOf(ChangesJSON{})),
+				"Stats":         jsonSchema(reflect.TypeOf(StatsJSON{})),
+				"APIError":      jsonSchema(reflect.TypeOf(APIError{})),
+			},
+			"securitySchemes": object{
+				"session": object{"type": "apiKey", "in": "cookie", "name": cookie},
+				"token":   object{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+func queryParam(name, description string, schema object) object {
+	return object{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+func schemaRef(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonRequest(schema object) object {
+	return object{"required": true, "content": object{"application/json": object{"schema": schema}}}
+}
+
+func jsonResponse(description string, schema object) object {
+	return object{"description": description, "content": object{"application/json": object{"schema": schema}}}
+}
+
+func withHeader(response object, name, description string) object {
+	response["headers"] = object{name: object{"description": description, "schema": object{"type": "string"}}}
+	return response
+}
+
+func errorResponse(description string) object {
+	return jsonResponse(description, schemaRef("APIError"))
+}
+
+// jsonSchema returns the schema of the Go type, as encoded by encoding/json.
+func jsonSchema(t reflect.Type) object {
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := jsonSchema(t.Elem())
+		schema["nullable"] = true
+		return schema
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return object{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		if t == reflect.TypeOf(time.Time{}) {
+			return object{"type": "string", "format": "date-time"}
+		}
+		properties := object{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if field.PkgPath != "" || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = jsonSchema(field.Type)
+		}
+		return object{"type": "object", "properties": properties}
+	default:
+		return object{}
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/duo-labs/webauthn/protocol"
+	"github.com/duo-labs/webauthn/webauthn"
+	"gorm.io/gorm"
+)
+
+// passkeyCeremonyCookie holds the challenge of a passkey registration
+// or login, between its begin and finish requests.
+const passkeyCeremonyCookie = "passkey_ceremony"
+
+//
+// ------------------------------------------------------------------
+// Passkeys
+// ------------------------------------------------------------------
+//
+
+// PasskeyCredential is the model for the `passkey_credentials` table.
+type PasskeyCredential struct {
+	ID              uint `gorm:"primarykey"`
+	CreatedAt       time.Time
+	UserID          uint
+	Name            string
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte `gorm:"column:aaguid"`
+	SignCount       uint32
+	LastUsedAt      *time.Time
+}
+
+// passkeyUser is a User with its passkeys. It implements webauthn.User.
+type passkeyUser struct {
+	User
+	credentials []PasskeyCredential
+}
+
+// WebAuthnID implements webauthn.User.
+func (u passkeyUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatUint(uint64(u.ID), 10))
+}
+
+// WebAuthnName implements webauthn.User.
+func (u passkeyUser) WebAuthnName() string {
+	return u.Username
+}
+
+// WebAuthnDisplayName implements webauthn.User.
+func (u passkeyUser) WebAuthnDisplayName() string {
+	return u.Username
+}
+
+// WebAuthnIcon implements webauthn.User.
+func (u passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+// WebAuthnCredentials implements webauthn.User.
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := []webauthn.Credential{}
+	for _, c := range u.credentials {
+		credentials = append(credentials, webauthn.Credential{
+			ID:              c.CredentialID,
+			PublicKey:       c.PublicKey,
+			AttestationType: c.AttestationType,
+			Authenticator:   webauthn.Authenticator{AAGUID: c.AAGUID, SignCount: c.SignCount},
+		})
+	}
+	return credentials
+}
+
+// Passkeys adds passkey login to the users backend. Users register
+// passkeys on the passkeys page after logging in with their password,
+// which keeps working as a fallback.
+type Passkeys struct {
+	sessions
+	s        *Server
+	devices  *RememberedDevices
+	webAuthn *webauthn.WebAuthn
+}
+
+// NewPasskeys ...
+func NewPasskeys(s *Server, ss sessions, devices *RememberedDevices) (*Passkeys, error) {
+	origin := s.Config.PasskeyOrigin
+	if origin == "" {
+		origin = "http://" + s.Config.Addr
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid SIMPLENOTES_PASSKEY_ORIGIN %q", origin)
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPDisplayName: "Simple Notes",
+		RPID:          u.Hostname(),
+		RPOrigin:      origin,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Passkeys{ss, s, devices, w}, nil
+}
+
+// loadUser returns the User with its passkeys.
+func (p *Passkeys) loadUser(query interface{}, args ...interface{}) (passkeyUser, error) {
+	user := passkeyUser{}
+	if err := p.s.DB.Where(query, args...).First(&user.User).Error; err != nil {
+		return user, err
+	}
+	err := p.s.DB.Where("user_id = ?", user.ID).Order("id").Find(&user.credentials).Error
+	return user, err
+}
+
+// setCeremony keeps the ceremony data in a signed cookie.
+func (p *Passkeys) setCeremony(w http.ResponseWriter, data *webauthn.SessionData) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	setCookie(w, passkeyCeremonyCookie, payload+"."+p.sign(payload), 5*time.Minute)
+	return nil
+}
+
+// ceremony reads the ceremony data, and clears the cookie.
+func (p *Passkeys) ceremony(w http.ResponseWriter, r *http.Request) (webauthn.SessionData, error) {
+	data := webauthn.SessionData{}
+	c, err := r.Cookie(passkeyCeremonyCookie)
+	if err != nil {
+		return data, errors.New("no passkey ceremony in progress")
+	}
+	setCookie(w, passkeyCeremonyCookie, "", -1)
+
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 2 || parts[1] != p.sign(parts[0]) {
+		return data, errors.New("invalid passkey ceremony")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return data, err
+	}
+	return data, json.Unmarshal(b, &data)
+}
+
+// PasskeysContext provides context data to the passkeys page.
+type PasskeysContext struct {
+	Username string
+	Passkeys []PasskeyCredential
+}
+
+// HandleList serves the passkeys page of the logged in User.
+func (p *Passkeys) HandleList(w http.ResponseWriter, r *http.Request) {
+	user, err := p.loadUser("username = ?", currentUser(r))
+	if err != nil {
+		p.s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	requestContext := PasskeysContext{
+		Username: user.Username,
+		Passkeys: user.credentials,
+	}
+
+	p.s.render(w, r, "passkeys", requestContext)
+}
+
+// HandleRegisterBegin starts the registration of a passkey.
+func (p *Passkeys) HandleRegisterBegin(w http.ResponseWriter, r *http.Request) {
+	user, err := p.loadUser("username = ?", currentUser(r))
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+
+	// Don't register the same authenticator twice.
+	exclude := []protocol.CredentialDescriptor{}
+	for _, c := range user.WebAuthnCredentials() {
+		exclude = append(exclude, protocol.CredentialDescriptor{Type: protocol.PublicKeyCredentialType, CredentialID: c.ID})
+	}
+
+	options, data, err := p.webAuthn.BeginRegistration(user, webauthn.WithExclusions(exclude))
+	if err == nil {
+		err = p.setCeremony(w, data)
+	}
+	if err != nil {
+		writeAPIError(w, r, ErrInternal, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, options)
+}
+
+// HandleRegisterFinish verifies and saves a new passkey.
+func (p *Passkeys) HandleRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	user, err := p.loadUser("username = ?", currentUser(r))
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+	data, err := p.ceremony(w, r)
+	if err != nil {
+		writeAPIError(w, r, ErrBadRequest, err)
+		return
+	}
+	credential, err := p.webAuthn.FinishRegistration(user, data, r)
+	if err != nil {
+		writeAPIError(w, r, ErrBadRequest, err, "The passkey could not be verified.")
+		return
+	}
+
+	passkey := PasskeyCredential{
+		UserID:          user.ID,
+		Name:            strings.TrimSpace(r.URL.Query().Get("name")),
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+	}
+	if passkey.Name == "" {
+		passkey.Name = fmt.Sprintf("Passkey %v", len(user.credentials)+1)
+	}
+
+	err = p.s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&passkey).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("name: \"\" → %q", passkey.Name)}
+		return audit(tx, requestActor(r, "web"), AuditCreate, "passkey", passkey.ID, changes)
+	})
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"redirect": "/passkeys"})
+}
+
+// HandleDelete removes a passkey of the logged in User.
+func (p *Passkeys) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	user, err := p.loadUser("username = ?", currentUser(r))
+	if err != nil {
+		p.s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	err = p.s.DB.Transaction(func(tx *gorm.DB) error {
+		passkey := PasskeyCredential{}
+		err := tx.Where("user_id = ?", user.ID).First(&passkey, r.FormValue("id")).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&passkey).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("name: %q → \"\"", passkey.Name)}
+		return audit(tx, requestActor(r, "web"), AuditDelete, "passkey", passkey.ID, changes)
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		p.s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, "/passkeys", http.StatusFound)
+}
+
+// HandleLoginBegin starts a passkey login for the username.
+func (p *Passkeys) HandleLoginBegin(w http.ResponseWriter, r *http.Request) {
+	username := strings.ToLower(strings.TrimSpace(r.FormValue("username")))
+	user, err := p.loadUser("username = ?", username)
+	if err != nil || len(user.credentials) == 0 {
+		writeAPIError(w, r, ErrBadRequest, err, "There are no passkeys for that username.")
+		return
+	}
+
+	options, data, err := p.webAuthn.BeginLogin(user)
+	if err == nil {
+		err = p.setCeremony(w, data)
+	}
+	if err != nil {
+		writeAPIError(w, r, ErrInternal, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, options)
+}
+
+// HandleLoginFinish verifies the passkey, and starts the session.
+func (p *Passkeys) HandleLoginFinish(w http.ResponseWriter, r *http.Request) {
+	data, err := p.ceremony(w, r)
+	if err != nil {
+		writeAPIError(w, r, ErrBadRequest, err)
+		return
+	}
+	user, err := p.loadUser("id = ?", string(data.UserID))
+	if err != nil {
+		writeAPIError(w, r, ErrBadRequest, err)
+		return
+	}
+	credential, err := p.webAuthn.FinishLogin(user, data, r)
+	if err == nil && credential.Authenticator.CloneWarning {
+		err = errors.New("passkey sign count went backwards, it may be cloned")
+	}
+	if err != nil {
+		writeAPIError(w, r, ErrBadRequest, err, "The passkey could not be verified.")
+		return
+	}
+
+	now := time.Now()
+	err = p.s.DB.Model(&PasskeyCredential{}).
+		Where("user_id = ? and credential_id = ?", user.ID, credential.ID).
+		Updates(map[string]interface{}{"sign_count": credential.Authenticator.SignCount, "last_used_at": now}).Error
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+
+	if r.FormValue("remember") == "true" {
+		if err := p.devices.Remember(w, r, user.Username); err != nil {
+			writeAPIError(w, r, ErrDatabase, err)
+			return
+		}
+	}
+	p.login(w, user.Username)
+	writeJSON(w, http.StatusOK, map[string]string{"redirect": loginNext(r)})
+}
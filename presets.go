@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+//
+// ------------------------------------------------------------------
+// Capture presets
+// ------------------------------------------------------------------
+//
+
+// CapturePreset is the model for the `capture_presets` table. A preset
+// fills in the create form with its tags and body, e.g. a "meeting"
+// preset with an agenda, at /note/new?preset=meeting.
+//
+// Presets belong to the user that created them. With the shared
+// password, there is no user, and all presets are shared.
+type CapturePreset struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Username  string
+	Name      string
+	Tags      string
+	Body      string
+}
+
+// URL returns the link to the create form with the preset.
+func (p CapturePreset) URL() string {
+	return "/note/new?preset=" + url.QueryEscape(p.Name)
+}
+
+// capturePresets returns the presets of the request's user, by name.
+func capturePresets(db *gorm.DB, r *http.Request) ([]CapturePreset, error) {
+	presets := []CapturePreset{}
+	err := db.Where("username = ?", currentUser(r)).Order("name").Find(&presets).Error
+	return presets, err
+}
+
+// bookmarklet returns the `javascript:` link that opens the create
+// form with the selected text and the address of the page.
+func bookmarklet(baseURL string) template.URL {
+	script := fmt.Sprintf(`(function(){var s=String(window.getSelection());`+
+		`window.open(%q+encodeURIComponent((s?s+"\n\n":"")+location.href))})()`,
+		baseURL+"/note/new?body=")
+	return template.URL("javascript:" + script)
+}
+
+// PresetsContext provides context data to the presets page.
+type PresetsContext struct {
+	Presets     []CapturePreset
+	Bookmarklet template.URL
+}
+
+// HandlePresetList serves the presets page.
+func (s *Server) HandlePresetList(w http.ResponseWriter, r *http.Request) {
+	presets, err := capturePresets(s.ReadDB, r)
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	requestContext := PresetsContext{
+		Presets:     presets,
+		Bookmarklet: bookmarklet(s.Config.baseURL()),
+	}
+	s.render(w, r, "presets", requestContext)
+}
+
+// HandlePresetCreate adds a capture preset, or updates the tags and
+// body of the preset with the same name.
+func (s *Server) HandlePresetCreate(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimSpace(r.FormValue("name")))
+	if name == "" || strings.ContainsAny(name, " \t/?&#") {
+		s.renderError(w, r, ErrBadRequest, fmt.Errorf("invalid preset name %q", name))
+		return
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		preset := CapturePreset{}
+		err := tx.Where(CapturePreset{Username: currentUser(r), Name: name}).FirstOrInit(&preset).Error
+		if err != nil {
+			return err
+		}
+		before := preset
+		preset.Tags = strings.TrimSpace(r.FormValue("tags"))
+		preset.Body = strings.ReplaceAll(r.FormValue("body"), "\r\n", "\n")
+
+		if err := tx.Save(&preset).Error; err != nil {
+			return err
+		}
+
+		action, changes := AuditUpdate, []string{}
+		if before.ID == 0 {
+			action = AuditCreate
+			changes = append(changes, fmt.Sprintf("name: \"\" → %q", preset.Name))
+		}
+		if before.Tags != preset.Tags {
+			changes = append(changes, fmt.Sprintf("tags: %q → %q", before.Tags, preset.Tags))
+		}
+		if before.Body != preset.Body {
+			changes = append(changes, fmt.Sprintf("body: %v → %v characters",
+				utf8.RuneCountInString(before.Body), utf8.RuneCountInString(preset.Body)))
+		}
+		return audit(tx, requestActor(r, "web"), action, "capture_preset", preset.ID, changes)
+	})
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, "/presets", http.StatusFound)
+}
+
+// HandlePresetDelete removes a capture preset.
+func (s *Server) HandlePresetDelete(w http.ResponseWriter, r *http.Request) {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		preset := CapturePreset{}
+		err := tx.Where("username = ?", currentUser(r)).First(&preset, chi.URLParam(r, "presetID")).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(&preset).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("name: %q → \"\"", preset.Name)}
+		return audit(tx, requestActor(r, "web"), AuditDelete, "capture_preset", preset.ID, changes)
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, "/presets", http.StatusFound)
+}
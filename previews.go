@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Link preview limits.
+const (
+	LinkPreviewTimeout  = 10 * time.Second
+	LinkPreviewMaxBytes = 1 << 20 // of the page that is read
+	LinkPreviewsPerNote = 3
+	LinkPreviewMaxText  = 300 // characters of the title and description
+)
+
+// noteURL matches the URLs in Note bodies.
+var noteURL = regexp.MustCompile(`https?://[^\s<>"'()\[\]]+`)
+
+// privateNetworks are the addresses link previews don't fetch from,
+// so a Note can't be used to probe the server's network: private,
+// loopback, link-local, unspecified, multicast and reserved addresses.
+var privateNetworks = []string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.168.0.0/16", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b::/96", "fc00::/7", "fe80::/10", "ff00::/8",
+}
+
+//
+// ------------------------------------------------------------------
+// Link previews
+// ------------------------------------------------------------------
+//
+
+// LinkPreview is the model for the `link_previews` table.
+// Pages that failed to load are saved with the Error, and not retried.
+type LinkPreview struct {
+	URL         string `gorm:"primaryKey"`
+	Title       string
+	Description string
+	Image       string
+	FetchedAt   time.Time
+	Error       string
+}
+
+// Host returns the host name of the URL, without "www.".
+func (p LinkPreview) Host() string {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+// noteURLs returns the distinct URLs in the body, in order.
+func noteURLs(body string) []string {
+	urls := []string{}
+	seen := map[string]bool{}
+	for _, u := range noteURL.FindAllString(body, -1) {
+		// Punctuation after a link is part of the sentence.
+		u = strings.TrimRight(u, ".,;:!?")
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// linkPreviews returns the fetched previews of the links in the Notes,
+// by Note id.
+func linkPreviews(db *gorm.DB, notes []Note) (map[uint][]LinkPreview, error) {
+	urls := []string{}
+	for _, note := range notes {
+		urls = append(urls, noteURLs(string(note.Body))...)
+	}
+	if len(urls) == 0 {
+		return nil, nil
+	}
+
+	found := []LinkPreview{}
+	if err := db.Where("url in ? and error = '' and title != ''", urls).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	byURL := map[string]LinkPreview{}
+	for _, preview := range found {
+		byURL[preview.URL] = preview
+	}
+
+	previews := map[uint][]LinkPreview{}
+	for _, note := range notes {
+		for _, u := range noteURLs(string(note.Body)) {
+			if preview, ok := byURL[u]; ok && len(previews[note.ID]) < LinkPreviewsPerNote {
+				previews[note.ID] = append(previews[note.ID], preview)
+			}
+		}
+	}
+	return previews, nil
+}
+
+// LinkPreviewer fetches the previews of the links in Notes, in the
+// background, as Notes are created and updated.
+type LinkPreviewer struct {
+	db     *gorm.DB
+	events *EventHub
+	client *http.Client
+}
+
+// NewLinkPreviewer ...
+func NewLinkPreviewer(s *Server) (*LinkPreviewer, error) {
+	blocked := []*net.IPNet{}
+	for _, cidr := range privateNetworks {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		blocked = append(blocked, ipNet)
+	}
+
+	dialer := &net.Dialer{
+		Timeout: LinkPreviewTimeout,
+		// Checked after the name is resolved, for every redirect.
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("invalid address %v", host)
+			}
+			for _, ipNet := range blocked {
+				if ipNet.Contains(ip) {
+					return fmt.Errorf("%v is a private address", host)
+				}
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+
+	return &LinkPreviewer{
+		db:     s.DB,
+		events: s.Events,
+		client: &http.Client{Transport: transport, Timeout: LinkPreviewTimeout},
+	}, nil
+}
+
+// Run fetches the previews of the existing Notes, then those of the
+// changed Notes until the server stops.
+func (p *LinkPreviewer) Run() {
+	events := p.events.Subscribe()
+	defer p.events.Unsubscribe(events)
+
+	notes := []Note{}
+	if err := p.db.Find(&notes).Error; err != nil {
+		log.Printf("[previews] %v", err)
+	}
+	for _, note := range notes {
+		p.fetchAll(noteURLs(string(note.Body)))
+	}
+
+	for event := range events {
+		if event.Type != NoteDeleted {
+			p.fetchAll(noteURLs(event.Body))
+		}
+	}
+}
+
+// fetchAll saves the previews of the URLs that were not fetched yet.
+func (p *LinkPreviewer) fetchAll(urls []string) {
+	for _, u := range urls {
+		var count int64
+		if err := p.db.Model(&LinkPreview{}).Where("url = ?", u).Count(&count).Error; err != nil || count > 0 {
+			continue
+		}
+
+		preview, err := p.fetch(u)
+		if err != nil {
+			preview.Error = err.Error()
+		}
+		preview.URL, preview.FetchedAt = u, time.Now()
+		if err := p.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&preview).Error; err != nil {
+			log.Printf("[previews] %v", err)
+		}
+	}
+}
+
+// fetch reads the title, description and image of the page.
+func (p *LinkPreviewer) fetch(pageURL string) (LinkPreview, error) {
+	preview := LinkPreview{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), LinkPreviewTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return preview, err
+	}
+	req.Header.Set("User-Agent", "SimpleNotes/1.0 (link preview)")
+	req.Header.Set("Accept", "text/html")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return preview, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return preview, fmt.Errorf("%v responded %v", req.URL.Host, resp.Status)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return preview, fmt.Errorf("not a web page: %v", mediaType)
+	}
+
+	preview = parseLinkPreview(io.LimitReader(resp.Body, LinkPreviewMaxBytes), resp.Request.URL)
+	if preview.Title == "" {
+		return preview, errors.New("the page has no title")
+	}
+	return preview, nil
+}
+
+// parseLinkPreview reads the preview from the page's <head>. The Open
+// Graph tags are preferred over the <title> and meta description.
+func parseLinkPreview(r io.Reader, base *url.URL) LinkPreview {
+	preview := LinkPreview{}
+	title, description := "", ""
+
+	z := html.NewTokenizer(r)
+head:
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		token := z.Token()
+		switch token.Data {
+		case "body":
+			// The preview tags are in the head.
+			break head
+		case "title":
+			if z.Next() == html.TextToken {
+				title = strings.TrimSpace(string(z.Text()))
+			}
+		case "meta":
+			attrs := map[string]string{}
+			for _, attr := range token.Attr {
+				attrs[attr.Key] = strings.TrimSpace(attr.Val)
+			}
+			switch attrs["property"] + attrs["name"] {
+			case "og:title":
+				preview.Title = attrs["content"]
+			case "og:description":
+				preview.Description = attrs["content"]
+			case "description":
+				description = attrs["content"]
+			case "og:image":
+				if u, err := base.Parse(attrs["content"]); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+					preview.Image = u.String()
+				}
+			}
+		}
+	}
+
+	if preview.Title == "" {
+		preview.Title = title
+	}
+	if preview.Description == "" {
+		preview.Description = description
+	}
+	preview.Title = clipText(preview.Title, LinkPreviewMaxText)
+	preview.Description = clipText(preview.Description, LinkPreviewMaxText)
+	return preview
+}
+
+// clipText shortens the text to the max amount of characters.
+func clipText(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) > max {
+		return strings.TrimSpace(string(runes[:max-1])) + "…"
+	}
+	return text
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PurgeInterval is how often expired and trashed Notes are looked for.
+const PurgeInterval = time.Minute
+
+// NoteForm expires values, besides durations like "24h".
+const (
+	NoteExpiresKeep  = "keep"  // keep the Note's expiry, if any
+	NoteExpiresNever = "never" // remove the Note's expiry
+)
+
+//
+// ------------------------------------------------------------------
+// Purging Notes
+// ------------------------------------------------------------------
+//
+
+// purgeNotes deletes the Notes for good, with their tags, revisions,
+// shares and recalls, unlike deleteNote which keeps them for undo.
+// The database leaves a tombstone of each, for the changes feed.
+// Tags left unused are handled by the stale tag policy.
+func (s *Server) purgeNotes(tx *gorm.DB, actor Actor, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	for _, table := range []string{"note_tag", "note_revisions", "shares", "recalls"} {
+		if err := tx.Exec("delete from "+table+" where note_id in ?", ids).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Unscoped().Delete(&Note{}, ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := audit(tx, actor, AuditDelete, "note", id, []string{"purged"}); err != nil {
+			return err
+		}
+	}
+	return s.cleanupTags(tx)
+}
+
+// expiryChanged reports whether the form sets or removes the expiry of
+// the Note.
+func (form *NoteForm) expiryChanged() bool {
+	return form.Expires != "" && form.Expires != NoteExpiresKeep
+}
+
+// Expiry returns when the Note expires, in the Notes' time zone.
+func (n Note) Expiry() time.Time {
+	if n.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return n.ExpiresAt.In(noteLocation())
+}
+
+// purgeExpiredNotes deletes the Notes whose ExpiresAt has passed, also
+// deleted ones. It returns the number of Notes deleted.
+func (s *Server) purgeExpiredNotes() (int, error) {
+	ids := []uint{}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Note{}).Unscoped().
+			Where("expires_at <= ?", time.Now()).
+			Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		return s.purgeNotes(tx, Actor{Source: "expiry"}, ids)
+	})
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+
+	s.Counts.Clear()
+	for _, id := range ids {
+		s.Events.Publish(NoteEvent{Type: NoteDeleted, NoteID: id})
+	}
+	return len(ids), nil
+}
+
+// trashedBefore returns the time before which deleted Notes are purged,
+// or the zero time when they are kept.
+func (cfg Config) trashedBefore() time.Time {
+	if cfg.TrashDays <= 0 {
+		return time.Time{}
+	}
+	return time.Now().AddDate(0, 0, -cfg.TrashDays)
+}
+
+// purgeTrashedNotes deletes the Notes that were deleted more than
+// TrashDays ago. It returns the number of Notes deleted.
+func (s *Server) purgeTrashedNotes() (int, error) {
+	before := s.Config.trashedBefore()
+	if before.IsZero() {
+		return 0, nil
+	}
+	ids := []uint{}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Note{}).Unscoped().
+			Where("deleted_at < ?", before).
+			Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		return s.purgeNotes(tx, Actor{Source: "trash"}, ids)
+	})
+	if err != nil {
+		return 0, err
+	}
+	// The Notes were gone from the lists already, nothing to publish.
+	return len(ids), nil
+}
+
+// runPurges is the purge Task, which deletes expired Notes, and the
+// trash. Failed purges are tried again the next time.
+func (s *Server) runPurges(ctx context.Context) error {
+	purged, err := s.purgeExpiredNotes()
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("[purge] deleted %v expired notes", purged)
+	}
+	if purged, err = s.purgeTrashedNotes(); err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("[purge] deleted %v notes from the trash", purged)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+)
+
+// ServiceWorkerAsset is the service worker in the static assets. It is
+// served at /sw.js, as a service worker only controls the pages below
+// its own path.
+const ServiceWorkerAsset = "/static/pwa/sw.js"
+
+func init() {
+	// Serve the web app manifest with its own content type.
+	mime.AddExtensionType(".webmanifest", "application/manifest+json")
+}
+
+//
+// ------------------------------------------------------------------
+// Progressive web app
+// ------------------------------------------------------------------
+//
+
+// HandleServiceWorker serves the service worker, which lets the notes
+// be read offline once installed, see static/pwa/sw.js. It isn't
+// cached, so changes to it reach the browsers right away.
+func (s *Server) HandleServiceWorker(w http.ResponseWriter, r *http.Request) {
+	asset := r.Clone(r.Context())
+	asset.URL.Path = ServiceWorkerAsset
+	w.Header().Set("Cache-Control", "no-cache")
+	s.StaticHandler.ServeHTTP(w, asset)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RememberTag tags the Notes that are resurfaced, see Recall.
+const RememberTag = "remember"
+
+// RecallShown is the amount of due Notes shown above the note list.
+const RecallShown = 3
+
+// RecallIntervals are the waits before a Note is resurfaced again,
+// which grow each time it is remembered.
+var RecallIntervals = []time.Duration{
+	24 * time.Hour,
+	3 * 24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+}
+
+//
+// ------------------------------------------------------------------
+// Spaced repetition
+// ------------------------------------------------------------------
+//
+
+// Recall is the model for the `recalls` table. Notes tagged "remember"
+// are resurfaced above the note list, and in the digest, at growing
+// intervals: a day after they are created, then after each interval
+// of RecallIntervals, once they are remembered ("got it"). Asking to
+// see a Note later starts it over at the first interval.
+//
+// Notes that were never resurfaced have no Recall.
+type Recall struct {
+	NoteID uint `gorm:"primaryKey"`
+	Step   int  // index of the current interval
+	DueAt  time.Time
+}
+
+// dueRecalls returns the Notes tagged "remember" that are due to be
+// resurfaced at the time, the longest due first.
+func dueRecalls(db *gorm.DB, now time.Time, limit int) ([]Note, error) {
+	notes := []Note{}
+	err := db.Preload("Tags").
+		Where(`id in (
+			select nt.note_id
+			from note_tag nt
+			inner join tags t on t.id = nt.tag_id
+			where t.name = ?
+		)`, RememberTag).
+		Where("not archived and not draft").
+		Scopes(notScheduled).
+		Where(`(
+			id not in (select note_id from recalls) and created_at <= ?
+			or id in (select note_id from recalls where due_at <= ?)
+		)`, now.Add(-RecallIntervals[0]), now).
+		Order("date").
+		Limit(limit).
+		Find(&notes).Error
+	return notes, err
+}
+
+// HandleRecallGotIt reschedules a resurfaced Note at the next,
+// longer interval.
+func (s *Server) HandleRecallGotIt(w http.ResponseWriter, r *http.Request) {
+	s.scheduleRecall(w, r, true)
+}
+
+// HandleRecallLater reschedules a resurfaced Note at the first,
+// shortest interval.
+func (s *Server) HandleRecallLater(w http.ResponseWriter, r *http.Request) {
+	s.scheduleRecall(w, r, false)
+}
+
+// scheduleRecall reschedules the Note of the request, and goes back
+// to the page it was resurfaced on.
+func (s *Server) scheduleRecall(w http.ResponseWriter, r *http.Request, remembered bool) {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		note := Note{}
+		if err := tx.First(&note, chi.URLParam(r, "noteID")).Error; err != nil {
+			return err
+		}
+		recall := Recall{}
+		if err := tx.Where(Recall{NoteID: note.ID}).FirstOrInit(&recall).Error; err != nil {
+			return err
+		}
+
+		if remembered && recall.Step < len(RecallIntervals)-1 {
+			recall.Step++
+		} else if !remembered {
+			recall.Step = 0
+		}
+		recall.DueAt = time.Now().Add(RecallIntervals[recall.Step])
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&recall).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrNoteNotFound, err)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	next := "/"
+	if u, err := url.Parse(r.Referer()); err == nil && u.Host == r.Host {
+		next = u.RequestURI()
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Remember me settings, of the users backend.
+const (
+	RememberCookie = "remember"
+	RememberMaxAge = 30 * 24 * time.Hour
+)
+
+//
+// ------------------------------------------------------------------
+// Remembered devices
+// ------------------------------------------------------------------
+//
+
+// RememberedDevice is the model for the `remembered_devices` table.
+// It is a browser where the user logged in with "remember me".
+type RememberedDevice struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UserID     uint
+	Name       string // the browser's user agent
+	TokenHash  string
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+}
+
+// RememberedDevices keeps users logged in for RememberMaxAge, after the
+// SessionMaxAge of their session. The remember cookie holds a device id
+// and a random token, of which only the hash is saved. The token is
+// replaced every time it starts a new session, so a copied cookie stops
+// working once either copy is used.
+type RememberedDevices struct {
+	s *Server
+}
+
+// NewRememberedDevices ...
+func NewRememberedDevices(s *Server) *RememberedDevices {
+	return &RememberedDevices{s}
+}
+
+// newRememberToken returns a random token, and its hash.
+func newRememberToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashRememberToken(token), nil
+}
+
+// hashRememberToken returns the hash of the token, as saved.
+func hashRememberToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// setCookie sets the remember cookie of the device.
+func (d *RememberedDevices) setCookie(w http.ResponseWriter, device RememberedDevice, token string) {
+	setCookie(w, RememberCookie, strconv.FormatUint(uint64(device.ID), 10)+"."+token, RememberMaxAge)
+}
+
+// Remember saves the browser of the request as a device of the user,
+// and sets its remember cookie.
+func (d *RememberedDevices) Remember(w http.ResponseWriter, r *http.Request, username string) error {
+	user := User{}
+	if err := d.s.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		return err
+	}
+	token, hash, err := newRememberToken()
+	if err != nil {
+		return err
+	}
+
+	name := r.UserAgent()
+	if len(name) > 200 {
+		name = name[:200]
+	}
+	now := time.Now()
+	device := RememberedDevice{
+		UserID:     user.ID,
+		Name:       name,
+		TokenHash:  hash,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(RememberMaxAge),
+	}
+	if err := d.s.DB.Create(&device).Error; err != nil {
+		return err
+	}
+	d.setCookie(w, device, token)
+	return nil
+}
+
+// device returns the RememberedDevice of the request's remember cookie,
+// and the token of the cookie.
+func (d *RememberedDevices) device(r *http.Request) (RememberedDevice, string, error) {
+	device := RememberedDevice{}
+	c, err := r.Cookie(RememberCookie)
+	if err != nil {
+		return device, "", err
+	}
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 2 {
+		return device, "", errors.New("invalid remember cookie")
+	}
+	err = d.s.DB.First(&device, "id = ?", parts[0]).Error
+	return device, parts[1], err
+}
+
+// Recall returns the user of the request's remember cookie, and replaces
+// its token. It is false when the cookie is missing, unknown, expired or
+// has an old token, and the cookie is cleared.
+func (d *RememberedDevices) Recall(w http.ResponseWriter, r *http.Request) (string, bool) {
+	device, token, err := d.device(r)
+	if errors.Is(err, http.ErrNoCookie) {
+		return "", false
+	}
+	if err == nil && subtle.ConstantTimeCompare([]byte(hashRememberToken(token)), []byte(device.TokenHash)) != 1 {
+		// The cookie was rotated by another request, which may have
+		// been someone with a copy of it.
+		log.Printf("[auth] old remember token for device %v from %v", device.ID, d.s.clientIP(r))
+		err = errors.New("old remember token")
+	}
+	if err == nil && time.Now().After(device.ExpiresAt) {
+		err = errors.New("expired remember token")
+	}
+
+	user := User{}
+	var hash string
+	if err == nil {
+		err = d.s.DB.First(&user, device.UserID).Error
+	}
+	if err == nil {
+		token, hash, err = newRememberToken()
+	}
+	if err == nil {
+		now := time.Now()
+		device.TokenHash, device.LastUsedAt, device.ExpiresAt = hash, now, now.Add(RememberMaxAge)
+		err = d.s.DB.Model(&device).
+			Updates(map[string]interface{}{"token_hash": hash, "last_used_at": now, "expires_at": device.ExpiresAt}).Error
+	}
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Printf("[auth] %v", err)
+		}
+		setCookie(w, RememberCookie, "", -1)
+		return "", false
+	}
+
+	d.setCookie(w, device, token)
+	return user.Username, true
+}
+
+// Forget removes the device of the request's remember cookie, and
+// clears the cookie, when logging out.
+func (d *RememberedDevices) Forget(w http.ResponseWriter, r *http.Request) error {
+	setCookie(w, RememberCookie, "", -1)
+	device, token, err := d.device(r)
+	if errors.Is(err, http.ErrNoCookie) || errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashRememberToken(token)), []byte(device.TokenHash)) != 1 {
+		return nil
+	}
+	return d.s.DB.Delete(&device).Error
+}
+
+// DevicesContext provides context data to the remembered devices page.
+type DevicesContext struct {
+	Username string
+	Devices  []RememberedDevice
+}
+
+// HandleList serves the remembered devices of the logged in User.
+func (d *RememberedDevices) HandleList(w http.ResponseWriter, r *http.Request) {
+	requestContext := DevicesContext{Username: currentUser(r)}
+
+	err := d.s.DB.Joins("join users on users.id = remembered_devices.user_id").
+		Where("users.username = ? and remembered_devices.expires_at > ?", requestContext.Username, time.Now()).
+		Order("remembered_devices.last_used_at desc").
+		Find(&requestContext.Devices).Error
+	if err != nil {
+		d.s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	d.s.render(w, r, "devices", requestContext)
+}
+
+// HandleForgetAll removes all remembered devices of the logged in User,
+// including the current one. Their sessions last until they expire.
+func (d *RememberedDevices) HandleForgetAll(w http.ResponseWriter, r *http.Request) {
+	username := currentUser(r)
+	err := d.s.DB.Where("user_id in (?)", d.s.DB.Model(&User{}).Select("id").Where("username = ?", username)).
+		Delete(&RememberedDevice{}).Error
+	if err != nil {
+		d.s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	log.Printf("[auth] forgot all remembered devices of %q", username)
+	setCookie(w, RememberCookie, "", -1)
+	http.Redirect(w, r, "/devices", http.StatusFound)
+}
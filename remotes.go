@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tunedmystic/simplenotes/client"
+)
+
+// Remote limits.
+const (
+	RemoteTimeout  = 5 * time.Second
+	RemoteCacheTTL = time.Minute
+	TimelineSize   = 50 // Notes from each instance
+)
+
+//
+// ------------------------------------------------------------------
+// Remote instances
+// ------------------------------------------------------------------
+//
+
+// Remote is another Simple Notes instance, whose Notes are read through
+// its API and shown in the timeline, next to the local Notes. Remotes
+// are only read, their Notes are never changed or copied.
+type Remote struct {
+	Name  string
+	URL   string // without the token
+	Query string // a search that narrows down the Notes, e.g. tag:team
+
+	client *client.Client
+
+	mu      sync.Mutex
+	notes   []client.Note
+	err     error
+	fetched time.Time
+}
+
+// parseRemotes parses the SIMPLENOTES_REMOTES list, of comma separated
+// name=url pairs. The read-only FederationToken of the instance is the
+// user of the url, which only lists its shared and public Notes, and a
+// `q` parameter can narrow them down, e.g.
+//
+//	work=https://TOKEN@notes.work.example.com?q=tag:team
+func parseRemotes(list string) ([]*Remote, error) {
+	remotes := []*Remote{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid SIMPLENOTES_REMOTES entry %q, use name=url", redactRemotes(entry))
+		}
+		name := strings.TrimSpace(entry[:i])
+
+		u, err := url.Parse(strings.TrimSpace(entry[i+1:]))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid SIMPLENOTES_REMOTES url for %q", name)
+		}
+		token := u.User.Username()
+		query := u.Query().Get("q")
+		u.User, u.RawQuery, u.Fragment = nil, "", ""
+
+		remote := &Remote{Name: name, URL: strings.TrimRight(u.String(), "/"), Query: query}
+		remote.client = client.New(remote.URL, token)
+		remote.client.HTTPClient.Timeout = RemoteTimeout
+		remote.client.Retries = 0
+		remotes = append(remotes, remote)
+	}
+	return remotes, nil
+}
+
+// redactRemotes hides the API tokens of the SIMPLENOTES_REMOTES list.
+func redactRemotes(list string) string {
+	entries := strings.Split(list, ",")
+	for i, entry := range entries {
+		if at := strings.Index(entry, "@"); at >= 0 {
+			if scheme := strings.Index(entry, "://"); scheme >= 0 && scheme < at {
+				entries[i] = entry[:scheme+3] + redacted + entry[at:]
+			}
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// Notes returns the most recent Notes of the Remote. They are cached
+// for RemoteCacheTTL, as is a failed request.
+func (rm *Remote) Notes(ctx context.Context) ([]client.Note, error) {
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	if time.Since(rm.fetched) < RemoteCacheTTL {
+		return rm.notes, rm.err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, RemoteTimeout)
+	defer cancel()
+	rm.notes, rm.err = rm.client.ListNotes(ctx, client.ListOptions{Limit: TimelineSize, Query: rm.Query})
+	rm.fetched = time.Now()
+	return rm.notes, rm.err
+}
+
+// TimelineEntry is a Note in the timeline, local or from a Remote.
+type TimelineEntry struct {
+	Source    string // the name of the Remote, empty for local Notes
+	URL       string // of the Note's page
+	Date      time.Time
+	Body      template.HTML
+	Monospace bool
+	Tags      []string
+}
+
+// TimelineContext provides context data to the timeline page.
+type TimelineContext struct {
+	Entries []TimelineEntry
+	Remotes []*Remote
+	Errors  []string // of the Remotes that couldn't be read
+}
+
+// HandleTimeline serves the local Notes and those of the Remotes,
+// newest first, in a single list.
+func (s *Server) HandleTimeline(w http.ResponseWriter, r *http.Request) {
+	requestContext := TimelineContext{Remotes: s.Remotes}
+
+	notes, err := searchNotes(s.ReadDB, SearchQuery{}, DefaultNoteSort.OrderBy(), TimelineSize)
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	for _, note := range notes {
+		entry := TimelineEntry{
+			URL:       fmt.Sprintf("/note/%v/change", note.ID),
+			Date:      note.Date,
+			Body:      template.HTML(template.HTMLEscapeString(string(note.Body))),
+			Monospace: note.Monospace,
+			Tags:      newNoteJSON(note).Tags,
+		}
+		if !note.Monospace {
+			entry.Body = renderNoteBody(note.Body)
+		}
+		requestContext.Entries = append(requestContext.Entries, entry)
+	}
+
+	// Read the Remotes at the same time, a slow one delays the page
+	// by up to RemoteTimeout.
+	results := make([][]client.Note, len(s.Remotes))
+	errs := make([]error, len(s.Remotes))
+	var wg sync.WaitGroup
+	for i, remote := range s.Remotes {
+		wg.Add(1)
+		go func(i int, remote *Remote) {
+			defer wg.Done()
+			results[i], errs[i] = remote.Notes(r.Context())
+		}(i, remote)
+	}
+	wg.Wait()
+
+	for i, remote := range s.Remotes {
+		if errs[i] != nil {
+			logError(r, ErrRemote, errs[i])
+			requestContext.Errors = append(requestContext.Errors, fmt.Sprintf("%v: %v", remote.Name, errs[i]))
+			continue
+		}
+		for _, note := range results[i] {
+			entry := TimelineEntry{
+				Source:    remote.Name,
+				URL:       fmt.Sprintf("%v/note/%v/change", remote.URL, note.ID),
+				Date:      note.Date,
+				Body:      template.HTML(template.HTMLEscapeString(note.Body)),
+				Monospace: note.Monospace,
+				Tags:      note.Tags,
+			}
+			if !note.Monospace {
+				entry.Body = renderNoteBody(EncryptedText(note.Body))
+			}
+			requestContext.Entries = append(requestContext.Entries, entry)
+		}
+	}
+
+	sort.SliceStable(requestContext.Entries, func(i, j int) bool {
+		return requestContext.Entries[i].Date.After(requestContext.Entries[j].Date)
+	})
+
+	s.render(w, r, "timeline", requestContext)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+// ReviewQueueSize is the amount of Notes shown in the review queue.
+const ReviewQueueSize = 20
+
+//
+// ------------------------------------------------------------------
+// Review queue
+// ------------------------------------------------------------------
+//
+
+// LastTouched returns when the Note was last updated, or kept in the
+// review queue.
+func (n Note) LastTouched() time.Time {
+	if n.ReviewedAt != nil && n.ReviewedAt.After(n.UpdatedAt) {
+		return *n.ReviewedAt
+	}
+	return n.UpdatedAt
+}
+
+// reviewBefore returns the time before which untouched Notes need a
+// review, or the zero time when the review queue is off.
+func (cfg Config) reviewBefore() time.Time {
+	if cfg.ReviewMonths <= 0 {
+		return time.Time{}
+	}
+	return time.Now().AddDate(0, -cfg.ReviewMonths, 0)
+}
+
+// reviewScope selects the Notes that weren't updated or reviewed since
+// the time, and aren't archived.
+func reviewScope(db *gorm.DB, before time.Time) *gorm.DB {
+	return db.Model(&Note{}).
+		Where("not archived and updated_at < ?", before).
+		Where("reviewed_at is null or reviewed_at < ?", before)
+}
+
+// ReviewContext provides context data to the review page.
+type ReviewContext struct {
+	Notes  []Note // the oldest untouched Notes
+	Total  int64  // the amount of Notes to review
+	Months int
+	Flash  *Flash
+}
+
+// HandleReview serves the review queue: the Notes that were not
+// touched for ReviewMonths, oldest first, to keep, archive or delete.
+func (s *Server) HandleReview(w http.ResponseWriter, r *http.Request) {
+	requestContext := ReviewContext{Months: s.Config.ReviewMonths, Flash: popFlash(w, r)}
+
+	if before := s.Config.reviewBefore(); !before.IsZero() {
+		err := reviewScope(s.ReadDB, before).Count(&requestContext.Total).Error
+		if err == nil {
+			err = reviewScope(s.ReadDB.Preload("Tags"), before).
+				Order("updated_at").
+				Limit(ReviewQueueSize).
+				Find(&requestContext.Notes).Error
+		}
+		if err != nil {
+			s.renderError(w, r, ErrDatabase, err)
+			return
+		}
+	}
+
+	s.render(w, r, "review", requestContext)
+}
+
+// HandleReviewKeep marks a Note as reviewed, which removes it from the
+// review queue for another ReviewMonths.
+func (s *Server) HandleReviewKeep(w http.ResponseWriter, r *http.Request) {
+	err := s.DB.Model(&Note{}).
+		Where("id = ?", chi.URLParam(r, "noteID")).
+		UpdateColumn("reviewed_at", time.Now()).Error
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, "/review", http.StatusFound)
+}
+
+// HandleReviewDelete deletes a Note from the review queue, with the
+// option to undo it.
+func (s *Server) HandleReviewDelete(w http.ResponseWriter, r *http.Request) {
+	noteID := chi.URLParam(r, "noteID")
+	if err := s.deleteNote(requestActor(r, "web"), noteID); err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	if id, err := strconv.ParseUint(noteID, 10, 64); err == nil {
+		setFlash(w, Flash{Message: "Note deleted.", UndoNoteID: uint(id)})
+	}
+	http.Redirect(w, r, "/review", http.StatusFound)
+}
+
+// HandleNoteArchive archives a Note, and goes back to the page it was
+// archived on.
+func (s *Server) HandleNoteArchive(w http.ResponseWriter, r *http.Request) {
+	s.setArchived(w, r, true)
+}
+
+// HandleNoteUnarchive brings an archived Note back to the note list.
+func (s *Server) HandleNoteUnarchive(w http.ResponseWriter, r *http.Request) {
+	s.setArchived(w, r, false)
+}
+
+// setArchived archives or unarchives the Note of the request.
+// The Note's updated time is kept, archiving doesn't change it.
+func (s *Server) setArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	note := Note{}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Tags").First(&note, chi.URLParam(r, "noteID")).Error; err != nil {
+			return err
+		}
+		if note.Archived == archived {
+			return nil
+		}
+		if err := tx.Model(&note).UpdateColumn("archived", archived).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("archived: %v → %v", !archived, archived)}
+		note.Archived = archived
+		return audit(tx, requestActor(r, "web"), AuditUpdate, "note", note.ID, changes)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrNoteNotFound, err)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	s.Counts.Clear()
+	s.Events.Publish(newNoteEvent(NoteUpdated, note))
+
+	next := "/"
+	if u, err := url.Parse(r.Referer()); err == nil && u.Host == r.Host {
+		next = u.RequestURI()
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+//
+// ------------------------------------------------------------------
+// Auto-tagging rules
+// ------------------------------------------------------------------
+//
+
+// TagRule is the model for the `tag_rules` table. Notes whose body
+// matches the pattern, a case-insensitive regular expression, are
+// tagged with the tag when they are saved.
+type TagRule struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Pattern   string
+	Tag       string
+}
+
+// matches reports whether the body matches the rule.
+// Invalid patterns match nothing, they are rejected when saved.
+func (rule TagRule) matches(body string) bool {
+	re, err := regexp.Compile("(?i)" + rule.Pattern)
+	return err == nil && re.MatchString(body)
+}
+
+// applyTagRules adds the tags of the rules that match the body to the
+// NoteForm, and returns the names of the added tags.
+//
+// On update, only rules that didn't match the previous body are
+// applied, so an auto tag that was removed isn't added back.
+func applyTagRules(tx *gorm.DB, form *NoteForm, previousBody string) ([]string, error) {
+	rules := []TagRule{}
+	if err := tx.Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	added := []string{}
+	for _, rule := range rules {
+		if !rule.matches(string(form.cleanedBody)) || (previousBody != "" && rule.matches(previousBody)) {
+			continue
+		}
+		tagged := false
+		for _, tag := range form.cleanedTags {
+			tagged = tagged || tag.Name == rule.Tag
+		}
+		if !tagged {
+			form.cleanedTags = append(form.cleanedTags, Tag{Name: rule.Tag})
+			added = append(added, rule.Tag)
+		}
+	}
+	return added, nil
+}
+
+// markAutoTags flags the Note's tags with the names as added by a rule.
+func markAutoTags(tx *gorm.DB, noteID uint, tags []Tag, names []string) error {
+	ids := []uint{}
+	for _, tag := range tags {
+		for _, name := range names {
+			if tag.Name == name {
+				ids = append(ids, tag.ID)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return tx.Table("note_tag").Where("note_id = ? and tag_id in ?", noteID, ids).Update("auto", true).Error
+}
+
+// autoTags returns the ids of the auto tags of the Notes, by Note id.
+func autoTags(db *gorm.DB, notes []Note) (map[uint]map[uint]bool, error) {
+	ids := []uint{}
+	for _, note := range notes {
+		ids = append(ids, note.ID)
+	}
+
+	rows := []struct {
+		NoteID uint
+		TagID  uint
+	}{}
+	err := db.Table("note_tag").Select("note_id, tag_id").Where("auto and note_id in ?", ids).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	auto := map[uint]map[uint]bool{}
+	for _, row := range rows {
+		if auto[row.NoteID] == nil {
+			auto[row.NoteID] = map[uint]bool{}
+		}
+		auto[row.NoteID][row.TagID] = true
+	}
+	return auto, nil
+}
+
+// HandleTagRuleCreate adds an auto-tagging rule.
+func (s *Server) HandleTagRuleCreate(w http.ResponseWriter, r *http.Request) {
+	rule := TagRule{
+		Pattern: strings.TrimSpace(r.FormValue("pattern")),
+		Tag:     strings.ToLower(strings.TrimSpace(r.FormValue("tag"))),
+	}
+	if _, err := regexp.Compile(rule.Pattern); err != nil || rule.Pattern == "" || rule.Tag == "" || strings.Contains(rule.Tag, ",") {
+		s.renderError(w, r, ErrBadRequest, fmt.Errorf("invalid tag rule %q → %q", rule.Pattern, rule.Tag))
+		return
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&rule).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("rule: \"\" → %q", rule.Pattern+" → "+rule.Tag)}
+		return audit(tx, requestActor(r, "web"), AuditCreate, "tag_rule", rule.ID, changes)
+	})
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, "/tags", http.StatusFound)
+}
+
+// HandleTagRuleDelete removes an auto-tagging rule.
+// The tags it added are kept.
+func (s *Server) HandleTagRuleDelete(w http.ResponseWriter, r *http.Request) {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		rule := TagRule{}
+		if err := tx.First(&rule, chi.URLParam(r, "ruleID")).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&rule).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("rule: %q → \"\"", rule.Pattern+" → "+rule.Tag)}
+		return audit(tx, requestActor(r, "web"), AuditDelete, "tag_rule", rule.ID, changes)
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, "/tags", http.StatusFound)
+}
+
+// HandleNoteUntag removes a tag from a Note, e.g. an auto tag
+// that doesn't fit, and goes back to the page it was removed on.
+func (s *Server) HandleNoteUntag(w http.ResponseWriter, r *http.Request) {
+	note := Note{}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Preload("Tags").First(&note, chi.URLParam(r, "noteID")).Error; err != nil {
+			return err
+		}
+		before := note
+		after := note
+		after.Tags = nil
+		for _, tag := range note.Tags {
+			if fmt.Sprint(tag.ID) != chi.URLParam(r, "tagID") {
+				after.Tags = append(after.Tags, tag)
+			}
+		}
+
+		err := tx.Exec("delete from note_tag where note_id = ? and tag_id = ?", note.ID, chi.URLParam(r, "tagID")).Error
+		if err != nil {
+			return err
+		}
+		note = after
+		if err := audit(tx, requestActor(r, "web"), AuditUpdate, "note", note.ID, noteChanges(before, after)); err != nil {
+			return err
+		}
+		return s.cleanupTags(tx)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrNoteNotFound, err)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	s.Counts.Clear()
+	s.Events.Publish(newNoteEvent(NoteUpdated, note))
+
+	next := "/"
+	if u, err := url.Parse(r.Referer()); err == nil && u.Host == r.Host {
+		next = u.RequestURI()
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
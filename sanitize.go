@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// HTML policies, for the html of Note bodies and Markdown.
+const (
+	HTMLPolicyUGC    = "ugc"    // formatting, links and images, as in comments on other sites
+	HTMLPolicyStrict = "strict" // text only, without formatting
+	HTMLPolicyOff    = "off"    // no sanitization
+)
+
+// htmlPolicy sanitizes all rendered html, see sanitizeHTML.
+// It is set from the Config when the server is opened.
+var htmlPolicy = newHTMLPolicy(HTMLPolicyUGC)
+
+// mathMLElements are the elements of the math in Note bodies.
+var mathMLElements = []string{
+	"math", "semantics", "annotation", "mrow", "mi", "mn", "mo", "mtext", "mspace", "mstyle",
+	"mfrac", "msqrt", "mroot", "msub", "msup", "msubsup", "mover", "munder", "munderover",
+	"mtable", "mtr", "mtd",
+}
+
+//
+// ------------------------------------------------------------------
+// HTML sanitization
+// ------------------------------------------------------------------
+//
+
+// newHTMLPolicy returns the bluemonday policy of the name, or nil for
+// HTMLPolicyOff. The UGC policy also allows the math of Note bodies,
+// and the colors of highlighted code.
+func newHTMLPolicy(name string) *bluemonday.Policy {
+	switch name {
+	case HTMLPolicyUGC:
+		p := bluemonday.UGCPolicy()
+		p.AllowNoAttrs().OnElements(mathMLElements...)
+		p.AllowAttrs("display").OnElements("math")
+		p.AllowAttrs("encoding").OnElements("annotation")
+		p.AllowAttrs("mathvariant", "stretchy", "accent", "accentunder", "linethickness", "width", "columnalign").
+			OnElements(mathMLElements...)
+		p.AllowStyles("color", "background-color", "font-weight", "font-style", "text-decoration").
+			OnElements("pre", "span")
+		return p
+	case HTMLPolicyStrict:
+		return bluemonday.StrictPolicy()
+	default:
+		return nil
+	}
+}
+
+// parseHTMLPolicy returns the policy of the SIMPLENOTES_HTML_POLICY.
+func parseHTMLPolicy(name string) (*bluemonday.Policy, error) {
+	switch name {
+	case HTMLPolicyUGC, HTMLPolicyStrict, HTMLPolicyOff:
+		return newHTMLPolicy(name), nil
+	default:
+		return nil, fmt.Errorf("invalid SIMPLENOTES_HTML_POLICY %q, use ugc, strict or off", name)
+	}
+}
+
+// sanitizeHTML removes what the htmlPolicy doesn't allow from the
+// rendered html, e.g. scripts and event handlers in pasted content.
+func sanitizeHTML(html string) template.HTML {
+	if htmlPolicy == nil {
+		return template.HTML(html)
+	}
+	return template.HTML(htmlPolicy.Sanitize(html))
+}
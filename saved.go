@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+//
+// ------------------------------------------------------------------
+// Saved searches
+// ------------------------------------------------------------------
+//
+
+// SavedSearch is the model for the `saved_searches` table.
+// Saved searches are pinned to the nav, with a count of matching Notes.
+type SavedSearch struct {
+	ID        uint `gorm:"primarykey"`
+	Name      string
+	Query     string
+	CreatedAt time.Time
+}
+
+// URL returns the home page link for the search.
+func (ss SavedSearch) URL() string {
+	return "/?q=" + url.QueryEscape(ss.Query)
+}
+
+// Permalink returns the link that runs the search, see
+// HandleSavedSearch.
+func (ss SavedSearch) Permalink() string {
+	return fmt.Sprintf("/saved/%v", ss.ID)
+}
+
+// PinnedSearch is a SavedSearch with its count of matching Notes.
+type PinnedSearch struct {
+	SavedSearch
+	Count int64
+}
+
+// SearchCounts caches the pinned searches and their counts, and the
+// counts of the tags. The cache is cleared whenever a Note, Tag or
+// SavedSearch changes.
+type SearchCounts struct {
+	db       *gorm.DB
+	mu       sync.Mutex
+	pinned   []PinnedSearch // nil when cleared
+	tagNotes map[string]int // nil when cleared
+}
+
+// NewSearchCounts ...
+func NewSearchCounts(db *gorm.DB) *SearchCounts {
+	return &SearchCounts{db: db}
+}
+
+// Clear empties the cache. The counts are recomputed on the next read.
+func (c *SearchCounts) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.pinned = nil
+	c.tagNotes = nil
+}
+
+// Pinned returns the saved searches, with their counts.
+func (c *SearchCounts) Pinned() []PinnedSearch {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pinned != nil {
+		return c.pinned
+	}
+
+	searches := []SavedSearch{}
+	if err := c.db.Order("name").Find(&searches).Error; err != nil {
+		return []PinnedSearch{}
+	}
+
+	pinned := []PinnedSearch{}
+	for _, ss := range searches {
+		sq, err := ParseSearchQuery(ss.Query)
+		if err != nil {
+			continue
+		}
+		count, err := countNotes(c.db, sq)
+		if err != nil {
+			continue
+		}
+		pinned = append(pinned, PinnedSearch{ss, count})
+	}
+
+	c.pinned = pinned
+	return pinned
+}
+
+// TagNotes returns the amount of Notes of each tag, by name, for the
+// tag chips. They are counted with a single grouped query.
+func (c *SearchCounts) TagNotes() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tagNotes != nil {
+		return c.tagNotes
+	}
+
+	counts := []struct {
+		Name  string
+		Notes int
+	}{}
+	err := c.db.Raw(`
+		select t.name, count(distinct n.id) as notes
+		from tags t
+		inner join note_tag nt on nt.tag_id = t.id
+		inner join notes n on n.id = nt.note_id and n.deleted_at is null
+		where t.deleted_at is null
+		group by t.name;
+	`).Scan(&counts).Error
+	if err != nil {
+		return map[string]int{}
+	}
+
+	c.tagNotes = map[string]int{}
+	for _, count := range counts {
+		c.tagNotes[count.Name] = count.Notes
+	}
+	return c.tagNotes
+}
+
+// Title returns the browser tab title for the page.
+// The home page shows the count when it is showing a pinned search,
+// and the note page shows the Note title.
+func (c *SearchCounts) Title(data interface{}) string {
+	switch ctx := data.(type) {
+	case IndexContext:
+		if ctx.Query == "" || ctx.AsOf != "" {
+			break
+		}
+		for _, ps := range c.Pinned() {
+			if ps.Query == ctx.Query {
+				return fmt.Sprintf("(%v) %v - Simple Notes", ps.Count, ps.Name)
+			}
+		}
+	case NoteFormContext:
+		if ctx.Title != "" {
+			return ctx.Title + " - Simple Notes"
+		}
+	}
+	return "Simple Notes"
+}
+
+// countNotes returns the amount of Notes matching the query.
+func countNotes(db *gorm.DB, sq SearchQuery) (int64, error) {
+	var count int64
+	if !sq.hasText() {
+		err := sq.Scope(db.Model(&Note{})).Count(&count).Error
+		return count, err
+	}
+
+	notes := []Note{}
+	if err := sq.Scope(db.Preload("Tags")).Find(&notes).Error; err != nil {
+		return 0, err
+	}
+	for _, note := range notes {
+		if sq.Matches(note) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// HandleSavedSearch runs a saved search, by redirecting to the home
+// page with its query.
+func (s *Server) HandleSavedSearch(w http.ResponseWriter, r *http.Request) {
+	ss := SavedSearch{}
+	err := s.ReadDB.First(&ss, chi.URLParam(r, "searchID")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrSearchNotFound, err)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	http.Redirect(w, r, ss.URL(), http.StatusFound)
+}
+
+// HandleSavedSearchCreate pins a search to the nav.
+func (s *Server) HandleSavedSearchCreate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, ErrBadRequest, err)
+		return
+	}
+
+	ss := SavedSearch{
+		Name:  strings.TrimSpace(r.Form.Get("name")),
+		Query: strings.TrimSpace(r.Form.Get("q")),
+	}
+	if ss.Name == "" {
+		ss.Name = ss.Query
+	}
+	if _, err := ParseSearchQuery(ss.Query); err != nil || ss.Query == "" {
+		s.renderError(w, r, ErrSearch, err)
+		return
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&ss).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("name: \"\" → %q", ss.Name), fmt.Sprintf("query: \"\" → %q", ss.Query)}
+		return audit(tx, requestActor(r, "web"), AuditCreate, "saved_search", ss.ID, changes)
+	})
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	s.Counts.Clear()
+
+	http.Redirect(w, r, ss.URL(), http.StatusFound)
+}
+
+// HandleSavedSearchDelete unpins a search.
+func (s *Server) HandleSavedSearchDelete(w http.ResponseWriter, r *http.Request) {
+	searchID := chi.URLParam(r, "searchID")
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		ss := SavedSearch{}
+		if err := tx.First(&ss, searchID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&ss).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("name: %q → \"\"", ss.Name), fmt.Sprintf("query: %q → \"\"", ss.Query)}
+		return audit(tx, requestActor(r, "web"), AuditDelete, "saved_search", ss.ID, changes)
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	s.Counts.Clear()
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//
+// ------------------------------------------------------------------
+// Scheduled Notes
+// ------------------------------------------------------------------
+//
+
+// wallNow returns the current wall clock time of the Notes' time zone,
+// the way Note dates are stored, see Settings.Timezone.
+func wallNow() time.Time {
+	now := localNow()
+	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.UTC)
+}
+
+// notScheduled selects the Notes that are shown: the ones that aren't
+// scheduled, or whose date has passed. There is no job that publishes
+// scheduled Notes, they are filtered when they are read.
+func notScheduled(db *gorm.DB) *gorm.DB {
+	return db.Where("not scheduled or date <= ?", wallNow())
+}
+
+// pendingScheduled selects the scheduled Notes whose date is still to
+// come, found by searching is:scheduled.
+func pendingScheduled(db *gorm.DB) *gorm.DB {
+	return db.Where("scheduled and date > ?", wallNow())
+}
+
+// Pending reports whether the Note is scheduled, and still hidden.
+func (n Note) Pending() bool {
+	return n.Scheduled && n.Date.After(wallNow())
+}
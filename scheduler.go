@@ -0,0 +1,393 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ShutdownTimeout is how long the server waits for requests and
+// running Tasks to finish when it stops.
+const ShutdownTimeout = 30 * time.Second
+
+//
+// ------------------------------------------------------------------
+// Scheduler
+// ------------------------------------------------------------------
+//
+
+// Schedule returns when a Task runs next, after the given time.
+// The zero time means never.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// everySchedule runs every interval.
+type everySchedule time.Duration
+
+// Next implements Schedule.
+func (e everySchedule) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(e))
+}
+
+// cronSchedule runs at the minutes that match all its fields, like a
+// crontab line. A day matches its day of the month or its weekday,
+// when both are restricted.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // bit n is set when n matches
+	domAny, dowAny                bool
+}
+
+// cronFields are the fields of a cron schedule, in order, with their
+// ranges.
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// cronAliases are the shorthands of common schedules.
+var cronAliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// parseSchedule parses a schedule, either "@every 10m" with a
+// duration, a crontab line like "30 7 * * 1-5", or @hourly, @daily,
+// @weekly or @monthly. Cron schedules are in the Notes' time zone.
+func parseSchedule(spec string) (Schedule, error) {
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid schedule %q, use e.g. @every 10m", spec)
+		}
+		return everySchedule(d), nil
+	}
+	if alias, ok := cronAliases[spec]; ok {
+		spec = alias
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid schedule %q, use minute hour day-of-month month day-of-week", spec)
+	}
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v %v", spec, cronFields[i].name, err)
+		}
+		bits[i] = b
+	}
+	// Sunday is both 0 and 7.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+	return &cronSchedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: fields[2] == "*",
+		dowAny: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses a comma separated list of "*", values and
+// ranges like "1-5", each optionally with a step like "*/15".
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("has an invalid step %q", part)
+			}
+			step, part = n, part[:i]
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("has an invalid value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("has an invalid value %q", part)
+				}
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("%q is out of range %v-%v", part, min, max)
+			}
+		}
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// Next implements Schedule. It looks up to five years ahead, e.g. for
+// February 30th, which never comes.
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	t := after.In(noteLocation()).Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of t matches the schedule.
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Task is work that the Scheduler runs periodically, e.g. the digest
+// emails or the purges. A Task doesn't overlap itself: a run that is
+// due while the last one is still running waits for it.
+type Task struct {
+	Name     string
+	Spec     string // see parseSchedule
+	Jitter   time.Duration
+	Schedule Schedule
+	run      func(ctx context.Context) error
+
+	mu      sync.Mutex
+	next    time.Time
+	lastRun time.Time
+	lastErr error
+}
+
+// TaskStatus is a snapshot of a Task, for the jobs page.
+type TaskStatus struct {
+	Name    string
+	Spec    string
+	Next    time.Time
+	LastRun time.Time
+	Error   string // of the last run
+}
+
+// Status returns a snapshot of the Task.
+func (t *Task) Status() TaskStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := TaskStatus{Name: t.Name, Spec: t.Spec, Next: t.next, LastRun: t.lastRun}
+	if t.lastErr != nil {
+		status.Error = t.lastErr.Error()
+	}
+	return status
+}
+
+// Scheduler runs the Tasks in the background, each on its Schedule,
+// until it is stopped. Tasks are registered before it starts.
+type Scheduler struct {
+	mu      sync.Mutex
+	tasks   []*Task
+	started bool
+	stop    chan struct{}
+	ctx     context.Context // canceled when a stop times out
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	rand    *rand.Rand
+}
+
+// NewScheduler ...
+func NewScheduler() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Add registers a Task, which runs on the schedule, see parseSchedule,
+// delayed by a random amount up to the jitter, so that Tasks due at the
+// same time don't all hit the database at once.
+func (sc *Scheduler) Add(name, spec string, jitter time.Duration, run func(ctx context.Context) error) error {
+	schedule, err := parseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("task %v: %w", name, err)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.started {
+		return fmt.Errorf("task %v: the scheduler is running", name)
+	}
+	sc.tasks = append(sc.tasks, &Task{Name: name, Spec: spec, Jitter: jitter, Schedule: schedule, run: run})
+	return nil
+}
+
+// Start runs the Tasks in the background.
+func (sc *Scheduler) Start() {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.started {
+		return
+	}
+	sc.started = true
+	for _, task := range sc.tasks {
+		sc.wg.Add(1)
+		go sc.loop(task)
+	}
+}
+
+// Stop stops scheduling the Tasks, and waits for the running ones to
+// finish. When the context is done first, the running Tasks' context
+// is canceled, and its error is returned.
+func (sc *Scheduler) Stop(ctx context.Context) error {
+	sc.mu.Lock()
+	select {
+	case <-sc.stop:
+	default:
+		close(sc.stop)
+	}
+	sc.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sc.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		sc.cancel()
+		return nil
+	case <-ctx.Done():
+		sc.cancel()
+		return ctx.Err()
+	}
+}
+
+// Tasks returns the status of the Tasks, next due first.
+func (sc *Scheduler) Tasks() []TaskStatus {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	statuses := []TaskStatus{}
+	for _, task := range sc.tasks {
+		statuses = append(statuses, task.Status())
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Next.Before(statuses[j].Next)
+	})
+	return statuses
+}
+
+// scheduleTasks registers the server's Tasks, per its Config.
+func (s *Server) scheduleTasks() error {
+	if err := s.Scheduler.Add("purge", fmt.Sprintf("@every %v", PurgeInterval), 10*time.Second, s.runPurges); err != nil {
+		return err
+	}
+	if s.Config.Digest != "" {
+		err := s.Scheduler.Add("digest", digestSchedule(s.Config.Digest, s.Config.DigestHour), time.Minute, s.runDigest)
+		if err != nil {
+			return err
+		}
+	}
+	if s.Usage != nil {
+		err := s.Scheduler.Add("usage", fmt.Sprintf("@every %v", UsageFlushInterval), 0, func(ctx context.Context) error {
+			return s.Usage.Flush()
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jitter returns a random delay up to max.
+func (sc *Scheduler) jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return time.Duration(sc.rand.Int63n(int64(max)))
+}
+
+// loop runs the Task whenever it is due, until the Scheduler stops.
+func (sc *Scheduler) loop(task *Task) {
+	defer sc.wg.Done()
+
+	for {
+		next := task.Schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("[scheduler] %v is never due", task.Name)
+			return
+		}
+		next = next.Add(sc.jitter(task.Jitter))
+		task.mu.Lock()
+		task.next = next
+		task.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-sc.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		err := sc.run(task)
+		task.mu.Lock()
+		task.lastRun, task.lastErr = time.Now(), err
+		task.mu.Unlock()
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("[scheduler] %v failed: %v", task.Name, err)
+		}
+	}
+}
+
+// run runs the Task once. A panic fails the run, rather than stopping
+// the server, and is logged with its stack trace.
+func (sc *Scheduler) run(task *Task) (err error) {
+	defer func() {
+		if rvr := recover(); rvr != nil {
+			PanicCount.Add(1)
+			log.Printf("[scheduler] %v: panic: %v\n%s", task.Name, rvr, debug.Stack())
+			err = fmt.Errorf("panic: %v", rvr)
+		}
+	}()
+	return task.run(sc.ctx)
+}
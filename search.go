@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// SearchDateFormat is the date format for the `before:` and `after:` filters.
+const SearchDateFormat = "2006-01-02"
+
+//
+// ------------------------------------------------------------------
+// Search
+// ------------------------------------------------------------------
+//
+
+// SearchQuery is a parsed search, e.g.
+//
+//	groceries tag:home after:2021-01-01 "oat milk"
+//	is:untagged before:2021-06-01
+//	is:archived tag:work
+//	is:draft
+//	is:scheduled
+//
+// A tag also matches its nested tags, e.g. tag:project matches Notes
+// tagged project/simplenotes. All parts must match. Terms and phrases are matched against the Note
+// body and tag names, ignoring case. Terms also match words with small
+// typos, see maxTypos. Archived Notes only match is:archived, draft
+// Notes only match is:draft, and scheduled Notes only match is:scheduled
+// until their date has passed.
+type SearchQuery struct {
+	Terms     []string   // free text words
+	Phrases   []string   // "quoted phrases"
+	Tags      []string   // tag:<name>
+	Untagged  bool       // is:untagged
+	Archived  bool       // is:archived
+	Draft     bool       // is:draft
+	Scheduled bool       // is:scheduled
+	Before    *time.Time // before:<date>, exclusive
+	After     *time.Time // after:<date>, exclusive
+}
+
+// ParseSearchQuery parses the search syntax.
+func ParseSearchQuery(q string) (SearchQuery, error) {
+	sq := SearchQuery{}
+
+	q, ok := normalizeText(q)
+	if !ok {
+		return sq, fmt.Errorf("search is not valid UTF-8")
+	}
+
+	for _, token := range splitSearch(q) {
+		key, value := "", token
+		if i := strings.Index(token, ":"); i > 0 && !strings.HasPrefix(token, `"`) {
+			key, value = strings.ToLower(token[:i]), unquoteSearch(token[i+1:])
+		}
+
+		switch key {
+		case "tag":
+			if tag := cleanTagName(value); tag != "" {
+				sq.Tags = append(sq.Tags, tag)
+			}
+		case "is":
+			switch strings.ToLower(value) {
+			case "untagged":
+				sq.Untagged = true
+			case "archived":
+				sq.Archived = true
+			case "draft":
+				sq.Draft = true
+			case "scheduled":
+				sq.Scheduled = true
+			default:
+				return sq, fmt.Errorf("unknown filter is:%v, use is:untagged, is:archived, is:draft or is:scheduled", value)
+			}
+		case "before":
+			d, err := time.Parse(SearchDateFormat, value)
+			if err != nil {
+				return sq, fmt.Errorf("invalid date for before: %q, use YYYY-MM-DD", value)
+			}
+			sq.Before = &d
+		case "after":
+			d, err := time.Parse(SearchDateFormat, value)
+			if err != nil {
+				return sq, fmt.Errorf("invalid date for after: %q, use YYYY-MM-DD", value)
+			}
+			// After the whole day.
+			d = d.AddDate(0, 0, 1)
+			sq.After = &d
+		default:
+			if strings.HasPrefix(token, `"`) {
+				if phrase := unquoteSearch(token); phrase != "" {
+					sq.Phrases = append(sq.Phrases, expandShortcodes(strings.ToLower(phrase)))
+				}
+			} else {
+				sq.Terms = append(sq.Terms, expandShortcodes(strings.ToLower(token)))
+			}
+		}
+	}
+
+	return sq, nil
+}
+
+// splitSearch splits the query on whitespace, keeping quoted text together.
+func splitSearch(q string) []string {
+	tokens := []string{}
+	token := strings.Builder{}
+	quoted := false
+
+	for _, r := range q {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			token.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(r)
+		}
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+
+	return tokens
+}
+
+// unquoteSearch removes the surrounding quotes. An unterminated quote is allowed.
+func unquoteSearch(s string) string {
+	return strings.TrimSpace(strings.Trim(s, `"`))
+}
+
+// hasText checks if the query has terms or phrases.
+// These are matched in Go, because the Note body may be encrypted.
+func (sq SearchQuery) hasText() bool {
+	return len(sq.Terms) > 0 || len(sq.Phrases) > 0
+}
+
+// Scope adds the tag and date filters to the db query.
+func (sq SearchQuery) Scope(db *gorm.DB) *gorm.DB {
+	for _, tag := range sq.Tags {
+		db = db.Where(`id in (
+			select nt.note_id
+			from note_tag nt
+			inner join tags t on t.id = nt.tag_id
+			where t.name = ? or substr(t.name, 1, ?) = ?
+		)`, tag, utf8.RuneCountInString(tag+TagSeparator), tag+TagSeparator)
+	}
+	if sq.Untagged {
+		db = db.Where("id not in (select note_id from note_tag)")
+	}
+	db = db.Where("archived = ?", sq.Archived)
+	db = db.Where("draft = ?", sq.Draft)
+	if sq.Scheduled {
+		db = pendingScheduled(db)
+	} else {
+		db = notScheduled(db)
+	}
+	if sq.Before != nil {
+		db = db.Where("date < ?", *sq.Before)
+	}
+	if sq.After != nil {
+		db = db.Where("date >= ?", *sq.After)
+	}
+	return db
+}
+
+// Matches checks the terms and phrases against the Note.
+func (sq SearchQuery) Matches(note Note) bool {
+	text := strings.ToLower(string(note.Body))
+	// Emoji match their :shortcodes:, e.g. 🚀 and :rocket: find both.
+	if expanded := expandShortcodes(text); expanded != text {
+		text += "\n" + expanded
+	}
+	for _, tag := range note.Tags {
+		text += "\n" + tag.Name
+	}
+
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	for _, term := range sq.Terms {
+		if !strings.Contains(text, term) && !fuzzyContains(words, term) {
+			return false
+		}
+	}
+	for _, phrase := range sq.Phrases {
+		if !strings.Contains(text, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+// searchNotes returns up to `limit` Notes matching the query, in the given order.
+func searchNotes(db *gorm.DB, sq SearchQuery, order string, limit int) ([]Note, error) {
+	notes := []Note{}
+	query := sq.Scope(db.Preload("Tags").Order(order))
+
+	if !sq.hasText() {
+		err := query.Limit(limit).Find(&notes).Error
+		return notes, err
+	}
+
+	candidates := []Note{}
+	if err := query.Find(&candidates).Error; err != nil {
+		return notes, err
+	}
+	for _, note := range candidates {
+		if len(notes) == limit {
+			break
+		}
+		if sq.Matches(note) {
+			notes = append(notes, note)
+		}
+	}
+	return notes, nil
+}
+
+// maxTypos is the amount of typos allowed when matching the term.
+// Short terms must match exactly.
+func maxTypos(term string) int {
+	switch n := len([]rune(term)); {
+	case n <= 3:
+		return 0
+	case n <= 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// fuzzyContains checks if any of the words matches the term, allowing typos.
+func fuzzyContains(words []string, term string) bool {
+	typos := maxTypos(term)
+	if typos == 0 {
+		return false
+	}
+	for _, word := range words {
+		if editDistance(word, term) <= typos {
+			return true
+		}
+	}
+	return false
+}
+
+// editDistance returns the amount of single character insertions, deletions,
+// substitutions and swaps of adjacent characters that turn a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// d[i][j] is the distance between ra[:i] and rb[:j].
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = minInt(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = minInt(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
+
+// minInt returns the smallest of the values.
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// SnippetRadius is the amount of characters shown around the first match.
+const SnippetRadius = 60
+
+// Snippet returns the part of the body around the first match, with the
+// matching text wrapped in <mark>. The rest of the text is escaped.
+func (sq SearchQuery) Snippet(body string) template.HTML {
+	runes := []rune(body)
+	matches := sq.matchRanges(runes)
+
+	// Show the window around the first match, or the start of the body.
+	start, end := 0, len(runes)
+	if len(matches) > 0 {
+		start = matches[0][0] - SnippetRadius
+		end = matches[0][1] + SnippetRadius
+	} else {
+		end = 2 * SnippetRadius
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	b := strings.Builder{}
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, m := range matches {
+		if m[1] <= pos || m[0] >= end {
+			continue
+		}
+		if m[0] > pos {
+			b.WriteString(template.HTMLEscapeString(string(runes[pos:m[0]])))
+			pos = m[0]
+		}
+		stop := m[1]
+		if stop > end {
+			stop = end
+		}
+		b.WriteString("<mark>" + template.HTMLEscapeString(string(runes[pos:stop])) + "</mark>")
+		pos = stop
+	}
+	if pos < end {
+		b.WriteString(template.HTMLEscapeString(string(runes[pos:end])))
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+
+	return template.HTML(b.String())
+}
+
+// matchRanges returns the sorted, non-overlapping [start, end) rune ranges
+// of the body that match the terms and phrases.
+func (sq SearchQuery) matchRanges(runes []rune) [][2]int {
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	ranges := [][2]int{}
+	for _, needle := range append(append([]string{}, sq.Terms...), sq.Phrases...) {
+		n := []rune(needle)
+		for i := 0; i+len(n) <= len(lower); i++ {
+			if string(lower[i:i+len(n)]) == needle {
+				ranges = append(ranges, [2]int{i, i + len(n)})
+			}
+		}
+	}
+
+	// Words that match a term with typos.
+	for i := 0; i < len(lower); {
+		if !unicode.IsLetter(lower[i]) && !unicode.IsNumber(lower[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(lower) && (unicode.IsLetter(lower[j]) || unicode.IsNumber(lower[j])) {
+			j++
+		}
+		word := []string{string(lower[i:j])}
+		for _, term := range sq.Terms {
+			if fuzzyContains(word, term) {
+				ranges = append(ranges, [2]int{i, j})
+				break
+			}
+		}
+		i = j
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i][0] < ranges[j][0]
+	})
+
+	merged := [][2]int{}
+	for _, r := range ranges {
+		if last := len(merged) - 1; last >= 0 && r[0] <= merged[last][1] {
+			if r[1] > merged[last][1] {
+				merged[last][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
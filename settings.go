@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Settings limits and themes.
+const (
+	MaxPageSize = 200
+
+	ThemeAuto  = "auto" // follows the device
+	ThemeLight = "light"
+	ThemeDark  = "dark"
+)
+
+// DateFormat is a way of showing the dates of Notes. The dates are
+// always entered and stored the same way, see NoteDateFormat.
+type DateFormat struct {
+	Name     string
+	Date     string // e.g. in the day headers of the Note list
+	Time     string
+	DateTime string // e.g. in the timeline and the digest
+}
+
+// DateFormats are the date formats offered in the settings.
+var DateFormats = []DateFormat{
+	{"us", NotePartialDateFormat, NotePartialTimeFormat, NoteDateFormat},
+	{"international", "_2 January 2006", "15:04", "_2 Jan 2006 15:04"},
+	{"iso", "2006-01-02", "15:04", "2006-01-02 15:04"},
+}
+
+// Example formats a fixed date, for the settings page.
+func (df DateFormat) Example() string {
+	return formatDate(time.Date(2021, 3, 4, 15, 20, 0, 0, time.UTC), df.DateTime)
+}
+
+// DefaultSettings are used until the settings are first saved.
+var DefaultSettings = Settings{
+	Timezone:   "America/New_York",
+	PageSize:   30,
+	NoteSort:   "date:desc",
+	Theme:      ThemeAuto,
+	DateFormat: LocaleDateFormat,
+	Locale:     "en",
+}
+
+//
+// ------------------------------------------------------------------
+// Settings
+// ------------------------------------------------------------------
+//
+
+// Settings is the model for the `settings` table, which has a single
+// row once the settings are saved at /settings.
+type Settings struct {
+	ID        uint `gorm:"primarykey"`
+	UpdatedAt time.Time
+
+	// Timezone is the time zone of the Notes' dates, which are stored
+	// as wall clock times. Changing it doesn't change the dates of the
+	// Notes, only the time that new Notes default to.
+	Timezone string
+
+	PageSize   int    // Notes on the index page
+	NoteSort   string // order of the Note list, e.g. "date:desc", see NoteSort
+	Theme      string // "auto", "light" or "dark"
+	DateFormat string // name of one of the DateFormats, or LocaleDateFormat
+	Locale     string // name of one of the Locales
+}
+
+// settings are the current Settings, see currentSettings.
+var (
+	settingsMu sync.RWMutex
+	settings   = DefaultSettings
+)
+
+// currentSettings returns the current Settings. They are loaded when
+// the server is opened, and replaced when they are saved.
+func currentSettings() Settings {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return settings
+}
+
+// loadSettings reads the saved Settings, if any.
+func loadSettings(db *gorm.DB) error {
+	st := Settings{}
+	err := db.First(&st).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	settings = st
+	return nil
+}
+
+// Sort returns the NoteSort of the Note list, when no other is picked.
+func (st Settings) Sort() NoteSort {
+	parts := strings.SplitN(st.NoteSort, ":", 2)
+	if len(parts) == 2 {
+		if ns := (NoteSort{Field: parts[0], Dir: parts[1]}); ns.IsValid() {
+			return ns
+		}
+	}
+	return DefaultNoteSort
+}
+
+// Language returns the Locale of the settings.
+func (st Settings) Language() Locale {
+	return findLocale(st.Locale)
+}
+
+// Format returns the DateFormat of the settings.
+func (st Settings) Format() DateFormat {
+	if st.DateFormat == LocaleDateFormat {
+		return st.Language().DateFormat
+	}
+	for _, df := range DateFormats {
+		if df.Name == st.DateFormat {
+			return df
+		}
+	}
+	return DateFormats[0]
+}
+
+// Validate checks the settings, and returns what is wrong.
+func (st Settings) Validate() []string {
+	errs := []string{}
+	if _, err := time.LoadLocation(st.Timezone); err != nil || st.Timezone == "" {
+		errs = append(errs, fmt.Sprintf("Unknown time zone %q.", st.Timezone))
+	}
+	if st.PageSize < 1 || st.PageSize > MaxPageSize {
+		errs = append(errs, fmt.Sprintf("The page size must be between 1 and %v.", MaxPageSize))
+	}
+	if st.Sort().String() != st.NoteSort {
+		errs = append(errs, fmt.Sprintf("Unknown order %q.", st.NoteSort))
+	}
+	switch st.Theme {
+	case ThemeAuto, ThemeLight, ThemeDark:
+	default:
+		errs = append(errs, fmt.Sprintf("Unknown theme %q.", st.Theme))
+	}
+	if st.Format().Name != st.DateFormat {
+		errs = append(errs, fmt.Sprintf("Unknown date format %q.", st.DateFormat))
+	}
+	if st.Language().Name != st.Locale {
+		errs = append(errs, fmt.Sprintf("Unknown language %q.", st.Locale))
+	}
+	return errs
+}
+
+// settingsChanges describes the difference between two Settings, for
+// the audit log.
+func settingsChanges(before, after Settings) []string {
+	changes := []string{}
+	add := func(name string, before, after interface{}) {
+		if before != after {
+			changes = append(changes, fmt.Sprintf("%v: %v → %v", name, before, after))
+		}
+	}
+	add("timezone", before.Timezone, after.Timezone)
+	add("page size", before.PageSize, after.PageSize)
+	add("sort", before.NoteSort, after.NoteSort)
+	add("theme", before.Theme, after.Theme)
+	add("date format", before.DateFormat, after.DateFormat)
+	add("locale", before.Locale, after.Locale)
+	return changes
+}
+
+// saveSettings saves the Settings, and makes them the current ones.
+func saveSettings(db *gorm.DB, actor Actor, st Settings) error {
+	before := currentSettings()
+	st.ID = 1
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&st).Error; err != nil {
+			return err
+		}
+		return audit(tx, actor, AuditUpdate, "settings", st.ID, settingsChanges(before, st))
+	})
+	if err != nil {
+		return err
+	}
+
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	settings = st
+	return nil
+}
+
+// noteTimezone returns the time zone of the Notes' dates.
+func noteTimezone() string {
+	return currentSettings().Timezone
+}
+
+// displayDateTime formats the date and time of a Note for display.
+func displayDateTime(t time.Time) string {
+	return formatDate(t, currentSettings().Format().DateTime)
+}
+
+// displayDay formats a date for display, without the time.
+func displayDay(t time.Time) string {
+	return formatDate(t, currentSettings().Format().Date)
+}
+
+// SettingsContext provides context data to the settings page.
+type SettingsContext struct {
+	Settings    Settings
+	Errors      []string
+	Saved       bool
+	Timezones   []string
+	Sorts       []NoteSort
+	DateFormats []DateFormat
+	Locales     []Locale
+}
+
+// newSettingsContext returns the context of the settings page.
+func newSettingsContext(st Settings) SettingsContext {
+	sorts := []NoteSort{}
+	for _, field := range []string{"date", "created", "updated"} {
+		sorts = append(sorts, NoteSort{Field: field, Dir: "desc"}, NoteSort{Field: field, Dir: "asc"})
+	}
+	// A time zone that isn't in the list, e.g. from an invalid form,
+	// is offered too.
+	timezones := Timezones
+	found := false
+	for _, tz := range timezones {
+		found = found || tz == st.Timezone
+	}
+	if !found {
+		timezones = append([]string{st.Timezone}, timezones...)
+	}
+	return SettingsContext{
+		Settings:    st,
+		Timezones:   timezones,
+		Sorts:       sorts,
+		DateFormats: append([]DateFormat{st.Language().DateFormat}, DateFormats...),
+		Locales:     Locales,
+	}
+}
+
+// HandleSettings serves the settings page.
+func (s *Server) HandleSettings(w http.ResponseWriter, r *http.Request) {
+	requestContext := newSettingsContext(currentSettings())
+	requestContext.Saved = r.URL.Query().Get("saved") != ""
+	s.render(w, r, "settings", requestContext)
+}
+
+// HandleSettingsUpdate saves the settings.
+func (s *Server) HandleSettingsUpdate(w http.ResponseWriter, r *http.Request) {
+	pageSize, _ := strconv.Atoi(r.FormValue("page_size"))
+	st := Settings{
+		Timezone:   strings.TrimSpace(r.FormValue("timezone")),
+		PageSize:   pageSize,
+		NoteSort:   r.FormValue("sort"),
+		Theme:      r.FormValue("theme"),
+		DateFormat: r.FormValue("date_format"),
+		Locale:     r.FormValue("locale"),
+	}
+
+	if errs := st.Validate(); len(errs) > 0 {
+		requestContext := newSettingsContext(st)
+		requestContext.Errors = errs
+		w.WriteHeader(http.StatusBadRequest)
+		s.render(w, r, "settings", requestContext)
+		return
+	}
+
+	if err := saveSettings(s.DB, requestActor(r, "web"), st); err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	http.Redirect(w, r, "/settings?saved=1", http.StatusFound)
+}
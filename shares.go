@@ -0,0 +1,219 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+// ShareMaxExpiry is the longest expiry of a share link.
+const ShareMaxExpiry = 365 * 24 * time.Hour
+
+// WatermarkTiles is how often the watermark is repeated on the page.
+const WatermarkTiles = 24
+
+//
+// ------------------------------------------------------------------
+// Share links
+// ------------------------------------------------------------------
+//
+
+// Share is the model for the `shares` table. A Share is a public link
+// to a single Note, at /s/{token}, until it expires or is revoked.
+//
+// Watermarked shares show the viewer's IP address and the date across
+// the page, and don't serve the raw text, to discourage copying.
+type Share struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	NoteID    uint
+	Token     string
+	ExpiresAt *time.Time // nil never expires
+	Watermark bool
+}
+
+// Expired reports whether the Share has expired.
+func (sh Share) Expired() bool {
+	return sh.ExpiresAt != nil && time.Now().After(*sh.ExpiresAt)
+}
+
+// newShareToken returns a random, unguessable token.
+func newShareToken() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HandleShareCreate adds a share link to a Note, with the expiry
+// (a duration, e.g. 24h, or empty for none) and watermark of the form.
+func (s *Server) HandleShareCreate(w http.ResponseWriter, r *http.Request) {
+	note := Note{}
+	if err := s.DB.First(&note, chi.URLParam(r, "noteID")).Error; err != nil {
+		s.renderError(w, r, ErrNoteNotFound, err)
+		return
+	}
+
+	token, err := newShareToken()
+	if err != nil {
+		s.renderError(w, r, ErrInternal, err)
+		return
+	}
+	share := Share{NoteID: note.ID, Token: token, Watermark: r.FormValue("watermark") != ""}
+
+	if expiry := r.FormValue("expiry"); expiry != "" {
+		d, err := time.ParseDuration(expiry)
+		if err != nil || d <= 0 || d > ShareMaxExpiry {
+			s.renderError(w, r, ErrBadRequest, fmt.Errorf("invalid share expiry %q", expiry))
+			return
+		}
+		expiresAt := time.Now().Add(d)
+		share.ExpiresAt = &expiresAt
+	}
+
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&share).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("note: \"\" → \"%v\"", note.ID)}
+		return audit(tx, requestActor(r, "web"), AuditCreate, "share", share.ID, changes)
+	})
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/note/%v/change", note.ID), http.StatusFound)
+}
+
+// HandleShareDelete revokes a share link.
+func (s *Server) HandleShareDelete(w http.ResponseWriter, r *http.Request) {
+	share := Share{}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&share, chi.URLParam(r, "shareID")).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&share).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("note: \"%v\" → \"\"", share.NoteID)}
+		return audit(tx, requestActor(r, "web"), AuditDelete, "share", share.ID, changes)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/note/%v/change", share.NoteID), http.StatusFound)
+}
+
+// ShareContext provides context data to the public share page.
+type ShareContext struct {
+	Error     string
+	Token     string
+	Note      *Note
+	Watermark []string // the watermark text, once per tile
+	Viewer    string   // the viewer's IP address and the date
+}
+
+// HandleShare serves the public page of a share link.
+func (s *Server) HandleShare(w http.ResponseWriter, r *http.Request) {
+	share, note, ok := s.sharedNote(w, r)
+	if !ok {
+		return
+	}
+
+	requestContext := ShareContext{Token: share.Token, Note: &note}
+	if share.Watermark {
+		requestContext.Viewer = fmt.Sprintf("%v, %v", s.clientIP(r), localNow().Format("January 2, 2006 3:04 PM"))
+		for i := 0; i < WatermarkTiles; i++ {
+			requestContext.Watermark = append(requestContext.Watermark, requestContext.Viewer)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	s.render(w, r, "share", requestContext)
+}
+
+// HandleShareRaw serves the body of a shared Note as plain text,
+// unless the share is watermarked.
+func (s *Server) HandleShareRaw(w http.ResponseWriter, r *http.Request) {
+	share, note, ok := s.sharedNote(w, r)
+	if !ok {
+		return
+	}
+	if share.Watermark {
+		s.renderShareError(w, r, ErrShareRaw, nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(note.Body))
+}
+
+// sharedNote returns the Share of the request's token, and its Note.
+// If the link is unknown, revoked or expired, the error page is served.
+func (s *Server) sharedNote(w http.ResponseWriter, r *http.Request) (Share, Note, bool) {
+	// Share links shouldn't show up in search engines.
+	w.Header().Set("X-Robots-Tag", "noindex")
+
+	share, note := Share{}, Note{}
+	if err := s.ReadDB.Where("token = ?", chi.URLParam(r, "token")).First(&share).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logError(r, ErrDatabase, err)
+		}
+		s.renderShareError(w, r, ErrShareNotFound, nil)
+		return share, note, false
+	}
+	if share.Expired() {
+		s.renderShareError(w, r, ErrShareExpired, nil)
+		return share, note, false
+	}
+	// Deleted Notes are not found, their links work again on undo.
+	if err := s.ReadDB.First(&note, share.NoteID).Error; err != nil {
+		s.renderShareError(w, r, ErrShareNotFound, err)
+		return share, note, false
+	}
+	return share, note, true
+}
+
+// renderShareError serves the share page with the error. The error
+// page isn't used, its header shows data that needs a login.
+func (s *Server) renderShareError(w http.ResponseWriter, r *http.Request, code ErrorCode, err error) {
+	logError(r, code, err)
+	w.WriteHeader(code.Status)
+	s.render(w, r, "share", ShareContext{Error: code.Message})
+}
+
+// clientIP returns the IP address of the client. Behind a trusted
+// proxy, the address is read from the X-Forwarded-For header.
+func (s *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	proxies, err := parseTrustedProxies(s.Config.TrustedProxies)
+	if err != nil || !fromProxy(r, proxies) {
+		return host
+	}
+	// The proxy appends the address it received the request from.
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); net.ParseIP(ip) != nil {
+		return ip
+	}
+	return host
+}
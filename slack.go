@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// ------------------------------------------------------------------
+// Slack
+// ------------------------------------------------------------------
+//
+
+// SlackResponse is the reply to a slash command. Ephemeral replies
+// are only shown to the user of the command.
+type SlackResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// HandleSlackCommand creates a Note with a Slack slash command, e.g.
+// `/note "remember to water the plants #home"`.
+func (s *Server) HandleSlackCommand(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		writeAPIError(w, r, ErrBadRequest, err)
+		return
+	}
+	if err := s.verifySlack(r.Header.Get("X-Slack-Request-Timestamp"), r.Header.Get("X-Slack-Signature"), body); err != nil {
+		writeAPIError(w, r, ErrInbound, err)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	text := strings.Trim(strings.TrimSpace(r.FormValue("text")), `"“”`)
+	if text == "" {
+		writeJSON(w, http.StatusOK, SlackResponse{"ephemeral", fmt.Sprintf("Usage: %v <note text, #hashtags become tags>", r.FormValue("command"))})
+		return
+	}
+
+	form := messageForm("", text)
+	if !form.IsValid() {
+		writeJSON(w, http.StatusOK, SlackResponse{"ephemeral", "The note was not saved: " + strings.Join(form.Errors, ", ")})
+		return
+	}
+
+	note, err := s.createNote(requestActor(r, "slack"), &form)
+	if err != nil {
+		logError(r, ErrDatabase, err)
+		writeJSON(w, http.StatusOK, SlackResponse{"ephemeral", ErrDatabase.Message})
+		return
+	}
+	reply := fmt.Sprintf("Saved <%v/note/%v/change|%v>", s.Config.baseURL(), note.ID, note.DisplayTitle())
+	writeJSON(w, http.StatusOK, SlackResponse{"ephemeral", reply})
+}
+
+// verifySlack checks the signature of a Slack request, which is
+// signed with the signing secret of the Slack app.
+func (s *Server) verifySlack(timestamp, signature string, body []byte) error {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > InboundMaxAge || age < -InboundMaxAge {
+		return errors.New("signature expired")
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.Config.SlackSigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	if !hmac.Equal([]byte(signature), []byte("v0="+hex.EncodeToString(mac.Sum(nil)))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+//
+// ------------------------------------------------------------------
+// Starred notes
+// ------------------------------------------------------------------
+//
+
+// HandleNoteStar stars a Note, or unstars a starred one, and goes back
+// to the page it was starred on. Like archiving, starring doesn't
+// change the Note's updated time.
+func (s *Server) HandleNoteStar(w http.ResponseWriter, r *http.Request) {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		note := Note{}
+		if err := tx.First(&note, chi.URLParam(r, "noteID")).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&note).UpdateColumn("starred", !note.Starred).Error; err != nil {
+			return err
+		}
+		changes := []string{fmt.Sprintf("starred: %v → %v", note.Starred, !note.Starred)}
+		return audit(tx, requestActor(r, "web"), AuditUpdate, "note", note.ID, changes)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrNoteNotFound, err)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	next := "/"
+	if u, err := url.Parse(r.Referer()); err == nil && u.Host == r.Host {
+		next = u.RequestURI()
+	}
+	http.Redirect(w, r, next, http.StatusFound)
+}
+
+// HandleStarred serves the starred Notes, newest first, including
+// archived ones. Drafts and pending scheduled Notes are left out.
+func (s *Server) HandleStarred(w http.ResponseWriter, r *http.Request) {
+	requestContext := IndexContext{Sort: DefaultNoteSort}
+
+	err := notScheduled(s.ReadDB.Preload("Tags")).
+		Where("starred").
+		Where("not draft").
+		Order(DefaultNoteSort.OrderBy()).
+		Find(&requestContext.Notes).Error
+	if err == nil {
+		requestContext.AutoTags, err = autoTags(s.ReadDB, requestContext.Notes)
+	}
+	if err == nil && s.Config.LinkPreviews {
+		requestContext.Previews, err = linkPreviews(s.ReadDB, requestContext.Notes)
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	s.render(w, r, "starred", requestContext)
+}
@@ -0,0 +1,67 @@
+// The service worker of Simple Notes. It keeps the pages that were
+// opened, so they can be read offline, and serves the offline page for
+// the others. Changes to notes still need a connection.
+
+const VERSION = "v1";
+const ASSETS = "assets-" + VERSION;
+const PAGES = "pages-" + VERSION;
+const OFFLINE = "/static/pwa/offline.html";
+
+self.addEventListener("install", event => {
+    event.waitUntil(
+        caches.open(ASSETS)
+            .then(cache => cache.addAll([OFFLINE, "/static/css/new.min.css", "/static/css/style.css", "/static/pwa/icon.svg"]))
+            .then(() => self.skipWaiting())
+    );
+});
+
+// Caches of older versions are removed.
+self.addEventListener("activate", event => {
+    event.waitUntil(
+        caches.keys()
+            .then(keys => Promise.all(keys.filter(key => key !== ASSETS && key !== PAGES).map(key => caches.delete(key))))
+            .then(() => self.clients.claim())
+    );
+});
+
+self.addEventListener("fetch", event => {
+    const request = event.request;
+    const url = new URL(request.url);
+    if (request.method !== "GET" || url.origin !== location.origin) {
+        return;
+    }
+
+    // The saved pages are someone's notes, so they are removed on logout.
+    if (url.pathname === "/logout") {
+        event.respondWith(caches.delete(PAGES).then(() => fetch(request)));
+        return;
+    }
+
+    // Assets are served from the cache, and saved on first use.
+    if (url.pathname.startsWith("/static/")) {
+        event.respondWith(
+            caches.match(request).then(cached => cached || fetch(request).then(response => {
+                if (response.ok) {
+                    const copy = response.clone();
+                    caches.open(ASSETS).then(cache => cache.put(request, copy));
+                }
+                return response;
+            }))
+        );
+        return;
+    }
+
+    // Pages are loaded from the network, and saved for offline use.
+    // The login page and other redirects are not saved.
+    if (request.mode === "navigate") {
+        event.respondWith(
+            fetch(request).then(response => {
+                if (response.ok && !response.redirected) {
+                    const copy = response.clone();
+                    caches.open(PAGES).then(cache => cache.put(request, copy));
+                }
+                return response;
+            }).catch(() => caches.match(request).then(cached => cached || caches.match(OFFLINE)))
+        );
+    }
+});
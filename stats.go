@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// StatsTopTags is the amount of tags shown on the stats page.
+const StatsTopTags = 10
+
+//
+// ------------------------------------------------------------------
+// Stats
+// ------------------------------------------------------------------
+//
+
+// MonthCount is the amount of Notes dated in a month.
+type MonthCount struct {
+	Month string // YYYY-MM
+	Notes int
+}
+
+// StatsContext provides context data to the stats page.
+type StatsContext struct {
+	Total             int64
+	Months            []MonthCount
+	TopTags           []TagCount
+	Words             int64 // in all Notes
+	AverageWords      int
+	AverageCharacters int
+	LongestStreak     int // consecutive days with Notes
+	MaxMonth          int // the busiest month, to scale the chart
+}
+
+// StatsJSON is the API representation of the stats.
+type StatsJSON struct {
+	Notes             int64 `json:"notes"`
+	Words             int64 `json:"words"`
+	AverageWords      int   `json:"average_words"`
+	AverageCharacters int   `json:"average_characters"`
+	LongestStreak     int   `json:"longest_streak"`
+}
+
+// HandleStats serves the stats page.
+func (s *Server) HandleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := noteStats(s.ReadDB)
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	s.render(w, r, "stats", stats)
+}
+
+// HandleAPIStats returns the totals and averages of the stats.
+func (s *Server) HandleAPIStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := noteStats(s.ReadDB)
+	if err != nil {
+		writeAPIError(w, r, ErrDatabase, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, StatsJSON{
+		Notes:             stats.Total,
+		Words:             stats.Words,
+		AverageWords:      stats.AverageWords,
+		AverageCharacters: stats.AverageCharacters,
+		LongestStreak:     stats.LongestStreak,
+	})
+}
+
+// noteStats computes the stats. Everything is aggregated in SQL,
+// the word and character counts are stored with each Note.
+func noteStats(db *gorm.DB) (StatsContext, error) {
+	stats := StatsContext{}
+
+	if err := db.Model(&Note{}).Count(&stats.Total).Error; err != nil {
+		return stats, err
+	}
+
+	err := db.Raw(`
+		select strftime('%Y-%m', date) as month, count(*) as notes
+		from notes
+		where deleted_at is null
+		group by month
+		order by month;
+	`).Scan(&stats.Months).Error
+	if err != nil {
+		return stats, err
+	}
+	for _, m := range stats.Months {
+		if m.Notes > stats.MaxMonth {
+			stats.MaxMonth = m.Notes
+		}
+	}
+
+	err = db.Raw(`
+		select t.name, count(distinct n.id) as notes
+		from tags t
+		join note_tag nt on nt.tag_id = t.id
+		join notes n on n.id = nt.note_id and n.deleted_at is null
+		where t.deleted_at is null
+		group by t.name
+		order by notes desc, t.name
+		limit ?;
+	`, StatsTopTags).Scan(&stats.TopTags).Error
+	if err != nil {
+		return stats, err
+	}
+
+	// Days in a streak have the same difference between the
+	// day number and their row number (gaps and islands).
+	err = db.Raw(`
+		with days as (
+			select distinct date(date) as day from notes where deleted_at is null
+		), islands as (
+			select julianday(day) - row_number() over (order by day) as island from days
+		)
+		select coalesce(max(length), 0) from (
+			select count(*) as length from islands group by island
+		);
+	`).Scan(&stats.LongestStreak).Error
+	if err != nil {
+		return stats, err
+	}
+
+	// Averages are rounded, like the chart they are approximate.
+	counts := struct {
+		Words             int64
+		AverageWords      float64
+		AverageCharacters float64
+	}{}
+	err = db.Raw(`
+		select coalesce(sum(words), 0) as words,
+			coalesce(avg(words), 0) as average_words,
+			coalesce(avg(characters), 0) as average_characters
+		from notes
+		where deleted_at is null;
+	`).Scan(&counts).Error
+	stats.Words = counts.Words
+	stats.AverageWords = int(counts.AverageWords + 0.5)
+	stats.AverageCharacters = int(counts.AverageCharacters + 0.5)
+	return stats, err
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gorm.io/gorm"
+)
+
+// SyncDelay is how long the sync waits for a file to stop changing,
+// as editors often write a file in several steps.
+const SyncDelay = 500 * time.Millisecond
+
+//
+// ------------------------------------------------------------------
+// Directory sync
+// ------------------------------------------------------------------
+//
+
+// DirSync keeps a directory with a Markdown file per Note, named by
+// its id, e.g. "12.md", in the format of the GitMirror. It syncs both
+// ways: changed Notes are written to their files, and files changed
+// in an editor are saved to their Notes.
+//
+// A new .md file, with any name, creates a Note and is renamed to its
+// id. Deleting a file deletes its Note, which can be undone like any
+// deletion.
+type DirSync struct {
+	s   *Server
+	dir string
+}
+
+// NewDirSync opens the SyncDir directory. It is created when it
+// doesn't exist.
+func NewDirSync(s *Server) (*DirSync, error) {
+	d := &DirSync{s: s, dir: s.Config.SyncDir}
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Run syncs the directory, then the changes of the Notes and the files
+// until the server stops.
+func (d *DirSync) Run() {
+	events := d.s.Events.Subscribe()
+	defer d.s.Events.Unsubscribe(events)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		err = watcher.Add(d.dir)
+	}
+	if err != nil {
+		log.Printf("[sync] %v", err)
+		return
+	}
+
+	if err := d.Sync(); err != nil {
+		log.Printf("[sync] %v", err)
+	}
+
+	// Changed files are read once they stop changing for SyncDelay.
+	changed := map[string]bool{}
+	timer := time.NewTimer(SyncDelay)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := d.writeNote(event.NoteID); err != nil {
+				log.Printf("[sync] note %v: %v", event.NoteID, err)
+			}
+		case event := <-watcher.Events:
+			if filepath.Ext(event.Name) == ".md" {
+				changed[filepath.Base(event.Name)] = true
+				timer.Reset(SyncDelay)
+			}
+		case err := <-watcher.Errors:
+			log.Printf("[sync] %v", err)
+		case <-timer.C:
+			names := []string{}
+			for name := range changed {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if err := d.readFile(name); err != nil {
+					log.Printf("[sync] %v: %v", name, err)
+				}
+			}
+			changed = map[string]bool{}
+		}
+	}
+}
+
+// Sync brings the directory and the Notes up to date, after changes
+// made while the server was stopped: files that are newer than their
+// Note are saved to it, and all other Notes are written to their files.
+func (d *DirSync) Sync() error {
+	files, err := filepath.Glob(filepath.Join(d.dir, "*.md"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		note, err := d.note(name)
+		if err == nil {
+			info, err := os.Stat(file)
+			if err != nil || !info.ModTime().After(note.UpdatedAt) {
+				continue
+			}
+		}
+		if err := d.readFile(name); err != nil {
+			log.Printf("[sync] %v: %v", name, err)
+		}
+	}
+
+	ids := []uint{}
+	if err := d.s.DB.Model(&Note{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := d.writeNote(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// note returns the Note of the file name, e.g. "12.md".
+func (d *DirSync) note(name string) (Note, error) {
+	note := Note{}
+	id, err := strconv.ParseUint(strings.TrimSuffix(name, ".md"), 10, 64)
+	if err != nil {
+		return note, gorm.ErrRecordNotFound
+	}
+	err = d.s.DB.Preload("Tags").First(&note, id).Error
+	return note, err
+}
+
+// writeNote writes the file of the Note, or removes it when the Note
+// was deleted. Files that are up to date are left as is. Notes that
+// expire have no file, so they aren't left on disk once purged.
+func (d *DirSync) writeNote(id uint) error {
+	path := filepath.Join(d.dir, fmt.Sprintf("%v.md", id))
+	note, err := d.note(filepath.Base(path))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && note.ExpiresAt != nil) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	content := gitMirrorFile(note)
+	if current, err := ioutil.ReadFile(path); err == nil && bytes.Equal(current, content) {
+		return nil
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
+
+// readFile saves the file to its Note. A new file creates a Note, and
+// is renamed to its id. A removed file deletes its Note.
+func (d *DirSync) readFile(name string) error {
+	actor := Actor{Source: "sync"}
+	path := filepath.Join(d.dir, name)
+	note, noteErr := d.note(name)
+	if noteErr != nil && !errors.Is(noteErr, gorm.ErrRecordNotFound) {
+		return noteErr
+	}
+	// Expiring Notes aren't synced, their file was removed on purpose.
+	if noteErr == nil && note.ExpiresAt != nil {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		if noteErr == nil {
+			return d.s.deleteNote(actor, fmt.Sprint(note.ID))
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	form, err := parseNoteFile(content)
+	if err != nil {
+		return err
+	}
+	if !form.IsValid() {
+		return errors.New(strings.Join(form.Errors, ", "))
+	}
+
+	// A new file, or the file of a deleted Note.
+	if noteErr != nil {
+		note, err := d.s.createNote(actor, &form)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		return d.writeNote(note.ID)
+	}
+
+	if !noteFileChanged(note, form) {
+		return nil
+	}
+	return d.s.updateNote(actor, &note, &form)
+}
+
+// parseNoteFile reads a Note file, with its date, tags and monospace
+// and scheduled flags in the front matter, see gitMirrorFile. A file without front
+// matter is only a body, dated now.
+func parseNoteFile(content []byte) (NoteForm, error) {
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+	if !strings.HasPrefix(text, "---\n") {
+		return messageForm("", text), nil
+	}
+
+	end := strings.Index(text[4:], "\n---\n")
+	if end < 0 {
+		return NoteForm{}, errors.New("the front matter is not closed by ---")
+	}
+	form := messageForm("", text[4+end+5:])
+	form.Tags = ""
+	for _, line := range strings.Split(text[4:4+end], "\n") {
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch strings.TrimSpace(line[:i]) {
+		case "date":
+			// The date is the Note's wall clock time, the offset is
+			// ignored.
+			date, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return form, fmt.Errorf("invalid date %q", value)
+			}
+			form.Date = date.Format(NotePartialDateFormat)
+			form.Time = date.Format("15:04:05")
+		case "tags":
+			form.Tags = strings.Trim(value, "[]")
+		case "monospace":
+			form.Monospace = value == "true"
+		case "scheduled":
+			form.Scheduled = value == "true"
+		}
+	}
+	return form, nil
+}
+
+// noteFileChanged reports whether the valid form of a Note file differs
+// from the Note.
+func noteFileChanged(note Note, form NoteForm) bool {
+	if string(form.cleanedBody) != string(note.Body) || form.Monospace != note.Monospace ||
+		form.Scheduled != note.Scheduled || !form.cleanedDateTime.Equal(note.Date) {
+		return true
+	}
+
+	tags := map[string]bool{}
+	for _, tag := range note.Tags {
+		tags[tag.Name] = true
+	}
+	if len(tags) != len(form.cleanedTags) {
+		return true
+	}
+	for _, tag := range form.cleanedTags {
+		if !tags[tag.Name] {
+			return true
+		}
+	}
+	return false
+}
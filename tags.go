@@ -0,0 +1,626 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+// Bulk tag operations.
+const (
+	TagOpRename    = "rename"    // rename the matching tags, e.g. proj-* to project/*
+	TagOpLowercase = "lowercase" // lowercase the matching tags
+)
+
+// TagSeparator separates the levels of nested tags, e.g.
+// project/simplenotes is a child of project.
+const TagSeparator = "/"
+
+// tagColor matches the colors of tags, as sent by color inputs.
+var tagColor = regexp.MustCompile(`^#[0-9a-f]{6}$`)
+
+// Stale tag policies. A tag is stale when no Note uses it.
+const (
+	StaleTagsDelete = "delete" // delete stale tags when Notes are saved
+	StaleTagsKeep   = "keep"   // keep stale tags, e.g. as a controlled vocabulary
+	StaleTagsReview = "review" // keep stale tags, and flag them on the tags page
+)
+
+//
+// ------------------------------------------------------------------
+// Tags
+// ------------------------------------------------------------------
+//
+
+// TagCount is a Tag with the amount of Notes that use it.
+type TagCount struct {
+	ID    uint
+	Name  string
+	Color string
+	Notes int
+}
+
+// Style returns the css of the Tag's chip, with a text color that can
+// be read on its color. Tags without a color use the default style.
+func (t Tag) Style() template.CSS {
+	if !tagColor.MatchString(t.Color) {
+		return ""
+	}
+	var r, g, b int
+	fmt.Sscanf(t.Color, "#%02x%02x%02x", &r, &g, &b)
+	text := "#ffffff"
+	if r*299+g*587+b*114 > 150*1000 {
+		text = "#1f2937"
+	}
+	return template.CSS(fmt.Sprintf("background: %v; color: %v;", t.Color, text))
+}
+
+// Style returns the css of the tag's chip, see Tag.Style.
+func (t TagCount) Style() template.CSS {
+	return Tag{Color: t.Color}.Style()
+}
+
+// DescriptionHTML renders the description like a Note body.
+func (t Tag) DescriptionHTML() template.HTML {
+	return renderNoteBody(EncryptedText(t.Description))
+}
+
+// AboutURL returns the link to the Tag's about page. The slashes of
+// nested tags are escaped, so the name is a single path segment.
+func (t Tag) AboutURL() string {
+	return "/tag/" + url.PathEscape(t.Name) + "/about"
+}
+
+// AboutURL returns the link to the tag's about page.
+func (t TagCount) AboutURL() string {
+	return Tag{Name: t.Name}.AboutURL()
+}
+
+// formTags returns the existing Tags of the comma separated names of a
+// form, in order, for their chips.
+func (s *Server) formTags(r *http.Request, names string) []Tag {
+	cleaned := []string{}
+	for _, name := range strings.Split(names, ",") {
+		if name = cleanTagName(name); name != "" {
+			cleaned = append(cleaned, name)
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil
+	}
+
+	found := []Tag{}
+	if err := s.ReadDB.Where("name in ?", cleaned).Find(&found).Error; err != nil {
+		logError(r, ErrDatabase, err)
+		return nil
+	}
+	byName := map[string]Tag{}
+	for _, tag := range found {
+		byName[tag.Name] = tag
+	}
+	tags := []Tag{}
+	for _, name := range cleaned {
+		if tag, ok := byName[name]; ok {
+			tags = append(tags, tag)
+			delete(byName, name)
+		}
+	}
+	return tags
+}
+
+// TagNode is a Tag on the tags page, nested under its parent.
+// Parents that no Note is tagged with directly have no Tag, and an ID
+// of 0, e.g. project for project/simplenotes.
+type TagNode struct {
+	TagCount
+	Label string // the last level of the name
+	Depth int
+}
+
+// cleanTagName lowercases the tag name, and removes the spaces and
+// empty levels of nested tags, e.g. "Project / Notes/" is project/notes.
+func cleanTagName(name string) string {
+	levels := []string{}
+	for _, level := range strings.Split(strings.ToLower(name), TagSeparator) {
+		if level = strings.Trim(level, " "); level != "" {
+			levels = append(levels, level)
+		}
+	}
+	return strings.Join(levels, TagSeparator)
+}
+
+// findOrCreateTags returns the saved Tags with the names of the tags,
+// in order and without duplicates, and creates the ones that don't
+// exist yet. Tags are unique by name, so all Notes with a tag share
+// its color and description.
+func findOrCreateTags(tx *gorm.DB, tags []Tag) ([]Tag, error) {
+	found := []Tag{}
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		if seen[tag.Name] {
+			continue
+		}
+		seen[tag.Name] = true
+		existing := Tag{}
+		if err := tx.Where(Tag{Name: tag.Name}).FirstOrCreate(&existing).Error; err != nil {
+			return nil, err
+		}
+		found = append(found, existing)
+	}
+	return found, nil
+}
+
+// mergeTag moves the Notes of a Tag to another Tag, and deletes it.
+// Notes that already have both keep one.
+func mergeTag(tx *gorm.DB, fromID, toID uint) error {
+	err := tx.Exec(`
+		insert or ignore into note_tag (note_id, tag_id, auto)
+		select note_id, ?, auto from note_tag where tag_id = ?;
+	`, toID, fromID).Error
+	if err != nil {
+		return err
+	}
+	if err := tx.Exec("delete from note_tag where tag_id = ?", fromID).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&Tag{}, fromID).Error
+}
+
+// tagTree orders the tags by level, each followed by its children.
+// Missing parents are added.
+func tagTree(tags []TagCount) []TagNode {
+	sorted := append([]TagCount{}, tags...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a := strings.Split(sorted[i].Name, TagSeparator)
+		b := strings.Split(sorted[j].Name, TagSeparator)
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	nodes := []TagNode{}
+	seen := map[string]bool{}
+	for _, tag := range sorted {
+		levels := strings.Split(tag.Name, TagSeparator)
+		for depth := range levels[:len(levels)-1] {
+			parent := strings.Join(levels[:depth+1], TagSeparator)
+			if !seen[parent] {
+				seen[parent] = true
+				nodes = append(nodes, TagNode{TagCount{Name: parent}, levels[depth], depth})
+			}
+		}
+		seen[tag.Name] = true
+		nodes = append(nodes, TagNode{tag, levels[len(levels)-1], len(levels) - 1})
+	}
+	return nodes
+}
+
+// TagsContext provides context data to the tags page.
+type TagsContext struct {
+	Tags    []TagNode
+	Review  bool // flag stale tags for review
+	Bulk    TagBulkForm
+	Preview []TagRename // the tags the bulk operation changes
+	Rules   []TagRule
+}
+
+// TagBulkForm is a bulk operation on the Tags that match a pattern.
+// A * in the pattern matches any text, which the * in the
+// replacement of a rename is substituted with, in order.
+type TagBulkForm struct {
+	Op          string
+	Pattern     string
+	Replacement string
+	Error       string
+}
+
+// TagRename is a change of a Tag name by a bulk operation.
+type TagRename struct {
+	ID    uint
+	From  string
+	To    string
+	Notes int
+}
+
+// renames returns the changes of the bulk operation to the tags.
+// Tags that don't match, or that keep their name, are left out.
+func (form TagBulkForm) renames(tags []TagCount) ([]TagRename, error) {
+	if form.Pattern == "" {
+		return nil, errors.New("Pattern is required")
+	}
+	parts := strings.Split(form.Pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	pattern := regexp.MustCompile("^" + strings.Join(parts, "(.*)") + "$")
+
+	if form.Op == TagOpRename && strings.Count(form.Replacement, "*") > strings.Count(form.Pattern, "*") {
+		return nil, errors.New("Replacement has more * than the pattern")
+	}
+
+	renames := []TagRename{}
+	for _, tag := range tags {
+		match := pattern.FindStringSubmatch(tag.Name)
+		if match == nil {
+			continue
+		}
+
+		to := ""
+		switch form.Op {
+		case TagOpRename:
+			to = form.Replacement
+			for _, text := range match[1:] {
+				to = strings.Replace(to, "*", text, 1)
+			}
+		case TagOpLowercase:
+			to = strings.ToLower(tag.Name)
+		default:
+			return nil, fmt.Errorf("Unknown operation %q", form.Op)
+		}
+
+		// Names are normalized like the note form's, so the tag:
+		// search finds them. A tag renamed to an existing name is
+		// merged into it, see HandleTagBulk.
+		to = cleanTagName(to)
+		if to == "" || strings.Contains(to, ",") {
+			return nil, fmt.Errorf("Tag %q can't be renamed to %q", tag.Name, to)
+		}
+		if to != tag.Name {
+			renames = append(renames, TagRename{tag.ID, tag.Name, to, tag.Notes})
+		}
+	}
+	return renames, nil
+}
+
+// tagCounts returns all Tags, with the amount of Notes that use them.
+func tagCounts(db *gorm.DB) ([]TagCount, error) {
+	tags := []TagCount{}
+	err := db.Raw(`
+		select t.id, t.name, t.color, count(n.id) as notes
+		from tags t
+		left join note_tag nt on nt.tag_id = t.id
+		left join notes n on n.id = nt.note_id and n.deleted_at is null
+		where t.deleted_at is null
+		group by t.id, t.name, t.color
+		order by t.name;
+	`).Scan(&tags).Error
+	return tags, err
+}
+
+// cleanupTags applies the stale tag policy after Notes are changed.
+// Stale tags are deleted unless the policy says to keep them.
+func (s *Server) cleanupTags(tx *gorm.DB) error {
+	switch s.Config.StaleTags {
+	case StaleTagsKeep, StaleTagsReview:
+		return nil
+	default:
+		return removeStaleTags(tx)
+	}
+}
+
+// HandleTagList serves the tags page. A bulk operation in the query
+// is previewed, with the tags it changes.
+func (s *Server) HandleTagList(w http.ResponseWriter, r *http.Request) {
+	tags, err := tagCounts(s.ReadDB)
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	rules := []TagRule{}
+	if err := s.ReadDB.Order("id").Find(&rules).Error; err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	requestContext := TagsContext{
+		Tags:   tagTree(tags),
+		Rules:  rules,
+		Review: s.Config.StaleTags == StaleTagsReview,
+		Bulk: TagBulkForm{
+			Op:          r.URL.Query().Get("op"),
+			Pattern:     r.URL.Query().Get("pattern"),
+			Replacement: r.URL.Query().Get("replacement"),
+		},
+	}
+	if requestContext.Bulk.Op != "" {
+		requestContext.Preview, err = requestContext.Bulk.renames(tags)
+		if err != nil {
+			requestContext.Bulk.Error = err.Error()
+		}
+	}
+
+	s.render(w, r, "tags", requestContext)
+}
+
+// HandleTagBulk applies a bulk operation to the Tags, in a single
+// transaction with an audit entry per renamed Tag. A Tag renamed to
+// the name of another is merged into it.
+func (s *Server) HandleTagBulk(w http.ResponseWriter, r *http.Request) {
+	form := TagBulkForm{
+		Op:          r.FormValue("op"),
+		Pattern:     r.FormValue("pattern"),
+		Replacement: r.FormValue("replacement"),
+	}
+
+	var invalid error
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		tags, err := tagCounts(tx)
+		if err != nil {
+			return err
+		}
+		renames, err := form.renames(tags)
+		if err != nil {
+			invalid = err
+			return err
+		}
+		for _, rename := range renames {
+			existing := Tag{}
+			err := tx.Where("name = ? and id != ?", rename.To, rename.ID).Take(&existing).Error
+			switch {
+			case err == nil:
+				err = mergeTag(tx, rename.ID, existing.ID)
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				err = tx.Model(&Tag{}).Where("id = ?", rename.ID).Update("name", rename.To).Error
+			}
+			if err != nil {
+				return err
+			}
+			changes := []string{fmt.Sprintf("name: %q → %q", rename.From, rename.To)}
+			if err := audit(tx, requestActor(r, "web"), AuditUpdate, "tag", rename.ID, changes); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if invalid != nil {
+		// Show the error in the preview.
+		query := url.Values{"op": {form.Op}, "pattern": {form.Pattern}, "replacement": {form.Replacement}}
+		http.Redirect(w, r, "/tags?"+query.Encode(), http.StatusFound)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	s.Counts.Clear()
+	http.Redirect(w, r, "/tags", http.StatusFound)
+}
+
+// TagCloudEntry is a tag in the tag cloud, weighted by its use.
+type TagCloudEntry struct {
+	Name   string  `json:"name"`
+	Notes  int     `json:"notes"`
+	Weight float64 `json:"weight"` // from 0 for the least used tag, to 1 for the most used
+	Color  string  `json:"color,omitempty"`
+}
+
+// FontSize returns the css font size of the entry, by its weight.
+func (e TagCloudEntry) FontSize() template.CSS {
+	return template.CSS(fmt.Sprintf("%.2fem", 0.8+e.Weight*1.6))
+}
+
+// tagCloud weighs the tags that are in use. The weight is logarithmic,
+// so a few big tags don't make all others tiny.
+func tagCloud(db *gorm.DB) ([]TagCloudEntry, error) {
+	cloud := []TagCloudEntry{}
+	err := db.Raw(`
+		select t.name, count(distinct n.id) as notes, max(t.color) as color
+		from tags t
+		inner join note_tag nt on nt.tag_id = t.id
+		inner join notes n on n.id = nt.note_id and n.deleted_at is null
+		where t.deleted_at is null
+		group by t.name
+		order by t.name;
+	`).Scan(&cloud).Error
+	if err != nil {
+		return nil, err
+	}
+
+	least, most := math.MaxInt32, 0
+	for _, entry := range cloud {
+		if entry.Notes < least {
+			least = entry.Notes
+		}
+		if entry.Notes > most {
+			most = entry.Notes
+		}
+	}
+	if most > least {
+		for i, entry := range cloud {
+			cloud[i].Weight = math.Log(float64(entry.Notes)/float64(least)) / math.Log(float64(most)/float64(least))
+		}
+	}
+	return cloud, nil
+}
+
+// HandleTagCloud serves the tag cloud, with the most used tags largest.
+func (s *Server) HandleTagCloud(w http.ResponseWriter, r *http.Request) {
+	cloud, err := tagCloud(s.ReadDB)
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	s.render(w, r, "tag-cloud", cloud)
+}
+
+// HandleUntagged serves the Notes without tags, newest first, to go
+// back and tag them. Archived Notes are left out.
+func (s *Server) HandleUntagged(w http.ResponseWriter, r *http.Request) {
+	requestContext := IndexContext{Sort: DefaultNoteSort}
+	requestContext.ReviewBefore = s.Config.reviewBefore()
+
+	err := SearchQuery{Untagged: true}.Scope(s.ReadDB).
+		Order(DefaultNoteSort.OrderBy()).
+		Find(&requestContext.Notes).Error
+	if err == nil && s.Config.LinkPreviews {
+		requestContext.Previews, err = linkPreviews(s.ReadDB, requestContext.Notes)
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	s.render(w, r, "untagged", requestContext)
+}
+
+// HandleTagColor sets the color of a Tag, or removes it when the
+// color is empty.
+func (s *Server) HandleTagColor(w http.ResponseWriter, r *http.Request) {
+	color := strings.ToLower(r.FormValue("color"))
+	if color != "" && !tagColor.MatchString(color) {
+		s.renderError(w, r, ErrBadRequest, fmt.Errorf("invalid tag color %q", color))
+		return
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		tag := Tag{}
+		if err := tx.First(&tag, chi.URLParam(r, "tagID")).Error; err != nil {
+			return err
+		}
+		if tag.Color == color {
+			return nil
+		}
+		changes := []string{fmt.Sprintf("color: %q → %q", tag.Color, color)}
+		if err := tx.Model(&tag).Update("color", color).Error; err != nil {
+			return err
+		}
+		return audit(tx, requestActor(r, "web"), AuditUpdate, "tag", tag.ID, changes)
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, "/tags", http.StatusFound)
+}
+
+// TagAboutContext provides context data to the tag about page.
+type TagAboutContext struct {
+	Tag      Tag
+	Notes    int
+	Children []TagNode // the nested tags
+	Editing  bool
+}
+
+// tagByName returns the Tag of the `{name}` url parameter. Tags are
+// unique by name, so its description is shared by all its Notes.
+func tagByName(db *gorm.DB, r *http.Request) (Tag, error) {
+	tag := Tag{}
+	name, err := url.PathUnescape(chi.URLParam(r, "name"))
+	if err != nil {
+		return tag, gorm.ErrRecordNotFound
+	}
+	err = db.Where("name = ?", name).Take(&tag).Error
+	return tag, err
+}
+
+// HandleTagAbout serves the about page of a Tag, with its description
+// and nested tags. The description is edited with `?edit=1`.
+func (s *Server) HandleTagAbout(w http.ResponseWriter, r *http.Request) {
+	tag, err := tagByName(s.ReadDB, r)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrTagNotFound, err)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	tags, err := tagCounts(s.ReadDB)
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	requestContext := TagAboutContext{
+		Tag:     tag,
+		Editing: r.URL.Query().Get("edit") != "" || tag.Description == "",
+	}
+	for _, node := range tagTree(tags) {
+		switch {
+		case node.Name == tag.Name:
+			requestContext.Notes = node.Notes
+		case strings.HasPrefix(node.Name, tag.Name+TagSeparator):
+			requestContext.Children = append(requestContext.Children, node)
+		}
+	}
+
+	s.render(w, r, "tag-about", requestContext)
+}
+
+// HandleTagAboutUpdate saves the description of a Tag.
+func (s *Server) HandleTagAboutUpdate(w http.ResponseWriter, r *http.Request) {
+	description, ok := normalizeText(r.FormValue("description"))
+	if !ok {
+		s.renderError(w, r, ErrBadRequest, errors.New("description is not valid UTF-8"))
+		return
+	}
+	description = strings.TrimSpace(description)
+
+	tag := Tag{}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if tag, err = tagByName(tx, r); err != nil {
+			return err
+		}
+		if tag.Description == description {
+			return nil
+		}
+		changes := []string{fmt.Sprintf("description: %v → %v characters", len([]rune(tag.Description)), len([]rune(description)))}
+		if err := tx.Model(&tag).Update("description", description).Error; err != nil {
+			return err
+		}
+		return audit(tx, requestActor(r, "web"), AuditUpdate, "tag", tag.ID, changes)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrTagNotFound, err)
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, tag.AboutURL(), http.StatusFound)
+}
+
+// HandleTagDelete deletes a stale Tag. Tags that are in use are kept.
+func (s *Server) HandleTagDelete(w http.ResponseWriter, r *http.Request) {
+	tagID := chi.URLParam(r, "tagID")
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		tag := Tag{}
+		if err := tx.First(&tag, tagID).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().
+			Where("id not in (select tag_id from note_tag)").
+			Delete(&Tag{}, tag.ID)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		changes := []string{fmt.Sprintf("name: %q → \"\"", tag.Name)}
+		return audit(tx, requestActor(r, "web"), AuditDelete, "tag", tag.ID, changes)
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	http.Redirect(w, r, "/tags", http.StatusFound)
+}
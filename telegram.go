@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TelegramSearchLimit is the max amount of Notes in a /search reply.
+const TelegramSearchLimit = 10
+
+// telegramAPI is the Telegram Bot API server.
+var telegramAPI = "https://api.telegram.org"
+
+//
+// ------------------------------------------------------------------
+// Telegram bot
+// ------------------------------------------------------------------
+//
+
+// TelegramUpdate is an incoming update of the Bot API.
+// Only text messages are used.
+type TelegramUpdate struct {
+	UpdateID int64 `json:"update_id"`
+	Message  *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+// TelegramBot creates Notes from the messages sent to the bot, and
+// answers /search queries. It long-polls the Bot API, so the server
+// doesn't need to be reachable from the internet.
+type TelegramBot struct {
+	s      *Server
+	client *http.Client
+	offset int64 // the next update to read
+}
+
+// NewTelegramBot ...
+func NewTelegramBot(s *Server) *TelegramBot {
+	return &TelegramBot{
+		s:      s,
+		client: &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// Run handles updates until the server stops. When the Bot API
+// can't be reached, it is retried after a while.
+func (b *TelegramBot) Run() {
+	for {
+		updates := []TelegramUpdate{}
+		params := url.Values{"offset": {strconv.FormatInt(b.offset, 10)}, "timeout": {"50"}}
+		if err := b.call("getUpdates", params, &updates); err != nil {
+			log.Printf("[telegram] %v", err)
+			time.Sleep(30 * time.Second)
+			continue
+		}
+
+		for _, update := range updates {
+			b.offset = update.UpdateID + 1
+			if update.Message != nil && update.Message.Text != "" {
+				b.handle(update.Message.Chat.ID, update.Message.Text)
+			}
+		}
+	}
+}
+
+// handle answers a message.
+func (b *TelegramBot) handle(chatID int64, text string) {
+	reply := ""
+	switch {
+	case !b.allowed(chatID):
+		reply = fmt.Sprintf("This chat is not allowed. Add %v to SIMPLENOTES_TELEGRAM_CHATS to use it.", chatID)
+	case text == "/start" || text == "/help":
+		reply = "Send a message to save it as a note, #hashtags become tags.\nFind notes with /search <query>."
+	case strings.HasPrefix(text, "/search"):
+		reply = b.search(strings.TrimSpace(strings.TrimPrefix(text, "/search")))
+	default:
+		reply = b.create(text)
+	}
+
+	params := url.Values{"chat_id": {strconv.FormatInt(chatID, 10)}, "text": {reply}}
+	if err := b.call("sendMessage", params, nil); err != nil {
+		log.Printf("[telegram] %v", err)
+	}
+}
+
+// allowed reports whether the chat is in TelegramChats. Anyone can
+// message a bot, so the chats must be listed.
+func (b *TelegramBot) allowed(chatID int64) bool {
+	for _, id := range strings.Split(b.s.Config.TelegramChats, ",") {
+		if strings.TrimSpace(id) == strconv.FormatInt(chatID, 10) {
+			return true
+		}
+	}
+	return false
+}
+
+// create saves the message as a Note, and returns the reply.
+func (b *TelegramBot) create(text string) string {
+	form := messageForm("", text)
+	if !form.IsValid() {
+		return "The note was not saved: " + strings.Join(form.Errors, ", ")
+	}
+
+	note, err := b.s.createNote(Actor{Source: "telegram"}, &form)
+	if err != nil {
+		log.Printf("[telegram] %v", err)
+		return ErrDatabase.Message
+	}
+	return fmt.Sprintf("Saved %q\n%v/note/%v/change", note.DisplayTitle(), b.s.Config.baseURL(), note.ID)
+}
+
+// search returns the reply to a /search query.
+func (b *TelegramBot) search(query string) string {
+	sq, err := ParseSearchQuery(query)
+	if err != nil {
+		return err.Error()
+	}
+	notes, err := searchNotes(b.s.ReadDB, sq, DefaultNoteSort.OrderBy(), TelegramSearchLimit)
+	if err != nil {
+		log.Printf("[telegram] %v", err)
+		return ErrDatabase.Message
+	}
+	if len(notes) == 0 {
+		return "No notes found."
+	}
+
+	lines := []string{}
+	for _, note := range notes {
+		lines = append(lines, fmt.Sprintf("%v · %v\n%v/note/%v/change",
+			note.DisplayDate(), note.DisplayTitle(), b.s.Config.baseURL(), note.ID))
+	}
+	return strings.Join(lines, "\n\n")
+}
+
+// call calls a Bot API method, and decodes its result.
+func (b *TelegramBot) call(method string, params url.Values, result interface{}) error {
+	endpoint := fmt.Sprintf("%v/bot%v/%v", telegramAPI, b.s.Config.TelegramToken, method)
+	resp, err := b.client.Post(endpoint, "application/x-www-form-urlencoded", bytes.NewBufferString(params.Encode()))
+	if err != nil {
+		// The error has the url, which has the token.
+		return fmt.Errorf("%v failed", method)
+	}
+	defer resp.Body.Close()
+
+	body := struct {
+		OK          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	if !body.OK {
+		return errors.New(method + ": " + body.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(body.Result, result)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Login throttling defaults.
+const (
+	DefaultLoginAttempts = 5       // failed logins before the lockout
+	DefaultLoginLockout  = 15 * 60 // seconds
+	LoginBackoff         = time.Second
+)
+
+//
+// ------------------------------------------------------------------
+// Login throttling
+// ------------------------------------------------------------------
+//
+
+// LoginThrottle slows down password guessing. Failed logins are counted
+// per client IP and per username. Each failure delays the next login,
+// by LoginBackoff doubled for every failure before it, and after the
+// attempts-th failure logins are locked for the lockout.
+//
+// Every login is counted as a failure when it starts, see Attempt, so
+// parallel guesses are throttled like guesses made one after another.
+// The failures are forgotten after a successful login, or after a
+// lockout without failures. A nil LoginThrottle allows all logins.
+type LoginThrottle struct {
+	attempts int
+	lockout  time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+	pruned   time.Time
+}
+
+// loginFailures are the failed logins of a client IP or username.
+type loginFailures struct {
+	count int
+	last  time.Time
+	until time.Time // no logins before
+}
+
+// NewLoginThrottle creates the LoginThrottle of the Config, or nil
+// when LoginAttempts is zero.
+func NewLoginThrottle(cfg Config) *LoginThrottle {
+	if cfg.LoginAttempts <= 0 {
+		return nil
+	}
+	return &LoginThrottle{
+		attempts: cfg.LoginAttempts,
+		lockout:  time.Duration(cfg.LoginLockout) * time.Second,
+		failures: map[string]*loginFailures{},
+	}
+}
+
+// loginKeys returns the keys that the failures of a login are counted
+// under. The password backend has no usernames.
+func loginKeys(ip, username string) []string {
+	keys := []string{"ip:" + ip}
+	if username != "" {
+		keys = append(keys, "user:"+username)
+	}
+	return keys
+}
+
+// Attempt returns how long until a login from the ip, for the username,
+// is allowed. When it is allowed now, it returns zero, and the login
+// is counted as failed until it Succeeded, which delays the next one.
+func (t *LoginThrottle) Attempt(ip, username string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	wait := time.Duration(0)
+	for _, key := range loginKeys(ip, username) {
+		if f, ok := t.failures[key]; ok {
+			if d := time.Until(f.until); d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait > 0 {
+		return wait
+	}
+
+	now := time.Now()
+	t.prune(now)
+	for _, key := range loginKeys(ip, username) {
+		f, ok := t.failures[key]
+		if !ok || now.Sub(f.last) > t.lockout {
+			f = &loginFailures{}
+			t.failures[key] = f
+		}
+		f.count++
+		f.last = now
+		f.until = now.Add(t.delay(f.count))
+		if f.count == t.attempts {
+			log.Printf("[auth] locked logins for %v for %v", key, t.lockout)
+		}
+	}
+	return 0
+}
+
+// Succeeded forgets the failed logins of the ip and the username.
+func (t *LoginThrottle) Succeeded(ip, username string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, key := range loginKeys(ip, username) {
+		delete(t.failures, key)
+	}
+}
+
+// delay returns how long logins wait after the count-th failure.
+func (t *LoginThrottle) delay(count int) time.Duration {
+	if count >= t.attempts {
+		return t.lockout
+	}
+	d := time.Duration(float64(LoginBackoff) * math.Pow(2, float64(count-1)))
+	if d > t.lockout {
+		return t.lockout
+	}
+	return d
+}
+
+// prune forgets the failures that are older than the lockout, at most
+// once a minute, so guessing from many addresses can't fill the memory.
+func (t *LoginThrottle) prune(now time.Time) {
+	if now.Sub(t.pruned) < time.Minute {
+		return
+	}
+	t.pruned = now
+	for key, f := range t.failures {
+		if now.Sub(f.last) > t.lockout {
+			delete(t.failures, key)
+		}
+	}
+}
+
+// setRetryAfter sets the Retry-After header of a throttled login,
+// in whole seconds.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+}
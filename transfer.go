@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ExportVersion is the version of the export file format.
+const ExportVersion = 1
+
+//
+// ------------------------------------------------------------------
+// Export and import
+// ------------------------------------------------------------------
+//
+
+// ExportFile is the format of `simplenotes export`, read back by `simplenotes import`.
+type ExportFile struct {
+	Version    int          `json:"version"`
+	ExportedAt time.Time    `json:"exported_at"`
+	Notes      []ExportNote `json:"notes"`
+}
+
+// ExportNote is a Note in the ExportFile. Bodies are always plaintext.
+type ExportNote struct {
+	Body      string    `json:"body"`
+	Date      time.Time `json:"date"`
+	Tags      []string  `json:"tags"`
+	Monospace bool      `json:"monospace,omitempty"`
+	Archived  bool      `json:"archived,omitempty"`
+	Starred   bool      `json:"starred,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ExportBatchSize is the amount of Notes read at once by exportNotes.
+const ExportBatchSize = 100
+
+// Progress is called for each Note of an export or import, with the
+// amount of Notes done so far. err is set for Notes that were skipped.
+type Progress func(done, total int, err error)
+
+// exportNotes writes all Notes, oldest first.
+func exportNotes(ctx context.Context, db *gorm.DB, w io.Writer, progress Progress) (int, error) {
+	var total int64
+	if err := db.Model(&Note{}).Count(&total).Error; err != nil {
+		return 0, err
+	}
+
+	file := ExportFile{
+		Version:    ExportVersion,
+		ExportedAt: time.Now().UTC(),
+		Notes:      []ExportNote{},
+	}
+	for offset := 0; offset < int(total); offset += ExportBatchSize {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		notes := []Note{}
+		if err := db.Preload("Tags").Order("date, id").Offset(offset).Limit(ExportBatchSize).Find(&notes).Error; err != nil {
+			return 0, err
+		}
+		for _, note := range notes {
+			n := newNoteJSON(note)
+			file.Notes = append(file.Notes, ExportNote{
+				Body:      n.Body,
+				Date:      n.Date,
+				Tags:      n.Tags,
+				Monospace: n.Monospace,
+				Archived:  note.Archived,
+				Starred:   note.Starred,
+				CreatedAt: note.CreatedAt,
+				UpdatedAt: note.UpdatedAt,
+			})
+			if progress != nil {
+				progress(len(file.Notes), int(total), nil)
+			}
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return len(file.Notes), enc.Encode(file)
+}
+
+// exportCSV writes all Notes as CSV, oldest first, for spreadsheets.
+func exportCSV(ctx context.Context, db *gorm.DB, w io.Writer) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "date", "body", "tags", "created_at", "updated_at"})
+
+	for offset := 0; ; offset += ExportBatchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		notes := []Note{}
+		if err := db.Preload("Tags").Order("date, id").Offset(offset).Limit(ExportBatchSize).Find(&notes).Error; err != nil {
+			return err
+		}
+		for _, note := range notes {
+			n := newNoteJSON(note)
+			out.Write([]string{
+				strconv.FormatUint(uint64(note.ID), 10),
+				n.Date.Format(time.RFC3339),
+				csvText(n.Body),
+				csvText(strings.Join(n.Tags, ",")),
+				note.CreatedAt.Format(time.RFC3339),
+				note.UpdatedAt.Format(time.RFC3339),
+			})
+		}
+		out.Flush()
+		if err := out.Error(); err != nil || len(notes) < ExportBatchSize {
+			return err
+		}
+	}
+}
+
+// csvText keeps spreadsheets from running text as a formula, e.g. the
+// body of a Note sent by email, by starting it with a quote.
+// Markdown list items, "- ", are left as is.
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) && !strings.HasPrefix(text, "- ") {
+		return "'" + text
+	}
+	return text
+}
+
+// HandleExportCSV downloads all Notes as a CSV file.
+func (s *Server) HandleExportCSV(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="simplenotes-%v.csv"`, time.Now().Format(NoteDayFormat)))
+	if err := exportCSV(r.Context(), s.ReadDB, w); err != nil {
+		// The response has started, so the error can only be logged.
+		logError(r, ErrDatabase, err)
+	}
+}
+
+// Org timestamp formats, active <...> for the date of a Note and
+// inactive [...] for when it was created and updated.
+const (
+	OrgTimestampFormat = "<2006-01-02 Mon 15:04>"
+	OrgInactiveFormat  = "[2006-01-02 Mon 15:04]"
+)
+
+// orgTagChars matches the characters that can't be in an org tag.
+var orgTagChars = regexp.MustCompile(`[^\pL\pN_@#%]+`)
+
+// exportOrg writes all Notes as a single org file, oldest first, for
+// Emacs: a heading per Note, with its tags as org tags and its date as
+// a timestamp. Archived Notes have the ARCHIVE tag. The bodies are
+// Markdown, as is.
+func exportOrg(ctx context.Context, db *gorm.DB, w io.Writer) error {
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "#+TITLE: Simple Notes\n#+DATE: %v\n", time.Now().In(noteLocation()).Format(OrgInactiveFormat))
+
+	for offset := 0; ; offset += ExportBatchSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		notes := []Note{}
+		if err := db.Preload("Tags").Order("date, id").Offset(offset).Limit(ExportBatchSize).Find(&notes).Error; err != nil {
+			return err
+		}
+		for _, note := range notes {
+			writeOrgNote(out, note)
+		}
+		if err := out.Flush(); err != nil || len(notes) < ExportBatchSize {
+			return err
+		}
+	}
+}
+
+// writeOrgNote writes the org heading of the Note.
+func writeOrgNote(w io.Writer, note Note) {
+	tags := []string{}
+	for _, tagName := range newNoteJSON(note).Tags {
+		if tag := strings.Trim(orgTagChars.ReplaceAllString(tagName, "_"), "_"); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if note.Archived {
+		tags = append(tags, "ARCHIVE")
+	}
+
+	heading := "* " + note.DisplayTitle()
+	if len(tags) > 0 {
+		heading += " :" + strings.Join(tags, ":") + ":"
+	}
+	fmt.Fprintf(w, "\n%v\n", heading)
+	fmt.Fprintln(w, ":PROPERTIES:")
+	fmt.Fprintf(w, ":ID:       %v\n", note.ID)
+	fmt.Fprintf(w, ":CREATED:  %v\n", noteWallTime(note.CreatedAt).Format(OrgInactiveFormat))
+	fmt.Fprintf(w, ":UPDATED:  %v\n", noteWallTime(note.UpdatedAt).Format(OrgInactiveFormat))
+	fmt.Fprintln(w, ":END:")
+	fmt.Fprintln(w, note.Date.Format(OrgTimestampFormat))
+
+	// Lines starting with a star would be headings, a comma escapes
+	// them as in org source blocks.
+	for _, line := range strings.Split(string(note.Body), "\n") {
+		if strings.HasPrefix(line, "*") {
+			line = "," + line
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// HandleExportOrg downloads all Notes as an org file.
+func (s *Server) HandleExportOrg(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/org; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="simplenotes-%v.org"`, time.Now().Format(NoteDayFormat)))
+	if err := exportOrg(r.Context(), s.ReadDB, w); err != nil {
+		// The response has started, so the error can only be logged.
+		logError(r, ErrDatabase, err)
+	}
+}
+
+// decodeExportFile reads an ExportFile.
+func decodeExportFile(r io.Reader) (ExportFile, error) {
+	file := ExportFile{}
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return file, fmt.Errorf("invalid export file: %v", err)
+	}
+	if file.Version != ExportVersion {
+		return file, fmt.Errorf("unsupported export version %v", file.Version)
+	}
+	return file, nil
+}
+
+// importNotes creates the Notes of an ExportFile, and returns the
+// amount of created Notes. The Notes are validated like the html form,
+// and created in a single transaction: if one is invalid, nothing is
+// imported, unless skipInvalid is set. Canceling imports nothing.
+func (s *Server) importNotes(ctx context.Context, file ExportFile, skipInvalid bool, progress Progress) (int, error) {
+	count := 0
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		for i, n := range file.Notes {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			form := NoteForm{
+				Body:      n.Body,
+				Date:      n.Date.Format(NotePartialDateFormat),
+				Time:      n.Date.Format(NotePartialTimeFormat),
+				Tags:      strings.Join(n.Tags, ","),
+				Monospace: n.Monospace,
+			}
+			if !form.IsValid() {
+				err := fmt.Errorf("note %v: %v", i+1, strings.Join(form.Errors, ", "))
+				if !skipInvalid {
+					return err
+				}
+				if progress != nil {
+					progress(i+1, len(file.Notes), err)
+				}
+				continue
+			}
+
+			note := Note{
+				Title:     EncryptedText(inferTitle(string(form.cleanedBody))),
+				Body:      form.cleanedBody,
+				Date:      n.Date, // keep the seconds, which the form drops
+				Monospace: form.Monospace,
+				Archived:  n.Archived,
+				Starred:   n.Starred,
+			}
+			note.CreatedAt, note.UpdatedAt = n.CreatedAt, n.UpdatedAt
+			note.Words, note.Characters = countBody(string(form.cleanedBody))
+
+			if err := tx.Create(&note).Error; err != nil {
+				return err
+			}
+			if len(form.cleanedTags) > 0 {
+				var err error
+				if form.cleanedTags, err = findOrCreateTags(tx, form.cleanedTags); err != nil {
+					return err
+				}
+				if err := tx.Model(&note).Association("Tags").Append(form.cleanedTags); err != nil {
+					return err
+				}
+				// Adding the tags touches the Note, keep its updated time.
+				if err := tx.Model(&note).UpdateColumn("updated_at", n.UpdatedAt).Error; err != nil {
+					return err
+				}
+			}
+			form.cleanedDateTime = n.Date
+			if err := saveRevision(tx, note.ID, &form); err != nil {
+				return err
+			}
+			note.Tags = form.cleanedTags
+			if err := audit(tx, Actor{Source: "import"}, AuditCreate, "note", note.ID, noteChanges(Note{}, note)); err != nil {
+				return err
+			}
+
+			count++
+			if progress != nil {
+				progress(i+1, len(file.Notes), nil)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.Counts.Clear()
+	return count, nil
+}
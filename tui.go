@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+//
+// ------------------------------------------------------------------
+// Note stores
+// ------------------------------------------------------------------
+//
+
+// NoteStore is where the TUI reads and writes Notes.
+type NoteStore interface {
+	List() ([]NoteJSON, error)
+	Save(id uint, input NoteInput) (NoteJSON, error)
+}
+
+// LocalStore is a NoteStore backed by the local database.
+type LocalStore struct {
+	s *Server
+}
+
+// List returns the most recent Notes.
+func (l LocalStore) List() ([]NoteJSON, error) {
+	notes := []Note{}
+	if err := l.s.ReadDB.Preload("Tags").Limit(MaxAPIListLimit).Order("date desc").Find(&notes).Error; err != nil {
+		return nil, err
+	}
+
+	results := []NoteJSON{}
+	for _, note := range notes {
+		results = append(results, newNoteJSON(note))
+	}
+	return results, nil
+}
+
+// Save creates a Note, or updates it if the id is not zero.
+func (l LocalStore) Save(id uint, input NoteInput) (NoteJSON, error) {
+	if id == 0 {
+		form := input.form()
+		if !form.IsValid() {
+			return NoteJSON{}, errors.New(strings.Join(form.Errors, ", "))
+		}
+		note, err := l.s.createNote(Actor{Source: "tui"}, &form)
+		return newNoteJSON(note), err
+	}
+
+	note := Note{}
+	if err := l.s.DB.Preload("Tags").First(&note, id).Error; err != nil {
+		return NoteJSON{}, err
+	}
+	if input.conflicts(note) {
+		return NoteJSON{}, errors.New("note was changed since it was read")
+	}
+	form, ok := input.updateForm(note)
+	if !ok {
+		return NoteJSON{}, errors.New(strings.Join(form.Errors, ", "))
+	}
+	err := l.s.updateNote(Actor{Source: "tui"}, &note, &form)
+	return newNoteJSON(note), err
+}
+
+// RemoteStore is a NoteStore backed by the API of a remote instance.
+type RemoteStore struct {
+	BaseURL string
+	client  *http.Client
+}
+
+// NewRemoteStore logs in to the remote instance.
+func NewRemoteStore(baseURL, password string) (*RemoteStore, error) {
+	jar, _ := cookiejar.New(nil)
+	r := &RemoteStore{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Jar: jar, Timeout: 10 * time.Second},
+	}
+	if err := sessionLogin(r.client, r.BaseURL, password); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// sessionLogin logs in to the server, storing the session cookie in the client's jar.
+func sessionLogin(client *http.Client, baseURL, password string) error {
+	resp, err := client.PostForm(baseURL+"/login", url.Values{"password": {password}})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	u, _ := url.Parse(baseURL)
+	if len(client.Jar.Cookies(u)) == 0 {
+		return errors.New("login failed")
+	}
+	return nil
+}
+
+// List returns the most recent Notes.
+func (r *RemoteStore) List() ([]NoteJSON, error) {
+	notes := []NoteJSON{}
+	err := r.do("GET", "/api/notes?limit="+strconv.Itoa(MaxAPIListLimit), nil, &notes)
+	return notes, err
+}
+
+// Save creates a Note, or updates it if the id is not zero.
+func (r *RemoteStore) Save(id uint, input NoteInput) (NoteJSON, error) {
+	note := NoteJSON{}
+	if id == 0 {
+		err := r.do("POST", "/api/notes", input, &note)
+		return note, err
+	}
+	err := r.do("PUT", fmt.Sprintf("/api/notes/%v", id), input, &note)
+	return note, err
+}
+
+// do performs an API request, decoding the JSON response into out.
+func (r *RemoteStore) do(method, path string, in, out interface{}) error {
+	body := &bytes.Buffer{}
+	if in != nil {
+		if err := json.NewEncoder(body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequest(method, r.BaseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		apiErr := APIError{}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if len(apiErr.Errors) > 0 {
+			return errors.New(strings.Join(apiErr.Errors, ", "))
+		}
+		return fmt.Errorf("request failed: %v", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+//
+// ------------------------------------------------------------------
+// TUI
+// ------------------------------------------------------------------
+//
+
+// Modes of the TUI.
+const (
+	tuiBrowse = iota
+	tuiSearch
+	tuiEdit
+)
+
+// notesLoadedMsg is sent when the Notes have been (re)loaded from the store.
+type notesLoadedMsg struct {
+	notes []NoteJSON
+	err   error
+}
+
+// noteSavedMsg is sent when a Note has been saved to the store.
+type noteSavedMsg struct {
+	err error
+}
+
+// tuiModel is the bubbletea model for browsing and editing Notes.
+type tuiModel struct {
+	store  NoteStore
+	notes  []NoteJSON
+	cursor int
+	mode   int
+	query  string
+	status string
+
+	// Edit mode state. editID is zero for new Notes.
+	editID        uint
+	editUpdatedAt time.Time
+	editDate      time.Time
+	editBody      string
+	editTags      string
+	editField     int // 0 = body, 1 = tags
+}
+
+func (m tuiModel) load() tea.Msg {
+	notes, err := m.store.List()
+	return notesLoadedMsg{notes, err}
+}
+
+// Init ...
+func (m tuiModel) Init() tea.Cmd {
+	return m.load
+}
+
+// filtered returns the Notes matching the search query.
+func (m tuiModel) filtered() []NoteJSON {
+	if m.query == "" {
+		return m.notes
+	}
+
+	query := strings.ToLower(m.query)
+	results := []NoteJSON{}
+	for _, note := range m.notes {
+		text := strings.ToLower(note.Body + " " + strings.Join(note.Tags, " "))
+		if strings.Contains(text, query) {
+			results = append(results, note)
+		}
+	}
+	return results
+}
+
+// Update ...
+func (m tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case notesLoadedMsg:
+		if msg.err != nil {
+			m.status = "Error: " + msg.err.Error()
+			return m, nil
+		}
+		m.notes = msg.notes
+		if m.cursor >= len(m.filtered()) {
+			m.cursor = 0
+		}
+		return m, nil
+
+	case noteSavedMsg:
+		if msg.err != nil {
+			m.status = "Error: " + msg.err.Error()
+			return m, nil
+		}
+		m.mode = tuiBrowse
+		m.status = "Saved"
+		return m, m.load
+
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			return m, tea.Quit
+		}
+		switch m.mode {
+		case tuiSearch:
+			return m.updateSearch(msg)
+		case tuiEdit:
+			return m.updateEdit(msg)
+		default:
+			return m.updateBrowse(msg)
+		}
+	}
+
+	return m, nil
+}
+
+func (m tuiModel) updateBrowse(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	notes := m.filtered()
+	m.status = ""
+
+	switch msg.String() {
+	case "q":
+		return m, tea.Quit
+	case "up", "k":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case "down", "j":
+		if m.cursor < len(notes)-1 {
+			m.cursor++
+		}
+	case "/":
+		m.mode = tuiSearch
+	case "r":
+		return m, m.load
+	case "n":
+		m.mode = tuiEdit
+		m.editID, m.editDate, m.editBody, m.editTags, m.editField = 0, localNow(), "", "", 0
+	case "e", "enter":
+		if len(notes) == 0 {
+			break
+		}
+		note := notes[m.cursor]
+		m.mode = tuiEdit
+		m.editID, m.editDate, m.editBody, m.editTags, m.editField = note.ID, note.Date, note.Body, strings.Join(note.Tags, ", "), 0
+		m.editUpdatedAt = note.UpdatedAt
+	}
+
+	return m, nil
+}
+
+func (m tuiModel) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEnter:
+		m.mode = tuiBrowse
+	case tea.KeyEsc:
+		m.mode = tuiBrowse
+		m.query = ""
+	case tea.KeyBackspace:
+		m.query = dropLastRune(m.query)
+	case tea.KeyRunes, tea.KeySpace:
+		m.query += string(msg.Runes)
+	}
+	m.cursor = 0
+
+	return m, nil
+}
+
+func (m tuiModel) updateEdit(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	field := &m.editBody
+	if m.editField == 1 {
+		field = &m.editTags
+	}
+
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.mode = tuiBrowse
+	case tea.KeyTab:
+		m.editField = 1 - m.editField
+	case tea.KeyCtrlS:
+		input := NoteInput{Body: m.editBody, Tags: m.editTags}
+		if m.editID != 0 {
+			// The Note's date, monospace and scheduled are kept.
+			input.UpdatedAt = &m.editUpdatedAt
+		} else {
+			input.Date = m.editDate.Format(NotePartialDateFormat)
+			input.Time = m.editDate.Format(NotePartialTimeFormat)
+		}
+		store, id := m.store, m.editID
+		return m, func() tea.Msg {
+			_, err := store.Save(id, input)
+			return noteSavedMsg{err}
+		}
+	case tea.KeyEnter:
+		if m.editField == 0 {
+			*field += "\n"
+		}
+	case tea.KeyBackspace:
+		*field = dropLastRune(*field)
+	case tea.KeyRunes, tea.KeySpace:
+		*field += string(msg.Runes)
+	}
+
+	return m, nil
+}
+
+// View ...
+func (m tuiModel) View() string {
+	b := &strings.Builder{}
+
+	if m.mode == tuiEdit {
+		title := "New note"
+		if m.editID != 0 {
+			title = fmt.Sprintf("Edit note %v", m.editID)
+		}
+		fmt.Fprintf(b, "%v (%v)\n\n", title, displayDateTime(m.editDate))
+		fmt.Fprintf(b, "%v Body:\n%v%v\n\n", tuiMarker(m.editField == 0), m.editBody, tuiCursor(m.editField == 0))
+		fmt.Fprintf(b, "%v Tags: %v%v\n\n", tuiMarker(m.editField == 1), m.editTags, tuiCursor(m.editField == 1))
+		fmt.Fprintf(b, "tab: switch field • ctrl+s: save • esc: cancel\n")
+	} else {
+		fmt.Fprintf(b, "Simple Notes\n\n")
+		notes := m.filtered()
+		for i, note := range notes {
+			tags := ""
+			if len(note.Tags) > 0 {
+				tags = " [" + strings.Join(note.Tags, ", ") + "]"
+			}
+			fmt.Fprintf(b, "%v %v  %v%v\n", tuiMarker(i == m.cursor), displayDateTime(note.Date), note.Title, tags)
+		}
+		if len(notes) == 0 {
+			fmt.Fprintf(b, "  No notes\n")
+		}
+		fmt.Fprintf(b, "\n")
+
+		if m.mode == tuiSearch {
+			fmt.Fprintf(b, "/%v█\n", m.query)
+		} else {
+			if m.query != "" {
+				fmt.Fprintf(b, "search: %v\n", m.query)
+			}
+			fmt.Fprintf(b, "j/k: move • enter: edit • n: new • /: search • r: refresh • q: quit\n")
+		}
+	}
+
+	if m.status != "" {
+		fmt.Fprintf(b, "\n%v\n", m.status)
+	}
+
+	return b.String()
+}
+
+func tuiMarker(selected bool) string {
+	if selected {
+		return ">"
+	}
+	return " "
+}
+
+func tuiCursor(selected bool) string {
+	if selected {
+		return "█"
+	}
+	return ""
+}
+
+// dropLastRune removes the last character of the string.
+func dropLastRune(s string) string {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return s
+	}
+	return string(runes[:len(runes)-1])
+}
+
+// runTUI starts the terminal interface against the given store.
+func runTUI(store NoteStore) error {
+	return tea.NewProgram(tuiModel{store: store}, tea.WithAltScreen()).Start()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+// UndoGracePeriod is how long a deleted Note can be restored with undo.
+const UndoGracePeriod = 10 * time.Minute
+
+// flashCookie holds the Flash message for the next page.
+const flashCookie = "flash"
+
+// AuditRestore is the audit action of an undone delete.
+const AuditRestore = "restore"
+
+//
+// ------------------------------------------------------------------
+// Undo
+// ------------------------------------------------------------------
+//
+
+// Flash is a message shown once, on the page after a redirect.
+type Flash struct {
+	Message    string `json:"message"`
+	UndoNoteID uint   `json:"undo_note_id,omitempty"` // offer to undo the delete of this Note
+}
+
+// setFlash shows the Flash on the next page.
+func setFlash(w http.ResponseWriter, flash Flash) {
+	b, err := json.Marshal(flash)
+	if err != nil {
+		return
+	}
+	setCookie(w, flashCookie, base64.RawURLEncoding.EncodeToString(b), UndoGracePeriod)
+}
+
+// popFlash returns the Flash for this page, if any, and clears it.
+func popFlash(w http.ResponseWriter, r *http.Request) *Flash {
+	c, err := r.Cookie(flashCookie)
+	if err != nil {
+		return nil
+	}
+	setCookie(w, flashCookie, "", -1)
+
+	flash := Flash{}
+	b, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil || json.Unmarshal(b, &flash) != nil {
+		return nil
+	}
+	return &flash
+}
+
+// restoreNote undoes the delete of a Note, if it was deleted within
+// the UndoGracePeriod. The Tags are restored from its latest revision.
+func (s *Server) restoreNote(actor Actor, noteID string) (Note, error) {
+	note := Note{}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().
+			Where("deleted_at >= ?", time.Now().Add(-UndoGracePeriod)).
+			First(&note, noteID).Error
+		if err != nil {
+			return err
+		}
+
+		revision := NoteRevision{}
+		if err := tx.Where("note_id = ?", note.ID).Order("id desc").First(&revision).Error; err != nil {
+			return err
+		}
+		tags := []Tag{}
+		for _, name := range strings.Split(revision.Tags, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				tags = append(tags, Tag{Name: name})
+			}
+		}
+		// Reattach the existing Tags, with their color and description.
+		if tags, err = findOrCreateTags(tx, tags); err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Model(&note).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if len(tags) > 0 {
+			if err := tx.Model(&note).Association("Tags").Append(tags); err != nil {
+				return err
+			}
+		}
+		note.Tags = tags
+		return audit(tx, actor, AuditRestore, "note", note.ID, noteChanges(Note{}, note))
+	})
+	if err != nil {
+		return Note{}, err
+	}
+
+	s.Counts.Clear()
+	s.Events.Publish(newNoteEvent(NoteCreated, note))
+	return note, nil
+}
+
+// HandleNoteUndo restores a Note that was just deleted.
+func (s *Server) HandleNoteUndo(w http.ResponseWriter, r *http.Request) {
+	noteID := chi.URLParam(r, "noteID")
+
+	if _, err := s.restoreNote(requestActor(r, "web"), noteID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			setFlash(w, Flash{Message: "The note can no longer be restored."})
+			http.Redirect(w, r, "/", http.StatusFound)
+			return
+		}
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+
+	setFlash(w, Flash{Message: "Note restored."})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
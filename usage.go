@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+// UsageFlushInterval is how often the page views are saved.
+const UsageFlushInterval = time.Minute
+
+// UsageDays is the amount of days shown on the admin page.
+const UsageDays = 30
+
+//
+// ------------------------------------------------------------------
+// Usage analytics
+// ------------------------------------------------------------------
+//
+
+// UsageCount is the model for the `usage_counts` table.
+type UsageCount struct {
+	Day   string `gorm:"primaryKey"` // YYYY-MM-DD
+	Route string `gorm:"primaryKey"` // method and route pattern, e.g. GET /note/{noteID}/change
+	Count int
+}
+
+// UsageCounter counts the page views per route, to see which features
+// are used. Nothing leaves the server: the counts are kept in memory,
+// and saved to the database every UsageFlushInterval.
+type UsageCounter struct {
+	db     *gorm.DB
+	mu     sync.Mutex
+	counts map[string]int // by route, since the last flush
+}
+
+// NewUsageCounter ...
+func NewUsageCounter(db *gorm.DB) *UsageCounter {
+	return &UsageCounter{db: db, counts: map[string]int{}}
+}
+
+// Count is a middleware that counts the requests by route pattern.
+// Requests that match no route, and static assets, are not counted.
+func (u *UsageCounter) Count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+
+		pattern := chi.RouteContext(r.Context()).RoutePattern()
+		if pattern == "" || pattern == "/static/*" {
+			return
+		}
+		u.mu.Lock()
+		u.counts[r.Method+" "+pattern]++
+		u.mu.Unlock()
+	})
+}
+
+// Flush adds the counts since the last flush to today's counts.
+func (u *UsageCounter) Flush() error {
+	u.mu.Lock()
+	counts := u.counts
+	u.counts = map[string]int{}
+	u.mu.Unlock()
+
+	if len(counts) == 0 {
+		return nil
+	}
+	day := localNow().Format(NoteDayFormat)
+	return u.db.Transaction(func(tx *gorm.DB) error {
+		for route, count := range counts {
+			err := tx.Exec(`
+				insert into usage_counts (day, route, count) values (?, ?, ?)
+				on conflict (day, route) do update set count = count + excluded.count;
+			`, day, route, count).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RouteUsage is the amount of requests to a route.
+type RouteUsage struct {
+	Route string
+	Count int
+}
+
+// AdminContext provides context data to the admin page.
+type AdminContext struct {
+	Analytics bool
+	Usage     []RouteUsage // the last UsageDays, most used first
+	MaxUsage  int          // to scale the chart
+}
+
+// HandleAdmin serves the admin page, with the usage of the routes.
+func (s *Server) HandleAdmin(w http.ResponseWriter, r *http.Request) {
+	requestContext := AdminContext{Analytics: s.Usage != nil}
+
+	if s.Usage != nil {
+		// Include the requests that were not saved yet.
+		if err := s.Usage.Flush(); err != nil {
+			logError(r, ErrDatabase, err)
+		}
+	}
+
+	since := localNow().AddDate(0, 0, -UsageDays+1).Format(NoteDayFormat)
+	err := s.DB.Model(&UsageCount{}).
+		Select("route, sum(count) as count").
+		Where("day >= ?", since).
+		Group("route").
+		Order("count desc, route").
+		Scan(&requestContext.Usage).Error
+	if err != nil {
+		s.renderError(w, r, ErrDatabase, err)
+		return
+	}
+	for _, u := range requestContext.Usage {
+		if u.Count > requestContext.MaxUsage {
+			requestContext.MaxUsage = u.Count
+		}
+	}
+
+	s.render(w, r, "admin", requestContext)
+}
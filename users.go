@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// MinPasswordLength is the min amount of characters a User password can have.
+const MinPasswordLength = 8
+
+// usernamePattern is the allowed format of usernames. Email
+// addresses are allowed, for accounts provisioned by an auth proxy.
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._@+-]{0,127}$`)
+
+// ErrInvalidUsername is returned for usernames that don't match usernamePattern.
+var ErrInvalidUsername = errors.New("username must be 1-128 lowercase letters, digits, '.', '_', '@', '+' or '-'")
+
+//
+// ------------------------------------------------------------------
+// Users
+// ------------------------------------------------------------------
+//
+
+// User is the model for the `users` table.
+type User struct {
+	gorm.Model
+	Username     string
+	PasswordHash string
+}
+
+// dummyPasswordHash is the bcrypt hash of a random, discarded password.
+// Logins for unknown usernames are checked against it, so that they
+// take as long as the ones for existing users.
+const dummyPasswordHash = "$2a$10$vVgRogoaSWnUEzjj4600Cu4LuGadKLe/E81fyFHmoyY6d1K6uf.xW"
+
+// CheckPassword checks the password against the User's hash.
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// createUser creates a User with the password. Usernames are lowercased.
+func createUser(db *gorm.DB, username, password string) (User, error) {
+	username = strings.ToLower(strings.TrimSpace(username))
+	if !usernamePattern.MatchString(username) {
+		return User{}, ErrInvalidUsername
+	}
+	if len([]rune(password)) < MinPasswordLength {
+		return User{}, fmt.Errorf("password must be at least %v characters", MinPasswordLength)
+	}
+
+	var count int64
+	if err := db.Model(&User{}).Where("username = ?", username).Count(&count).Error; err != nil {
+		return User{}, err
+	}
+	if count > 0 {
+		return User{}, fmt.Errorf("user %q already exists", username)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	user := User{Username: username, PasswordHash: string(hash)}
+	err = db.Create(&user).Error
+	return user, err
+}
+
+// provisionUser creates a User without a password, for a username
+// from an auth proxy. If the User was created concurrently, it is returned.
+func provisionUser(db *gorm.DB, username string) (User, error) {
+	if !usernamePattern.MatchString(username) {
+		return User{}, ErrInvalidUsername
+	}
+
+	user := User{Username: username}
+	if err := db.Create(&user).Error; err != nil {
+		if db.Where("username = ?", username).First(&user).Error == nil {
+			return user, nil
+		}
+		return User{}, err
+	}
+
+	log.Printf("[auth] provisioned user %v", username)
+	return user, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/yuin/goldmark"
+	emoji "github.com/yuin/goldmark-emoji"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+	"gorm.io/gorm"
+)
+
+//
+// ------------------------------------------------------------------
+// Welcome
+// ------------------------------------------------------------------
+//
+
+// renderMarkdown converts Markdown to html. Raw html in the
+// Markdown is not rendered, and the output is sanitized too.
+// Fenced code blocks are highlighted with the code theme, and emoji
+// :shortcodes: are expanded.
+func renderMarkdown(source, codeTheme string) (template.HTML, error) {
+	highlighter, err := newCodeHighlighter(codeTheme)
+	if err != nil {
+		return "", err
+	}
+	markdown := goldmark.New(
+		goldmark.WithExtensions(emoji.New()),
+		goldmark.WithRendererOptions(
+			renderer.WithNodeRenderers(util.Prioritized(highlighter, 100)),
+		),
+	)
+
+	var b bytes.Buffer
+	if err := markdown.Convert([]byte(source), &b); err != nil {
+		return "", err
+	}
+	return sanitizeHTML(b.String()), nil
+}
+
+// welcomeHTML returns the content shown above the note list: the body
+// of the configured welcome Note, or else the configured Markdown.
+// It is empty when neither is set.
+func welcomeHTML(db *gorm.DB, cfg Config) (template.HTML, error) {
+	source := cfg.Welcome
+	if cfg.WelcomeNoteID != 0 {
+		note := Note{}
+		if err := db.First(&note, cfg.WelcomeNoteID).Error; err != nil {
+			return "", err
+		}
+		source = string(note.Body)
+	}
+
+	if source == "" {
+		return "", nil
+	}
+	return renderMarkdown(source, cfg.CodeTheme)
+}